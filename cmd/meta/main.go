@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/tymbaca/sfs/internal/metaserver"
+)
+
+func main() {
+	api := flag.String("api", "localhost:6890", "API address, it's also the replica ID")
+	raftAddr := flag.String("raft", "localhost:6891", "raft address")
+	dir := flag.String("dir", "cmd/output/meta/1st-replica", "raft data dir, empty means in memory")
+	join := flag.String("join", "", "API address of any replica of the existing cluster, empty bootstraps new one")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	lis, err := net.Listen("tcp", *api)
+	if err != nil {
+		log.Fatalf("can't listen on '%s': %s", *api, err)
+	}
+
+	node, err := metaserver.NewNode(ctx, metaserver.Config{
+		APIAddr:  *api,
+		RaftAddr: *raftAddr,
+		Dir:      *dir,
+		Join:     *join,
+	})
+	if err != nil {
+		log.Fatalf("can't start the replica: %s", err)
+	}
+	defer node.Shutdown()
+
+	fmt.Println("started metadata replica on addr:", *api)
+	log.Println(node.Serve(ctx, lis))
+}
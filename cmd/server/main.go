@@ -2,13 +2,50 @@ package main
 
 import (
 	"context"
+	_ "expvar"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/tymbaca/sfs/internal/storage"
+	"github.com/tymbaca/sfs/pkg/mem"
 	sfs "github.com/tymbaca/sfs/pkg/server"
 )
 
+const (
+	metricsAddr = ":6880"
+	replicasEnv = "SFS_REPLICAS"
+)
+
+// clusterAddrs are the node addresses as clients see them (see SFS_ADDRS)
+var clusterAddrs = []string{"localhost:6886", "localhost:6887", "localhost:6888"}
+
+var scrubCfg = sfs.ScrubConfig{
+	Interval:    time.Hour,
+	BytesPerSec: 32 * mem.MiB,
+	Quarantine:  true,
+}
+
+// the node bandwidth is shared by the classes of requests, interactive first
+var qosCfg = sfs.QoSConfig{
+	ConnBytesPerSec: 128 * mem.MiB,
+	BytesPerSec:     512 * mem.MiB,
+}
+
+// nodes share the disk here, so they leave the space for each other
+var storageLimits = storage.Limits{HighWater: 0.9}
+
+var admissionCfg = sfs.AdmissionConfig{
+	MaxConns:      1024,
+	MaxInFlight:   map[byte]int{'*': 64, '/': 256},
+	QueueTimeout:  5 * time.Second,
+	IdleTimeout:   time.Minute,
+	HeaderTimeout: 10 * time.Second,
+}
+
 func main() {
 	ctx := context.Background()
 
@@ -16,22 +53,73 @@ func main() {
 	storage1 := storage.NewFileStorage("cmd/output/server/1st-node")
 	storage2 := storage.NewFileStorage("cmd/output/server/2nd-node")
 	storage3 := storage.NewFileStorage("cmd/output/server/3rd-node")
+	for _, stor := range []*storage.FileStorage{storage1, storage2, storage3} {
+		stor.SetLimits(storageLimits)
+	}
 
 	server1 := sfs.New(":6886", storage1)
+	server1.SetQoS(qosCfg)
+	server1.SetAdmission(admissionCfg)
 	go func() {
 		log.Fatal(server1.Run(ctx))
 	}()
 
 	server2 := sfs.New(":6887", storage2)
+	server2.SetQoS(qosCfg)
+	server2.SetAdmission(admissionCfg)
 	go func() {
 		log.Fatal(server2.Run(ctx))
 	}()
 
 	server3 := sfs.New(":6888", storage3)
+	server3.SetQoS(qosCfg)
+	server3.SetAdmission(admissionCfg)
 	go func() {
 		log.Fatal(server3.Run(ctx))
 	}()
 
+	for _, srv := range []*sfs.Server{server1, server2, server3} {
+		go func() {
+			log.Println(srv.RunScrubber(ctx, scrubCfg))
+		}()
+	}
+
+	// every node has all the nodes as seeds, in the SFS_ADDRS order, so the
+	// bootstrapped clients place chunks the same way
+	for i, srv := range []*sfs.Server{server1, server2, server3} {
+		cfg := sfs.MembershipConfig{
+			Self:           clusterAddrs[i],
+			Seeds:          clusterAddrs,
+			GossipInterval: time.Second,
+			SuspectTimeout: 5 * time.Second,
+		}
+
+		go func() {
+			log.Println(srv.RunMembership(ctx, cfg))
+		}()
+	}
+
+	// anti-entropy makes sense only when chunks are replicated
+	replicas, _ := strconv.Atoi(os.Getenv(replicasEnv))
+	if replicas > 1 {
+		for i, srv := range []*sfs.Server{server1, server2, server3} {
+			cfg := sfs.RepairConfig{
+				Layout:      sfs.Layout{Self: clusterAddrs[i], Nodes: clusterAddrs, Replicas: replicas},
+				Interval:    10 * time.Minute,
+				BytesPerSec: 32 * mem.MiB,
+			}
+
+			go func() {
+				log.Println(srv.RunRepair(ctx, cfg))
+			}()
+		}
+	}
+
+	// scrub metrics are served on /debug/vars
+	go func() {
+		log.Fatal(http.ListenAndServe(metricsAddr, nil))
+	}()
+
 	fmt.Println("started nodes on addrs:", server1.Addr(), server2.Addr(), server3.Addr())
 	<-make(chan struct{})
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/storage"
+	"github.com/tymbaca/sfs/internal/transport"
+	sfs "github.com/tymbaca/sfs/pkg/client"
+	"github.com/tymbaca/sfs/pkg/meta"
+	"github.com/tymbaca/sfs/pkg/rebalance"
+)
+
+const (
+	addrsEnv = "SFS_ADDRS"
+	metaEnv  = "SFS_META"
+)
+
+func main() {
+	ctx := context.Background()
+
+	if len(os.Args) < 2 {
+		fmt.Println("specify the operation")
+		os.Exit(1)
+	}
+
+	op := os.Args[1]
+
+	switch op {
+	case "rebalance":
+		rebalanceCmd(ctx, os.Args[2:])
+	case "scrub":
+		scrubCmd(ctx, os.Args[2:])
+	case "gc":
+		gcCmd(ctx, os.Args[2:])
+	case "prune":
+		pruneCmd(ctx, os.Args[2:])
+	case "stats":
+		statsCmd(ctx, os.Args[2:])
+	default:
+		fmt.Println("unknown operation")
+		os.Exit(1)
+	}
+}
+
+func rebalanceCmd(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("rebalance", flag.ExitOnError)
+	oldAddrs := fs.String("old", "", "comma-separated node addresses of the current layout")
+	newAddrs := fs.String("new", "", "comma-separated node addresses of the target layout")
+	journal := fs.String("journal", "rebalance.journal", "path to the journal of completed moves, used to resume")
+	rate := fs.Int64("rate", 0, "copy rate limit in bytes per second, 0 means unlimited")
+	replicas := fs.Int("replicas", 1, "count of nodes each chunk is stored on")
+	parallel := fs.Int("parallel", 4, "count of chunks moved at the same time")
+	dryRun := fs.Bool("dry-run", false, "only print the planned moves")
+	fs.Parse(args)
+
+	if strings.TrimSpace(*oldAddrs) == "" || strings.TrimSpace(*newAddrs) == "" {
+		fmt.Println("specify both --old and --new layouts")
+		os.Exit(1)
+	}
+
+	moves, err := rebalance.Plan(ctx, strings.Split(*oldAddrs, ","), strings.Split(*newAddrs, ","), *replicas)
+	if err != nil {
+		fmt.Printf("can't plan the rebalance: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("planned %d moves\n", len(moves))
+	if *dryRun {
+		for _, m := range moves {
+			if m.Bucket != "" {
+				fmt.Printf("[%s] ", m.Bucket)
+			}
+			fmt.Printf("%s/%d: %s -> %s\n", m.Name, m.ID, m.From, m.To)
+		}
+		return
+	}
+
+	r := rebalance.New(rebalance.Config{
+		Journal:     *journal,
+		BytesPerSec: *rate,
+		Parallel:    *parallel,
+	})
+
+	stats, err := r.Run(ctx, moves)
+	fmt.Printf("moved %d chunks (%d bytes), skipped %d already moved\n", stats.Moved, stats.Bytes, stats.Skipped)
+	if err != nil {
+		fmt.Printf("error while rebalancing, rerun to resume: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func statsCmd(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	addrs := fs.String("addrs", os.Getenv(addrsEnv), "comma-separated node addresses")
+	fs.Parse(args)
+
+	if strings.TrimSpace(*addrs) == "" {
+		fmt.Printf("specify --addrs or set env var %s\n", addrsEnv)
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, addr := range strings.Split(*addrs, ",") {
+		trans := transport.NewTCPTransport(addr)
+		stats, err := trans.Stats(ctx)
+		trans.Close()
+		if err != nil {
+			fmt.Printf("%s: can't get stats: %s\n", addr, err)
+			failed = true
+			continue
+		}
+
+		usedPct := 0.0
+		if stats.Total > 0 {
+			usedPct = float64(stats.Used) * 100 / float64(stats.Total)
+		}
+		fmt.Printf("%s: used %d, free %d, total %d bytes (%.1f%%)\n", addr, stats.Used, stats.Free, stats.Total, usedPct)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func scrubCmd(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("scrub", flag.ExitOnError)
+	addrs := fs.String("addrs", os.Getenv(addrsEnv), "comma-separated node addresses")
+	fs.Parse(args)
+
+	if strings.TrimSpace(*addrs) == "" {
+		fmt.Printf("specify --addrs or set env var %s\n", addrsEnv)
+		os.Exit(1)
+	}
+
+	nodes := strings.Split(*addrs, ",")
+	healthy := true
+
+	// Per node reports of the background scrubbers
+	for _, addr := range nodes {
+		trans := transport.NewTCPTransport(addr)
+		data, err := trans.ScrubReport(ctx)
+		trans.Close()
+		if errors.Is(err, common.ErrNotFound) {
+			fmt.Printf("%s: scrub didn't finish yet\n", addr)
+			continue
+		} else if err != nil {
+			fmt.Printf("%s: can't get scrub report: %s\n", addr, err)
+			healthy = false
+			continue
+		}
+
+		var report storage.ScrubReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			fmt.Printf("%s: invalid scrub report: %s\n", addr, err)
+			healthy = false
+			continue
+		}
+
+		fmt.Printf("%s: scrubbed at %s, checked %d chunks (%d bytes) in %s\n",
+			addr, report.Started.Format(time.RFC3339), report.Checked, report.CheckedBytes, report.Duration)
+		healthy = printEntries(addr, "corrupted", report.Corrupted) && healthy
+		healthy = printEntries(addr, "truncated", report.Truncated) && healthy
+		healthy = printEntries(addr, "empty", report.Empty) && healthy
+		healthy = printEntries(addr, "unknown entry", report.Unknown) && healthy
+		printEntries(addr, "no checksum", report.MissingChecksum)
+		printEntries(addr, "quarantined", report.Quarantined)
+	}
+
+	// Gaps can only be found looking at the whole cluster
+	fileIDs := make(map[string][]uint64)
+	for _, addr := range nodes {
+		trans := transport.NewTCPTransport(addr)
+		names, err := trans.ListFiles(ctx)
+		trans.Close()
+		if err != nil {
+			fmt.Printf("%s: can't list files: %s\n", addr, err)
+			os.Exit(1)
+		}
+
+		for _, name := range names {
+			trans := transport.NewTCPTransport(addr)
+			ids, err := trans.ListIDs(ctx, name)
+			trans.Close()
+			if err != nil {
+				fmt.Printf("%s: can't list chunk ids of '%s': %s\n", addr, name, err)
+				os.Exit(1)
+			}
+
+			fileIDs[name] = append(fileIDs[name], ids...)
+		}
+	}
+
+	for name, ids := range fileIDs {
+		if gaps := chunks.Gaps(ids); len(gaps) > 0 {
+			fmt.Printf("'%s': missing chunks %v\n", name, gaps)
+			healthy = false
+		}
+	}
+
+	if !healthy {
+		os.Exit(2)
+	}
+}
+
+func gcCmd(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	addrs := fs.String("addrs", os.Getenv(addrsEnv), "comma-separated node addresses")
+	metaAddrs := fs.String("meta", os.Getenv(metaEnv), "comma-separated metadata service addresses")
+	grace := fs.Duration("grace", 24*time.Hour, "how long the chunk must be unreferenced to be collected")
+	fs.Parse(args)
+
+	if strings.TrimSpace(*addrs) == "" || strings.TrimSpace(*metaAddrs) == "" {
+		fmt.Printf("specify --addrs and --meta or set env vars %s and %s\n", addrsEnv, metaEnv)
+		os.Exit(1)
+	}
+
+	client := sfs.NewClient(*addrs, 1, sfs.WithMeta(meta.NewClient(*metaAddrs)))
+	collected, err := client.CollectGarbage(ctx, *grace)
+	fmt.Printf("collected %d chunks\n", collected)
+	if err != nil {
+		fmt.Printf("can't collect garbage: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func pruneCmd(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	addrs := fs.String("addrs", os.Getenv(addrsEnv), "comma-separated node addresses")
+	metaAddrs := fs.String("meta", os.Getenv(metaEnv), "comma-separated metadata service addresses")
+	keep := fs.Int("keep", 3, "count of the newest replaced versions of each file to keep")
+	keepFor := fs.Duration("keep-for", 24*time.Hour, "how long to keep the replaced versions, must exceed the longest download")
+	fs.Parse(args)
+
+	if strings.TrimSpace(*addrs) == "" || strings.TrimSpace(*metaAddrs) == "" {
+		fmt.Printf("specify --addrs and --meta or set env vars %s and %s\n", addrsEnv, metaEnv)
+		os.Exit(1)
+	}
+
+	client := sfs.NewClient(*addrs, 1, sfs.WithMeta(meta.NewClient(*metaAddrs)))
+	pruned, err := client.PruneVersions(ctx, sfs.Retention{Keep: *keep, KeepFor: *keepFor})
+	fmt.Printf("pruned %d versions\n", pruned)
+	if err != nil {
+		fmt.Printf("can't prune versions: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// printEntries prints the entries and returns true if there are none.
+func printEntries(addr, kind string, entries []string) bool {
+	for _, e := range entries {
+		fmt.Printf("%s: %s: %s\n", addr, kind, e)
+	}
+
+	return len(entries) == 0
+}
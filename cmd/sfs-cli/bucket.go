@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	sfs "github.com/tymbaca/sfs/pkg/client"
+)
+
+// bucketCmd runs 'bucket create|list|delete'.
+func bucketCmd(ctx context.Context, client *sfs.Client, args []string) {
+	if len(args) < 1 {
+		fmt.Println("specify the bucket operation: create, list or delete")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("bucket create", flag.ExitOnError)
+		maxBytes := fs.Uint64("max-bytes", 0, "max bytes of the bucket chunks on each node, 0 is unlimited")
+		maxObjects := fs.Uint64("max-objects", 0, "max count of the bucket files on each node, 0 is unlimited")
+		fs.Parse(args[1:])
+
+		if fs.NArg() < 1 {
+			fmt.Println("specify the bucket name")
+			os.Exit(1)
+		}
+
+		bucket := sfs.Bucket{Name: fs.Arg(0), MaxBytes: *maxBytes, MaxObjects: *maxObjects}
+		if err := client.CreateBucket(ctx, bucket); err != nil {
+			fmt.Printf("error while creating the bucket: %s\n", err)
+			os.Exit(1)
+		}
+	case "list":
+		buckets, err := client.ListBuckets(ctx)
+		for _, b := range buckets {
+			fmt.Printf("%s: %d bytes, %d files (max %d bytes, %d files per node)\n", b.Name, b.Bytes, b.Objects, b.MaxBytes, b.MaxObjects)
+		}
+		if err != nil {
+			fmt.Printf("error while listing the buckets: %s\n", err)
+			os.Exit(1)
+		}
+	case "delete":
+		if len(args) < 2 {
+			fmt.Println("specify the bucket name")
+			os.Exit(1)
+		}
+
+		if err := client.DeleteBucket(ctx, args[1]); err != nil {
+			fmt.Printf("error while deleting the bucket: %s\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Println("unknown bucket operation")
+		os.Exit(1)
+	}
+}
@@ -2,28 +2,117 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tymbaca/sfs/internal/files"
 	sfs "github.com/tymbaca/sfs/pkg/client"
 	"github.com/tymbaca/sfs/pkg/mem"
+	"github.com/tymbaca/sfs/pkg/meta"
+	"github.com/tymbaca/sfs/pkg/qos"
 )
 
-const addrsEnv = "SFS_ADDRS"
+const (
+	addrsEnv    = "SFS_ADDRS"
+	seedEnv     = "SFS_SEED"
+	replicasEnv = "SFS_REPLICAS"
+	metaEnv     = "SFS_META"
+	kekEnv      = "SFS_KEK"
+	classEnv    = "SFS_CLASS"
+	rateEnv     = "SFS_RATE"
+	bucketEnv   = "SFS_BUCKET"
+)
 
 func main() {
 	ctx := context.Background()
 	addrs := os.Getenv(addrsEnv)
-	if strings.TrimSpace(addrs) == "" {
-		fmt.Printf("set server nodes addresses in env var %s\n", addrsEnv)
+	seed := os.Getenv(seedEnv)
+	if strings.TrimSpace(addrs) == "" && strings.TrimSpace(seed) == "" {
+		fmt.Printf("set server nodes addresses in env var %s or the seed node in %s\n", addrsEnv, seedEnv)
 		os.Exit(1)
 	}
 
-	client := sfs.NewClient(addrs, 64*mem.MiB)
+	replicas, _ := strconv.Atoi(os.Getenv(replicasEnv))
+	opts := []sfs.Option{sfs.WithReplicas(replicas), progressOption()}
+	if metaAddrs := os.Getenv(metaEnv); strings.TrimSpace(metaAddrs) != "" {
+		opts = append(opts, sfs.WithMeta(meta.NewClient(metaAddrs)))
+	}
+	if kekHex := os.Getenv(kekEnv); strings.TrimSpace(kekHex) != "" {
+		kek, err := hex.DecodeString(strings.TrimSpace(kekHex))
+		if err != nil {
+			fmt.Printf("invalid key-encryption key in %s: %s\n", kekEnv, err)
+			os.Exit(1)
+		}
+		opts = append(opts, sfs.WithEncryption(kek))
+	}
+	if className := os.Getenv(classEnv); strings.TrimSpace(className) != "" {
+		class, err := qos.ParseClass(strings.TrimSpace(className))
+		if err != nil {
+			fmt.Printf("invalid class in %s: %s\n", classEnv, err)
+			os.Exit(1)
+		}
+		ctx = qos.WithClass(ctx, class)
+	}
+	// the same limit for uploads and downloads, in bytes per second
+	if rate, _ := strconv.ParseInt(os.Getenv(rateEnv), 10, 64); rate > 0 {
+		opts = append(opts, sfs.WithBandwidth(sfs.Bandwidth{Upload: rate, Download: rate}))
+	}
+
+	// upload flags go before its arguments
+	var md sfs.Metadata
+	for len(os.Args) > 2 && os.Args[1] == "upload" && strings.HasPrefix(os.Args[2], "--") {
+		flag, value, _ := strings.Cut(os.Args[2], "=")
+		switch flag {
+		case "--resume":
+			// the checkpoint is kept in the user cache dir, so the rerun
+			// after the crash uploads only the missing chunks
+			cacheDir, err := os.UserCacheDir()
+			if err != nil {
+				cacheDir = os.TempDir()
+			}
+			opts = append(opts, sfs.WithResume(filepath.Join(cacheDir, "sfs", "checkpoints")))
+		case "--type":
+			md.ContentType = value
+		case "--tag":
+			k, v, _ := strings.Cut(value, "=")
+			if md.Tags == nil {
+				md.Tags = make(map[string]string)
+			}
+			md.Tags[k] = v
+		default:
+			fmt.Printf("unknown upload flag: %s\n", flag)
+			os.Exit(1)
+		}
+		os.Args = slices.Delete(os.Args, 2, 3)
+	}
+	var uploadOpts []sfs.UploadOption
+	if md.ContentType != "" || md.Tags != nil {
+		uploadOpts = append(uploadOpts, sfs.WithMetadata(md))
+	}
+
+	var client *sfs.Client
+	if strings.TrimSpace(addrs) != "" {
+		client = sfs.NewClient(addrs, 64*mem.MiB, opts...)
+	} else {
+		var err error
+		client, err = sfs.Bootstrap(ctx, seed, 64*mem.MiB, opts...)
+		if err != nil {
+			fmt.Printf("can't bootstrap from the seed node: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if bucket := strings.TrimSpace(os.Getenv(bucketEnv)); bucket != "" {
+		client = client.Bucket(bucket)
+	}
 
 	if len(os.Args) < 2 {
 		fmt.Println("specify the operation")
@@ -40,13 +129,27 @@ func main() {
 		}
 		pathToFile := os.Args[2]
 
+		// "-" is the stdin, which size is unknown
+		if pathToFile == "-" {
+			if len(os.Args) < 4 {
+				fmt.Println("specify the name of the file read from stdin")
+				os.Exit(1)
+			}
+
+			if err := client.UploadStream(ctx, os.Args[3], os.Stdin, uploadOpts...); err != nil {
+				fmt.Printf("error while uploading: %s\n", err)
+				os.Exit(1)
+			}
+			break
+		}
+
 		f, err := os.Open(pathToFile)
 		if err != nil {
 			fmt.Printf("can't open the file: %s\n", err)
 			os.Exit(1)
 		}
 
-		err = client.UploadFile(ctx, path.Base(pathToFile), f)
+		err = client.UploadFile(ctx, path.Base(pathToFile), f, uploadOpts...)
 		if err != nil {
 			fmt.Printf("error while uploading: %s\n", err)
 			os.Exit(1)
@@ -82,8 +185,78 @@ func main() {
 			fmt.Printf("error while downloading: final size dismatch: expected %d, got %d %s\n", expectedSize, actualSize, err)
 			os.Exit(1)
 		}
+	case "stat":
+		if len(os.Args) < 3 {
+			fmt.Println("specify the filename")
+			os.Exit(1)
+		}
+
+		info, err := client.Stat(ctx, os.Args[2])
+		if err != nil {
+			fmt.Printf("error while getting the file info: %s\n", err)
+			os.Exit(1)
+		}
+		printInfo(info)
+	case "list":
+		filter, err := parseFilter(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		infos, err := client.List(ctx, filter)
+		if err != nil {
+			fmt.Printf("error while listing the files: %s\n", err)
+			os.Exit(1)
+		}
+		for _, info := range infos {
+			printInfo(info)
+		}
+	case "bucket":
+		bucketCmd(ctx, client, os.Args[2:])
 
 	default:
 		fmt.Println("unknown operation")
 	}
 }
+
+func printInfo(info sfs.FileInfo) {
+	fmt.Printf("%s\t%d\t%s\t%s", info.Name, info.Size, info.ETag, info.ContentType)
+	if !info.CreatedAt.IsZero() {
+		fmt.Printf("\t%s", info.CreatedAt.Format(time.RFC3339))
+	}
+
+	keys := make([]string, 0, len(info.Tags))
+	for k := range info.Tags {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Printf("\t%s=%s", k, info.Tags[k])
+	}
+	fmt.Println()
+}
+
+// parseFilter parses 'list [--type=<type>] [--tag=<key>=<value>]... [prefix]'.
+func parseFilter(args []string) (sfs.Filter, error) {
+	var filter sfs.Filter
+	for _, arg := range args {
+		flag, value, _ := strings.Cut(arg, "=")
+		switch {
+		case flag == "--type":
+			filter.ContentType = value
+		case flag == "--tag":
+			k, v, _ := strings.Cut(value, "=")
+			if filter.Tags == nil {
+				filter.Tags = make(map[string]string)
+			}
+			filter.Tags[k] = v
+		case strings.HasPrefix(arg, "--"):
+			return sfs.Filter{}, fmt.Errorf("unknown list flag: %s", flag)
+		default:
+			filter.Prefix = arg
+		}
+	}
+
+	return filter, nil
+}
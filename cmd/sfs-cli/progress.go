@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	sfs "github.com/tymbaca/sfs/pkg/client"
+	"github.com/tymbaca/sfs/pkg/mem"
+)
+
+const barWidth = 30
+
+// progressOption renders the progress bar to stderr when it's a terminal,
+// otherwise prints the line every few seconds, as for CI logs.
+func progressOption() sfs.Option {
+	stat, err := os.Stderr.Stat()
+	if err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		return sfs.WithProgress(200*time.Millisecond, func(p sfs.Progress) {
+			renderBar(os.Stderr, p)
+		})
+	}
+
+	return sfs.WithProgress(5*time.Second, func(p sfs.Progress) {
+		fmt.Fprintln(os.Stderr, progressLine(p))
+	})
+}
+
+func renderBar(w io.Writer, p sfs.Progress) {
+	filled := 0
+	if p.TotalBytes > 0 {
+		filled = int(min(p.Bytes, p.TotalBytes) * barWidth / p.TotalBytes)
+	}
+
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	fmt.Fprintf(w, "\r[%s] %s\033[K", bar, progressLine(p))
+	if p.Done {
+		fmt.Fprintln(w)
+	}
+}
+
+func progressLine(p sfs.Progress) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s: %s", p.Op, p.Name, size(p.Bytes))
+	if p.TotalBytes > 0 {
+		fmt.Fprintf(&b, "/%s (%d%%)", size(p.TotalBytes), p.Bytes*100/p.TotalBytes)
+	}
+
+	fmt.Fprintf(&b, ", chunks %d", p.Chunks)
+	if p.TotalChunks > 0 {
+		fmt.Fprintf(&b, "/%d", p.TotalChunks)
+	}
+
+	throughput := 0.0
+	for _, node := range p.Nodes {
+		throughput += node.Throughput
+	}
+	fmt.Fprintf(&b, ", %s/s", size(int64(throughput)))
+
+	if p.ETA > 0 {
+		fmt.Fprintf(&b, ", ETA %s", p.ETA.Round(time.Second))
+	}
+
+	return b.String()
+}
+
+func size(n int64) string {
+	switch {
+	case n >= mem.GiB:
+		return fmt.Sprintf("%.1f GiB", float64(n)/float64(mem.GiB))
+	case n >= mem.MiB:
+		return fmt.Sprintf("%.1f MiB", float64(n)/float64(mem.MiB))
+	case n >= mem.KiB:
+		return fmt.Sprintf("%.1f KiB", float64(n)/float64(mem.KiB))
+	}
+
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	sfs "github.com/tymbaca/sfs/pkg/client"
+	"github.com/tymbaca/sfs/pkg/davgw"
+	"github.com/tymbaca/sfs/pkg/httpgw"
+	"github.com/tymbaca/sfs/pkg/mem"
+	"github.com/tymbaca/sfs/pkg/meta"
+	"github.com/tymbaca/sfs/pkg/s3gw"
+)
+
+const (
+	addrsEnv    = "SFS_ADDRS"
+	seedEnv     = "SFS_SEED"
+	replicasEnv = "SFS_REPLICAS"
+	metaEnv     = "SFS_META"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:6900", "S3 API address")
+	httpAddr := flag.String("http", "", "plain HTTP API address, empty disables it")
+	davAddr := flag.String("dav", "", "WebDAV address, empty disables it")
+	spoolDir := flag.String("spool", "", "dir of objects and parts being uploaded, empty means the system temp dir")
+	uploadExpiry := flag.Duration("upload-expiry", s3gw.DefaultUploadExpiry, "time multipart uploads are kept after their last request")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	addrs := os.Getenv(addrsEnv)
+	seed := os.Getenv(seedEnv)
+	if strings.TrimSpace(addrs) == "" && strings.TrimSpace(seed) == "" {
+		log.Fatalf("set server nodes addresses in env var %s or the seed node in %s", addrsEnv, seedEnv)
+	}
+
+	replicas, _ := strconv.Atoi(os.Getenv(replicasEnv))
+	opts := []sfs.Option{sfs.WithReplicas(replicas)}
+	cfg := s3gw.Config{SpoolDir: *spoolDir, UploadExpiry: *uploadExpiry}
+	if metaAddrs := os.Getenv(metaEnv); strings.TrimSpace(metaAddrs) != "" {
+		opts = append(opts, sfs.WithMeta(meta.NewClient(metaAddrs)))
+		cfg.Metadata = true
+	}
+
+	var client *sfs.Client
+	if strings.TrimSpace(addrs) != "" {
+		client = sfs.NewClient(addrs, 64*mem.MiB, opts...)
+	} else {
+		var err error
+		client, err = sfs.Bootstrap(ctx, seed, 64*mem.MiB, opts...)
+		if err != nil {
+			log.Fatalf("can't bootstrap from the seed node: %s", err)
+		}
+	}
+	go client.RunHealthCheck(ctx, 10*time.Second)
+
+	if *httpAddr != "" {
+		httpSrv := &http.Server{Addr: *httpAddr, Handler: httpgw.New(client)}
+		go shutdownOnDone(ctx, httpSrv)
+		go func() {
+			fmt.Println("started HTTP gateway on addr:", *httpAddr)
+			if err := httpSrv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if *davAddr != "" {
+		davHandler, err := davgw.New(client, davgw.Config{SpoolDir: *spoolDir})
+		if err != nil {
+			log.Fatalf("can't start WebDAV gateway: %s, set %s", err, metaEnv)
+		}
+
+		davSrv := &http.Server{Addr: *davAddr, Handler: davHandler}
+		go shutdownOnDone(ctx, davSrv)
+		go func() {
+			fmt.Println("started WebDAV gateway on addr:", *davAddr)
+			if err := davSrv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	srv := &http.Server{Addr: *addr, Handler: s3gw.New(client, cfg).Handler()}
+	go shutdownOnDone(ctx, srv)
+
+	fmt.Println("started S3 gateway on addr:", *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+func shutdownOnDone(ctx context.Context, srv *http.Server) {
+	<-ctx.Done()
+	srv.Shutdown(context.Background())
+}
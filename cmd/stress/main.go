@@ -12,10 +12,12 @@ import (
 	"github.com/tymbaca/sfs/internal/logger"
 	sfs_client "github.com/tymbaca/sfs/pkg/client"
 	"github.com/tymbaca/sfs/pkg/mem"
+	"github.com/tymbaca/sfs/pkg/qos"
 )
 
 func main() {
-	ctx := context.Background()
+	// the load must not starve interactive requests of the nodes
+	ctx := qos.WithClass(context.Background(), qos.Batch)
 
 	f, err := os.Open("cmd/input/random-8gb")
 	if err != nil {
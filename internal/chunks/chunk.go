@@ -9,8 +9,13 @@ import (
 type Chunk struct {
 	ID       uint64
 	Filename string
-	Size     uint64
-	Body     io.Reader
+	// Size is the size of the chunk data, uncompressed.
+	Size uint64
+	Body io.Reader
+	// Codec is the compression of Body. BodySize is the size of compressed
+	// Body, it's used only when Codec is not [CodecNone].
+	Codec    Codec
+	BodySize uint64
 }
 
 const chunkFmt = `{
@@ -26,7 +31,12 @@ func (ch Chunk) String() string {
 
 func SendChunk(w io.Writer, chunk Chunk) error {
 	// we need len of bytes, not len of utf-8 symbols, so we use [len]
-	if err := binary.Write(w, binary.LittleEndian, uint64(len(chunk.Filename))); err != nil {
+	filenameSize := uint64(len(chunk.Filename))
+	if chunk.Codec != CodecNone {
+		filenameSize |= ExtFlag
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, filenameSize); err != nil {
 		return fmt.Errorf("can't write filename size: %w", err)
 	}
 
@@ -42,6 +52,12 @@ func SendChunk(w io.Writer, chunk Chunk) error {
 		return fmt.Errorf("can't write chunk size: %w", err)
 	}
 
+	if chunk.Codec != CodecNone {
+		if err := binary.Write(w, binary.LittleEndian, [2]uint64{uint64(chunk.Codec), chunk.BodySize}); err != nil {
+			return fmt.Errorf("can't write chunk codec: %w", err)
+		}
+	}
+
 	if _, err := io.Copy(w, chunk.Body); err != nil {
 		return fmt.Errorf("can't write chunk body: %w", err)
 	}
@@ -55,6 +71,9 @@ func RecvChunk(r io.Reader) (Chunk, error) {
 		return Chunk{}, fmt.Errorf("can't read filename size from chunk: %w", err)
 	}
 
+	ext := filenameSize&ExtFlag != 0
+	filenameSize &^= ExtFlag
+
 	filename := make([]byte, filenameSize)
 	_, err := io.ReadFull(r, filename)
 	if err != nil {
@@ -71,10 +90,21 @@ func RecvChunk(r io.Reader) (Chunk, error) {
 		return Chunk{}, fmt.Errorf("can't read body size from chunk: %w", err)
 	}
 
-	return Chunk{
+	chunk := Chunk{
 		ID:       id,
 		Filename: string(filename),
 		Size:     bodySize,
-		Body:     io.LimitReader(r, int64(bodySize)), // to not suck in next chunks
-	}, nil
+	}
+
+	if ext {
+		var codec [2]uint64
+		if err := binary.Read(r, binary.LittleEndian, &codec); err != nil {
+			return Chunk{}, fmt.Errorf("can't read codec from chunk: %w", err)
+		}
+		chunk.Codec, chunk.BodySize = Codec(codec[0]), codec[1]
+		bodySize = chunk.BodySize
+	}
+
+	chunk.Body = io.LimitReader(r, int64(bodySize)) // to not suck in next chunks
+	return chunk, nil
 }
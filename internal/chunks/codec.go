@@ -0,0 +1,103 @@
+package chunks
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec is the compression of the chunk body.
+type Codec uint64
+
+const (
+	CodecNone Codec = iota
+	CodecZstd
+	CodecS2
+)
+
+// Codecs are all the supported codecs, except [CodecNone].
+var Codecs = []Codec{CodecZstd, CodecS2}
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecZstd:
+		return "zstd"
+	case CodecS2:
+		return "s2"
+	}
+
+	return fmt.Sprintf("codec(%d)", uint64(c))
+}
+
+// ExtFlag is set in the filename size of the chunk frame and of the receive
+// chunk request when they have the extension with codec fields (see README).
+// Old clients never set it, so the frames stay compatible.
+const ExtFlag = uint64(1) << 63
+
+// AcceptMask returns the bitmask of codecs the receiver of chunk accepts.
+func AcceptMask(codecs ...Codec) uint64 {
+	var mask uint64
+	for _, c := range codecs {
+		mask |= 1 << c
+	}
+
+	return mask
+}
+
+// Accepts checks whether the codec is in the mask. [CodecNone] is always
+// accepted.
+func Accepts(mask uint64, c Codec) bool {
+	return c == CodecNone || mask&(1<<c) != 0
+}
+
+// Compress reads r to the end and returns its data compressed with codec.
+func Compress(codec Codec, r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch codec {
+	case CodecZstd:
+		enc, err := zstd.NewWriter(&buf, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		w = enc
+	case CodecS2:
+		w = s2.NewWriter(&buf, s2.WriterConcurrency(1))
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", codec)
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decompress returns the reader of the data decompressed from r.
+func Decompress(codec Codec, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case CodecNone:
+		return io.NopCloser(r), nil
+	case CodecZstd:
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case CodecS2:
+		return io.NopCloser(s2.NewReader(r)), nil
+	}
+
+	return nil, fmt.Errorf("unsupported codec: %s", codec)
+}
@@ -0,0 +1,36 @@
+package chunks
+
+import "slices"
+
+// IsContinuous reports whether m contains exactly the ids 0, 1, 2 ... len(m)-1.
+func IsContinuous[V any](m map[uint64]V) bool {
+	for i := range len(m) {
+		_, ok := m[uint64(i)]
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Gaps returns the ids missing in the 0..max(ids) sequence.
+func Gaps(ids []uint64) []uint64 {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	set := make(map[uint64]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+
+	var gaps []uint64
+	for i := range slices.Max(ids) {
+		if _, ok := set[i]; !ok {
+			gaps = append(gaps, i)
+		}
+	}
+
+	return gaps
+}
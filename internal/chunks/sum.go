@@ -0,0 +1,44 @@
+package chunks
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+)
+
+// Sum is the checksum of the stored chunk.
+type Sum struct {
+	ID   uint64
+	Size uint64
+	CRC  uint32 // CRC-32C
+}
+
+// Replica is the state of the chunk on some node, repair compares them: the
+// checksum of the chunk with the time it was written, or the time it was
+// deleted.
+type Replica struct {
+	Sum
+	Stamp   time.Time
+	Deleted bool
+}
+
+// FileSum summarizes all the chunks of the file some node holds.
+type FileSum struct {
+	Name string
+	Hash [sha256.Size]byte
+}
+
+// HashSums returns the hash over the sums, which must be sorted by ID. Two
+// nodes holding the same chunks get the same hash.
+func HashSums(sums []Sum) [sha256.Size]byte {
+	h := sha256.New()
+	for _, s := range sums {
+		binary.Write(h, binary.LittleEndian, s.ID)
+		binary.Write(h, binary.LittleEndian, s.Size)
+		binary.Write(h, binary.LittleEndian, uint64(s.CRC))
+	}
+
+	var res [sha256.Size]byte
+	h.Sum(res[:0])
+	return res
+}
@@ -7,4 +7,9 @@ const (
 	NotFound   Code = 20
 	InvalidReq Code = 21
 	Internal   Code = 30
+	// NoSpace is returned when the node has no space for the chunk.
+	NoSpace Code = 31
+	// Busy is returned when the node is overloaded, the request may be retried
+	// later.
+	Busy Code = 40
 )
@@ -0,0 +1,17 @@
+package common
+
+import "context"
+
+type bucketKey struct{}
+
+// WithBucket returns ctx of the requests to the bucket. Empty name is the
+// default bucket.
+func WithBucket(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, bucketKey{}, name)
+}
+
+// BucketFrom returns the bucket of ctx, empty for the default one.
+func BucketFrom(ctx context.Context) string {
+	name, _ := ctx.Value(bucketKey{}).(string)
+	return name
+}
@@ -2,4 +2,11 @@ package common
 
 import "errors"
 
-var ErrNotFound = errors.New("resource not found")
+var (
+	ErrNotFound = errors.New("resource not found")
+	// ErrBusy is returned when the node rejected the request because it's
+	// overloaded. The request may be retried later.
+	ErrBusy = errors.New("node is busy")
+	// ErrNoSpace is returned when the node has no space for the chunk.
+	ErrNoSpace = errors.New("no space left")
+)
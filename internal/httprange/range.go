@@ -0,0 +1,58 @@
+// Package httprange parses the Range header of the gateways.
+package httprange
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsatisfiable is returned if the range starts past the end of the file.
+var ErrUnsatisfiable = errors.New("range not satisfiable")
+
+// Parse returns the range of the Range header of the file of size. Multiple
+// and malformed ranges are ignored, as HTTP allows, so the whole file is
+// returned and partial is false.
+func Parse(header string, size int64) (offset, length int64, partial bool, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, size, false, nil
+	}
+
+	if first == "" {
+		// the suffix of the file
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, ErrUnsatisfiable
+		}
+		n = min(n, size)
+		return size - n, n, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, false, nil
+	}
+
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, size, false, nil
+		}
+		end = min(end, size-1)
+	}
+
+	if start >= size {
+		return 0, 0, false, ErrUnsatisfiable
+	}
+
+	return start, end - start + 1, true, nil
+}
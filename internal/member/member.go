@@ -0,0 +1,132 @@
+// Package member holds the cluster member type shared by the server
+// membership subsystem, transport and clients.
+package member
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+)
+
+type State uint64
+
+const (
+	Alive State = iota
+	Suspect
+	Dead
+	Left
+)
+
+func (s State) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	case Left:
+		return "left"
+	}
+
+	return "unknown"
+}
+
+type Member struct {
+	Addr string
+	// Heartbeat is incremented by the member itself. Higher heartbeat means
+	// fresher information.
+	Heartbeat uint64
+	State     State
+	// Joined is when the member joined the cluster first, in unix
+	// nanoseconds. It orders the members, so it never changes: the earliest
+	// known value wins.
+	Joined uint64
+}
+
+// Supersedes reports whether m is fresher than other information about the
+// same member. With the same heartbeat the worse state wins, so failures and
+// leaves spread until the member refutes them with a higher heartbeat.
+func (m Member) Supersedes(other Member) bool {
+	if m.Heartbeat != other.Heartbeat {
+		return m.Heartbeat > other.Heartbeat
+	}
+
+	return m.State > other.State
+}
+
+// Sort sorts members in the placement order: seeds in the given order, then
+// the others by join time. Joining members come last, so the order of the
+// cluster seeds, which clients are configured with, never changes.
+func Sort(members []Member, seeds []string) {
+	rank := func(m Member) int {
+		if i := slices.Index(seeds, m.Addr); i >= 0 {
+			return i
+		}
+		return len(seeds)
+	}
+
+	slices.SortFunc(members, func(a, b Member) int {
+		return cmp.Or(
+			cmp.Compare(rank(a), rank(b)),
+			cmp.Compare(a.Joined, b.Joined),
+			cmp.Compare(a.Addr, b.Addr),
+		)
+	})
+}
+
+// Write writes members as '<count>[<addr_size><addr><heartbeat><state><joined>]'.
+func Write(w io.Writer, members []Member) error {
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(members))); err != nil {
+		return fmt.Errorf("can't write members count: %w", err)
+	}
+
+	for _, m := range members {
+		// we need len of bytes, not len of utf-8 symbols, so we use [len]
+		if err := binary.Write(w, binary.LittleEndian, uint64(len(m.Addr))); err != nil {
+			return fmt.Errorf("can't write addr size: %w", err)
+		}
+
+		if _, err := w.Write([]byte(m.Addr)); err != nil {
+			return fmt.Errorf("can't write addr: %w", err)
+		}
+
+		if err := binary.Write(w, binary.LittleEndian, [3]uint64{m.Heartbeat, uint64(m.State), m.Joined}); err != nil {
+			return fmt.Errorf("can't write member: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Read reads members written by [Write].
+func Read(r io.Reader) ([]Member, error) {
+	var count uint64
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, fmt.Errorf("can't read members count: %w", err)
+	}
+
+	members := make([]Member, 0, min(count, 1024))
+	for i := range count {
+		var addrSize uint64
+		if err := binary.Read(r, binary.LittleEndian, &addrSize); err != nil {
+			return nil, fmt.Errorf("can't read the #%d addr size: %w", i, err)
+		}
+
+		addr := make([]byte, addrSize)
+		if _, err := io.ReadFull(r, addr); err != nil {
+			return nil, fmt.Errorf("can't read the #%d addr: %w", i, err)
+		}
+
+		var raw [3]uint64 // heartbeat, state, joined
+		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("can't read the #%d member: %w", i, err)
+		}
+
+		members = append(members, Member{Addr: string(addr), Heartbeat: raw[0], State: State(raw[1]), Joined: raw[2]})
+	}
+
+	return members, nil
+}
@@ -0,0 +1,396 @@
+package metaserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	// opPin keeps the deduplicated chunks of the upload from being collected
+	opPin = "pin"
+	// opClaim marks the garbage chunk as being collected
+	opClaim = "claim"
+	// opCollect forgets the collected garbage chunk
+	opCollect = "collect"
+	// opPrune forgets the replaced version of the file
+	opPrune = "prune"
+)
+
+// pinRetention is how long the expired pin is kept. The upload which
+// outlived its pin may still commit until the chunk is claimed or the pin is
+// forgotten.
+const pinRetention = 24 * time.Hour
+
+// command is the raft log entry.
+type command struct {
+	Op   string     `json:"op"`
+	Name string     `json:"name,omitempty"`
+	File *meta.File `json:"file,omitempty"`
+	Hash string     `json:"hash,omitempty"`
+	// Hashes are the pinned chunks.
+	Hashes []string `json:"hashes,omitempty"`
+	// TTL is the time the chunks are pinned or claimed for.
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Version is the pruned version.
+	Version uint64 `json:"version,omitempty"`
+	// Time is set by the leader, so all replicas apply the same.
+	Time time.Time `json:"time"`
+}
+
+// fsm is the file catalog state machine. Replaced versions of versioned
+// files are kept until pruned. It also counts the references to deduplicated
+// chunks, the chunks without references become garbage.
+//
+// The upload pins the chunks before checking which ones the nodes have, the
+// collector claims the garbage chunk before deleting it. The chunk is not
+// claimed while pinned and not pinned while claimed, and the manifest is
+// committed only if its chunks are still referenced, garbage or pinned, so
+// the skipped chunk is never collected under the upload.
+type fsm struct {
+	mu         sync.RWMutex
+	files      map[string]meta.File
+	versions   map[string][]meta.Version // the oldest first
+	refs       map[string]int            // derived from files and versions
+	garbage    map[string]meta.Garbage
+	pins       map[string]time.Time // pinned until
+	collecting map[string]claim
+}
+
+// claim is the garbage chunk being collected. It's garbage again after the
+// lease, e.g. if the collector died.
+type claim struct {
+	meta.Garbage
+	Until time.Time `json:"until"`
+}
+
+func newFSM() *fsm {
+	return &fsm{
+		files:      make(map[string]meta.File),
+		versions:   make(map[string][]meta.Version),
+		refs:       make(map[string]int),
+		garbage:    make(map[string]meta.Garbage),
+		pins:       make(map[string]time.Time),
+		collecting: make(map[string]claim),
+	}
+}
+
+func (f *fsm) Apply(l *raft.Log) any {
+	var cmd command
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return fmt.Errorf("can't unmarshal command: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch cmd.Op {
+	case opPut:
+		if err := f.checkCollected(*cmd.File); err != nil {
+			return err
+		}
+		// references are added first, so the chunks shared by the old and
+		// new versions never become garbage
+		f.ref(*cmd.File)
+		if old, ok := f.files[cmd.File.Name]; ok {
+			f.replace(old, cmd.File.Version, cmd.Time)
+		}
+		f.files[cmd.File.Name] = *cmd.File
+	case opDelete:
+		old, ok := f.files[cmd.Name]
+		if !ok {
+			return meta.ErrNotFound
+		}
+		f.replace(old, 0, cmd.Time)
+		delete(f.files, cmd.Name)
+	case opPrune:
+		versions := f.versions[cmd.Name]
+		i := slices.IndexFunc(versions, func(v meta.Version) bool { return v.Version == cmd.Version })
+		if i < 0 {
+			return meta.ErrNotFound
+		}
+		f.unref(versions[i].File, cmd.Time)
+		if versions = slices.Delete(versions, i, i+1); len(versions) == 0 {
+			delete(f.versions, cmd.Name)
+		} else {
+			f.versions[cmd.Name] = versions
+		}
+	case opPin:
+		for _, hash := range cmd.Hashes {
+			if c, ok := f.collecting[hash]; ok && cmd.Time.Before(c.Until) {
+				return meta.ErrCollected
+			}
+		}
+		f.purgePins(cmd.Time)
+		until := cmd.Time.Add(cmd.TTL)
+		for _, hash := range cmd.Hashes {
+			if until.After(f.pins[hash]) {
+				f.pins[hash] = until
+			}
+		}
+	case opClaim:
+		g, ok := f.garbage[cmd.Hash]
+		if c, claimed := f.collecting[cmd.Hash]; claimed && !cmd.Time.Before(c.Until) {
+			g, ok = c.Garbage, true
+		}
+		if until, pinned := f.pins[cmd.Hash]; !ok || pinned && cmd.Time.Before(until) {
+			return meta.ErrNotFound
+		}
+		delete(f.garbage, cmd.Hash)
+		delete(f.pins, cmd.Hash)
+		f.collecting[cmd.Hash] = claim{Garbage: g, Until: cmd.Time.Add(cmd.TTL)}
+	case opCollect:
+		if _, ok := f.collecting[cmd.Hash]; !ok {
+			return meta.ErrNotFound
+		}
+		delete(f.collecting, cmd.Hash)
+	default:
+		return fmt.Errorf("unknown command: '%s'", cmd.Op)
+	}
+
+	return nil
+}
+
+// checkCollected returns [meta.ErrCollected] if some deduplicated chunk of the
+// file may be collected: it's not referenced, not garbage and its pin was
+// dropped by the claim or never taken.
+func (f *fsm) checkCollected(file meta.File) error {
+	for _, chk := range file.Chunks {
+		if chk.Hash == "" || f.refs[chk.Hash] > 0 {
+			continue
+		}
+		if _, ok := f.garbage[chk.Hash]; ok {
+			continue
+		}
+		// the expired pin is still good while the chunk is not claimed
+		if _, ok := f.pins[chk.Hash]; ok {
+			continue
+		}
+
+		return fmt.Errorf("%w: %s", meta.ErrCollected, chk.Hash)
+	}
+
+	return nil
+}
+
+// purgePins forgets the pins expired long ago, the upload holding them must
+// have failed.
+func (f *fsm) purgePins(now time.Time) {
+	for hash, until := range f.pins {
+		if now.Sub(until) > pinRetention {
+			delete(f.pins, hash)
+		}
+	}
+}
+
+// replace keeps the replaced versioned file, the unversioned one is dropped,
+// as well as the same version put again.
+func (f *fsm) replace(old meta.File, version uint64, now time.Time) {
+	if old.Version == 0 || old.Version == version {
+		f.unref(old, now)
+		return
+	}
+
+	f.versions[old.Name] = append(f.versions[old.Name], meta.Version{File: old, ReplacedAt: now})
+}
+
+func (f *fsm) ref(file meta.File) {
+	for _, chk := range file.Chunks {
+		if chk.Hash != "" {
+			f.refs[chk.Hash]++
+			delete(f.garbage, chk.Hash)
+			delete(f.pins, chk.Hash)
+		}
+	}
+}
+
+func (f *fsm) unref(file meta.File, now time.Time) {
+	for _, chk := range file.Chunks {
+		if chk.Hash == "" {
+			continue
+		}
+
+		f.refs[chk.Hash]--
+		if f.refs[chk.Hash] <= 0 {
+			delete(f.refs, chk.Hash)
+			f.garbage[chk.Hash] = meta.Garbage{Hash: chk.Hash, Size: chk.Size, Nodes: chk.Nodes, Since: now}
+		}
+	}
+}
+
+func (f *fsm) get(name string) (meta.File, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	file, ok := f.files[name]
+	return file, ok
+}
+
+// getVersion returns the version of the file, current or replaced.
+func (f *fsm) getVersion(name string, version uint64) (meta.File, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if file, ok := f.files[name]; ok && file.Version == version {
+		return file, true
+	}
+
+	for _, v := range f.versions[name] {
+		if v.Version == version {
+			return v.File, true
+		}
+	}
+
+	return meta.File{}, false
+}
+
+func (f *fsm) list(prefix string) []meta.File {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	files := make([]meta.File, 0)
+	for name, file := range f.files {
+		if strings.HasPrefix(name, prefix) {
+			files = append(files, file)
+		}
+	}
+
+	slices.SortFunc(files, func(a, b meta.File) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return files
+}
+
+func (f *fsm) listVersions(prefix string) []meta.Version {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	versions := make([]meta.Version, 0)
+	for name, vs := range f.versions {
+		if strings.HasPrefix(name, prefix) {
+			versions = append(versions, vs...)
+		}
+	}
+
+	// stable, so the versions of each file stay the oldest first
+	slices.SortStableFunc(versions, func(a, b meta.Version) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return versions
+}
+
+func (f *fsm) listGarbage() []meta.Garbage {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	garbage := make([]meta.Garbage, 0, len(f.garbage))
+	for _, g := range f.garbage {
+		garbage = append(garbage, g)
+	}
+	// the collector of these died
+	for _, c := range f.collecting {
+		if time.Now().After(c.Until) {
+			garbage = append(garbage, c.Garbage)
+		}
+	}
+
+	slices.SortFunc(garbage, func(a, b meta.Garbage) int {
+		return strings.Compare(a.Hash, b.Hash)
+	})
+
+	return garbage
+}
+
+// state is the snapshot content. Reference counts are recomputed on restore.
+type state struct {
+	Files      map[string]meta.File      `json:"files"`
+	Versions   map[string][]meta.Version `json:"versions"`
+	Garbage    map[string]meta.Garbage   `json:"garbage"`
+	Pins       map[string]time.Time      `json:"pins"`
+	Collecting map[string]claim          `json:"collecting"`
+}
+
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	data, err := json.Marshal(state{
+		Files:      f.files,
+		Versions:   f.versions,
+		Garbage:    f.garbage,
+		Pins:       f.pins,
+		Collecting: f.collecting,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot(data), nil
+}
+
+func (f *fsm) Restore(r io.ReadCloser) error {
+	defer r.Close()
+
+	var st state
+	if err := json.NewDecoder(r).Decode(&st); err != nil {
+		return fmt.Errorf("can't decode snapshot: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.files = make(map[string]meta.File, len(st.Files))
+	f.versions = make(map[string][]meta.Version, len(st.Versions))
+	f.refs = make(map[string]int)
+	f.garbage = make(map[string]meta.Garbage, len(st.Garbage))
+	maps.Copy(f.garbage, st.Garbage)
+	f.pins = make(map[string]time.Time, len(st.Pins))
+	maps.Copy(f.pins, st.Pins)
+	f.collecting = make(map[string]claim, len(st.Collecting))
+	maps.Copy(f.collecting, st.Collecting)
+	for name, file := range st.Files {
+		f.files[name] = file
+		f.countRefs(file)
+	}
+	for name, vs := range st.Versions {
+		f.versions[name] = vs
+		for _, v := range vs {
+			f.countRefs(v.File)
+		}
+	}
+
+	return nil
+}
+
+func (f *fsm) countRefs(file meta.File) {
+	for _, chk := range file.Chunks {
+		if chk.Hash != "" {
+			f.refs[chk.Hash]++
+		}
+	}
+}
+
+type snapshot []byte
+
+func (s snapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+
+	return sink.Close()
+}
+
+func (s snapshot) Release() {}
@@ -0,0 +1,365 @@
+// Package metaserver is the replica of the metadata service. The file catalog
+// is the raft-replicated state machine, the API is HTTP with JSON bodies (see
+// pkg/meta for the client).
+package metaserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+const applyTimeout = 5 * time.Second
+
+type Config struct {
+	// APIAddr is the HTTP API address as clients see it. It's also the raft
+	// server ID, so followers can point clients to the leader.
+	APIAddr string
+	// RaftAddr is the address raft listens on. Port 0 means random port.
+	RaftAddr string
+	// Dir keeps the raft log and snapshots. Empty means in memory.
+	Dir string
+	// Join is the API address of any replica of the existing cluster. Empty
+	// means the new cluster is bootstrapped with this node only.
+	Join string
+	// HeartbeatTimeout tunes raft timeouts. Zero means raft defaults.
+	HeartbeatTimeout time.Duration
+}
+
+type Node struct {
+	cfg   Config
+	raft  *raft.Raft
+	fsm   *fsm
+	trans *raft.NetworkTransport
+}
+
+func NewNode(ctx context.Context, cfg Config) (*Node, error) {
+	rcfg := raft.DefaultConfig()
+	rcfg.LocalID = raft.ServerID(cfg.APIAddr)
+	rcfg.Logger = hclog.New(&hclog.LoggerOptions{Name: "meta-raft", Level: hclog.Warn, Output: raftLogOutput()})
+	if cfg.HeartbeatTimeout > 0 {
+		rcfg.HeartbeatTimeout = cfg.HeartbeatTimeout
+		rcfg.ElectionTimeout = cfg.HeartbeatTimeout
+		rcfg.LeaderLeaseTimeout = cfg.HeartbeatTimeout / 2
+		rcfg.CommitTimeout = cfg.HeartbeatTimeout / 10
+	}
+
+	trans, err := raft.NewTCPTransport(cfg.RaftAddr, nil, 3, 10*time.Second, raftLogOutput())
+	if err != nil {
+		return nil, fmt.Errorf("can't create raft transport: %w", err)
+	}
+
+	logs, stable, snaps, err := newStores(cfg.Dir)
+	if err != nil {
+		trans.Close()
+		return nil, err
+	}
+
+	f := newFSM()
+	r, err := raft.NewRaft(rcfg, f, logs, stable, snaps, trans)
+	if err != nil {
+		trans.Close()
+		return nil, fmt.Errorf("can't create raft: %w", err)
+	}
+
+	n := &Node{cfg: cfg, raft: r, fsm: f, trans: trans}
+
+	hasState, err := raft.HasExistingState(logs, stable, snaps)
+	if err != nil {
+		n.Shutdown()
+		return nil, err
+	}
+
+	switch {
+	case hasState:
+		// restarted replica, it knows the cluster
+	case cfg.Join == "":
+		err = r.BootstrapCluster(raft.Configuration{
+			Servers: []raft.Server{{ID: rcfg.LocalID, Address: trans.LocalAddr()}},
+		}).Error()
+	default:
+		err = meta.NewClient(cfg.Join).Join(ctx, cfg.APIAddr, string(trans.LocalAddr()))
+	}
+	if err != nil {
+		n.Shutdown()
+		return nil, fmt.Errorf("can't join the cluster: %w", err)
+	}
+
+	return n, nil
+}
+
+func newStores(dir string) (raft.LogStore, raft.StableStore, raft.SnapshotStore, error) {
+	if dir == "" {
+		store := raft.NewInmemStore()
+		return store, store, raft.NewInmemSnapshotStore(), nil
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, nil, nil, fmt.Errorf("can't create data dir: %w", err)
+	}
+
+	store, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft.db"))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("can't open raft store: %w", err)
+	}
+
+	snaps, err := raft.NewFileSnapshotStore(dir, 2, raftLogOutput())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("can't open snapshot store: %w", err)
+	}
+
+	return store, store, snaps, nil
+}
+
+func raftLogOutput() io.Writer {
+	if logger.Enabled {
+		return os.Stderr
+	}
+
+	return io.Discard
+}
+
+// Shutdown stops the replica. The rest of the cluster elects the new leader
+// if it was the leader.
+func (n *Node) Shutdown() error {
+	err := n.raft.Shutdown().Error()
+	n.trans.Close()
+	return err
+}
+
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// Serve serves the API on lis until ctx is done.
+func (n *Node) Serve(ctx context.Context, lis net.Listener) error {
+	srv := &http.Server{Handler: n.Handler()}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	err := srv.Serve(lis)
+	if errors.Is(err, http.ErrServerClosed) {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /files", n.handleList)
+	mux.HandleFunc("GET /files/{name...}", n.handleGet)
+	mux.HandleFunc("PUT /files/{name...}", n.handlePut)
+	mux.HandleFunc("DELETE /files/{name...}", n.handleDelete)
+	mux.HandleFunc("GET /versions", n.handleListVersions)
+	mux.HandleFunc("DELETE /versions/{version}/{name...}", n.handleDeleteVersion)
+	mux.HandleFunc("GET /garbage", n.handleListGarbage)
+	mux.HandleFunc("POST /garbage/{hash}/claim", n.handleClaimGarbage)
+	mux.HandleFunc("DELETE /garbage/{hash}", n.handleDeleteGarbage)
+	mux.HandleFunc("POST /pins", n.handlePin)
+	mux.HandleFunc("POST /join", n.handleJoin)
+	return mux
+}
+
+// ensureLeader checks that the node is still the leader, so the reads are
+// not stale. Otherwise it writes the leader hint.
+func (n *Node) ensureLeader(w http.ResponseWriter) bool {
+	if err := n.raft.VerifyLeader().Error(); err != nil {
+		_, leaderID := n.raft.LeaderWithID()
+		w.Header().Set(meta.LeaderHeader, string(leaderID))
+		http.Error(w, "not the leader", http.StatusServiceUnavailable)
+		return false
+	}
+
+	return true
+}
+
+func (n *Node) apply(w http.ResponseWriter, cmd command) {
+	cmd.Time = time.Now().UTC()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	future := n.raft.Apply(data, applyTimeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			_, leaderID := n.raft.LeaderWithID()
+			w.Header().Set(meta.LeaderHeader, string(leaderID))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err, ok := future.Response().(error); ok && err != nil {
+		if errors.Is(err, meta.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if errors.Is(err, meta.ErrCollected) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (n *Node) handlePut(w http.ResponseWriter, r *http.Request) {
+	var file meta.File
+	if err := json.NewDecoder(r.Body).Decode(&file); err != nil {
+		http.Error(w, fmt.Sprintf("invalid file: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if file.Name != r.PathValue("name") {
+		http.Error(w, "file name doesn't match the path", http.StatusBadRequest)
+		return
+	}
+
+	n.apply(w, command{Op: opPut, File: &file})
+}
+
+func (n *Node) handleDelete(w http.ResponseWriter, r *http.Request) {
+	n.apply(w, command{Op: opDelete, Name: r.PathValue("name")})
+}
+
+func (n *Node) handleGet(w http.ResponseWriter, r *http.Request) {
+	if !n.ensureLeader(w) {
+		return
+	}
+
+	name := r.PathValue("name")
+	file, ok := n.fsm.get(name)
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid version: %s", err), http.StatusBadRequest)
+			return
+		}
+		file, ok = n.fsm.getVersion(name, version)
+	}
+
+	if !ok {
+		http.Error(w, meta.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, file)
+}
+
+func (n *Node) handleList(w http.ResponseWriter, r *http.Request) {
+	if !n.ensureLeader(w) {
+		return
+	}
+
+	writeJSON(w, n.fsm.list(r.URL.Query().Get("prefix")))
+}
+
+func (n *Node) handleListVersions(w http.ResponseWriter, r *http.Request) {
+	if !n.ensureLeader(w) {
+		return
+	}
+
+	writeJSON(w, n.fsm.listVersions(r.URL.Query().Get("prefix")))
+}
+
+func (n *Node) handleDeleteVersion(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.ParseUint(r.PathValue("version"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid version: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	n.apply(w, command{Op: opPrune, Name: r.PathValue("name"), Version: version})
+}
+
+func (n *Node) handleListGarbage(w http.ResponseWriter, r *http.Request) {
+	if !n.ensureLeader(w) {
+		return
+	}
+
+	writeJSON(w, n.fsm.listGarbage())
+}
+
+func (n *Node) handleClaimGarbage(w http.ResponseWriter, r *http.Request) {
+	lease, err := time.ParseDuration(r.URL.Query().Get("lease"))
+	if err != nil || lease <= 0 {
+		http.Error(w, fmt.Sprintf("invalid lease: '%s'", r.URL.Query().Get("lease")), http.StatusBadRequest)
+		return
+	}
+
+	n.apply(w, command{Op: opClaim, Hash: r.PathValue("hash"), TTL: lease})
+}
+
+func (n *Node) handleDeleteGarbage(w http.ResponseWriter, r *http.Request) {
+	n.apply(w, command{Op: opCollect, Hash: r.PathValue("hash")})
+}
+
+func (n *Node) handlePin(w http.ResponseWriter, r *http.Request) {
+	var req meta.PinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid pin request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.TTL <= 0 {
+		http.Error(w, fmt.Sprintf("invalid ttl: %s", req.TTL), http.StatusBadRequest)
+		return
+	}
+
+	n.apply(w, command{Op: opPin, Hashes: req.Hashes, TTL: req.TTL})
+}
+
+func (n *Node) handleJoin(w http.ResponseWriter, r *http.Request) {
+	var req meta.JoinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid join request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	err := n.raft.AddVoter(raft.ServerID(req.ID), raft.ServerAddress(req.RaftAddr), 0, applyTimeout).Error()
+	if errors.Is(err, raft.ErrNotLeader) {
+		_, leaderID := n.raft.LeaderWithID()
+		w.Header().Set(meta.LeaderHeader, string(leaderID))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Logf("meta: replica '%s' (raft '%s') joined", req.ID, req.RaftAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Logf("meta: can't write response: %s", err)
+	}
+}
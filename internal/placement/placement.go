@@ -0,0 +1,33 @@
+package placement
+
+import (
+	"fmt"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// Node returns the address from addrs which must hold the id'th chunk of
+// the file. The result depends on len(addrs), so changing the address list
+// moves chunks between nodes (see pkg/rebalance).
+func Node(addrs []string, name string, id uint64) string {
+	return Nodes(addrs, name, id, 1)[0]
+}
+
+// Nodes returns the addresses which must hold the replicas of the id'th
+// chunk of the file. The first one is the same as [Node], the rest are the
+// next nodes in addrs, wrapping around. Result has no more than len(addrs)
+// elements.
+func Nodes(addrs []string, name string, id uint64, replicas int) []string {
+	key := []byte(name + fmt.Sprint(id))
+	hash := murmur3.Sum32(key)
+
+	replicas = max(1, min(replicas, len(addrs)))
+	idx := int(hash) % len(addrs)
+
+	nodes := make([]string, 0, replicas)
+	for i := range replicas {
+		nodes = append(nodes, addrs[(idx+i)%len(addrs)])
+	}
+
+	return nodes
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// NewLimiter creates token-bucket limiter for bytesPerSec. If bytesPerSec is
+// not positive the limiter is unlimited.
+func NewLimiter(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(bytesPerSec))
+}
+
+// NewReader wraps r so every read waits for the limiter tokens first.
+func NewReader(ctx context.Context, r io.Reader, lim *rate.Limiter) io.Reader {
+	return &reader{ctx: ctx, r: r, lim: lim}
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	lim *rate.Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.lim.Limit() == rate.Inf {
+		return r.r.Read(p)
+	}
+
+	// never ask for more tokens than bucket can hold
+	if burst := r.lim.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.lim.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
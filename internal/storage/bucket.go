@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+
+	"github.com/tymbaca/sfs/internal/common"
+)
+
+// Bucket trees are stored as '<base>/.buckets/<name>' with the same layout as
+// the default tree and the bucket config in '.bucket' file inside.
+const (
+	bucketsDir = ".buckets"
+	bucketFile = ".bucket"
+)
+
+var (
+	ErrInvalidBucket  = errors.New("invalid bucket name")
+	ErrBucketNotEmpty = errors.New("bucket is not empty")
+)
+
+var bucketNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+// Bucket is the namespace of files. Its quotas are enforced by every node on
+// its own.
+type Bucket struct {
+	Name string `json:"name"`
+	// MaxBytes is the max count of bytes of the bucket chunks. Zero means
+	// unlimited.
+	MaxBytes uint64 `json:"max_bytes"`
+	// MaxObjects is the max count of the bucket files. Zero means unlimited.
+	MaxObjects uint64 `json:"max_objects"`
+}
+
+type BucketStats struct {
+	Bucket
+	// Bytes is the size of the bucket chunks.
+	Bytes uint64
+	// Objects is the count of the bucket files.
+	Objects uint64
+}
+
+// CheckBucketName returns [ErrInvalidBucket] unless name is 1-63 lowercase
+// letters, digits, '.', '_' and '-', starting with a letter or digit.
+func CheckBucketName(name string) error {
+	if !bucketNameRe.MatchString(name) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidBucket, name)
+	}
+
+	return nil
+}
+
+// CreateBucket creates the bucket, or updates the quotas of the existing one.
+func (s *FileStorage) CreateBucket(ctx context.Context, bucket Bucket) error {
+	if err := CheckBucketName(bucket.Name); err != nil {
+		return err
+	}
+
+	s.bucketsMu.Lock()
+	defer s.bucketsMu.Unlock()
+
+	dir := s.bucketDir(bucket.Name)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("can't create bucket folder: %w", err)
+	}
+
+	data, err := json.Marshal(bucket)
+	if err != nil {
+		return fmt.Errorf("can't marshal bucket: %w", err)
+	}
+
+	tmp := filepath.Join(dir, bucketFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("can't write bucket config: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, bucketFile)); err != nil {
+		return fmt.Errorf("can't write bucket config: %w", err)
+	}
+
+	if tree, ok := s.buckets[bucket.Name]; ok {
+		tree.SetLimits(bucket.limits())
+	}
+
+	return nil
+}
+
+// ListBuckets returns the buckets sorted by name.
+func (s *FileStorage) ListBuckets(ctx context.Context) ([]BucketStats, error) {
+	trees, err := s.bucketTrees()
+	if err != nil {
+		return nil, fmt.Errorf("can't list buckets: %w", err)
+	}
+
+	buckets := make([]BucketStats, 0, len(trees))
+	for _, tree := range trees {
+		stats, err := tree.bucketStats()
+		if err != nil {
+			return nil, fmt.Errorf("can't get bucket '%s' stats: %w", tree.bucket, err)
+		}
+		buckets = append(buckets, stats)
+	}
+
+	return buckets, nil
+}
+
+// DeleteBucket deletes the bucket. Returns [ErrBucketNotEmpty] if it still
+// has files.
+func (s *FileStorage) DeleteBucket(ctx context.Context, name string) error {
+	s.bucketsMu.Lock()
+	defer s.bucketsMu.Unlock()
+
+	tree, err := s.bucketLocked(name)
+	if err != nil {
+		return err
+	}
+
+	stats, err := tree.bucketStats()
+	if err != nil {
+		return err
+	}
+
+	if stats.Objects > 0 {
+		return fmt.Errorf("%w: '%s' has %d files", ErrBucketNotEmpty, name, stats.Objects)
+	}
+
+	if err := os.RemoveAll(tree.baseDir); err != nil {
+		return fmt.Errorf("can't delete bucket folder: %w", err)
+	}
+	delete(s.buckets, name)
+
+	return nil
+}
+
+// tree returns the storage of the ctx bucket, s itself for the default one.
+func (s *FileStorage) tree(ctx context.Context) (*FileStorage, error) {
+	name := common.BucketFrom(ctx)
+	if name == "" || s.parent != nil {
+		return s, nil
+	}
+
+	s.bucketsMu.Lock()
+	defer s.bucketsMu.Unlock()
+
+	return s.bucketLocked(name)
+}
+
+func (s *FileStorage) bucketLocked(name string) (*FileStorage, error) {
+	if tree, ok := s.buckets[name]; ok {
+		return tree, nil
+	}
+
+	if err := CheckBucketName(name); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.bucketDir(name), bucketFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("bucket '%s': %w", name, common.ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("can't read bucket config: %w", err)
+	}
+
+	var bucket Bucket
+	if err := json.Unmarshal(data, &bucket); err != nil {
+		return nil, fmt.Errorf("can't unmarshal bucket config: %w", err)
+	}
+
+	tree := &FileStorage{baseDir: s.bucketDir(name), parent: s, bucket: name}
+	tree.capacity.limits = bucket.limits()
+
+	if s.buckets == nil {
+		s.buckets = make(map[string]*FileStorage)
+	}
+	s.buckets[name] = tree
+
+	return tree, nil
+}
+
+func (s *FileStorage) bucketStats() (BucketStats, error) {
+	if err := s.initUsed(); err != nil {
+		return BucketStats{}, err
+	}
+
+	s.capacity.mu.Lock()
+	defer s.capacity.mu.Unlock()
+
+	return BucketStats{
+		Bucket: Bucket{
+			Name:       s.bucket,
+			MaxBytes:   s.capacity.limits.Quota,
+			MaxObjects: s.capacity.limits.MaxObjects,
+		},
+		Bytes:   s.capacity.used,
+		Objects: s.capacity.objects,
+	}, nil
+}
+
+// bucketNames returns the names of the bucket folders, sorted.
+func (s *FileStorage) bucketNames() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.baseDir, bucketsDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
+
+// bucketTrees returns the storages of all the buckets, sorted by name. Folders
+// without the config, e.g. deleted half way, are skipped.
+func (s *FileStorage) bucketTrees() ([]*FileStorage, error) {
+	names, err := s.bucketNames()
+	if err != nil {
+		return nil, err
+	}
+
+	s.bucketsMu.Lock()
+	defer s.bucketsMu.Unlock()
+
+	trees := make([]*FileStorage, 0, len(names))
+	for _, name := range names {
+		tree, err := s.bucketLocked(name)
+		if errors.Is(err, common.ErrNotFound) || errors.Is(err, ErrInvalidBucket) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		trees = append(trees, tree)
+	}
+
+	return trees, nil
+}
+
+func (s *FileStorage) bucketDir(name string) string {
+	return filepath.Join(s.baseDir, bucketsDir, name)
+}
+
+func (b Bucket) limits() Limits {
+	return Limits{Quota: b.MaxBytes, MaxObjects: b.MaxObjects}
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
+)
+
+func TestBuckets(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	s := NewFileStorage(baseDir)
+
+	team := common.WithBucket(ctx, "team")
+	store := func(ctx context.Context, name, data string) error {
+		return s.StoreChunk(ctx, chunks.Chunk{Filename: name, Size: uint64(len(data)), Body: strings.NewReader(data)})
+	}
+
+	require.ErrorIs(t, store(team, "build.zip", "team"), common.ErrNotFound)
+	require.ErrorIs(t, s.CreateBucket(ctx, Bucket{Name: "../team"}), ErrInvalidBucket)
+	require.NoError(t, s.CreateBucket(ctx, Bucket{Name: "team", MaxBytes: 10, MaxObjects: 2}))
+
+	// the same name in the default and the team bucket
+	require.NoError(t, store(ctx, "build.zip", "default"))
+	require.NoError(t, store(team, "build.zip", "team"))
+
+	chk, closeChk, err := s.GetChunk(team, "build.zip", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), chk.Size)
+	closeChk()
+
+	names, err := s.ListFiles(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"build.zip"}, names)
+
+	// quotas
+	require.NoError(t, store(team, "b", "bb"))
+	require.ErrorIs(t, store(team, "c", "c"), common.ErrNoSpace)
+	require.ErrorIs(t, store(team, "b", "bbbbbbbb"), common.ErrNoSpace)
+
+	buckets, err := s.ListBuckets(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []BucketStats{{Bucket: Bucket{Name: "team", MaxBytes: 10, MaxObjects: 2}, Bytes: 6, Objects: 2}}, buckets)
+
+	// the node counts the buckets too, also after restart
+	stats, err := s.Stats()
+	require.NoError(t, err)
+	require.Equal(t, uint64(13), stats.Used)
+
+	s = NewFileStorage(baseDir)
+	stats, err = s.Stats()
+	require.NoError(t, err)
+	require.Equal(t, uint64(13), stats.Used)
+
+	require.ErrorIs(t, s.DeleteBucket(ctx, "team"), ErrBucketNotEmpty)
+	require.NoError(t, s.DeleteChunk(team, "build.zip", 0))
+	require.NoError(t, s.DeleteChunk(team, "b", 0))
+	require.NoError(t, s.DeleteBucket(ctx, "team"))
+
+	buckets, err = s.ListBuckets(ctx)
+	require.NoError(t, err)
+	require.Empty(t, buckets)
+	require.ErrorIs(t, s.DeleteBucket(ctx, "team"), common.ErrNotFound)
+
+	stats, err = s.Stats()
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), stats.Used)
+}
+
+func TestInvalidNames(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	s := NewFileStorage(baseDir)
+
+	team := common.WithBucket(ctx, "team")
+	require.NoError(t, s.CreateBucket(ctx, Bucket{Name: "team", MaxBytes: 10, MaxObjects: 1}))
+	require.NoError(t, s.StoreChunk(team, chunks.Chunk{Filename: "x", Size: 1, Body: strings.NewReader("x")}))
+
+	for _, tc := range []struct {
+		ctx  context.Context
+		name string
+	}{
+		// into the team tree, past its quotas
+		{ctx, ".buckets/team/y"},
+		// out of the team tree into the default one
+		{team, "../../y"},
+		{team, "a/../../../y"},
+		{ctx, "/etc/y"},
+		{ctx, "a//y"},
+		{ctx, "."},
+		{ctx, ".quarantine/y"},
+	} {
+		err := s.StoreChunk(tc.ctx, chunks.Chunk{Filename: tc.name, Size: 1, Body: strings.NewReader("y")})
+		require.ErrorIs(t, err, ErrInvalidName, tc.name)
+
+		_, _, err = s.GetChunk(tc.ctx, tc.name, 0)
+		require.ErrorIs(t, err, ErrInvalidName, tc.name)
+		_, err = s.HasChunk(tc.ctx, tc.name, 0)
+		require.ErrorIs(t, err, ErrInvalidName, tc.name)
+		_, err = s.ListChunkIDs(tc.ctx, tc.name)
+		require.ErrorIs(t, err, ErrInvalidName, tc.name)
+		_, err = s.ChunkSums(tc.ctx, tc.name)
+		require.ErrorIs(t, err, ErrInvalidName, tc.name)
+		require.ErrorIs(t, s.DeleteChunk(tc.ctx, tc.name, 0), ErrInvalidName, tc.name)
+	}
+
+	// the team chunk is not reachable from the default tree
+	_, _, err := s.GetChunk(ctx, ".buckets/team/x", 0)
+	require.ErrorIs(t, err, ErrInvalidName)
+
+	names, err := s.ListFiles(ctx)
+	require.NoError(t, err)
+	require.Empty(t, names)
+
+	buckets, err := s.ListBuckets(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []BucketStats{{Bucket: Bucket{Name: "team", MaxBytes: 10, MaxObjects: 1}, Bytes: 1, Objects: 1}}, buckets)
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/tymbaca/sfs/internal/common"
+)
+
+type Limits struct {
+	// Quota is the max count of bytes of the chunk files. Zero means
+	// unlimited.
+	Quota uint64
+	// HighWater is the max used fraction of the disk, e.g. 0.9. Zero means
+	// the whole disk.
+	HighWater float64
+	// MaxObjects is the max count of files. Zero means unlimited.
+	MaxObjects uint64
+}
+
+// Stats is the capacity of the storage in bytes.
+type Stats struct {
+	// Used is the size of the chunk files.
+	Used uint64
+	// Free is how much more the storage accepts within its limits.
+	Free uint64
+	// Total is Used and Free together.
+	Total uint64
+}
+
+// capacity tracks the bytes used by the chunk files and the count of files.
+// They are counted once on the first use, then kept up to date by the writes
+// and deletes.
+type capacity struct {
+	limits Limits
+
+	once    sync.Once
+	initErr error
+
+	mu      sync.Mutex
+	used    uint64
+	objects uint64
+	// pending are the reserved bytes not written yet, which the disk space
+	// doesn't show
+	pending uint64
+}
+
+// SetLimits sets the limits the storage stops accepting chunks at.
+func (s *FileStorage) SetLimits(limits Limits) {
+	s.capacity.mu.Lock()
+	defer s.capacity.mu.Unlock()
+
+	s.capacity.limits = limits
+}
+
+// Stats returns the capacity of the storage.
+func (s *FileStorage) Stats() (Stats, error) {
+	if err := s.initUsed(); err != nil {
+		return Stats{}, err
+	}
+
+	s.capacity.mu.Lock()
+	defer s.capacity.mu.Unlock()
+
+	free, err := s.freeLocked()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return Stats{Used: s.capacity.used, Free: free, Total: s.capacity.used + free}, nil
+}
+
+func (s *FileStorage) initUsed() error {
+	s.capacity.once.Do(func() {
+		used, objects, err := s.walkUsed()
+		if err != nil {
+			s.capacity.initErr = fmt.Errorf("can't count used space: %w", err)
+			return
+		}
+
+		s.capacity.mu.Lock()
+		defer s.capacity.mu.Unlock()
+		s.capacity.used += used
+		s.capacity.objects += objects
+	})
+
+	return s.capacity.initErr
+}
+
+func (s *FileStorage) walkUsed() (used, objects uint64, err error) {
+	dirs := make(map[string]struct{})
+	count := func(pth string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		used += uint64(info.Size())
+		dirs[filepath.Dir(pth)] = struct{}{}
+
+		return nil
+	}
+
+	if err := walkChunks(s.baseDir, count); err != nil {
+		return 0, 0, err
+	}
+	objects = uint64(len(dirs))
+
+	if s.parent == nil {
+		// bucket trees take the space of the node too
+		names, err := s.bucketNames()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		for _, name := range names {
+			if err := walkChunks(s.bucketDir(name), count); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	return used, objects, nil
+}
+
+// freeLocked returns how many bytes the storage still accepts.
+func (s *FileStorage) freeLocked() (uint64, error) {
+	// base dir may not exist before the first chunk
+	if err := os.MkdirAll(s.baseDir, os.ModePerm); err != nil {
+		return 0, fmt.Errorf("can't create base dir: %w", err)
+	}
+
+	total, avail, err := diskSpace(s.baseDir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		// only the quota is enforced
+		total, avail = math.MaxInt64, math.MaxInt64
+	} else if err != nil {
+		return 0, fmt.Errorf("can't get disk space: %w", err)
+	}
+
+	pending := s.capacity.pending
+	free := avail - min(pending, avail)
+	if hw := s.capacity.limits.HighWater; hw > 0 && hw < 1 {
+		allowed := uint64(hw * float64(total))
+		free = allowed - min(total-avail+pending, allowed)
+	}
+
+	if quota := s.capacity.limits.Quota; quota > 0 {
+		free = min(free, quota-min(s.capacity.used, quota))
+	}
+
+	return free, nil
+}
+
+// reserve counts size bytes as used and pending, if they fit the limits with
+// the replaced bytes of the overwritten chunk freed. The reservation is
+// corrected with [FileStorage.adjustUsed] when the actual size is known and
+// settled with [FileStorage.settle] when the bytes are written.
+func (s *FileStorage) reserve(size, replaced uint64) error {
+	if err := s.initUsed(); err != nil {
+		return err
+	}
+
+	s.capacity.mu.Lock()
+	defer s.capacity.mu.Unlock()
+
+	free, err := s.freeLocked()
+	if err != nil {
+		return err
+	}
+
+	if size > free+replaced {
+		return fmt.Errorf("%w: %d bytes needed, %d free", common.ErrNoSpace, size, free+replaced)
+	}
+
+	s.capacity.used += size
+	s.capacity.pending += size
+
+	if s.parent != nil {
+		if err := s.parent.reserve(size, replaced); err != nil {
+			s.capacity.used -= size
+			s.capacity.pending -= size
+			return err
+		}
+	}
+
+	return nil
+}
+
+// settle stops counting size reserved bytes as pending, as they are written
+// or the write failed.
+func (s *FileStorage) settle(size uint64) {
+	s.capacity.mu.Lock()
+	s.capacity.pending -= min(size, s.capacity.pending)
+	s.capacity.mu.Unlock()
+
+	if s.parent != nil {
+		s.parent.settle(size)
+	}
+}
+
+// addObject counts the new file of folder dir, if it fits the limits. The
+// folder is created under the lock, so the concurrent chunks of the file count
+// it once.
+func (s *FileStorage) addObject(dir string) error {
+	s.capacity.mu.Lock()
+	defer s.capacity.mu.Unlock()
+
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	if limit := s.capacity.limits.MaxObjects; limit > 0 && s.capacity.objects >= limit {
+		return fmt.Errorf("%w: %d files of %d", common.ErrNoSpace, s.capacity.objects, limit)
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("can't create file folder: %w", err)
+	}
+	s.capacity.objects++
+
+	return nil
+}
+
+// adjustUsed changes the used bytes by delta.
+func (s *FileStorage) adjustUsed(delta int64) {
+	s.capacity.mu.Lock()
+	if delta < 0 {
+		s.capacity.used -= min(uint64(-delta), s.capacity.used)
+	} else {
+		s.capacity.used += uint64(delta)
+	}
+	s.capacity.mu.Unlock()
+
+	if s.parent != nil {
+		s.parent.adjustUsed(delta)
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
+)
+
+func TestCapacity(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	s := NewFileStorage(baseDir)
+	s.SetLimits(Limits{Quota: 10})
+
+	store := func(id uint64, data string) error {
+		return s.StoreChunk(ctx, chunks.Chunk{ID: id, Filename: "file", Size: uint64(len(data)), Body: strings.NewReader(data)})
+	}
+
+	require.NoError(t, store(0, "012345"))
+	require.ErrorIs(t, store(1, "012345"), common.ErrNoSpace)
+	has, err := s.HasChunk(ctx, "file", 1)
+	require.NoError(t, err)
+	require.False(t, has)
+
+	// overwrite counts the new size only
+	require.NoError(t, store(0, "0123"))
+	require.NoError(t, store(1, "012345"))
+
+	stats, err := s.Stats()
+	require.NoError(t, err)
+	require.Equal(t, Stats{Used: 10, Free: 0, Total: 10}, stats)
+
+	require.NoError(t, s.DeleteChunk(ctx, "file", 1))
+	stats, err = s.Stats()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), stats.Used)
+
+	// used space is counted on start
+	s = NewFileStorage(baseDir)
+	s.SetLimits(Limits{Quota: 10})
+	stats, err = s.Stats()
+	require.NoError(t, err)
+	require.Equal(t, Stats{Used: 4, Free: 6, Total: 10}, stats)
+
+	// the overwritten chunk frees its space
+	require.NoError(t, store(1, "012345"))
+	stats, err = s.Stats()
+	require.NoError(t, err)
+	require.Zero(t, stats.Free)
+	require.NoError(t, store(1, "543210"))
+	require.ErrorIs(t, store(1, "0123456"), common.ErrNoSpace)
+
+	// the disk is never filled above high water
+	s.SetLimits(Limits{HighWater: 0.000001})
+	require.ErrorIs(t, store(2, "0"), common.ErrNoSpace)
+}
+
+func TestCapacityPending(t *testing.T) {
+	s := NewFileStorage(t.TempDir())
+	s.SetLimits(Limits{HighWater: 0.99})
+
+	before, err := s.Stats()
+	require.NoError(t, err)
+	if before.Free < 2 {
+		t.Skip("the disk is full")
+	}
+
+	// the reserved bytes are not on the disk yet, but they are not free
+	size := before.Free / 2
+	require.NoError(t, s.reserve(size, 0))
+	require.ErrorIs(t, s.reserve(size+before.Free/4, 0), common.ErrNoSpace)
+
+	stats, err := s.Stats()
+	require.NoError(t, err)
+	require.Less(t, stats.Free, before.Free-size/2)
+
+	s.settle(size)
+	s.adjustUsed(-int64(size))
+	stats, err = s.Stats()
+	require.NoError(t, err)
+	require.Greater(t, stats.Free, before.Free-size/2)
+}
@@ -4,46 +4,258 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/tymbaca/sfs/internal/chunks"
 	"github.com/tymbaca/sfs/internal/common"
-	"github.com/tymbaca/sfs/internal/files"
 	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/ratelimit"
 )
 
+// ErrInvalidName is returned for the file name which is not the valid path
+// inside the tree, e.g. '../x' or '.buckets/x'.
+var ErrInvalidName = errors.New("invalid file name")
+
 type FileStorage struct {
-	baseDir string
+	baseDir  string
+	capacity capacity
+
+	// parent is the node storage of the bucket tree, nil for the node one.
+	parent *FileStorage
+	bucket string
+
+	bucketsMu sync.Mutex
+	buckets   map[string]*FileStorage
+
+	chunkLocks [chunkLockStripes]sync.Mutex
 }
 
+// chunkLockStripes is the count of locks the chunks are spread between, see
+// [FileStorage.lockChunk].
+const chunkLockStripes = 64
+
+// Chunks and checksums are written to the temp files with this suffix, then
+// renamed into place.
+const tmpExt = ".tmp"
+
 func NewFileStorage(baseDir string) *FileStorage {
 	return &FileStorage{
 		baseDir: baseDir,
 	}
 }
 
+// StoreChunk writes the chunk. Returns [common.ErrNoSpace] if it doesn't fit
+// the limits or the disk. The chunk and its checksum are written aside and
+// renamed into place together, so the replaced chunk is never seen
+// half-written, and it's kept if the new one fails.
 func (s *FileStorage) StoreChunk(ctx context.Context, chunk chunks.Chunk) error {
-	f, err := files.CreateFile(path.Join(s.baseDir, chunk.Filename, strconv.Itoa(int(chunk.ID))))
+	if err := checkName(chunk.Filename); err != nil {
+		return err
+	}
+
+	s, err := s.tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	size := chunk.Size
+	if chunk.Codec != chunks.CodecNone {
+		size = chunk.BodySize
+	}
+
+	// the overwritten chunk is freed when the new one replaces it
+	chunkPath := s.chunkPath(chunk.Filename, chunk.ID)
+	replacedSize := uint64(0)
+	if stat, err := os.Stat(chunkPath); err == nil {
+		replacedSize = uint64(stat.Size())
+	}
+
+	if err := s.reserve(size, replacedSize); err != nil {
+		return fmt.Errorf("can't store %s/%d: %w", chunk.Filename, chunk.ID, err)
+	}
+
+	if err := s.addObject(path.Dir(chunkPath)); err != nil {
+		s.settle(size)
+		s.adjustUsed(-int64(size))
+		return fmt.Errorf("can't store %s/%d: %w", chunk.Filename, chunk.ID, err)
+	}
+
+	tmp, n, sum, err := s.writeChunk(chunkPath, chunk)
+	// the reservation is corrected by the written size
+	s.settle(size)
+	s.adjustUsed(n - int64(size))
 	if err != nil {
-		return fmt.Errorf("can't create file for %s/%d: %w", chunk.Filename, chunk.ID, err)
+		if tmp != "" {
+			os.Remove(tmp)
+			s.adjustUsed(-n)
+		}
+		s.removeEmptyDirs(path.Dir(chunkPath))
+		return err
+	}
+
+	sumTmp, err := stageSum(chunkPath, sum)
+	if err != nil {
+		os.Remove(tmp)
+		s.adjustUsed(-n)
+		s.removeEmptyDirs(path.Dir(chunkPath))
+		if errors.Is(err, syscall.ENOSPC) {
+			err = common.ErrNoSpace
+		}
+		return fmt.Errorf("can't store checksum for %s/%d: %w", chunk.Filename, chunk.ID, err)
+	}
+
+	unlock := s.lockChunk(chunkPath)
+	defer unlock()
+
+	replaced := int64(0)
+	if stat, err := os.Stat(chunkPath); err == nil {
+		replaced = stat.Size()
+	}
+
+	if err := os.Rename(tmp, chunkPath); err != nil {
+		os.Remove(tmp)
+		os.Remove(sumTmp)
+		s.adjustUsed(-n)
+		return fmt.Errorf("can't store %s/%d: %w", chunk.Filename, chunk.ID, err)
 	}
+	s.adjustUsed(-replaced)
 
-	if _, err = io.Copy(f, chunk.Body); err != nil {
-		return fmt.Errorf("can't write to file for %s/%d: %w", chunk.Filename, chunk.ID, err)
+	if err := os.Rename(sumTmp, chunkPath+sumExt); err != nil {
+		// the old checksum doesn't match the new chunk, the missing one is
+		// computed again
+		os.Remove(sumTmp)
+		os.Remove(chunkPath + sumExt)
+		return fmt.Errorf("can't store checksum for %s/%d: %w", chunk.Filename, chunk.ID, err)
+	}
+
+	// the chunk written again is not deleted anymore
+	if err := s.removeTombstone(chunk.Filename, chunk.ID); err != nil {
+		return fmt.Errorf("can't remove tombstone of %s/%d: %w", chunk.Filename, chunk.ID, err)
 	}
 
 	return nil
 }
 
+// writeChunk writes the chunk to the temp file next to chunkPath and checks
+// it. Returns the temp file, if it's created, with the count of bytes written
+// to it and the checksum of the chunk.
+func (s *FileStorage) writeChunk(chunkPath string, chunk chunks.Chunk) (string, int64, chunkSum, error) {
+	f, err := os.CreateTemp(path.Dir(chunkPath), path.Base(chunkPath)+".*"+tmpExt)
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			err = common.ErrNoSpace
+		}
+		return "", 0, chunkSum{}, fmt.Errorf("can't create file for %s/%d: %w", chunk.Filename, chunk.ID, err)
+	}
+
+	crc := crc32.New(crcTable)
+	n, err := io.Copy(io.MultiWriter(f, crc), chunk.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			err = common.ErrNoSpace
+		}
+		return f.Name(), n, chunkSum{}, fmt.Errorf("can't write to file for %s/%d: %w", chunk.Filename, chunk.ID, err)
+	}
+
+	sum := chunkSum{Size: uint64(n), CRC: crc.Sum32()}
+	if chunk.Codec == chunks.CodecNone && sum.Size != chunk.Size {
+		// the body ended early, e.g. the client disconnected
+		return f.Name(), n, chunkSum{}, fmt.Errorf("%s/%d size is %d, expected %d", chunk.Filename, chunk.ID, sum.Size, chunk.Size)
+	}
+	if chunk.Codec != chunks.CodecNone {
+		// it also checks that compressed data is valid
+		sum.Codec = chunk.Codec
+		sum.RawSize, sum.RawCRC, err = rawSum(f.Name(), chunk.Codec)
+		if err != nil {
+			return f.Name(), n, chunkSum{}, fmt.Errorf("can't decompress %s/%d: %w", chunk.Filename, chunk.ID, err)
+		}
+
+		if sum.RawSize != chunk.Size {
+			return f.Name(), n, chunkSum{}, fmt.Errorf("decompressed %s/%d size is %d, expected %d", chunk.Filename, chunk.ID, sum.RawSize, chunk.Size)
+		}
+	}
+
+	return f.Name(), n, sum, nil
+}
+
+func rawSum(chunkPath string, codec chunks.Codec) (uint64, uint32, error) {
+	f, err := os.Open(chunkPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	dec, err := chunks.Decompress(codec, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer dec.Close()
+
+	crc := crc32.New(crcTable)
+	n, err := io.Copy(crc, dec)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint64(n), crc.Sum32(), nil
+}
+
+// lockChunk locks the chunk file and its checksum, so they are replaced,
+// opened and quarantined together. Returns the unlock.
+func (s *FileStorage) lockChunk(chunkPath string) func() {
+	mu := &s.chunkLocks[crc32.ChecksumIEEE([]byte(chunkPath))%chunkLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// checkName returns [ErrInvalidName] unless name is the valid slash-separated
+// path (see [fs.ValidPath]) without '..' and its first element doesn't start
+// with '.', as the service folders do. So the file can't be stored outside
+// of its tree or in the tree of the bucket.
+func checkName(name string) error {
+	if !fs.ValidPath(name) || name == "." || strings.Contains(name, "..") || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%w: '%s'", ErrInvalidName, name)
+	}
+
+	return nil
+}
+
+func (s *FileStorage) chunkPath(name string, id uint64) string {
+	return path.Join(s.baseDir, name, strconv.Itoa(int(id)))
+}
+
 // GetChunk gets the chunk with file io.Reader inside. It's the called responsibility to close
 // the file.
 func (s *FileStorage) GetChunk(ctx context.Context, name string, id uint64) (chk chunks.Chunk, closeChk func() error, err error) {
+	if err := checkName(name); err != nil {
+		return chunks.Chunk{}, nil, err
+	}
+
+	s, err = s.tree(ctx)
+	if err != nil {
+		return chunks.Chunk{}, nil, err
+	}
+
+	// the chunk and its checksum are opened together, so they match
+	chunkPath := s.chunkPath(name, id)
+	unlock := s.lockChunk(chunkPath)
+	defer unlock()
+
 	// Open the file
-	f, err := os.Open(path.Join(s.baseDir, name, strconv.Itoa(int(id))))
+	f, err := os.Open(chunkPath)
 	if err != nil && errors.Is(err, fs.ErrNotExist) {
 		return chunks.Chunk{}, nil, common.ErrNotFound
 	} else if err != nil {
@@ -53,18 +265,66 @@ func (s *FileStorage) GetChunk(ctx context.Context, name string, id uint64) (chk
 	// Get stat for size
 	stat, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return chunks.Chunk{}, nil, fmt.Errorf("can't get chunk file stat: %w", err)
 	}
 
-	return chunks.Chunk{
+	chk = chunks.Chunk{
 		ID:       id,
 		Filename: name,
 		Size:     uint64(stat.Size()),
 		Body:     f,
-	}, f.Close, nil
+	}
+
+	// chunks stored before checksums have no sidecar, they are uncompressed
+	sum, err := readSum(chunkPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		f.Close()
+		return chunks.Chunk{}, nil, fmt.Errorf("can't get chunk checksum: %w", err)
+	}
+
+	if sum.Codec != chunks.CodecNone {
+		chk.Codec = sum.Codec
+		chk.BodySize = chk.Size
+		chk.Size = sum.RawSize
+	}
+
+	return chk, f.Close, nil
+}
+
+// HasChunk checks whether the chunk is stored and matches its checksum, so
+// the damaged one is uploaded again. Chunks stored before checksums are
+// considered missing.
+func (s *FileStorage) HasChunk(ctx context.Context, name string, id uint64) (bool, error) {
+	if err := checkName(name); err != nil {
+		return false, err
+	}
+
+	s, err := s.tree(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	_, problem, err := verifyChunk(ctx, s.chunkPath(name, id), ratelimit.NewLimiter(0))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("can't check the chunk: %w", err)
+	}
+
+	return problem == chunkOK, nil
 }
 
 func (s *FileStorage) ListChunkIDs(ctx context.Context, name string) ([]uint64, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	s, err := s.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	entries, err := os.ReadDir(path.Join(s.baseDir, name))
 	if err != nil && errors.Is(err, fs.ErrNotExist) {
 		return nil, common.ErrNotFound
@@ -75,6 +335,10 @@ func (s *FileStorage) ListChunkIDs(ctx context.Context, name string) ([]uint64,
 	ids := make([]uint64, 0, len(entries))
 	for _, e := range entries {
 		if !e.IsDir() {
+			if strings.HasSuffix(e.Name(), sumExt) || strings.HasSuffix(e.Name(), tmpExt) {
+				continue
+			}
+
 			id, err := strconv.Atoi(e.Name())
 			if err != nil {
 				logger.Logf("got non-int name in chunks folder: path: %s", path.Join(s.baseDir, name, e.Name()))
@@ -87,3 +351,129 @@ func (s *FileStorage) ListChunkIDs(ctx context.Context, name string) ([]uint64,
 
 	return ids, nil
 }
+
+// ListFiles returns names of all files which have at least one chunk in the
+// storage. Names may contain slashes (e.g. "1/random-8gb").
+func (s *FileStorage) ListFiles(ctx context.Context) ([]string, error) {
+	s, err := s.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	err = walkChunks(s.baseDir, func(pth string, d fs.DirEntry) error {
+		name, err := filepath.Rel(s.baseDir, filepath.Dir(pth))
+		if err != nil {
+			return err
+		}
+
+		seen[filepath.ToSlash(name)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't list files: %w", err)
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
+
+// DeleteChunk removes the chunk file. If it was the last chunk of the file,
+// the empty file folders are removed too.
+func (s *FileStorage) DeleteChunk(ctx context.Context, name string, id uint64) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	s, err := s.tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	// deletes before the first count would be lost
+	if err := s.initUsed(); err != nil {
+		return err
+	}
+
+	chunkPath := s.chunkPath(name, id)
+	unlock := s.lockChunk(chunkPath)
+	defer unlock()
+
+	return s.deleteChunkLocked(name, id)
+}
+
+// deleteChunkLocked deletes the chunk, its lock must be held.
+func (s *FileStorage) deleteChunkLocked(name string, id uint64) error {
+	chunkPath := s.chunkPath(name, id)
+
+	stat, err := os.Stat(chunkPath)
+	if err == nil {
+		err = os.Remove(chunkPath)
+	}
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return common.ErrNotFound
+	} else if err != nil {
+		return fmt.Errorf("can't delete the chunk: %w", err)
+	}
+	s.adjustUsed(-stat.Size())
+
+	if err := os.Remove(chunkPath + sumExt); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("can't delete the chunk checksum: %w", err)
+	}
+
+	s.removeEmptyDirs(path.Join(s.baseDir, name))
+
+	return nil
+}
+
+// removeEmptyDirs removes the file folder dir and its parents while they are
+// empty, stopping at s.baseDir. The removed file is not counted anymore.
+func (s *FileStorage) removeEmptyDirs(dir string) {
+	s.capacity.mu.Lock()
+	defer s.capacity.mu.Unlock()
+
+	base := path.Clean(s.baseDir)
+	dir = path.Clean(dir)
+	// fails on non-empty dir, that's what we want
+	if dir == base || !strings.HasPrefix(dir, base) || os.Remove(dir) != nil {
+		return
+	}
+	s.capacity.objects -= min(s.capacity.objects, 1)
+
+	for dir = path.Dir(dir); dir != base && strings.HasPrefix(dir, base); dir = path.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+// walkChunks calls fn for every chunk file under root, skipping the service
+// folders, e.g. quarantine and buckets.
+func walkChunks(root string, fn func(pth string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(root, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && pth == root {
+				return fs.SkipAll
+			}
+			return err
+		}
+
+		if d.IsDir() {
+			if pth != root && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if _, err := strconv.Atoi(d.Name()); err != nil {
+			return nil
+		}
+
+		return fn(pth, d)
+	})
+}
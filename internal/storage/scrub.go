@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/ratelimit"
+	"golang.org/x/time/rate"
+)
+
+// Bad chunks are moved here (keeping '<name>/<id>' layout), so they are not
+// served anymore and client reads them from a healthy replica.
+const quarantineDir = ".quarantine"
+
+type ScrubOptions struct {
+	// Limiter limits the read rate of the chunk files. Nil means unlimited.
+	Limiter *rate.Limiter
+	// Quarantine moves corrupted, truncated and empty chunks out of the way.
+	Quarantine bool
+	// CheckGaps reports files with gaps in chunk ids. Only makes sense when
+	// node holds whole files (e.g. single node cluster), otherwise the chunks
+	// are spread between nodes and every file has gaps.
+	CheckGaps bool
+}
+
+type ScrubReport struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+
+	Checked      int64 `json:"checked"`
+	CheckedBytes int64 `json:"checked_bytes"`
+
+	Corrupted       []string            `json:"corrupted,omitempty"`
+	Truncated       []string            `json:"truncated,omitempty"`
+	Empty           []string            `json:"empty,omitempty"`
+	MissingChecksum []string            `json:"missing_checksum,omitempty"`
+	Unknown         []string            `json:"unknown,omitempty"`
+	Gaps            map[string][]uint64 `json:"gaps,omitempty"`
+	Quarantined     []string            `json:"quarantined,omitempty"`
+}
+
+// Scrub walks all the chunks in storage, including the buckets, and verifies
+// them against their stored checksums. Bucket entries of the report are
+// prefixed with '.buckets/<name>/'.
+func (s *FileStorage) Scrub(ctx context.Context, opts ScrubOptions) (ScrubReport, error) {
+	if opts.Limiter == nil {
+		opts.Limiter = ratelimit.NewLimiter(0)
+	}
+
+	report := ScrubReport{Started: time.Now()}
+	fileIDs := make(map[string][]uint64)
+
+	trees, err := s.bucketTrees()
+	if err != nil {
+		return report, fmt.Errorf("can't scrub storage: %w", err)
+	}
+
+	for _, tree := range append([]*FileStorage{s}, trees...) {
+		prefix := ""
+		if tree.parent != nil {
+			prefix = path.Join(bucketsDir, tree.bucket) + "/"
+		}
+
+		if err := tree.scrubTree(ctx, opts, prefix, &report, fileIDs); err != nil {
+			return report, fmt.Errorf("can't scrub storage: %w", err)
+		}
+	}
+
+	if opts.CheckGaps {
+		for name, ids := range fileIDs {
+			if gaps := chunks.Gaps(ids); len(gaps) > 0 {
+				if report.Gaps == nil {
+					report.Gaps = make(map[string][]uint64)
+				}
+				report.Gaps[name] = gaps
+			}
+		}
+	}
+
+	report.Duration = time.Since(report.Started)
+	return report, nil
+}
+
+// scrubTree scrubs the chunks of s tree. Report entries and fileIDs keys get
+// the prefix.
+func (s *FileStorage) scrubTree(ctx context.Context, opts ScrubOptions, prefix string, report *ScrubReport, fileIDs map[string][]uint64) error {
+	return filepath.WalkDir(s.baseDir, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && pth == s.baseDir {
+				return fs.SkipAll
+			}
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		rel, err := filepath.Rel(s.baseDir, pth)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if pth != s.baseDir && strings.HasPrefix(d.Name(), ".") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		// temp files are of the chunks being stored
+		if strings.HasSuffix(d.Name(), sumExt) || strings.HasSuffix(d.Name(), tmpExt) {
+			return nil
+		}
+
+		id, err := strconv.Atoi(d.Name())
+		if err != nil {
+			if pth != filepath.Join(s.baseDir, bucketFile) {
+				report.Unknown = append(report.Unknown, prefix+rel)
+			}
+			return nil
+		}
+
+		name := prefix + filepath.ToSlash(filepath.Dir(rel))
+		fileIDs[name] = append(fileIDs[name], uint64(id))
+
+		return s.scrubChunk(ctx, pth, rel, prefix, opts, report)
+	})
+}
+
+// chunkProblem is what is wrong with the chunk file.
+type chunkProblem int
+
+const (
+	chunkOK chunkProblem = iota
+	// chunkNoSum is the chunk stored before checksums, it's not bad
+	chunkNoSum
+	chunkEmpty
+	chunkTruncated
+	chunkCorrupted
+)
+
+func (p chunkProblem) bad() bool {
+	return p > chunkNoSum
+}
+
+// scrubChunk checks the chunk file and adds the problems to the report. The
+// bad chunk is checked again under the chunk lock, as it may have been
+// replaced meanwhile, and quarantined if it's still bad. The chunk deleted
+// meanwhile is skipped.
+func (s *FileStorage) scrubChunk(ctx context.Context, pth, rel, prefix string, opts ScrubOptions, report *ScrubReport) error {
+	n, problem, err := verifyChunk(ctx, pth, opts.Limiter)
+	if err == nil && problem.bad() {
+		unlock := s.lockChunk(pth)
+		defer unlock()
+
+		n, problem, err = verifyChunk(ctx, pth, ratelimit.NewLimiter(0))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("can't check chunk %s: %w", prefix+rel, err)
+	}
+
+	report.Checked++
+	report.CheckedBytes += n
+
+	switch problem {
+	case chunkNoSum:
+		report.MissingChecksum = append(report.MissingChecksum, prefix+rel)
+	case chunkEmpty:
+		report.Empty = append(report.Empty, prefix+rel)
+	case chunkTruncated:
+		report.Truncated = append(report.Truncated, prefix+rel)
+	case chunkCorrupted:
+		report.Corrupted = append(report.Corrupted, prefix+rel)
+	}
+
+	if problem.bad() && opts.Quarantine {
+		if err := s.quarantine(pth, rel); err != nil {
+			return err
+		}
+		report.Quarantined = append(report.Quarantined, prefix+rel)
+	}
+
+	return nil
+}
+
+// verifyChunk reads the chunk file and checks it against its checksum.
+// Returns the size of the file.
+func verifyChunk(ctx context.Context, pth string, lim *rate.Limiter) (int64, chunkProblem, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return 0, chunkOK, err
+	}
+	defer f.Close()
+
+	crc := crc32.New(crcTable)
+	n, err := io.Copy(crc, ratelimit.NewReader(ctx, f, lim))
+	if err != nil {
+		return 0, chunkOK, err
+	}
+
+	sum, err := readSum(pth)
+	// only the empty file is stored as the empty chunk, see its checksum
+	if n == 0 && (err != nil || sum.Size != 0) {
+		return n, chunkEmpty, nil
+	}
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return n, chunkNoSum, nil
+	} else if err != nil {
+		return 0, chunkOK, err
+	}
+
+	if uint64(n) != sum.Size {
+		return n, chunkTruncated, nil
+	}
+
+	if crc.Sum32() != sum.CRC {
+		return n, chunkCorrupted, nil
+	}
+
+	return n, chunkOK, nil
+}
+
+func (s *FileStorage) quarantine(pth, rel string) error {
+	dst := filepath.Join(s.baseDir, quarantineDir, filepath.FromSlash(rel))
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return fmt.Errorf("can't quarantine chunk %s: %w", rel, err)
+	}
+
+	stat, err := os.Stat(pth)
+	if err != nil {
+		return fmt.Errorf("can't quarantine chunk %s: %w", rel, err)
+	}
+
+	if err := os.Rename(pth, dst); err != nil {
+		return fmt.Errorf("can't quarantine chunk %s: %w", rel, err)
+	}
+	// quarantined chunks are not counted, as they can be deleted any time
+	s.adjustUsed(-stat.Size())
+
+	if err := os.Rename(pth+sumExt, dst+sumExt); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("can't quarantine chunk %s checksum: %w", rel, err)
+	}
+
+	s.removeEmptyDirs(filepath.Dir(pth))
+
+	return nil
+}
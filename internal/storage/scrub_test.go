@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
+)
+
+func TestScrub(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	s := NewFileStorage(baseDir)
+
+	for id := range uint64(6) {
+		if id == 3 {
+			continue // gap
+		}
+
+		err := s.StoreChunk(ctx, chunks.Chunk{ID: id, Filename: "dir/file", Size: 5, Body: strings.NewReader("01234")})
+		require.NoError(t, err)
+	}
+
+	// corrupted
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "dir/file/1"), []byte("01x34"), 0o644))
+	// truncated
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "dir/file/2"), []byte("012"), 0o644))
+	// empty
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "dir/file/4"), nil, 0o644))
+	// stored before checksums
+	require.NoError(t, os.Remove(filepath.Join(baseDir, "dir/file/5"+sumExt)))
+	// garbage
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "dir/file/garbage"), []byte("x"), 0o644))
+
+	report, err := s.Scrub(ctx, ScrubOptions{Quarantine: true, CheckGaps: true})
+	require.NoError(t, err)
+
+	require.EqualValues(t, 5, report.Checked)
+	require.Equal(t, []string{"dir/file/1"}, report.Corrupted)
+	require.Equal(t, []string{"dir/file/2"}, report.Truncated)
+	require.Equal(t, []string{"dir/file/4"}, report.Empty)
+	require.Equal(t, []string{"dir/file/5"}, report.MissingChecksum)
+	require.Equal(t, []string{"dir/file/garbage"}, report.Unknown)
+	require.Equal(t, map[string][]uint64{"dir/file": {3}}, report.Gaps)
+	require.Equal(t, []string{"dir/file/1", "dir/file/2", "dir/file/4"}, report.Quarantined)
+
+	// quarantined chunks are not served anymore
+	_, _, err = s.GetChunk(ctx, "dir/file", 1)
+	require.ErrorIs(t, err, common.ErrNotFound)
+
+	ids, err := s.ListChunkIDs(ctx, "dir/file")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []uint64{0, 5}, ids)
+
+	// quarantine is not visible as a file
+	names, err := s.ListFiles(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/file"}, names)
+
+	// second scrub finds nothing new
+	report, err = s.Scrub(ctx, ScrubOptions{Quarantine: true})
+	require.NoError(t, err)
+	require.Empty(t, report.Quarantined)
+}
+
+func TestHasChunkDamaged(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	s := NewFileStorage(baseDir)
+
+	for id := range uint64(4) {
+		err := s.StoreChunk(ctx, chunks.Chunk{ID: id, Filename: "file", Size: 5, Body: strings.NewReader("01234")})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "file/1"), []byte("01x34"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "file/2"), []byte("012"), 0o644))
+	require.NoError(t, os.Remove(filepath.Join(baseDir, "file/3"+sumExt)))
+
+	// only the good chunk may be skipped by the upload
+	for id, want := range []bool{true, false, false, false, false} {
+		has, err := s.HasChunk(ctx, "file", uint64(id))
+		require.NoError(t, err)
+		require.Equal(t, want, has, id)
+	}
+
+	// the empty file is the empty chunk with its checksum
+	require.NoError(t, s.StoreChunk(ctx, chunks.Chunk{Filename: "empty", Body: strings.NewReader("")}))
+	has, err := s.HasChunk(ctx, "empty", 0)
+	require.NoError(t, err)
+	require.True(t, has)
+
+	report, err := s.Scrub(ctx, ScrubOptions{})
+	require.NoError(t, err)
+	require.Empty(t, report.Empty)
+}
+
+func TestScrubConcurrentStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewFileStorage(t.TempDir())
+
+	bodies := []string{"0123456789", "abc"}
+	store := func(i int) error {
+		body := bodies[i%len(bodies)]
+		return s.StoreChunk(ctx, chunks.Chunk{ID: 0, Filename: "file", Size: uint64(len(body)), Body: strings.NewReader(body)})
+	}
+	require.NoError(t, store(0))
+
+	done := make(chan error)
+	go func() {
+		for i := range 200 {
+			if err := store(i); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	// the chunk being replaced is never seen half-written
+	for running := true; running; {
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			running = false
+		default:
+		}
+
+		report, err := s.Scrub(ctx, ScrubOptions{Quarantine: true})
+		require.NoError(t, err)
+		require.Empty(t, report.Quarantined)
+	}
+}
+
+func TestStoreChunkFailed(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	s := NewFileStorage(baseDir)
+
+	require.NoError(t, s.StoreChunk(ctx, chunks.Chunk{ID: 0, Filename: "file", Size: 5, Body: strings.NewReader("01234")}))
+
+	// not a valid zstd frame
+	err := s.StoreChunk(ctx, chunks.Chunk{ID: 0, Filename: "file", Size: 5, Codec: chunks.CodecZstd, BodySize: 3, Body: strings.NewReader("xyz")})
+	require.Error(t, err)
+
+	// the old chunk is kept with its checksum
+	chk, cls, err := s.GetChunk(ctx, "file", 0)
+	require.NoError(t, err)
+	data, err := io.ReadAll(chk.Body)
+	require.NoError(t, err)
+	require.NoError(t, cls())
+	require.Equal(t, "01234", string(data))
+
+	report, err := s.Scrub(ctx, ScrubOptions{})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, report.Checked)
+	require.Empty(t, report.Corrupted)
+	require.Empty(t, report.Truncated)
+
+	entries, err := os.ReadDir(filepath.Join(baseDir, "file"))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestStoreChunkShort(t *testing.T) {
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	s := NewFileStorage(baseDir)
+
+	require.NoError(t, s.StoreChunk(ctx, chunks.Chunk{ID: 0, Filename: "file", Size: 5, Body: strings.NewReader("01234")}))
+
+	// the body ended before the declared size
+	err := s.StoreChunk(ctx, chunks.Chunk{ID: 0, Filename: "file", Size: 10, Body: strings.NewReader("abc")})
+	require.Error(t, err)
+
+	// the old chunk is kept with its checksum
+	chk, cls, err := s.GetChunk(ctx, "file", 0)
+	require.NoError(t, err)
+	data, err := io.ReadAll(chk.Body)
+	require.NoError(t, err)
+	require.NoError(t, cls())
+	require.Equal(t, "01234", string(data))
+
+	// the short chunk is not stored as the new one either
+	require.Error(t, s.StoreChunk(ctx, chunks.Chunk{ID: 1, Filename: "file", Size: 10, Body: strings.NewReader("abc")}))
+	has, err := s.HasChunk(ctx, "file", 1)
+	require.NoError(t, err)
+	require.False(t, has)
+
+	report, err := s.Scrub(ctx, ScrubOptions{})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, report.Checked)
+	require.Empty(t, report.Corrupted)
+	require.Empty(t, report.Truncated)
+
+	entries, err := os.ReadDir(filepath.Join(baseDir, "file"))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
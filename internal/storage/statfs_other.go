@@ -0,0 +1,9 @@
+//go:build !unix
+
+package storage
+
+import "errors"
+
+func diskSpace(dir string) (total, avail uint64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package storage
+
+import "golang.org/x/sys/unix"
+
+// diskSpace returns the total and available to unprivileged users bytes of
+// the disk dir is on.
+func diskSpace(dir string) (total, avail uint64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+
+	return uint64(st.Blocks) * uint64(st.Bsize), uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+)
+
+// Checksum sidecar is stored next to the chunk file as '<id>.sum'. It
+// contains little-endian uint64 size and uint32 CRC-32C of the chunk file.
+// For compressed chunks it also has uint64 codec, uint64 size and uint32
+// CRC-32C of the uncompressed data.
+const sumExt = ".sum"
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type chunkSum struct {
+	Size uint64
+	CRC  uint32
+
+	Codec   chunks.Codec
+	RawSize uint64
+	RawCRC  uint32
+}
+
+// storedSum is the part of [chunkSum] about the chunk file. Sidecars of the
+// uncompressed chunks contain only it.
+type storedSum struct {
+	Size uint64
+	CRC  uint32
+}
+
+// raw returns the checksum of the uncompressed data.
+func (s chunkSum) raw() (uint64, uint32) {
+	if s.Codec == chunks.CodecNone {
+		return s.Size, s.CRC
+	}
+
+	return s.RawSize, s.RawCRC
+}
+
+func writeSum(chunkPath string, sum chunkSum) error {
+	tmp, err := stageSum(chunkPath, sum)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, chunkPath+sumExt); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// stageSum writes the checksum of the chunk to the temp file next to it, which
+// is renamed into place then. Returns the temp file.
+func stageSum(chunkPath string, sum chunkSum) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(chunkPath), filepath.Base(chunkPath)+sumExt+".*"+tmpExt)
+	if err != nil {
+		return "", fmt.Errorf("can't create checksum file: %w", err)
+	}
+
+	var data any = storedSum{Size: sum.Size, CRC: sum.CRC}
+	if sum.Codec != chunks.CodecNone {
+		data = sum
+	}
+
+	err = binary.Write(f, binary.LittleEndian, data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("can't write checksum file: %w", err)
+	}
+
+	return f.Name(), nil
+}
+
+func readSum(chunkPath string) (chunkSum, error) {
+	f, err := os.Open(chunkPath + sumExt)
+	if err != nil {
+		return chunkSum{}, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return chunkSum{}, fmt.Errorf("can't read checksum file: %w", err)
+	}
+
+	var sum chunkSum
+	if len(data) == binary.Size(storedSum{}) {
+		var stored storedSum
+		err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &stored)
+		sum = chunkSum{Size: stored.Size, CRC: stored.CRC}
+	} else {
+		err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &sum)
+	}
+	if err != nil {
+		return chunkSum{}, fmt.Errorf("can't read checksum file: %w", err)
+	}
+
+	return sum, nil
+}
+
+// ChunkSums returns the checksums of all the file chunks sorted by ID. For the
+// chunks stored before checksums were introduced it computes and stores them.
+// Checksums are of the uncompressed data, so they are the same for replicas
+// stored with different compression.
+func (s *FileStorage) ChunkSums(ctx context.Context, name string) ([]chunks.Sum, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	s, err := s.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := s.ListChunkIDs(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(ids)
+
+	sums := make([]chunks.Sum, 0, len(ids))
+	for _, id := range ids {
+		chunkPath := s.chunkPath(name, id)
+
+		sum, err := readSum(chunkPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			sum, err = s.backfillSum(chunkPath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't get checksum of %s/%d: %w", name, id, err)
+		}
+
+		size, crc := sum.raw()
+		sums = append(sums, chunks.Sum{ID: id, Size: size, CRC: crc})
+	}
+
+	return sums, nil
+}
+
+// backfillSum computes and stores the checksum of the chunk stored without
+// it. It's done under the chunk lock, so the checksum of the replaced chunk
+// doesn't overwrite the one of the new chunk.
+func (s *FileStorage) backfillSum(chunkPath string) (chunkSum, error) {
+	unlock := s.lockChunk(chunkPath)
+	defer unlock()
+
+	if sum, err := readSum(chunkPath); !errors.Is(err, fs.ErrNotExist) {
+		return sum, err
+	}
+
+	sum, err := computeSum(chunkPath)
+	if err != nil {
+		return chunkSum{}, err
+	}
+
+	return sum, writeSum(chunkPath, sum)
+}
+
+func computeSum(chunkPath string) (chunkSum, error) {
+	f, err := os.Open(chunkPath)
+	if err != nil {
+		return chunkSum{}, err
+	}
+	defer f.Close()
+
+	crc := crc32.New(crcTable)
+	n, err := io.Copy(crc, f)
+	if err != nil {
+		return chunkSum{}, err
+	}
+
+	return chunkSum{Size: uint64(n), CRC: crc.Sum32()}, nil
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
+)
+
+// tombstonesDir keeps the deleted chunks as the empty files <name>/<id>,
+// their mtime is the time of the delete. Repair deletes the replicas written
+// before it instead of bringing them back.
+const tombstonesDir = ".tombstones"
+
+// TombstoneChunk deletes the chunk like [FileStorage.DeleteChunk] and leaves
+// the tombstone of it, which is removed when the chunk is stored again.
+func (s *FileStorage) TombstoneChunk(ctx context.Context, name string, id uint64) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	s, err := s.tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.initUsed(); err != nil {
+		return err
+	}
+
+	chunkPath := s.chunkPath(name, id)
+	unlock := s.lockChunk(chunkPath)
+	defer unlock()
+
+	if err := s.deleteChunkLocked(name, id); err != nil {
+		return err
+	}
+
+	if err := s.writeTombstone(name, id, time.Now()); err != nil {
+		return fmt.Errorf("can't write tombstone of %s/%d: %w", name, id, err)
+	}
+
+	return nil
+}
+
+// ChunkReplicas returns the states of all the file chunks sorted by ID: the
+// stored chunks with their checksums and the time they were written, and the
+// deleted ones.
+func (s *FileStorage) ChunkReplicas(ctx context.Context, name string) ([]chunks.Replica, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	s, err := s.tree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sums, err := s.ChunkSums(ctx, name)
+	if err != nil && !errors.Is(err, common.ErrNotFound) {
+		return nil, err
+	}
+
+	tombstones, err := s.tombstones(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't get tombstones of '%s': %w", name, err)
+	}
+
+	replicas := make([]chunks.Replica, 0, len(sums)+len(tombstones))
+	for _, sum := range sums {
+		stat, err := os.Stat(s.chunkPath(name, sum.ID))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // deleted meanwhile
+		} else if err != nil {
+			return nil, fmt.Errorf("can't stat %s/%d: %w", name, sum.ID, err)
+		}
+
+		replicas = append(replicas, chunks.Replica{Sum: sum, Stamp: stat.ModTime()})
+		delete(tombstones, sum.ID)
+	}
+
+	for id, stamp := range tombstones {
+		replicas = append(replicas, chunks.Replica{Sum: chunks.Sum{ID: id}, Stamp: stamp, Deleted: true})
+	}
+
+	slices.SortFunc(replicas, func(a, b chunks.Replica) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return replicas, nil
+}
+
+// SetChunkStamp sets the time the chunk was written, e.g. to the time of the
+// replica it was copied from.
+func (s *FileStorage) SetChunkStamp(ctx context.Context, name string, id uint64, stamp time.Time) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	s, err := s.tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	chunkPath := s.chunkPath(name, id)
+	unlock := s.lockChunk(chunkPath)
+	defer unlock()
+
+	if err := os.Chtimes(chunkPath, stamp, stamp); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return common.ErrNotFound
+		}
+		return fmt.Errorf("can't set time of %s/%d: %w", name, id, err)
+	}
+
+	return nil
+}
+
+// PurgeTombstones removes the tombstones left before the time.
+func (s *FileStorage) PurgeTombstones(ctx context.Context, before time.Time) error {
+	s, err := s.tree(ctx)
+	if err != nil {
+		return err
+	}
+
+	root := filepath.Join(s.baseDir, tombstonesDir)
+	var dirs []string
+	err = filepath.WalkDir(root, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && pth == root {
+				return fs.SkipAll
+			}
+			return err
+		}
+
+		if d.IsDir() {
+			dirs = append(dirs, pth)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if info.ModTime().Before(before) {
+			return os.Remove(pth)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can't purge tombstones: %w", err)
+	}
+
+	// children first, removal of non-empty ones fails
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+
+	return nil
+}
+
+func (s *FileStorage) tombstonePath(name string, id uint64) string {
+	return path.Join(s.baseDir, tombstonesDir, name, strconv.FormatUint(id, 10))
+}
+
+func (s *FileStorage) writeTombstone(name string, id uint64, stamp time.Time) error {
+	pth := s.tombstonePath(name, id)
+	if err := os.MkdirAll(path.Dir(pth), os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(pth, nil, 0o644); err != nil {
+		return err
+	}
+
+	return os.Chtimes(pth, stamp, stamp)
+}
+
+func (s *FileStorage) removeTombstone(name string, id uint64) error {
+	if err := os.Remove(s.tombstonePath(name, id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// tombstones returns the times of the deletes of the file chunks.
+func (s *FileStorage) tombstones(name string) (map[uint64]time.Time, error) {
+	entries, err := os.ReadDir(path.Join(s.baseDir, tombstonesDir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[uint64]time.Time{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	res := make(map[uint64]time.Time, len(entries))
+	for _, e := range entries {
+		id, err := strconv.ParseUint(e.Name(), 10, 64)
+		if err != nil || e.IsDir() {
+			continue // tombstones of the nested files
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		res[id] = info.ModTime()
+	}
+
+	return res, nil
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
+)
+
+func TestTombstone(t *testing.T) {
+	ctx := context.Background()
+	s := NewFileStorage(t.TempDir())
+
+	for id := range uint64(2) {
+		require.NoError(t, s.StoreChunk(ctx, chunks.Chunk{ID: id, Filename: "dir/file", Size: 5, Body: strings.NewReader("01234")}))
+	}
+
+	require.NoError(t, s.TombstoneChunk(ctx, "dir/file", 1))
+	require.ErrorIs(t, s.TombstoneChunk(ctx, "dir/file", 1), common.ErrNotFound)
+
+	replicas, err := s.ChunkReplicas(ctx, "dir/file")
+	require.NoError(t, err)
+	require.Len(t, replicas, 2)
+	require.False(t, replicas[0].Deleted)
+	require.EqualValues(t, 5, replicas[0].Size)
+	require.True(t, replicas[1].Deleted)
+	require.False(t, replicas[1].Stamp.Before(replicas[0].Stamp))
+
+	// tombstones are not files or chunks
+	names, err := s.ListFiles(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/file"}, names)
+
+	report, err := s.Scrub(ctx, ScrubOptions{})
+	require.NoError(t, err)
+	require.Empty(t, report.Unknown)
+
+	// the chunk stored again is not deleted anymore
+	require.NoError(t, s.StoreChunk(ctx, chunks.Chunk{ID: 1, Filename: "dir/file", Size: 3, Body: strings.NewReader("abc")}))
+	replicas, err = s.ChunkReplicas(ctx, "dir/file")
+	require.NoError(t, err)
+	require.False(t, replicas[1].Deleted)
+
+	// the stamp of the copied chunk is kept
+	stamp := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, s.SetChunkStamp(ctx, "dir/file", 1, stamp))
+	replicas, err = s.ChunkReplicas(ctx, "dir/file")
+	require.NoError(t, err)
+	require.True(t, stamp.Equal(replicas[1].Stamp))
+
+	// the file of the expired tombstones is gone
+	for id := range uint64(2) {
+		require.NoError(t, s.TombstoneChunk(ctx, "dir/file", id))
+	}
+	require.NoError(t, s.PurgeTombstones(ctx, time.Now().Add(time.Second)))
+
+	replicas, err = s.ChunkReplicas(ctx, "dir/file")
+	require.NoError(t, err)
+	require.Empty(t, replicas)
+}
@@ -0,0 +1,138 @@
+// Package testcluster starts in-process sfs nodes for tests.
+package testcluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/metaserver"
+	"github.com/tymbaca/sfs/internal/storage"
+	sfs "github.com/tymbaca/sfs/pkg/server"
+)
+
+var silence sync.Once
+
+// silenceLogger turns the logger off for the whole test binary. Nodes of one
+// test can still log while the next one runs, so tests must not toggle it.
+func silenceLogger() {
+	silence.Do(func() { logger.Enabled = false })
+}
+
+type Node struct {
+	Addr    string
+	Dir     string
+	Server  *sfs.Server
+	Storage *storage.FileStorage
+	// Stop stops the node, so it doesn't accept connections anymore.
+	Stop func()
+}
+
+// Start starts n nodes on random local ports, each with its own storage in
+// the test temp dir, and returns their addresses. Nodes are stopped when the
+// test ends.
+func Start(t testing.TB, n int) []string {
+	t.Helper()
+
+	nodes := StartNodes(t, n)
+	addrs := make([]string, 0, n)
+	for _, node := range nodes {
+		addrs = append(addrs, node.Addr)
+	}
+
+	return addrs
+}
+
+// StartNodes is the same as [Start], but returns the nodes.
+func StartNodes(t testing.TB, n int) []Node {
+	t.Helper()
+
+	dir := t.TempDir()
+	nodes := make([]Node, 0, n)
+	for i := range n {
+		nodes = append(nodes, StartNode(t, filepath.Join(dir, fmt.Sprint(i))))
+	}
+
+	return nodes
+}
+
+// StartNode starts a single node with storage in baseDir.
+func StartNode(t testing.TB, baseDir string) Node {
+	t.Helper()
+	silenceLogger()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	stor := storage.NewFileStorage(baseDir)
+	srv := sfs.New(lis.Addr().String(), stor)
+	go srv.Serve(ctx, lis)
+
+	return Node{
+		Addr:    lis.Addr().String(),
+		Dir:     baseDir,
+		Server:  srv,
+		Storage: stor,
+		Stop:    cancel,
+	}
+}
+
+type MetaReplica struct {
+	Addr string
+	Node *metaserver.Node
+	// Stop stops the replica, both API and raft.
+	Stop func()
+}
+
+// StartMeta starts the metadata service cluster of n in-memory replicas on
+// random local ports. The first replica bootstraps the cluster, others join
+// it. Replicas are stopped when the test ends.
+func StartMeta(t testing.TB, n int) []MetaReplica {
+	t.Helper()
+	silenceLogger()
+
+	replicas := make([]MetaReplica, 0, n)
+	for i := range n {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("can't listen: %s", err)
+		}
+
+		cfg := metaserver.Config{
+			APIAddr:          lis.Addr().String(),
+			RaftAddr:         "127.0.0.1:0",
+			HeartbeatTimeout: 200 * time.Millisecond,
+		}
+		if i > 0 {
+			cfg.Join = replicas[0].Addr
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		node, err := metaserver.NewNode(ctx, cfg)
+		if err != nil {
+			cancel()
+			t.Fatalf("can't start meta replica: %s", err)
+		}
+		go node.Serve(ctx, lis)
+
+		stop := func() {
+			cancel()
+			node.Shutdown()
+		}
+		t.Cleanup(stop)
+
+		replicas = append(replicas, MetaReplica{Addr: cfg.APIAddr, Node: node, Stop: stop})
+	}
+
+	return replicas
+}
@@ -3,20 +3,70 @@ package transport
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"time"
 
 	"github.com/tymbaca/sfs/internal/chunks"
 	"github.com/tymbaca/sfs/internal/codes"
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/member"
+	"github.com/tymbaca/sfs/internal/storage"
+	"github.com/tymbaca/sfs/pkg/qos"
 )
 
 type Transport interface {
-	// Sends the chunk to peer
+	// Sends the chunk to peer. Returns [common.ErrNoSpace] if peer has no
+	// space for it.
 	SendChunk(ctx context.Context, chunk chunks.Chunk) error
 	// Returns the chunk ids of the file that respondent has.
 	ListIDs(ctx context.Context, name string) ([]uint64, error)
-	RecvChunk(ctx context.Context, name string, id uint64) (chunks.Chunk, error)
+	// Receives the chunk. If accept codecs are given, the chunk stored
+	// compressed with one of them is received as is, otherwise respondent
+	// decompresses it.
+	RecvChunk(ctx context.Context, name string, id uint64, accept ...chunks.Codec) (chunks.Chunk, error)
+	// Returns names of all files that respondent has chunks of.
+	ListFiles(ctx context.Context) ([]string, error)
+	// Deletes the chunk from peer. Returns [common.ErrNotFound] if peer
+	// doesn't have it.
+	DeleteChunk(ctx context.Context, name string, id uint64) error
+	// Deletes the chunk from peer for good: peer remembers the delete, so
+	// repair deletes the replicas written before it instead of copying them
+	// back. Returns [common.ErrNotFound] if peer doesn't have it.
+	TombstoneChunk(ctx context.Context, name string, id uint64) error
+	// Checks whether respondent has the chunk.
+	HasChunk(ctx context.Context, name string, id uint64) (bool, error)
+	// Returns the last scrub report of respondent as JSON. Returns
+	// [common.ErrNotFound] if scrub didn't finish yet.
+	ScrubReport(ctx context.Context) ([]byte, error)
+	// Returns the hash summary of every file respondent holds. If peer is not
+	// empty, only the chunks that both respondent and peer must hold are
+	// summarized.
+	FileSums(ctx context.Context, peer string) ([]chunks.FileSum, error)
+	// Returns the checksums of the file chunks respondent holds, filtered by
+	// peer the same way as in FileSums.
+	ChunkSums(ctx context.Context, peer string, name string) ([]chunks.Sum, error)
+	// Returns the states of the file chunks respondent holds or deleted,
+	// filtered by peer the same way as in FileSums.
+	ChunkReplicas(ctx context.Context, peer string, name string) ([]chunks.Replica, error)
+	// Checks that respondent is alive.
+	Ping(ctx context.Context) error
+	// Sends the members known to requester and returns the members known to
+	// respondent, after it merged them.
+	Gossip(ctx context.Context, members []member.Member) ([]member.Member, error)
+	// Returns the cluster members known to respondent, sorted by address.
+	Members(ctx context.Context) ([]member.Member, error)
+	// Returns the capacity of respondent storage.
+	Stats(ctx context.Context) (storage.Stats, error)
+	// Creates the bucket or updates its quotas.
+	CreateBucket(ctx context.Context, bucket storage.Bucket) error
+	// Returns the buckets of respondent sorted by name.
+	ListBuckets(ctx context.Context) ([]storage.BucketStats, error)
+	// Deletes the empty bucket. Returns [common.ErrNotFound] if respondent
+	// doesn't have it.
+	DeleteBucket(ctx context.Context, name string) error
 	Close() error
 }
 
@@ -31,19 +81,38 @@ func NewTCPTransport(addr string) *TCPTransport {
 	}
 }
 
-func (t *TCPTransport) ensureDial() (err error) {
+func (t *TCPTransport) ensureDial(ctx context.Context) (err error) {
 	if t.conn == nil {
-		t.conn, err = net.Dial("tcp", t.addr)
+		var d net.Dialer
+		t.conn, err = d.DialContext(ctx, "tcp", t.addr)
 		if err != nil {
 			return err
 		}
+
+		// interactive requests go without the prefix, so old nodes still
+		// understand them
+		if class := qos.ClassFrom(ctx); class != qos.Interactive {
+			if err := writeClass(t.conn, class); err != nil {
+				t.conn.Close()
+				t.conn = nil
+				return fmt.Errorf("can't write the class: %w", err)
+			}
+		}
+
+		if bucket := common.BucketFrom(ctx); bucket != "" {
+			if err := writeBucket(t.conn, "+", bucket); err != nil {
+				t.conn.Close()
+				t.conn = nil
+				return fmt.Errorf("can't write the bucket: %w", err)
+			}
+		}
 	}
 
 	return nil
 }
 
 func (t *TCPTransport) SendChunk(ctx context.Context, chk chunks.Chunk) error {
-	if err := t.ensureDial(); err != nil {
+	if err := t.ensureDial(ctx); err != nil {
 		return err
 	}
 
@@ -52,11 +121,35 @@ func (t *TCPTransport) SendChunk(ctx context.Context, chk chunks.Chunk) error {
 		return err
 	}
 
-	return chunks.SendChunk(t.conn, chk)
+	if err := chunks.SendChunk(t.conn, chk); err != nil {
+		return err
+	}
+
+	// Wait for the server to store the chunk
+	code, err := readCode(t.conn)
+	if err != nil {
+		return fmt.Errorf("can't read the code: %w", err)
+	}
+
+	msg, err := readMsg(t.conn)
+	if err != nil {
+		return err
+	}
+
+	switch code {
+	case codes.Ok:
+		return nil
+	case codes.NoSpace:
+		return fmt.Errorf("%w: %s", common.ErrNoSpace, msg)
+	case codes.NotFound:
+		return fmt.Errorf("%w: %s", common.ErrNotFound, msg)
+	}
+
+	return fmt.Errorf("got error from server, code %d, msg: %s", code, msg)
 }
 
 func (t *TCPTransport) ListIDs(ctx context.Context, name string) ([]uint64, error) {
-	if err := t.ensureDial(); err != nil {
+	if err := t.ensureDial(ctx); err != nil {
 		return nil, err
 	}
 
@@ -114,9 +207,9 @@ func (t *TCPTransport) ListIDs(ctx context.Context, name string) ([]uint64, erro
 	return nil, fmt.Errorf("list ids: unsupported response code: %d", code)
 }
 
-func (t *TCPTransport) RecvChunk(ctx context.Context, name string, id uint64) (chunks.Chunk, error) {
+func (t *TCPTransport) RecvChunk(ctx context.Context, name string, id uint64, accept ...chunks.Codec) (chunks.Chunk, error) {
 	// TODO should we just create conn for each trans endpoint and defer close it?
-	if err := t.ensureDial(); err != nil {
+	if err := t.ensureDial(ctx); err != nil {
 		return chunks.Chunk{}, err
 	}
 
@@ -125,7 +218,12 @@ func (t *TCPTransport) RecvChunk(ctx context.Context, name string, id uint64) (c
 	}
 
 	// we need len of bytes, not len of utf-8 symbols, so we use [len]
-	if err := binary.Write(t.conn, binary.LittleEndian, uint64(len(name))); err != nil {
+	filenameSize := uint64(len(name))
+	if len(accept) > 0 {
+		filenameSize |= chunks.ExtFlag
+	}
+
+	if err := binary.Write(t.conn, binary.LittleEndian, filenameSize); err != nil {
 		return chunks.Chunk{}, fmt.Errorf("can't write filename size: %w", err)
 	}
 
@@ -137,6 +235,12 @@ func (t *TCPTransport) RecvChunk(ctx context.Context, name string, id uint64) (c
 		return chunks.Chunk{}, fmt.Errorf("can't write chunk ID: %w", err)
 	}
 
+	if len(accept) > 0 {
+		if err := binary.Write(t.conn, binary.LittleEndian, chunks.AcceptMask(accept...)); err != nil {
+			return chunks.Chunk{}, fmt.Errorf("can't write accepted codecs: %w", err)
+		}
+	}
+
 	// Starting to read response
 	code, err := readCode(t.conn)
 	if err != nil {
@@ -153,7 +257,7 @@ func (t *TCPTransport) RecvChunk(ctx context.Context, name string, id uint64) (c
 		return chk, nil
 
 	case codes.NotFound:
-		return chunks.Chunk{}, fmt.Errorf("file not found: %d", code)
+		return chunks.Chunk{}, common.ErrNotFound
 
 	case codes.Internal, codes.InvalidReq:
 		msg, err := readMsg(t.conn)
@@ -166,6 +270,549 @@ func (t *TCPTransport) RecvChunk(ctx context.Context, name string, id uint64) (c
 	return chunks.Chunk{}, fmt.Errorf("recv chunk: unsupported response code: %d", code)
 }
 
+func (t *TCPTransport) ListFiles(ctx context.Context) ([]string, error) {
+	if err := t.ensureDial(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, err := t.conn.Write([]byte("$")); err != nil {
+		return nil, err
+	}
+
+	code, err := readCode(t.conn)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the code: %w", err)
+	}
+
+	switch code {
+	case codes.Ok:
+		var count uint64
+		if err := binary.Read(t.conn, binary.LittleEndian, &count); err != nil {
+			return nil, fmt.Errorf("can't read the names count: %w", err)
+		}
+
+		names := make([]string, 0, count)
+		for i := range count {
+			name, err := readMsg(t.conn)
+			if err != nil {
+				return nil, fmt.Errorf("can't read the #%d name: %w", i, err)
+			}
+
+			names = append(names, name)
+		}
+
+		return names, nil
+
+	case codes.Internal, codes.InvalidReq:
+		msg, err := readMsg(t.conn)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("got error from server, code %d, msg: %s", code, msg)
+	}
+
+	return nil, fmt.Errorf("list files: unsupported response code: %d", code)
+}
+
+func (t *TCPTransport) DeleteChunk(ctx context.Context, name string, id uint64) error {
+	return t.deleteChunk(ctx, '-', name, id)
+}
+
+func (t *TCPTransport) TombstoneChunk(ctx context.Context, name string, id uint64) error {
+	return t.deleteChunk(ctx, '_', name, id)
+}
+
+func (t *TCPTransport) deleteChunk(ctx context.Context, head byte, name string, id uint64) error {
+	if err := t.ensureDial(ctx); err != nil {
+		return err
+	}
+
+	if _, err := t.conn.Write([]byte{head}); err != nil {
+		return err
+	}
+
+	// we need len of bytes, not len of utf-8 symbols, so we use [len]
+	if err := binary.Write(t.conn, binary.LittleEndian, uint64(len(name))); err != nil {
+		return fmt.Errorf("can't write filename size: %w", err)
+	}
+
+	if _, err := t.conn.Write([]byte(name)); err != nil {
+		return fmt.Errorf("can't write filename: %w", err)
+	}
+
+	if err := binary.Write(t.conn, binary.LittleEndian, id); err != nil {
+		return fmt.Errorf("can't write chunk ID: %w", err)
+	}
+
+	code, err := readCode(t.conn)
+	if err != nil {
+		return fmt.Errorf("can't read the code: %w", err)
+	}
+
+	switch code {
+	case codes.Ok:
+		_, err := readMsg(t.conn)
+		return err
+
+	case codes.NotFound:
+		return common.ErrNotFound
+
+	case codes.Internal, codes.InvalidReq:
+		msg, err := readMsg(t.conn)
+		if err != nil {
+			return err
+		}
+
+		return fmt.Errorf("got error from server, code %d, msg: %s", code, msg)
+	}
+
+	return fmt.Errorf("delete chunk: unsupported response code: %d", code)
+}
+
+func (t *TCPTransport) HasChunk(ctx context.Context, name string, id uint64) (bool, error) {
+	if err := t.ensureDial(ctx); err != nil {
+		return false, err
+	}
+
+	if _, err := t.conn.Write([]byte("#")); err != nil {
+		return false, err
+	}
+
+	// we need len of bytes, not len of utf-8 symbols, so we use [len]
+	if err := binary.Write(t.conn, binary.LittleEndian, uint64(len(name))); err != nil {
+		return false, fmt.Errorf("can't write filename size: %w", err)
+	}
+
+	if _, err := t.conn.Write([]byte(name)); err != nil {
+		return false, fmt.Errorf("can't write filename: %w", err)
+	}
+
+	if err := binary.Write(t.conn, binary.LittleEndian, id); err != nil {
+		return false, fmt.Errorf("can't write chunk ID: %w", err)
+	}
+
+	code, err := readCode(t.conn)
+	if err != nil {
+		return false, fmt.Errorf("can't read the code: %w", err)
+	}
+
+	switch code {
+	case codes.Ok:
+		return true, nil
+
+	case codes.NotFound:
+		return false, nil
+
+	case codes.Internal, codes.InvalidReq:
+		msg, err := readMsg(t.conn)
+		if err != nil {
+			return false, err
+		}
+
+		return false, fmt.Errorf("got error from server, code %d, msg: %s", code, msg)
+	}
+
+	return false, fmt.Errorf("has chunk: unsupported response code: %d", code)
+}
+
+func (t *TCPTransport) ScrubReport(ctx context.Context) ([]byte, error) {
+	if err := t.ensureDial(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, err := t.conn.Write([]byte("!")); err != nil {
+		return nil, err
+	}
+
+	code, err := readCode(t.conn)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the code: %w", err)
+	}
+
+	switch code {
+	case codes.Ok:
+		msg, err := readMsg(t.conn)
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(msg), nil
+
+	case codes.NotFound:
+		return nil, common.ErrNotFound
+
+	case codes.Internal, codes.InvalidReq:
+		msg, err := readMsg(t.conn)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("got error from server, code %d, msg: %s", code, msg)
+	}
+
+	return nil, fmt.Errorf("scrub report: unsupported response code: %d", code)
+}
+
+func (t *TCPTransport) FileSums(ctx context.Context, peer string) ([]chunks.FileSum, error) {
+	if err := t.writeSumsReq(ctx, peer, ""); err != nil {
+		return nil, err
+	}
+
+	count, err := t.readSumsCount()
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make([]chunks.FileSum, 0, count)
+	for i := range count {
+		name, err := readMsg(t.conn)
+		if err != nil {
+			return nil, fmt.Errorf("can't read the #%d filename: %w", i, err)
+		}
+
+		sum := chunks.FileSum{Name: name}
+		if _, err := io.ReadFull(t.conn, sum.Hash[:]); err != nil {
+			return nil, fmt.Errorf("can't read the #%d file hash: %w", i, err)
+		}
+
+		sums = append(sums, sum)
+	}
+
+	return sums, nil
+}
+
+func (t *TCPTransport) ChunkSums(ctx context.Context, peer string, name string) ([]chunks.Sum, error) {
+	if name == "" {
+		return nil, errors.New("filename is empty")
+	}
+
+	if err := t.writeSumsReq(ctx, peer, name); err != nil {
+		return nil, err
+	}
+
+	count, err := t.readSumsCount()
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make([]chunks.Sum, 0, count)
+	for i := range count {
+		var raw [3]uint64 // id, size, crc
+		if err := binary.Read(t.conn, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("can't read the #%d chunk sum: %w", i, err)
+		}
+
+		sums = append(sums, chunks.Sum{ID: raw[0], Size: raw[1], CRC: uint32(raw[2])})
+	}
+
+	return sums, nil
+}
+
+func (t *TCPTransport) ChunkReplicas(ctx context.Context, peer string, name string) ([]chunks.Replica, error) {
+	if name == "" {
+		return nil, errors.New("filename is empty")
+	}
+
+	if err := t.writeNamesReq(ctx, '<', peer, name); err != nil {
+		return nil, err
+	}
+
+	count, err := t.readSumsCount()
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := make([]chunks.Replica, 0, count)
+	for i := range count {
+		var raw [5]uint64 // id, size, crc, stamp, deleted
+		if err := binary.Read(t.conn, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("can't read the #%d chunk replica: %w", i, err)
+		}
+
+		replicas = append(replicas, chunks.Replica{
+			Sum:     chunks.Sum{ID: raw[0], Size: raw[1], CRC: uint32(raw[2])},
+			Stamp:   time.Unix(0, int64(raw[3])),
+			Deleted: raw[4] != 0,
+		})
+	}
+
+	return replicas, nil
+}
+
+func (t *TCPTransport) writeSumsReq(ctx context.Context, peer string, name string) error {
+	return t.writeNamesReq(ctx, '^', peer, name)
+}
+
+// writeNamesReq writes the request of head kind with the peer and file name.
+func (t *TCPTransport) writeNamesReq(ctx context.Context, head byte, peer string, name string) error {
+	if err := t.ensureDial(ctx); err != nil {
+		return err
+	}
+
+	if _, err := t.conn.Write([]byte{head}); err != nil {
+		return err
+	}
+
+	for _, s := range []string{peer, name} {
+		// we need len of bytes, not len of utf-8 symbols, so we use [len]
+		if err := binary.Write(t.conn, binary.LittleEndian, uint64(len(s))); err != nil {
+			return fmt.Errorf("can't write string size: %w", err)
+		}
+
+		if _, err := t.conn.Write([]byte(s)); err != nil {
+			return fmt.Errorf("can't write string: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (t *TCPTransport) readSumsCount() (uint64, error) {
+	code, err := readCode(t.conn)
+	if err != nil {
+		return 0, fmt.Errorf("can't read the code: %w", err)
+	}
+
+	switch code {
+	case codes.Ok:
+		var count uint64
+		if err := binary.Read(t.conn, binary.LittleEndian, &count); err != nil {
+			return 0, fmt.Errorf("can't read the sums count: %w", err)
+		}
+
+		return count, nil
+
+	case codes.Internal, codes.InvalidReq:
+		msg, err := readMsg(t.conn)
+		if err != nil {
+			return 0, err
+		}
+
+		return 0, fmt.Errorf("got error from server, code %d, msg: %s", code, msg)
+	}
+
+	return 0, fmt.Errorf("sums: unsupported response code: %d", code)
+}
+
+func (t *TCPTransport) Ping(ctx context.Context) error {
+	if err := t.ensureDial(ctx); err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		t.conn.SetDeadline(deadline)
+	}
+
+	if _, err := t.conn.Write([]byte("?")); err != nil {
+		return err
+	}
+
+	code, err := readCode(t.conn)
+	if err != nil {
+		return fmt.Errorf("can't read the code: %w", err)
+	}
+
+	msg, err := readMsg(t.conn)
+	if err != nil {
+		return err
+	}
+
+	if code != codes.Ok {
+		return fmt.Errorf("got error from server, code %d, msg: %s", code, msg)
+	}
+
+	return nil
+}
+
+func (t *TCPTransport) Gossip(ctx context.Context, members []member.Member) ([]member.Member, error) {
+	if err := t.ensureDial(ctx); err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		t.conn.SetDeadline(deadline)
+	}
+
+	if _, err := t.conn.Write([]byte("@")); err != nil {
+		return nil, err
+	}
+
+	if err := member.Write(t.conn, members); err != nil {
+		return nil, err
+	}
+
+	return t.readMembersResp()
+}
+
+func (t *TCPTransport) Members(ctx context.Context) ([]member.Member, error) {
+	if err := t.ensureDial(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, err := t.conn.Write([]byte("&")); err != nil {
+		return nil, err
+	}
+
+	return t.readMembersResp()
+}
+
+func (t *TCPTransport) readMembersResp() ([]member.Member, error) {
+	code, err := readCode(t.conn)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the code: %w", err)
+	}
+
+	switch code {
+	case codes.Ok:
+		return member.Read(t.conn)
+
+	case codes.Internal, codes.InvalidReq:
+		msg, err := readMsg(t.conn)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("got error from server, code %d, msg: %s", code, msg)
+	}
+
+	return nil, fmt.Errorf("members: unsupported response code: %d", code)
+}
+
+func (t *TCPTransport) Stats(ctx context.Context) (storage.Stats, error) {
+	if err := t.ensureDial(ctx); err != nil {
+		return storage.Stats{}, err
+	}
+
+	if _, err := t.conn.Write([]byte("=")); err != nil {
+		return storage.Stats{}, err
+	}
+
+	code, err := readCode(t.conn)
+	if err != nil {
+		return storage.Stats{}, fmt.Errorf("can't read the code: %w", err)
+	}
+
+	switch code {
+	case codes.Ok:
+		var fields [3]uint64
+		if err := binary.Read(t.conn, binary.LittleEndian, &fields); err != nil {
+			return storage.Stats{}, fmt.Errorf("can't read the stats: %w", err)
+		}
+
+		return storage.Stats{Used: fields[0], Free: fields[1], Total: fields[2]}, nil
+	case codes.Internal, codes.InvalidReq:
+		msg, err := readMsg(t.conn)
+		if err != nil {
+			return storage.Stats{}, err
+		}
+		return storage.Stats{}, fmt.Errorf("got error from server, code %d, msg: %s", code, msg)
+	}
+
+	return storage.Stats{}, fmt.Errorf("stats: unsupported response code: %d", code)
+}
+
+func (t *TCPTransport) CreateBucket(ctx context.Context, bucket storage.Bucket) error {
+	if err := t.ensureDial(ctx); err != nil {
+		return err
+	}
+
+	if err := writeBucket(t.conn, "(", bucket.Name); err != nil {
+		return err
+	}
+
+	if err := binary.Write(t.conn, binary.LittleEndian, []uint64{bucket.MaxBytes, bucket.MaxObjects}); err != nil {
+		return fmt.Errorf("can't write bucket quotas: %w", err)
+	}
+
+	return t.readBucketResp()
+}
+
+func (t *TCPTransport) ListBuckets(ctx context.Context) ([]storage.BucketStats, error) {
+	if err := t.ensureDial(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, err := t.conn.Write([]byte("[")); err != nil {
+		return nil, err
+	}
+
+	code, err := readCode(t.conn)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the code: %w", err)
+	}
+
+	switch code {
+	case codes.Ok:
+		var count uint64
+		if err := binary.Read(t.conn, binary.LittleEndian, &count); err != nil {
+			return nil, fmt.Errorf("can't read buckets count: %w", err)
+		}
+
+		buckets := make([]storage.BucketStats, 0, min(count, 1024))
+		for range count {
+			name, err := readMsg(t.conn)
+			if err != nil {
+				return nil, fmt.Errorf("can't read bucket: %w", err)
+			}
+
+			var fields [4]uint64
+			if err := binary.Read(t.conn, binary.LittleEndian, &fields); err != nil {
+				return nil, fmt.Errorf("can't read bucket stats: %w", err)
+			}
+
+			buckets = append(buckets, storage.BucketStats{
+				Bucket:  storage.Bucket{Name: name, MaxBytes: fields[0], MaxObjects: fields[1]},
+				Bytes:   fields[2],
+				Objects: fields[3],
+			})
+		}
+
+		return buckets, nil
+	case codes.Internal, codes.InvalidReq:
+		msg, err := readMsg(t.conn)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("got error from server, code %d, msg: %s", code, msg)
+	}
+
+	return nil, fmt.Errorf("list buckets: unsupported response code: %d", code)
+}
+
+func (t *TCPTransport) DeleteBucket(ctx context.Context, name string) error {
+	if err := t.ensureDial(ctx); err != nil {
+		return err
+	}
+
+	if err := writeBucket(t.conn, ")", name); err != nil {
+		return err
+	}
+
+	return t.readBucketResp()
+}
+
+func (t *TCPTransport) readBucketResp() error {
+	code, err := readCode(t.conn)
+	if err != nil {
+		return fmt.Errorf("can't read the code: %w", err)
+	}
+
+	msg, err := readMsg(t.conn)
+	if err != nil {
+		return err
+	}
+
+	switch code {
+	case codes.Ok:
+		return nil
+	case codes.NotFound:
+		return fmt.Errorf("%w: %s", common.ErrNotFound, msg)
+	}
+
+	return fmt.Errorf("got error from server, code %d, msg: %s", code, msg)
+}
+
 func (t *TCPTransport) Close() error {
 	if t.conn == nil {
 		return nil
@@ -176,9 +823,19 @@ func (t *TCPTransport) Close() error {
 	return conn.Close()
 }
 
+// readCode reads the response code. BUSY response of any request is returned
+// as [common.ErrBusy].
 func readCode(r io.Reader) (code codes.Code, err error) {
-	err = binary.Read(r, binary.LittleEndian, &code)
-	return
+	if err = binary.Read(r, binary.LittleEndian, &code); err != nil {
+		return code, err
+	}
+
+	if code == codes.Busy {
+		msg, _ := readMsg(r)
+		return code, fmt.Errorf("%w: %s", common.ErrBusy, msg)
+	}
+
+	return code, nil
 }
 
 func readMsg(r io.Reader) (string, error) {
@@ -194,3 +851,26 @@ func readMsg(r io.Reader) (string, error) {
 
 	return string(msgBuf), nil
 }
+
+// writeClass writes the class prefix of the request: '~' and the class.
+func writeClass(w io.Writer, class qos.Class) error {
+	if _, err := w.Write([]byte("~")); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, uint64(class))
+}
+
+// writeBucket writes the head and the bucket name.
+func writeBucket(w io.Writer, head string, name string) error {
+	if _, err := w.Write([]byte(head)); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(name))); err != nil {
+		return fmt.Errorf("can't write bucket size: %w", err)
+	}
+
+	_, err := w.Write([]byte(name))
+	return err
+}
@@ -0,0 +1,118 @@
+package chunkio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// CDCConfig configures content-defined chunking. Chunk sizes are within
+// [MinSize, MaxSize] (except the last chunk, which may be smaller) and are
+// normally distributed around AvgSize.
+type CDCConfig struct {
+	MinSize int64
+	AvgSize int64
+	MaxSize int64
+}
+
+func (cfg CDCConfig) validate() error {
+	if cfg.MinSize < 1 || cfg.MinSize > cfg.AvgSize || cfg.AvgSize > cfg.MaxSize {
+		return fmt.Errorf("invalid chunk sizes: must be 0 < min (%d) <= avg (%d) <= max (%d)", cfg.MinSize, cfg.AvgSize, cfg.MaxSize)
+	}
+
+	return nil
+}
+
+// SplitCDC splits r into content-defined chunks with FastCDC: boundaries are
+// found with the rolling Gear hash, so they depend on the content only and
+// inserting bytes changes only the chunks around the insertion. Returned
+// windows are the same [Reader] as [Split] returns. r is read once, in MaxSize
+// blocks.
+func SplitCDC(r io.ReaderAt, totalSize int64, cfg CDCConfig) ([]*Reader, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	// harder to match mask before AvgSize and easier after, it's "normalized
+	// chunking" of FastCDC, which narrows the size distribution
+	avgBits := bits.Len64(uint64(cfg.AvgSize)) - 1
+	maskS := highBits(avgBits + 1)
+	maskL := highBits(max(avgBits-1, 1))
+
+	chunks := make([]*Reader, 0, totalSize/cfg.AvgSize+1)
+	buf := make([]byte, cfg.MaxSize)
+	filled := 0
+	offset := int64(0) // offset of buf[0] in r
+
+	for offset < totalSize {
+		// refill the buffer
+		want := min(int64(len(buf)), totalSize-offset)
+		if int64(filled) < want {
+			n, err := r.ReadAt(buf[filled:want], offset+int64(filled))
+			if err != nil && !(errors.Is(err, io.EOF) && int64(filled+n) == want) {
+				return nil, fmt.Errorf("can't read at %d: %w", offset+int64(filled), err)
+			}
+			filled += n
+		}
+
+		cut := cutPoint(buf[:filled], cfg, maskS, maskL)
+		chunks = append(chunks, NewReader(r, offset, offset+int64(cut)))
+
+		copy(buf, buf[cut:filled])
+		filled -= cut
+		offset += int64(cut)
+	}
+
+	return chunks, nil
+}
+
+// cutPoint returns the length of the next chunk at the start of data.
+func cutPoint(data []byte, cfg CDCConfig, maskS, maskL uint64) int {
+	n := len(data)
+	if int64(n) <= cfg.MinSize {
+		return n
+	}
+
+	normal := min(n, int(cfg.AvgSize))
+
+	var fp uint64
+	i := int(cfg.MinSize)
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskS == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskL == 0 {
+			return i + 1
+		}
+	}
+
+	return n
+}
+
+// highBits returns the mask of n highest bits. High bits of Gear hash depend
+// on the last 64 bytes, low bits only on the last few.
+func highBits(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// gear is the table of random values for the Gear hash. It must be the same
+// for all clients, otherwise they cut the same content differently, so it's
+// generated from the fixed seed.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x5f5f736673) // splitmix64
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
@@ -0,0 +1,84 @@
+package chunkio
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCDC(t *testing.T) {
+	cfg := CDCConfig{MinSize: 2 << 10, AvgSize: 8 << 10, MaxSize: 32 << 10}
+
+	data := make([]byte, 4<<20)
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for i := range data {
+		data[i] = byte(rnd.Uint32())
+	}
+
+	t.Run("chunks cover the data", func(t *testing.T) {
+		rs, err := SplitCDC(bytes.NewReader(data), int64(len(data)), cfg)
+		require.NoError(t, err)
+
+		var joined []byte
+		for i, r := range rs {
+			if i < len(rs)-1 {
+				require.GreaterOrEqual(t, r.Size(), cfg.MinSize)
+			}
+			require.LessOrEqual(t, r.Size(), cfg.MaxSize)
+
+			b, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Len(t, b, int(r.Size()))
+			joined = append(joined, b...)
+		}
+		require.Equal(t, data, joined)
+
+		avg := int64(len(data)) / int64(len(rs))
+		require.InDelta(t, cfg.AvgSize, avg, float64(cfg.AvgSize)/2)
+	})
+
+	t.Run("insertion changes only nearby chunks", func(t *testing.T) {
+		before := chunkHashes(t, data, cfg)
+		after := chunkHashes(t, append([]byte{42}, data...), cfg)
+
+		shared := 0
+		for h := range after {
+			if before[h] {
+				shared++
+			}
+		}
+		require.GreaterOrEqual(t, shared, len(before)-3)
+	})
+
+	t.Run("small data is one chunk", func(t *testing.T) {
+		rs, err := SplitCDC(bytes.NewReader(data[:100]), 100, cfg)
+		require.NoError(t, err)
+		require.Len(t, rs, 1)
+		require.Equal(t, int64(100), rs[0].Size())
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := SplitCDC(bytes.NewReader(data), int64(len(data)), CDCConfig{MinSize: 10, AvgSize: 5, MaxSize: 20})
+		require.Error(t, err)
+	})
+}
+
+func chunkHashes(t *testing.T, data []byte, cfg CDCConfig) map[[32]byte]bool {
+	t.Helper()
+
+	rs, err := SplitCDC(bytes.NewReader(data), int64(len(data)), cfg)
+	require.NoError(t, err)
+
+	hashes := make(map[[32]byte]bool, len(rs))
+	for _, r := range rs {
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		hashes[sha256.Sum256(b)] = true
+	}
+
+	return hashes
+}
@@ -78,6 +78,33 @@ func (r *Reader) Read(p []byte) (int, error) {
 	return n, nil
 }
 
+// Seek sets the offset within the chunk.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		offset += r.start
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.limit
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+
+	if offset < r.start {
+		return 0, fmt.Errorf("negative position: %d", offset-r.start)
+	}
+
+	r.offset = offset
+	return offset - r.start, nil
+}
+
 func (r *Reader) Size() int64 {
 	return r.limit - r.start
 }
+
+// Clone returns new [Reader] over the same window, starting from the
+// beginning. Used to send the same chunk to several nodes.
+func (r *Reader) Clone() *Reader {
+	return NewReader(r.r, r.start, r.limit)
+}
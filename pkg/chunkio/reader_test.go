@@ -231,3 +231,27 @@ func TestReader(t *testing.T) {
 		require.ErrorIs(t, err, io.EOF)
 	})
 }
+
+func TestReaderSeek(t *testing.T) {
+	sr := strings.NewReader("1----2----3----")
+	r := NewReader(sr, 5, 10)
+
+	buf := make([]byte, 3)
+	_, err := r.Read(buf)
+	require.NoError(t, err)
+
+	pos, err := r.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), pos)
+
+	pos, err = r.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), pos)
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "2----", string(got))
+
+	_, err = r.Seek(-6, io.SeekEnd)
+	require.Error(t, err)
+}
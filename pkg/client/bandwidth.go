@@ -0,0 +1,81 @@
+package sfs
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/tymbaca/sfs/internal/ratelimit"
+	"golang.org/x/time/rate"
+)
+
+// Bandwidth is the limits of the client transfer rate, in bytes per second.
+// Non-positive means unlimited. The limits apply to the chunks as they are
+// sent, i.e. after compression and encryption.
+type Bandwidth struct {
+	// Upload and Download limit the client in total.
+	Upload   int64
+	Download int64
+	// NodeUpload and NodeDownload limit the transfers with each node.
+	NodeUpload   int64
+	NodeDownload int64
+}
+
+type limiters struct {
+	cfg      Bandwidth
+	upload   *rate.Limiter
+	download *rate.Limiter
+
+	mu    sync.Mutex
+	nodes map[string]*nodeLimiters
+}
+
+type nodeLimiters struct {
+	upload   *rate.Limiter
+	download *rate.Limiter
+}
+
+func newLimiters(cfg Bandwidth) *limiters {
+	return &limiters{
+		cfg:      cfg,
+		upload:   ratelimit.NewLimiter(cfg.Upload),
+		download: ratelimit.NewLimiter(cfg.Download),
+		nodes:    make(map[string]*nodeLimiters),
+	}
+}
+
+func (l *limiters) node(addr string) *nodeLimiters {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n, ok := l.nodes[addr]
+	if !ok {
+		n = &nodeLimiters{
+			upload:   ratelimit.NewLimiter(l.cfg.NodeUpload),
+			download: ratelimit.NewLimiter(l.cfg.NodeDownload),
+		}
+		l.nodes[addr] = n
+	}
+
+	return n
+}
+
+// uploadReader limits the rate of r sent to addr. Nil limiters don't limit.
+func (l *limiters) uploadReader(ctx context.Context, r io.Reader, addr string) io.Reader {
+	if l == nil {
+		return r
+	}
+
+	r = ratelimit.NewReader(ctx, r, l.node(addr).upload)
+	return ratelimit.NewReader(ctx, r, l.upload)
+}
+
+// downloadReader limits the rate of r received from addr.
+func (l *limiters) downloadReader(ctx context.Context, r io.Reader, addr string) io.Reader {
+	if l == nil {
+		return r
+	}
+
+	r = ratelimit.NewReader(ctx, r, l.node(addr).download)
+	return ratelimit.NewReader(ctx, r, l.download)
+}
@@ -0,0 +1,43 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/mem"
+)
+
+func TestBandwidth(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 2)
+	client := NewClient(strings.Join(addrs, ","), 64*mem.KiB, WithBandwidth(Bandwidth{
+		Upload:       256 * mem.KiB,
+		NodeDownload: 128 * mem.KiB,
+	}))
+
+	data := make([]byte, 512*mem.KiB)
+	rand.Read(data)
+
+	// the first second is the bucket burst
+	start := time.Now()
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+	require.Greater(t, time.Since(start), 900*time.Millisecond)
+
+	// one of the nodes gives at least half of the file
+	start = time.Now()
+	r, cls, _, err := client.Download(ctx, "file")
+	require.NoError(t, err)
+	defer cls()
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+	require.Greater(t, time.Since(start), 900*time.Millisecond)
+}
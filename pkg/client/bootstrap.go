@@ -0,0 +1,49 @@
+package sfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tymbaca/sfs/internal/member"
+	"github.com/tymbaca/sfs/internal/transport"
+)
+
+// Bootstrap creates the client for the cluster seed node is member of. The
+// nodes are taken from the seed member list (see Server.RunMembership) in its
+// order: the cluster seeds first, then the joined nodes. So bootstrapped
+// clients agree on placement with each other and with the clients created by
+// [NewClient] from the seeds. Dead and left members are kept in the list, only
+// marked down, because removing them would move the chunks of the whole
+// cluster. Membership only tells which nodes are live.
+func Bootstrap(ctx context.Context, seed string, chunkSize int64, opts ...Option) (*Client, error) {
+	trans := transport.NewTCPTransport(seed)
+	defer trans.Close()
+
+	members, err := trans.Members(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't get members from seed '%s': %w", seed, err)
+	}
+
+	if len(members) == 0 {
+		return nil, errors.New("seed knows no cluster members")
+	}
+
+	addrs := make([]string, 0, len(members))
+	for _, m := range members {
+		addrs = append(addrs, m.Addr)
+	}
+
+	c := NewClient(strings.Join(addrs, ","), chunkSize, opts...)
+	for _, m := range members {
+		switch m.State {
+		case member.Suspect:
+			c.health.failure(m.Addr, false)
+		case member.Dead, member.Left:
+			c.health.markDown(m.Addr)
+		}
+	}
+
+	return c, nil
+}
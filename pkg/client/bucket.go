@@ -0,0 +1,178 @@
+package sfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/storage"
+	"github.com/tymbaca/sfs/internal/transport"
+	"go.uber.org/multierr"
+	"golang.org/x/sync/errgroup"
+)
+
+// bucketsDir is the catalog directory the files of buckets are recorded in,
+// each under its bucket name. Nodes store their chunks by the plain name in
+// the bucket tree.
+const bucketsDir = "_buckets"
+
+// Bucket is the namespace of files with its own quotas. Quotas are enforced
+// by every node on its own.
+type Bucket struct {
+	Name string
+	// MaxBytes is the max size of the bucket chunks on each node. Zero means
+	// unlimited.
+	MaxBytes uint64
+	// MaxObjects is the max count of the bucket files on each node. Zero
+	// means unlimited.
+	MaxObjects uint64
+}
+
+type BucketStats struct {
+	Bucket
+	// Bytes is the size of the bucket chunks on all nodes, replicas included.
+	Bytes uint64
+	// Objects is the count of the bucket files on the node holding the most.
+	Objects uint64
+}
+
+// Bucket returns the client of the bucket: its files are stored and listed
+// apart from the files of the same name in other buckets. The bucket must be
+// created with [Client.CreateBucket] first. Empty name is the default bucket.
+// Deduplication is not supported in buckets.
+func (c *Client) Bucket(name string) *Client {
+	b := *c
+	b.bucket = name
+	return &b
+}
+
+// CreateBucket creates the bucket on every node, or updates its quotas.
+func (c *Client) CreateBucket(ctx context.Context, bucket Bucket) error {
+	if err := storage.CheckBucketName(bucket.Name); err != nil {
+		return fmt.Errorf("can't create the bucket: %w", err)
+	}
+
+	var g errgroup.Group
+	for _, addr := range c.addrs {
+		g.Go(func() error {
+			trans := transport.NewTCPTransport(addr)
+			defer trans.Close()
+
+			if err := trans.CreateBucket(ctx, storage.Bucket(bucket)); err != nil {
+				return fmt.Errorf("can't create the bucket on '%s': %w", addr, err)
+			}
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// ListBuckets returns the buckets of the live nodes sorted by name. Nodes
+// which didn't answer are missing from the stats and their errors are
+// returned.
+func (c *Client) ListBuckets(ctx context.Context) ([]BucketStats, error) {
+	var mu sync.Mutex
+	var errs error
+	merged := make(map[string]*BucketStats)
+
+	var g errgroup.Group
+	for _, addr := range c.liveAddrs() {
+		g.Go(func() error {
+			trans := transport.NewTCPTransport(addr)
+			defer trans.Close()
+
+			buckets, err := trans.ListBuckets(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("'%s': %w", addr, err))
+				return nil
+			}
+
+			for _, b := range buckets {
+				stats, ok := merged[b.Name]
+				if !ok {
+					stats = &BucketStats{Bucket: Bucket(b.Bucket)}
+					merged[b.Name] = stats
+				}
+				stats.Bytes += b.Bytes
+				stats.Objects = max(stats.Objects, b.Objects)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	buckets := make([]BucketStats, 0, len(merged))
+	for _, stats := range merged {
+		buckets = append(buckets, *stats)
+	}
+	slices.SortFunc(buckets, func(a, b BucketStats) int { return strings.Compare(a.Name, b.Name) })
+
+	return buckets, errs
+}
+
+// DeleteBucket deletes the bucket from every node. The bucket must be empty.
+func (c *Client) DeleteBucket(ctx context.Context, name string) error {
+	if c.meta != nil {
+		files, err := c.meta.List(ctx, c.Bucket(name).catalogName(""))
+		if err != nil {
+			return fmt.Errorf("can't delete the bucket: can't list files: %w", err)
+		}
+
+		if len(files) > 0 {
+			return fmt.Errorf("can't delete the bucket: %w: '%s' has %d files", storage.ErrBucketNotEmpty, name, len(files))
+		}
+	}
+
+	var g errgroup.Group
+	for _, addr := range c.addrs {
+		g.Go(func() error {
+			trans := transport.NewTCPTransport(addr)
+			defer trans.Close()
+
+			err := trans.DeleteBucket(ctx, name)
+			if err != nil && !errors.Is(err, common.ErrNotFound) {
+				return fmt.Errorf("can't delete the bucket on '%s': %w", addr, err)
+			}
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// withBucket returns ctx of the requests to the client bucket.
+func (c *Client) withBucket(ctx context.Context) context.Context {
+	if c.bucket == "" {
+		return ctx
+	}
+
+	return common.WithBucket(ctx, c.bucket)
+}
+
+// catalogName returns the name the file of the client bucket is recorded
+// under in the catalog.
+func (c *Client) catalogName(name string) string {
+	if c.bucket == "" {
+		return name
+	}
+
+	return bucketsDir + "/" + c.bucket + "/" + name
+}
+
+// inBucket reports whether the catalog name is of the file of the client
+// bucket.
+func (c *Client) inBucket(catalogName string) bool {
+	if c.bucket == "" {
+		return !strings.HasPrefix(catalogName, bucketsDir+"/")
+	}
+
+	return strings.HasPrefix(catalogName, c.catalogName(""))
+}
@@ -0,0 +1,69 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestBuckets(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 2)
+	addrs := nodes[0].Addr + "," + nodes[1].Addr
+	replicas := testcluster.StartMeta(t, 1)
+	catalog := meta.NewClient(replicas[0].Addr)
+
+	random := func(size int) []byte {
+		data := make([]byte, size)
+		rand.Read(data)
+		return data
+	}
+	upload := func(client *Client, name string, data []byte) error {
+		return client.Upload(ctx, name, bytes.NewReader(data), int64(len(data)))
+	}
+
+	client := NewClient(addrs, 512)
+	team := client.Bucket("team")
+	require.ErrorIs(t, upload(team, "build.zip", random(512)), common.ErrNotFound)
+	require.NoError(t, client.CreateBucket(ctx, Bucket{Name: "team", MaxObjects: 1}))
+
+	// the same name in the default and the team bucket
+	defaultData, teamData := random(4*512), random(3*512)
+	require.NoError(t, upload(client, "build.zip", defaultData))
+	require.NoError(t, upload(team, "build.zip", teamData))
+	assertDownload(t, client, "build.zip", defaultData)
+	assertDownload(t, team, "build.zip", teamData)
+
+	// bucket quota doesn't make the nodes full for others
+	require.ErrorIs(t, upload(team, "other.zip", random(512)), common.ErrNoSpace)
+	require.NoError(t, upload(client, "other.zip", random(512)))
+
+	buckets, err := client.ListBuckets(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []BucketStats{{Bucket: Bucket{Name: "team", MaxObjects: 1}, Bytes: 3 * 512, Objects: 1}}, buckets)
+	require.Error(t, client.DeleteBucket(ctx, "team"))
+
+	// catalog records the files of buckets apart too
+	metaClient := NewClient(addrs, 512, WithMeta(catalog))
+	require.NoError(t, metaClient.CreateBucket(ctx, Bucket{Name: "docs"}))
+	docs := metaClient.Bucket("docs")
+	docsData := random(2 * 512)
+	require.NoError(t, upload(docs, "build.zip", docsData))
+	assertDownload(t, docs, "build.zip", docsData)
+
+	_, err = catalog.Get(ctx, "_buckets/docs/build.zip")
+	require.NoError(t, err)
+	_, _, _, err = metaClient.Download(ctx, "build.zip")
+	require.ErrorContains(t, err, "file not found")
+
+	require.ErrorContains(t, metaClient.DeleteBucket(ctx, "docs"), "not empty")
+	require.NoError(t, catalog.Delete(ctx, "_buckets/docs/build.zip"))
+	require.ErrorContains(t, metaClient.DeleteBucket(ctx, "docs"), "not empty")
+}
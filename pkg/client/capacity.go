@@ -0,0 +1,60 @@
+package sfs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/tymbaca/sfs/internal/transport"
+	"go.uber.org/multierr"
+	"golang.org/x/sync/errgroup"
+)
+
+// Capacity is the storage capacity of the node in bytes.
+type Capacity struct {
+	Used uint64
+	// Free is how much more the node accepts within its limits.
+	Free  uint64
+	Total uint64
+}
+
+// NodeCapacity returns the capacity of every live node. Nodes which didn't
+// answer are missing from the result and their errors are returned.
+func (c *Client) NodeCapacity(ctx context.Context) (map[string]Capacity, error) {
+	var mu sync.Mutex
+	var errs error
+	capacities := make(map[string]Capacity)
+
+	var g errgroup.Group
+	for _, addr := range c.liveAddrs() {
+		g.Go(func() error {
+			capacity, err := c.nodeCapacity(ctx, addr)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("'%s': %w", addr, err))
+				return nil
+			}
+
+			capacities[addr] = capacity
+			c.health.setFull(addr, capacity.Free < uint64(c.chunkSize))
+			return nil
+		})
+	}
+	g.Wait()
+
+	return capacities, errs
+}
+
+func (c *Client) nodeCapacity(ctx context.Context, addr string) (Capacity, error) {
+	trans := transport.NewTCPTransport(addr)
+	defer trans.Close()
+
+	stats, err := trans.Stats(ctx)
+	if err != nil {
+		return Capacity{}, fmt.Errorf("can't get stats: %w", err)
+	}
+
+	return Capacity(stats), nil
+}
@@ -0,0 +1,40 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/storage"
+	"github.com/tymbaca/sfs/internal/testcluster"
+)
+
+func TestCapacity(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 2)
+	full, spare := nodes[0], nodes[1]
+	full.Storage.SetLimits(storage.Limits{Quota: 1024})
+	addrs := full.Addr + "," + spare.Addr
+
+	data := make([]byte, 10*512)
+	rand.Read(data)
+
+	// the node rejects what doesn't fit
+	client := NewClient(addrs, 512)
+	err := client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data)))
+	require.ErrorIs(t, err, common.ErrNoSpace)
+
+	// placement skips the full node once its capacity is known
+	capacities, err := client.NodeCapacity(ctx)
+	require.NoError(t, err)
+	require.Less(t, capacities[full.Addr].Free, uint64(512))
+	require.Equal(t, uint64(1024), capacities[full.Addr].Total)
+	require.Greater(t, capacities[spare.Addr].Free, uint64(len(data)))
+
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+	assertDownload(t, client, "file", data)
+}
@@ -0,0 +1,121 @@
+package sfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/placement"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+// HasMeta reports whether the client keeps the file manifests in the
+// metadata service, see [WithMeta].
+func (c *Client) HasMeta() bool {
+	return c.meta != nil
+}
+
+// manifestChunk is the uploaded chunk to be recorded in the catalog.
+type manifestChunk struct {
+	chunk  chunks.Chunk
+	nodes  []string
+	crc    *crcReader
+	parity bool
+	hash   string
+}
+
+// commitManifest records the uploaded file in the metadata service catalog.
+// File name, size and schemes are taken from file.
+func (c *Client) commitManifest(ctx context.Context, file meta.File, manifest []manifestChunk) error {
+	file.Chunks = make([]meta.Chunk, 0, len(manifest))
+	file.CreatedAt = time.Now().UTC()
+
+	for _, mc := range manifest {
+		file.Chunks = append(file.Chunks, meta.Chunk{
+			ID:     mc.chunk.ID,
+			Size:   mc.chunk.Size,
+			CRC:    mc.crc.crc,
+			Nodes:  mc.nodes,
+			Parity: mc.parity,
+			Hash:   mc.hash,
+		})
+	}
+
+	if err := c.meta.Put(ctx, file); err != nil {
+		return fmt.Errorf("can't commit the manifest of '%s': %w", file.Name, err)
+	}
+
+	return nil
+}
+
+// lookupManifest returns the manifest of the file from the catalog.
+func (c *Client) lookupManifest(ctx context.Context, name string) (meta.File, error) {
+	file, err := c.meta.Get(ctx, c.catalogName(name))
+	if err != nil {
+		if errors.Is(err, meta.ErrNotFound) {
+			return meta.File{}, ErrNotFound
+		}
+		return meta.File{}, fmt.Errorf("can't get the manifest: %w", err)
+	}
+
+	return file, nil
+}
+
+// holdersFromManifest returns the live holders of each chunk of the file.
+func (c *Client) holdersFromManifest(file meta.File) (map[uint64][]holder, error) {
+	holders := make(map[uint64][]holder, len(file.Chunks))
+	for _, chk := range file.Chunks {
+		sum := chunks.Sum{ID: chk.ID, Size: chk.Size, CRC: chk.CRC}
+		key := chunkKey{blobName(file), chk.ID}
+		if chk.Hash != "" {
+			key = contentKey(chk.Hash)
+		}
+
+		for _, addr := range c.manifestNodes(key, chk.Nodes) {
+			holders[chk.ID] = append(holders[chk.ID], holder{addr: addr, key: key, sum: sum})
+		}
+
+		if len(holders[chk.ID]) == 0 {
+			return nil, fmt.Errorf("all nodes holding chunk %d are down", chk.ID)
+		}
+	}
+
+	if len(holders) == 0 || !chunks.IsContinuous(holders) {
+		return nil, errors.New("file manifest is incomplete")
+	}
+
+	return holders, nil
+}
+
+// manifestNodes returns the live nodes to ask for the chunk the manifest lists
+// on nodes: them first, then the placement nodes of the current layout, then
+// the rest. Rebalance moves the chunks without updating the manifests.
+func (c *Client) manifestNodes(key chunkKey, nodes []string) []string {
+	order := slices.Concat(nodes, placement.Nodes(c.addrs, key.name, key.id, len(c.addrs)))
+
+	addrs := make([]string, 0, len(order))
+	for _, addr := range order {
+		if !c.health.isDown(addr) && !slices.Contains(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// crcReader computes CRC-32C of everything read through it.
+type crcReader struct {
+	r   io.Reader
+	crc uint32
+}
+
+func (r *crcReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc = crc32.Update(r.crc, crcTable, p[:n])
+	return n, err
+}
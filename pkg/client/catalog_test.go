@@ -0,0 +1,43 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestCatalog(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 3)
+	replicas := testcluster.StartMeta(t, 1)
+	catalog := meta.NewClient(replicas[0].Addr)
+
+	data := make([]byte, 10*512+100)
+	rand.Read(data)
+
+	client := NewClient(strings.Join(addrs, ","), 512, WithReplicas(2), WithMeta(catalog))
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+
+	file, err := catalog.Get(ctx, "file")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), file.Size)
+	require.Len(t, file.Chunks, 11)
+	for i, chk := range file.Chunks {
+		require.Equal(t, uint64(i), chk.ID)
+		require.Equal(t, client.resolveNodesByChunk(file.Blob, chk.ID), chk.Nodes)
+	}
+
+	assertDownload(t, client, "file", data)
+
+	// the chunk locations come from the catalog only
+	require.NoError(t, catalog.Delete(ctx, "file"))
+	_, _, _, err = client.Download(ctx, "file")
+	require.Error(t, err)
+}
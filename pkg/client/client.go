@@ -2,104 +2,307 @@ package sfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/spaolacci/murmur3"
 	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
 	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/placement"
 	"github.com/tymbaca/sfs/internal/transport"
 	"github.com/tymbaca/sfs/pkg/chunkio"
 	"github.com/tymbaca/sfs/pkg/mem"
+	"github.com/tymbaca/sfs/pkg/meta"
+	"golang.org/x/sync/errgroup"
 )
 
+// ErrNotFound is returned when the file is not stored.
+var ErrNotFound = errors.New("file not found")
+
+// ErrInvalidRange is returned by [Client.DownloadRange] if the range starts
+// past the end of the file.
+var ErrInvalidRange = errors.New("invalid range")
+
 type Client struct {
-	addrs     []string
-	chunkSize int64 // bytes
+	addrs       []string
+	chunkSize   int64 // bytes
+	cdc         *chunkio.CDCConfig
+	compression chunks.Codec
+	replicas    int
+
+	readRepair bool
+	repairSem  chan struct{}
+
+	health *nodeHealth
+	trims  *staleTrims
+
+	meta    *meta.Client
+	erasure *meta.Erasure
+	dedup   bool
+	kek     []byte
+
+	resumeDir string
+
+	progress         func(Progress)
+	progressInterval time.Duration
+
+	bandwidth *limiters
+
+	bucket string
 }
 
-func NewClient(addrs string, chunkSize int64) *Client {
-	return &Client{
+func NewClient(addrs string, chunkSize int64, opts ...Option) *Client {
+	c := &Client{
 		addrs:     strings.Split(addrs, ","),
 		chunkSize: chunkSize,
+		replicas:  1,
+		health:    newNodeHealth(),
+		trims:     newStaleTrims(),
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
 }
 
-func (c *Client) UploadFile(ctx context.Context, name string, f *os.File) error {
+func (c *Client) UploadFile(ctx context.Context, name string, f *os.File, opts ...UploadOption) error {
 	stat, err := f.Stat()
 	if err != nil {
 		return fmt.Errorf("can't get file stats: %w", err)
 	}
 
-	return c.Upload(ctx, name, f, stat.Size())
+	return c.Upload(ctx, name, f, stat.Size(), opts...)
 }
 
-func (c *Client) Upload(ctx context.Context, name string, r io.ReaderAt, totalSize int64) error {
-	chunks, err := formChunks(r, totalSize, name, c.chunkSize)
+func (c *Client) Upload(ctx context.Context, name string, r io.ReaderAt, totalSize int64, opts ...UploadOption) error {
+	ctx = c.withBucket(ctx)
+	ctx, tr := c.startTransfer(ctx, opUpload, name)
+	defer tr.finish()
+
+	file, key, err := c.newManifest(name, newUploadOptions(opts))
+	if err != nil {
+		return fmt.Errorf("can't upload the file: %w", err)
+	}
+
+	if c.erasure != nil {
+		return c.uploadErasure(ctx, file, r, totalSize, key)
+	}
+
+	if c.dedup {
+		if key != nil {
+			return errors.New("can't upload the file: deduplication is not compatible with encryption")
+		}
+		if c.bucket != "" {
+			return errors.New("can't upload the file: deduplication is not supported in buckets")
+		}
+		return c.uploadDedup(ctx, file, r, totalSize)
+	}
+
+	if c.resumeDir != "" && key == nil {
+		return c.uploadResumable(ctx, file, r, totalSize)
+	}
+
+	chks, err := c.splitStored(r, totalSize)
 	if err != nil {
-		return err
+		return fmt.Errorf("can't split the file: %w", err)
 	}
+	chunks := formChunks(chks, blobName(file))
 
-	var wg sync.WaitGroup
+	var g errgroup.Group
+	var manifest []manifestChunk
 	for chunk := range chunks {
-		wg.Add(1)
-		go c.uploadChunk(ctx, chunk, &wg)
+		addrs := c.resolveNodesByChunk(chunk.Filename, chunk.ID)
+		if len(addrs) == 0 {
+			g.Go(func() error {
+				return fmt.Errorf("can't upload chunk %d: all nodes are down", chunk.ID)
+			})
+			continue
+		}
+
+		for i, addr := range addrs {
+			tr.expect(int64(chunk.Size), 1)
+			replica := cloneChunk(chunk)
+			if c.meta != nil && i == 0 {
+				// checksum is computed on the fly from the first replica
+				crc := &crcReader{r: replica.Body}
+				replica.Body = crc
+				manifest = append(manifest, manifestChunk{chunk: chunk, nodes: addrs, crc: crc})
+			}
+
+			g.Go(func() error {
+				return c.uploadChunk(ctx, replica, addr, key)
+			})
+		}
 	}
 
-	wg.Wait()
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("can't upload the file: %w", err)
+	}
+
+	if c.meta == nil {
+		c.trimStale(ctx, name, uint64(len(chks)))
+		return nil
+	}
+
+	file.Size = totalSize
+	if err := c.commitManifest(ctx, file, manifest); err != nil {
+		return fmt.Errorf("can't upload the file: %w", err)
+	}
 
 	return nil
 }
 
-func (c *Client) uploadChunk(ctx context.Context, chunk chunks.Chunk, wg *sync.WaitGroup) error {
-	defer wg.Done()
+// uploadChunk sends the chunk to the node, sealed with key if it's not nil,
+// otherwise compressed if the compression is on.
+func (c *Client) uploadChunk(ctx context.Context, chunk chunks.Chunk, addr string, key *fileKey) error {
 	start := time.Now()
-	logger.Debugf("starting to upload the %d chunk", chunk.ID)
+	logger.Debugf("starting to upload the %d chunk to '%s'", chunk.ID, addr)
 	defer func() {
-		logger.Debugf("uploaded %d chunk, %.2f MiB, time elapsed: %s", chunk.ID, float32(chunk.Size)/float32(mem.MiB), time.Since(start))
+		logger.Debugf("uploaded %d chunk to '%s', %.2f MiB, time elapsed: %s", chunk.ID, addr, float32(chunk.Size)/float32(mem.MiB), time.Since(start))
 	}()
 
-	trans, err := c.getTransport(chunk)
-	if err != nil {
-		return fmt.Errorf("can't get transport: %w", err)
+	// the busy node has read the whole body, so the retry sends it again
+	tr := transferFrom(ctx)
+	rewind := rewinder(chunk.Body)
+	retry := func() bool {
+		if rewind == nil || !rewind() {
+			return false
+		}
+		tr.expect(int64(chunk.Size), 0)
+		return true
 	}
+
+	return retryBusy(ctx, retry, func() error {
+		return c.sendChunk(ctx, chunk, addr, key)
+	})
+}
+
+func (c *Client) sendChunk(ctx context.Context, chunk chunks.Chunk, addr string, key *fileKey) error {
+	tr := transferFrom(ctx)
+	chunk.Body = tr.reader(chunk.Body, addr, false)
+
+	if key != nil {
+		// ciphertext doesn't compress, so encrypted chunks are never compressed
+		var err error
+		if chunk, err = key.seal(chunk); err != nil {
+			return fmt.Errorf("can't encrypt chunk %d: %w", chunk.ID, err)
+		}
+	} else if c.compression != chunks.CodecNone {
+		var err error
+		if chunk, err = compressChunk(chunk, c.compression); err != nil {
+			return fmt.Errorf("can't compress chunk %d: %w", chunk.ID, err)
+		}
+	}
+
+	chunk.Body = c.bandwidth.uploadReader(ctx, chunk.Body, addr)
+
+	trans := transport.NewTCPTransport(addr)
 	defer trans.Close()
 
-	if err = trans.SendChunk(ctx, chunk); err != nil {
-		return fmt.Errorf("can't send chunk: %w", err)
+	if err := trans.SendChunk(ctx, chunk); err != nil {
+		// the bucket quota is not the node one
+		if errors.Is(err, common.ErrNoSpace) && c.bucket == "" {
+			c.health.setFull(addr, true)
+		}
+		c.health.requestFailed(addr, err)
+		return fmt.Errorf("can't send chunk %d to '%s': %w", chunk.ID, addr, err)
 	}
+	tr.chunkDone()
 
 	return nil
 }
 
-func (c *Client) getTransport(chunk chunks.Chunk) (transport.Transport, error) {
-	addr := c.resolveNodeByChunk(chunk.Filename, chunk.ID)
-	trans := transport.NewTCPTransport(addr)
+// resolveNodesByChunk returns the nodes which must hold the replicas of the
+// chunk. Down and full nodes are skipped in favor of the next ones in placement
+// order.
+func (c *Client) resolveNodesByChunk(name string, id uint64) []string {
+	addrs := make([]string, 0, c.replicas)
+	for _, addr := range placement.Nodes(c.addrs, name, id, len(c.addrs)) {
+		if len(addrs) == c.replicas {
+			break
+		}
+
+		if c.health.canStore(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	logger.Debugf("name '%s', id %d, addrs = %v", name, id, addrs)
+
+	return addrs
+}
 
-	return trans, nil
+// cloneChunk returns the copy of the chunk with its own body, so it can be
+// sent to another replica.
+func cloneChunk(chunk chunks.Chunk) chunks.Chunk {
+	if r, ok := chunk.Body.(*chunkio.Reader); ok {
+		chunk.Body = r.Clone()
+	}
+
+	return chunk
 }
 
-func (c *Client) resolveNodeByChunk(name string, id uint64) string {
-	key := []byte(name + fmt.Sprint(id))
-	hash := murmur3.Sum32(key)
-	logger.Debugf("name '%s', id %d, hash = %d", name, id, hash)
+// rewinder returns the function which makes r read from its current position
+// again, or nil if r can't be rewound.
+func rewinder(r io.Reader) func() bool {
+	switch r := r.(type) {
+	case *crcReader:
+		inner := rewinder(r.r)
+		if inner == nil {
+			return nil
+		}
+
+		crc := r.crc
+		return func() bool {
+			r.crc = crc
+			return inner()
+		}
+	case io.Seeker:
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil
+		}
+
+		return func() bool {
+			_, err := r.Seek(pos, io.SeekStart)
+			return err == nil
+		}
+	}
 
-	idx := int(hash) % len(c.addrs)
-	return c.addrs[idx]
+	return nil
 }
 
-func formChunks(r io.ReaderAt, totalSize int64, name string, size int64) (<-chan chunks.Chunk, error) {
-	if size < 1 {
+// split splits the file into chunks of chunkSize or, with content-defined
+// chunking, of variable size.
+func (c *Client) split(r io.ReaderAt, totalSize int64) ([]*chunkio.Reader, error) {
+	if c.cdc != nil {
+		return chunkio.SplitCDC(r, totalSize, *c.cdc)
+	}
+
+	if c.chunkSize < 1 {
 		panic("can't split byte non-positive size")
 	}
 
-	chks := chunkio.Split(r, totalSize, size)
+	return chunkio.Split(r, totalSize, c.chunkSize), nil
+}
+
+// splitStored splits the file uploaded without the manifest. The empty file
+// is the empty chunk 0, so it's told from the missing one.
+func (c *Client) splitStored(r io.ReaderAt, totalSize int64) ([]*chunkio.Reader, error) {
+	if totalSize == 0 && c.meta == nil {
+		return []*chunkio.Reader{chunkio.NewReader(r, 0, 0)}, nil
+	}
+
+	return c.split(r, totalSize)
+}
 
+func formChunks(chks []*chunkio.Reader, name string) <-chan chunks.Chunk {
 	ch := make(chan chunks.Chunk)
 	go func() {
 		defer close(ch)
@@ -113,7 +316,7 @@ func formChunks(r io.ReaderAt, totalSize int64, name string, size int64) (<-chan
 		}
 	}()
 
-	return ch, nil
+	return ch
 }
 
 func closeConns(conns []net.Conn) {
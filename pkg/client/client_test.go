@@ -2,8 +2,11 @@ package sfs
 
 import (
 	"bytes"
+	"crypto/rand"
 	"io"
 	"testing"
+
+	"github.com/tymbaca/sfs/pkg/chunkio"
 )
 
 func Test_split(t *testing.T) {
@@ -37,6 +40,32 @@ func Test_split(t *testing.T) {
 	// })
 }
 
+func Test_splitCDC(t *testing.T) {
+	data := make([]byte, 64<<10)
+	rand.Read(data)
+	r := bytes.NewReader(data)
+
+	c := NewClient("", 5, WithContentDefinedChunking(chunkio.CDCConfig{MinSize: 512, AvgSize: 2048, MaxSize: 8192}))
+	rs, err := c.split(r, r.Size())
+	if err != nil {
+		t.FailNow()
+	}
+
+	var joined []byte
+	for _, chunk := range rs {
+		if chunk.Size() > 8192 {
+			t.FailNow()
+		}
+
+		b, _ := io.ReadAll(chunk)
+		joined = append(joined, b...)
+	}
+
+	if len(rs) < 2 || !bytes.Equal(joined, data) {
+		t.FailNow()
+	}
+}
+
 func assertReaderString(t *testing.T, r io.Reader, data string) {
 	assertReader(t, r, []byte(data))
 }
@@ -0,0 +1,58 @@
+package sfs
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"go.uber.org/multierr"
+)
+
+// Compression is the codec chunks are compressed with on upload.
+type Compression uint64
+
+const (
+	NoCompression = Compression(chunks.CodecNone)
+	Zstd          = Compression(chunks.CodecZstd)
+	S2            = Compression(chunks.CodecS2)
+)
+
+// compressChunk compresses the chunk body in memory. Incompressible chunks
+// are sent as is.
+func compressChunk(chunk chunks.Chunk, codec chunks.Codec) (chunks.Chunk, error) {
+	raw, err := io.ReadAll(chunk.Body)
+	if err != nil {
+		return chunks.Chunk{}, err
+	}
+
+	data, err := chunks.Compress(codec, bytes.NewReader(raw))
+	if err != nil {
+		return chunks.Chunk{}, err
+	}
+
+	if len(data) >= len(raw) {
+		chunk.Body = bytes.NewReader(raw)
+		return chunk, nil
+	}
+
+	chunk.Body, chunk.Codec, chunk.BodySize = bytes.NewReader(data), codec, uint64(len(data))
+	return chunk, nil
+}
+
+// decompressChunk wraps the received chunk body with decompressor, if it's
+// compressed. Returned close func closes both.
+func decompressChunk(chk chunks.Chunk, closeChk func() error) (chunks.Chunk, func() error, error) {
+	if chk.Codec == chunks.CodecNone {
+		return chk, closeChk, nil
+	}
+
+	dec, err := chunks.Decompress(chk.Codec, chk.Body)
+	if err != nil {
+		return chunks.Chunk{}, nil, err
+	}
+
+	chk.Body, chk.Codec, chk.BodySize = dec, chunks.CodecNone, 0
+	return chk, func() error {
+		return multierr.Append(dec.Close(), closeChk())
+	}, nil
+}
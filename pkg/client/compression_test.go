@@ -0,0 +1,69 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/internal/transport"
+)
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+
+	node := testcluster.StartNodes(t, 1)[0]
+
+	var csv strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&csv, "%d,some,compressible,line\n", i)
+	}
+	data := []byte(csv.String())
+
+	for _, codec := range []Compression{Zstd, S2} {
+		t.Run(chunks.Codec(codec).String(), func(t *testing.T) {
+			name := "file." + chunks.Codec(codec).String()
+			client := NewClient(node.Addr, 4096, WithCompression(codec))
+			require.NoError(t, client.Upload(ctx, name, bytes.NewReader(data), int64(len(data))))
+
+			stat, err := os.Stat(filepath.Join(node.Dir, name, "0"))
+			require.NoError(t, err)
+			require.Less(t, stat.Size(), int64(4096))
+
+			// any client downloads it
+			assertDownload(t, NewClient(node.Addr, 4096), name, data)
+
+			// old clients get it decompressed by the node
+			trans := transport.NewTCPTransport(node.Addr)
+			chk, err := trans.RecvChunk(ctx, name, 0)
+			require.NoError(t, err)
+			require.Equal(t, chunks.CodecNone, chk.Codec)
+			require.Equal(t, uint64(4096), chk.Size)
+			body, err := io.ReadAll(chk.Body)
+			require.NoError(t, err)
+			require.Equal(t, data[:4096], body)
+			trans.Close()
+
+			// checksums are of the uncompressed data
+			sums, err := node.Storage.ChunkSums(ctx, name)
+			require.NoError(t, err)
+			require.Equal(t, uint64(4096), sums[0].Size)
+			require.Equal(t, crc32.Checksum(data[:4096], crcTable), sums[0].CRC)
+		})
+	}
+
+	// incompressible chunks are stored as is
+	client := NewClient(node.Addr, 4096, WithCompression(Zstd))
+	require.NoError(t, client.Upload(ctx, "tiny", strings.NewReader("0123456789"), 10))
+	stat, err := os.Stat(filepath.Join(node.Dir, "tiny", "0"))
+	require.NoError(t, err)
+	require.Equal(t, int64(10), stat.Size())
+}
@@ -0,0 +1,259 @@
+package sfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/chunkio"
+	"github.com/tymbaca/sfs/pkg/meta"
+	"golang.org/x/sync/errgroup"
+)
+
+// contentDir is the directory deduplicated chunks are stored in, each one as
+// the chunk 0 of the file named by its hash. For nodes they are regular files,
+// so scrub, anti-entropy repair and rebalance cover them too.
+const contentDir = "_cas"
+
+const (
+	// pinTTL is how long the chunks of the upload are kept from collection.
+	pinTTL = time.Hour
+	// collectLease is how long the collector holds the claimed chunk, it's
+	// collectable again after.
+	collectLease = time.Minute
+	// dedupAttempts limits the uploads of the file, repeated when its chunk
+	// was collected under the upload.
+	dedupAttempts = 3
+)
+
+func contentKey(hash string) chunkKey {
+	return chunkKey{name: contentDir + "/" + hash, id: 0}
+}
+
+// uploadDedup uploads the chunks by their content hash, skipping the ones
+// nodes already have, and commits the manifest referencing them. The chunks
+// are pinned first, so they are not collected under the upload. The upload
+// is repeated if some chunk was collected anyway, e.g. the upload outlived
+// the pin.
+func (c *Client) uploadDedup(ctx context.Context, file meta.File, r io.ReaderAt, totalSize int64) error {
+	if c.meta == nil {
+		return errors.New("can't upload the file: deduplication requires the metadata service")
+	}
+
+	chks, err := c.split(r, totalSize)
+	if err != nil {
+		return fmt.Errorf("can't split the file: %w", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := c.uploadContents(ctx, file, chks, totalSize)
+		if !errors.Is(err, meta.ErrCollected) || attempt == dedupAttempts {
+			return err
+		}
+
+		logger.Logf("chunk of '%s' was collected during upload, uploading again: %s", file.Name, err)
+	}
+}
+
+func (c *Client) uploadContents(ctx context.Context, file meta.File, chks []*chunkio.Reader, totalSize int64) error {
+	manifest := make([]manifestChunk, len(chks))
+	var g errgroup.Group
+	for id, chk := range chks {
+		g.Go(func() error {
+			mc, err := hashContent(uint64(id), chk)
+			manifest[id] = mc
+			return err
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("can't upload the file: %w", err)
+	}
+
+	hashes := make([]string, 0, len(manifest))
+	for _, mc := range manifest {
+		hashes = append(hashes, mc.hash)
+	}
+	if err := c.pinChunks(ctx, hashes); err != nil {
+		return fmt.Errorf("can't upload the file: %w", err)
+	}
+
+	for id, chk := range chks {
+		g.Go(func() error {
+			nodes, err := c.uploadContent(ctx, uint64(id), manifest[id].hash, chk)
+			manifest[id].nodes = nodes
+			return err
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("can't upload the file: %w", err)
+	}
+
+	file.Size = totalSize
+	if err := c.commitManifest(ctx, file, manifest); err != nil {
+		return fmt.Errorf("can't upload the file: %w", err)
+	}
+
+	return nil
+}
+
+// pinChunks pins the chunks, waiting for the collector holding some of them
+// to finish. Its lease limits the wait.
+func (c *Client) pinChunks(ctx context.Context, hashes []string) error {
+	for {
+		err := c.meta.PinChunks(ctx, hashes, pinTTL)
+		if !errors.Is(err, meta.ErrCollected) {
+			if err != nil {
+				return fmt.Errorf("can't pin chunks: %w", err)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("can't pin chunks: %w", ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// hashContent hashes the chunk.
+func hashContent(id uint64, chk *chunkio.Reader) (manifestChunk, error) {
+	crc := &crcReader{r: chk.Clone()}
+	h := sha256.New()
+	if _, err := io.Copy(h, crc); err != nil {
+		return manifestChunk{}, fmt.Errorf("can't hash chunk %d: %w", id, err)
+	}
+
+	return manifestChunk{
+		chunk: chunks.Chunk{ID: id, Size: uint64(chk.Size())},
+		crc:   crc,
+		hash:  hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// uploadContent uploads the pinned chunk to the nodes which must hold it, but
+// don't have it yet. Returns the nodes.
+func (c *Client) uploadContent(ctx context.Context, id uint64, hash string, chk *chunkio.Reader) ([]string, error) {
+	key := contentKey(hash)
+
+	addrs := c.resolveNodesByChunk(key.name, key.id)
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("can't upload chunk %d: all nodes are down", id)
+	}
+
+	tr := transferFrom(ctx)
+	for _, addr := range addrs {
+		has, err := c.hasChunk(ctx, key, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		tr.expect(chk.Size(), 1)
+		if has {
+			logger.Debugf("chunk %d (%s) is already on '%s', skipped", id, hash, addr)
+			tr.skip(chk.Size(), 1)
+			continue
+		}
+
+		chunk := chunks.Chunk{ID: key.id, Filename: key.name, Size: uint64(chk.Size()), Body: chk.Clone()}
+		if err := c.uploadChunk(ctx, chunk, addr, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return addrs, nil
+}
+
+func (c *Client) hasChunk(ctx context.Context, key chunkKey, addr string) (bool, error) {
+	trans := transport.NewTCPTransport(addr)
+	defer trans.Close()
+
+	has, err := trans.HasChunk(ctx, key.name, key.id)
+	if err != nil {
+		c.health.requestFailed(addr, err)
+		return false, fmt.Errorf("can't check chunk '%s' on '%s': %w", key.name, addr, err)
+	}
+
+	return has, nil
+}
+
+// CollectGarbage deletes from the nodes the deduplicated chunks no file has
+// referenced for longer than grace, returns the count of collected chunks.
+// The chunks pinned by uploads are skipped.
+func (c *Client) CollectGarbage(ctx context.Context, grace time.Duration) (int, error) {
+	if c.meta == nil {
+		return 0, errors.New("can't collect garbage: deduplication requires the metadata service")
+	}
+
+	garbage, err := c.meta.Garbage(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("can't get garbage: %w", err)
+	}
+
+	collected := 0
+	for _, g := range garbage {
+		if time.Since(g.Since) < grace {
+			continue
+		}
+
+		// claim it first, so it's not pinned by the upload while deleted.
+		// Failed deletion is retried after the lease.
+		if err := c.meta.ClaimGarbage(ctx, g.Hash, collectLease); errors.Is(err, meta.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return collected, fmt.Errorf("can't claim garbage %s: %w", g.Hash, err)
+		}
+
+		if err := c.collectContent(ctx, g.Hash); err != nil {
+			return collected, fmt.Errorf("can't collect garbage %s: %w", g.Hash, err)
+		}
+
+		if err := c.meta.DeleteGarbage(ctx, g.Hash); err != nil {
+			return collected, fmt.Errorf("can't delete garbage %s: %w", g.Hash, err)
+		}
+		collected++
+	}
+
+	return collected, nil
+}
+
+// collectContent deletes the claimed chunk, giving up before the lease ends,
+// as the chunk may be pinned and uploaded again after.
+func (c *Client) collectContent(ctx context.Context, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, collectLease/2)
+	defer cancel()
+
+	return c.deleteContent(ctx, hash)
+}
+
+// deleteContent deletes the deduplicated chunk from every node, not only the
+// placement ones, as the cluster may be not rebalanced yet.
+func (c *Client) deleteContent(ctx context.Context, hash string) error {
+	key := contentKey(hash)
+
+	var g errgroup.Group
+	for _, addr := range c.addrs {
+		g.Go(func() error {
+			trans := transport.NewTCPTransport(addr)
+			defer trans.Close()
+
+			err := trans.DeleteChunk(ctx, key.name, key.id)
+			if err != nil && !errors.Is(err, common.ErrNotFound) {
+				return fmt.Errorf("can't delete from '%s': %w", addr, err)
+			}
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
@@ -0,0 +1,130 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestDedup(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 3)
+	addrs := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		addrs = append(addrs, n.Addr)
+	}
+
+	replicas := testcluster.StartMeta(t, 1)
+	catalog := meta.NewClient(replicas[0].Addr)
+
+	client := NewClient(strings.Join(addrs, ","), 512, WithMeta(catalog), WithDedup(), WithReplicas(2))
+
+	stored := func() int {
+		count := 0
+		for _, n := range nodes {
+			names, err := n.Storage.ListFiles(ctx)
+			require.NoError(t, err)
+			for _, name := range names {
+				if strings.HasPrefix(name, contentDir+"/") {
+					count++
+				}
+			}
+		}
+		return count
+	}
+
+	data := make([]byte, 10*512)
+	rand.Read(data)
+
+	require.NoError(t, client.Upload(ctx, "a", bytes.NewReader(data), int64(len(data))))
+	require.Equal(t, 10*2, stored())
+
+	// the same content is not stored twice
+	require.NoError(t, client.Upload(ctx, "b", bytes.NewReader(data), int64(len(data))))
+	require.Equal(t, 10*2, stored())
+
+	assertDownload(t, client, "a", data)
+	assertDownload(t, client, "b", data)
+
+	// "a" shares the first half with the old version
+	newData := bytes.Clone(data)
+	rand.Read(newData[5*512:])
+	require.NoError(t, client.Upload(ctx, "a", bytes.NewReader(newData), int64(len(newData))))
+	require.Equal(t, 15*2, stored())
+
+	// old chunks are still referenced by "b"
+	collected, err := client.CollectGarbage(ctx, 0)
+	require.NoError(t, err)
+	require.Zero(t, collected)
+
+	// and by the replaced versions until they are pruned
+	require.NoError(t, catalog.Delete(ctx, "b"))
+	pruned, err := client.PruneVersions(ctx, Retention{})
+	require.NoError(t, err)
+	require.Equal(t, 2, pruned)
+	collected, err = client.CollectGarbage(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, 5, collected)
+	require.Equal(t, 10*2, stored())
+
+	assertDownload(t, client, "a", newData)
+}
+
+func TestDedupCollectRace(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 2)
+	replicas := testcluster.StartMeta(t, 1)
+	catalog := meta.NewClient(replicas[0].Addr)
+
+	client := NewClient(strings.Join(addrs, ","), 512, WithMeta(catalog), WithDedup())
+
+	data := make([]byte, 2*512)
+	rand.Read(data)
+
+	require.NoError(t, client.Upload(ctx, "a", bytes.NewReader(data), int64(len(data))))
+	require.NoError(t, catalog.Delete(ctx, "a"))
+	_, err := client.PruneVersions(ctx, Retention{})
+	require.NoError(t, err)
+
+	garbage, err := catalog.Garbage(ctx)
+	require.NoError(t, err)
+	require.Len(t, garbage, 2)
+	hash := garbage[0].Hash
+
+	// the upload pinned the chunks, they are not collected under it
+	require.NoError(t, catalog.PinChunks(ctx, []string{garbage[1].Hash}, time.Hour))
+	require.ErrorIs(t, catalog.ClaimGarbage(ctx, garbage[1].Hash, time.Hour), meta.ErrNotFound)
+
+	// the pin expired and the chunk is being collected, so the upload which
+	// skipped it can't commit
+	require.NoError(t, catalog.PinChunks(ctx, []string{hash}, time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, catalog.ClaimGarbage(ctx, hash, time.Hour))
+	require.ErrorIs(t, catalog.PinChunks(ctx, []string{hash}, time.Hour), meta.ErrCollected)
+	file := meta.File{Name: "b", Chunks: []meta.Chunk{{Hash: hash, Size: 512, Nodes: addrs[:1]}}}
+	require.ErrorIs(t, catalog.Put(ctx, file), meta.ErrCollected)
+
+	// the chunk which was never pinned is not trusted either
+	file.Chunks[0].Hash = strings.Repeat("0", 64)
+	require.ErrorIs(t, catalog.Put(ctx, file), meta.ErrCollected)
+
+	require.NoError(t, client.deleteContent(ctx, hash))
+	require.NoError(t, catalog.DeleteGarbage(ctx, hash))
+
+	// the collected chunk is uploaded again
+	require.NoError(t, client.Upload(ctx, "b", bytes.NewReader(data), int64(len(data))))
+	assertDownload(t, client, "b", data)
+
+	collected, err := client.CollectGarbage(ctx, 0)
+	require.NoError(t, err)
+	require.Zero(t, collected)
+}
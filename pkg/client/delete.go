@@ -0,0 +1,86 @@
+package sfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/meta"
+	"golang.org/x/sync/errgroup"
+)
+
+// Delete deletes the file. Versioned file becomes the replaced version, its
+// chunks are deleted when it's pruned, see [Client.PruneVersions]. Chunks of
+// deduplicated files are collected as garbage. Returns [ErrNotFound] if the
+// file is not stored.
+func (c *Client) Delete(ctx context.Context, name string) error {
+	ctx = c.withBucket(ctx)
+	if c.meta == nil {
+		deleted, err := c.deleteStored(ctx, name)
+		if err != nil {
+			return fmt.Errorf("can't delete '%s': %w", name, err)
+		}
+		if deleted == 0 {
+			return fmt.Errorf("can't delete '%s': %w", name, ErrNotFound)
+		}
+		return nil
+	}
+
+	file, err := c.lookupManifest(ctx, name)
+	if err != nil {
+		return fmt.Errorf("can't delete '%s': %w", name, err)
+	}
+
+	// forget it first, failed deletion leaks the chunks, which is better than
+	// the manifest of lost chunks
+	if err := c.meta.Delete(ctx, file.Name); errors.Is(err, meta.ErrNotFound) {
+		return fmt.Errorf("can't delete '%s': %w", name, ErrNotFound)
+	} else if err != nil {
+		return fmt.Errorf("can't delete '%s': %w", name, err)
+	}
+
+	// empty files have no chunks
+	if file.Version != 0 || len(file.Chunks) == 0 {
+		return nil
+	}
+
+	for _, chk := range file.Chunks {
+		if chk.Hash != "" {
+			return nil
+		}
+	}
+
+	if _, err := c.deleteStored(ctx, blobName(file)); err != nil {
+		return fmt.Errorf("can't delete '%s': %w", name, err)
+	}
+
+	return nil
+}
+
+// deleteStored deletes the chunks stored under name from every node, not only
+// the placement ones, as the cluster may be not rebalanced yet. Returns the
+// count of deleted chunks.
+func (c *Client) deleteStored(ctx context.Context, name string) (int, error) {
+	var deleted atomic.Int64
+
+	var g errgroup.Group
+	for _, addr := range c.addrs {
+		g.Go(func() error {
+			trans := transport.NewTCPTransport(addr)
+			defer trans.Close()
+
+			ids, err := trans.ListIDs(ctx, name)
+			if err != nil {
+				return fmt.Errorf("can't list chunks on '%s': %w", addr, err)
+			}
+			deleted.Add(int64(len(ids)))
+
+			return deleteChunks(ctx, addr, name, ids)
+		})
+	}
+
+	err := g.Wait()
+	return int(deleted.Load()), err
+}
@@ -0,0 +1,68 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 2)
+	replicas := testcluster.StartMeta(t, 1)
+	nodes := addrs[0] + "," + addrs[1]
+	catalog := meta.NewClient(replicas[0].Addr)
+
+	data := make([]byte, 3*512)
+	rand.Read(data)
+
+	stored := func(name string) int {
+		count := 0
+		for _, addr := range addrs {
+			ids, err := transport.NewTCPTransport(addr).ListIDs(ctx, name)
+			require.NoError(t, err)
+			count += len(ids)
+		}
+		return count
+	}
+
+	plain := NewClient(nodes, 512)
+	require.NoError(t, plain.Upload(ctx, "plain", bytes.NewReader(data), int64(len(data))))
+	require.NotZero(t, stored("plain"))
+
+	require.NoError(t, plain.Delete(ctx, "plain"))
+	require.Zero(t, stored("plain"))
+	_, err := plain.Stat(ctx, "plain")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.ErrorIs(t, plain.Delete(ctx, "plain"), ErrNotFound)
+
+	// the replaced version keeps its chunks until it's pruned
+	versioned := NewClient(nodes, 512, WithMeta(catalog))
+	require.NoError(t, versioned.Upload(ctx, "versioned", bytes.NewReader(data), int64(len(data))))
+	file, err := catalog.Get(ctx, "versioned")
+	require.NoError(t, err)
+	blob := versionBlob("versioned", file.Version)
+
+	require.NoError(t, versioned.Delete(ctx, "versioned"))
+	_, err = versioned.Stat(ctx, "versioned")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, versioned.Delete(ctx, "versioned"), ErrNotFound)
+
+	versions, err := catalog.Versions(ctx, "versioned")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.NotZero(t, stored(blob))
+
+	pruned, err := versioned.PruneVersions(ctx, Retention{})
+	require.NoError(t, err)
+	require.Equal(t, 1, pruned)
+	require.Zero(t, stored(blob))
+}
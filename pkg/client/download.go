@@ -1,48 +1,278 @@
 package sfs
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"slices"
 	"sync"
 
 	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/placement"
 	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/meta"
 	"go.uber.org/multierr"
 	"golang.org/x/sync/errgroup"
 )
 
 func (c *Client) Download(ctx context.Context, name string) (io.Reader, func() error, int64, error) {
-	// Get id-addr mapping to know where to go for each chunk
-	idToAddr, err := c.resolveChunksAddrs(ctx, name)
+	ctx = c.withBucket(ctx)
+	ctx, tr := c.startTransfer(ctx, opDownload, name)
+	return tr.finishDownload(c.download(ctx, name))
+}
+
+func (c *Client) download(ctx context.Context, name string) (io.Reader, func() error, int64, error) {
+	if c.meta != nil {
+		file, err := c.lookupManifest(ctx, name)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("can't download the file: can't resolve chunks for '%s': %w", name, err)
+		}
+
+		return c.downloadManifest(ctx, file)
+	}
+
+	// Get id-holders mapping to know where to go for each chunk
+	holders, err := c.resolveChunksAddrs(ctx, name)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("can't download the file: can't resolve chunks for '%s': %w", name, err)
+	}
+
+	return c.downloadChunks(ctx, name, holders, nil, false)
+}
+
+// DownloadRange downloads length bytes of the file from offset. Only the
+// chunks holding them are received, except for erasure coded files, which are
+// received whole. Negative length means up to the end of the file. Returns the
+// size of the range.
+func (c *Client) DownloadRange(ctx context.Context, name string, offset, length int64) (io.Reader, func() error, int64, error) {
+	ctx = c.withBucket(ctx)
+	ctx, tr := c.startTransfer(ctx, opDownload, name)
+	return tr.finishDownload(c.downloadRange(ctx, name, offset, length))
+}
+
+func (c *Client) downloadRange(ctx context.Context, name string, offset, length int64) (io.Reader, func() error, int64, error) {
+	if c.meta != nil {
+		file, err := c.lookupManifest(ctx, name)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("can't download the file: can't resolve chunks for '%s': %w", name, err)
+		}
+
+		return c.downloadManifestRange(ctx, file, offset, length, false)
+	}
+
+	holders, err := c.resolveChunksAddrs(ctx, name)
 	if err != nil {
 		return nil, nil, 0, fmt.Errorf("can't download the file: can't resolve chunks for '%s': %w", name, err)
 	}
 
-	chunks := make([]chunks.Chunk, len(idToAddr))
-	closes := make([]func() error, len(idToAddr))
+	// chunk sizes are known only in read repair mode
+	if !c.readRepair {
+		sums, err := c.nodeChunkSums(ctx, name)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("can't download the file: can't get chunk sizes of '%s': %w", name, err)
+		}
+
+		if len(sums) != len(holders) {
+			return nil, nil, 0, fmt.Errorf("can't download the file: chunks of '%s' changed meanwhile", name)
+		}
+
+		for _, sum := range sums {
+			for i := range holders[sum.ID] {
+				holders[sum.ID][i].sum = sum
+			}
+		}
+	}
+
+	return c.downloadHoldersRange(ctx, name, holders, nil, offset, length, false)
+}
+
+// downloadManifestRange downloads the range of the file by its manifest. The
+// chunks are verified against the manifest if verify is true.
+func (c *Client) downloadManifestRange(ctx context.Context, file meta.File, offset, length int64, verify bool) (io.Reader, func() error, int64, error) {
+	if file.Erasure != nil {
+		offset, length, err := clampRange(file.Size, offset, length)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("can't download the file: %w", err)
+		}
+
+		r, cls, _, err := c.downloadManifest(ctx, file)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		return sliceReader(r, cls, offset, length, false)
+	}
+
+	key, err := c.openFileKey(file)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("can't download the file: %w", err)
+	}
+
+	holders, err := c.holdersFromManifest(file)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("can't download the file: can't resolve chunks for '%s': %w", file.Name, err)
+	}
+
+	return c.downloadHoldersRange(ctx, file.Name, holders, key, offset, length, verify)
+}
+
+// downloadHoldersRange downloads the range of the file from the holders of
+// its chunks, which must know the chunk sizes.
+func (c *Client) downloadHoldersRange(ctx context.Context, name string, holders map[uint64][]holder, key *fileKey, offset, length int64, verify bool) (io.Reader, func() error, int64, error) {
+	total := int64(0)
+	for _, hs := range holders {
+		total += int64(hs[0].sum.Size)
+	}
+
+	offset, length, err := clampRange(total, offset, length)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("can't download the file: %w", err)
+	}
+
+	if length == 0 {
+		return bytes.NewReader(nil), func() error { return nil }, 0, nil
+	}
+
+	// chunks are continuous, so the ones in range are found in order of ids
+	part := make(map[uint64][]holder)
+	skip, pos := int64(0), int64(0)
+	for id := range uint64(len(holders)) {
+		size := int64(holders[id][0].sum.Size)
+		if pos+size > offset && pos < offset+length {
+			if len(part) == 0 {
+				skip = offset - pos
+			}
+			part[id] = holders[id]
+		}
+		pos += size
+	}
+
+	r, cls, _, err := c.downloadChunks(ctx, name, part, key, verify)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	// the rest of the last chunk is read in read repair mode, so it's verified
+	// and the repair starts
+	return sliceReader(r, cls, skip, length, c.readRepair)
+}
+
+// clampRange returns the range of the file of size, with length limited by the
+// end of the file.
+func clampRange(size, offset, length int64) (int64, int64, error) {
+	if offset < 0 || offset > size {
+		return 0, 0, fmt.Errorf("%w: offset %d of %d bytes", ErrInvalidRange, offset, size)
+	}
+
+	if length < 0 || length > size-offset {
+		length = size - offset
+	}
+
+	return offset, length, nil
+}
+
+// sliceReader skips skip bytes of r and limits it by length. The rest of r is
+// read and discarded after the range if drain is true.
+func sliceReader(r io.Reader, cls func() error, skip, length int64, drain bool) (io.Reader, func() error, int64, error) {
+	if _, err := io.CopyN(io.Discard, r, skip); err != nil {
+		cls()
+		return nil, nil, 0, fmt.Errorf("can't download the file: can't skip to the range: %w", err)
+	}
+
+	if drain {
+		return &drainingReader{r: r, n: length}, cls, length, nil
+	}
+
+	return io.LimitReader(r, length), cls, length, nil
+}
+
+// drainingReader reads n bytes of r, then reads the rest of r before it
+// returns [io.EOF].
+type drainingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (d *drainingReader) Read(p []byte) (int, error) {
+	if d.n <= 0 {
+		if _, err := io.Copy(io.Discard, d.r); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+
+	n, err := d.r.Read(p[:min(int64(len(p)), d.n)])
+	d.n -= int64(n)
+
+	return n, err
+}
+
+// downloadManifest downloads the file by its manifest. The manifest pins the
+// version, so the concurrent upload doesn't affect the download.
+func (c *Client) downloadManifest(ctx context.Context, file meta.File) (io.Reader, func() error, int64, error) {
+	key, err := c.openFileKey(file)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("can't download the file: %w", err)
+	}
+
+	if file.Erasure != nil {
+		return c.downloadErasure(ctx, file, key)
+	}
+
+	holders, err := c.holdersFromManifest(file)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("can't download the file: can't resolve chunks for '%s': %w", file.Name, err)
+	}
+
+	return c.downloadChunks(ctx, file.Name, holders, key, false)
+}
+
+// downloadChunks receives the chunks from their holders and decrypts them
+// with key, if it's not nil. The chunks are verified against the checksums of
+// the holders in read repair mode or if verify is true.
+func (c *Client) downloadChunks(ctx context.Context, name string, holders map[uint64][]holder, key *fileKey, verify bool) (io.Reader, func() error, int64, error) {
+	// holders may be of the part of the file, see [Client.DownloadRange]
+	ids := make([]uint64, 0, len(holders))
+	for id := range holders {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	chunks := make([]chunks.Chunk, len(ids))
+	closes := make([]func() error, len(ids))
+	sources := make([][]holder, len(ids))
 	var g errgroup.Group
 
 	// Receive all chunks
-	for id, addr := range idToAddr {
-		id, addr := id, addr
+	for i, id := range ids {
+		hs := holders[id]
 		g.Go(func() error {
-			trans := transport.NewTCPTransport(addr)
+			chk, src, cls, err := c.recvChunk(ctx, hs, key)
 
-			chk, err := trans.RecvChunk(ctx, name, id)
-
-			chunks[id] = chk // result will be in order of IDs: 0, 1, 2, etc
-			closes[id] = trans.Close
+			chunks[i] = chk // result will be in order of IDs
+			closes[i] = cls
+			sources[i] = src
 			return err
 		})
 	}
 
-	err = g.Wait()
+	err := g.Wait()
 	if err != nil {
+		for _, cls := range closes {
+			if cls != nil {
+				cls()
+			}
+		}
 		return nil, nil, 0, fmt.Errorf("can't download the file: %w", err)
 	}
 
+	for i, src := range sources {
+		holders[ids[i]] = src
+	}
+
 	// Merge readers
 	readers := make([]io.Reader, 0, len(chunks))
 	size := int64(0)
@@ -50,11 +280,20 @@ func (c *Client) Download(ctx context.Context, name string) (io.Reader, func() e
 		size += int64(chk.Size)
 		readers = append(readers, chk.Body)
 	}
+	transferFrom(ctx).expect(size, len(chunks))
+
+	if c.readRepair {
+		readers = c.withReadRepair(ctx, name, holders, ids, readers)
+	} else if verify {
+		for i, r := range readers {
+			readers[i] = &verifyingReader{r: r, crc: crc32.New(crcTable), name: name, src: holders[ids[i]][0]}
+		}
+	}
 
 	mergedReader := io.MultiReader(readers...)
 	closeFn := func() (err error) {
 		for _, cls := range closes {
-			multierr.Append(err, cls())
+			err = multierr.Append(err, cls())
 		}
 		return err
 	}
@@ -62,62 +301,173 @@ func (c *Client) Download(ctx context.Context, name string) (io.Reader, func() e
 	return mergedReader, closeFn, size, nil
 }
 
-func (c *Client) resolveChunksAddrs(ctx context.Context, name string) (map[uint64]string, error) {
-	addrToIDs := make(map[string][]uint64, len(c.addrs))
+// recvChunk receives the chunk from the first holder which answers. Returns
+// the holders with the one used in the first place. The chunk is decrypted
+// with key, if it's not nil.
+func (c *Client) recvChunk(ctx context.Context, hs []holder, key *fileKey) (chk chunks.Chunk, src []holder, cls func() error, err error) {
+	// the holders are asked again if the ones which didn't fail were busy
+	err = retryBusy(ctx, nil, func() error {
+		chk, src, cls, err = c.recvChunkOnce(ctx, hs, key)
+		return err
+	})
+
+	return chk, src, cls, err
+}
+
+func (c *Client) recvChunkOnce(ctx context.Context, hs []holder, key *fileKey) (chunks.Chunk, []holder, func() error, error) {
+	var errs error
+	for i, h := range hs {
+		trans := transport.NewTCPTransport(h.addr)
+
+		chk, err := trans.RecvChunk(ctx, h.key.name, h.key.id, chunks.Codecs...)
+		if err != nil {
+			trans.Close()
+			c.health.requestFailed(h.addr, err)
+			errs = multierr.Append(errs, fmt.Errorf("can't receive chunk %d of '%s' from '%s': %w", h.key.id, h.key.name, h.addr, err))
+			continue
+		}
+		chk.Body = c.bandwidth.downloadReader(ctx, chk.Body, h.addr)
+
+		chk, cls, err := decompressChunk(chk, trans.Close)
+		if err != nil {
+			trans.Close()
+			errs = multierr.Append(errs, fmt.Errorf("can't decompress chunk %d of '%s' from '%s': %w", h.key.id, h.key.name, h.addr, err))
+			continue
+		}
+
+		if chk, err = key.open(chk, h.key.name, h.key.id); err != nil {
+			cls()
+			errs = multierr.Append(errs, fmt.Errorf("can't decrypt chunk %d of '%s' from '%s': %w", h.key.id, h.key.name, h.addr, err))
+			continue
+		}
+
+		chk.Body = transferFrom(ctx).reader(chk.Body, h.addr, true)
+		src := append([]holder{h}, slices.Delete(slices.Clone(hs), i, i+1)...)
+		return chk, src, cls, nil
+	}
+
+	return chunks.Chunk{}, hs, nil, errs
+}
+
+// holder is the node which holds the chunk. sum is known only in read repair
+// mode or with the metadata service.
+type holder struct {
+	addr string
+	key  chunkKey
+	sum  chunks.Sum
+}
+
+// chunkKey is the name and id the chunk is stored under on the node. It's the
+// file name and chunk id, except for deduplicated chunks.
+type chunkKey struct {
+	name string
+	id   uint64
+}
+
+// resolveChunksAddrs returns all the nodes holding each chunk of the file, in
+// the order of c.addrs.
+func (c *Client) resolveChunksAddrs(ctx context.Context, name string) (map[uint64][]holder, error) {
+	addrs := c.liveAddrs()
+	addrToHolds := make(map[string][]holder, len(addrs))
+	answered := make(map[string]bool, len(addrs))
 	var mu sync.Mutex
+	var nodeErrs error
 	var g errgroup.Group
 
-	// Get chunk ids from each address
-	for _, addr := range c.addrs {
+	// Get chunk ids from each live address. Unreachable node is fine as long
+	// as other replicas cover its chunks and the end of the file, see checkEnd.
+	for _, addr := range addrs {
 		addr := addr
 		g.Go(func() (err error) {
+			defer func() {
+				if err != nil {
+					c.health.requestFailed(addr, err)
+					mu.Lock()
+					nodeErrs = multierr.Append(nodeErrs, fmt.Errorf("'%s': %w", addr, err))
+					mu.Unlock()
+				}
+			}()
+
 			trans := transport.NewTCPTransport(addr)
+			defer trans.Close()
+
+			var holds []holder
+			if c.readRepair {
+				// checksums are needed to find damaged replicas
+				sums, err := trans.ChunkSums(ctx, "", name)
+				if err != nil {
+					return err
+				}
 
-			ids, err := trans.ListIDs(ctx, name)
-			if err != nil {
-				return err
+				for _, sum := range sums {
+					holds = append(holds, holder{addr: addr, key: chunkKey{name, sum.ID}, sum: sum})
+				}
+			} else {
+				ids, err := trans.ListIDs(ctx, name)
+				if err != nil {
+					return err
+				}
+
+				for _, id := range ids {
+					holds = append(holds, holder{addr: addr, key: chunkKey{name, id}, sum: chunks.Sum{ID: id}})
+				}
 			}
 
 			mu.Lock()
-			addrToIDs[addr] = ids
+			addrToHolds[addr] = holds
+			answered[addr] = true
 			mu.Unlock()
 			return nil
 		})
 	}
 
-	err := g.Wait()
-	if err != nil {
-		return nil, fmt.Errorf("can't detect chunk ids: %w", err)
-	}
+	g.Wait()
 
-	// WARN: need to rebalance
-	// mapping ids to addrs
-	idToAddr := make(map[uint64]string)
-	for addr, ids := range addrToIDs {
-		for _, id := range ids {
-			idToAddr[id] = addr
+	// Chunks are searched on every node, not only on the placement one,
+	// because cluster may be not rebalanced yet (see sfs-admin rebalance).
+	// mapping ids to holders
+	holders := make(map[uint64][]holder)
+	for _, addr := range addrs {
+		for _, h := range addrToHolds[addr] {
+			holders[h.sum.ID] = append(holders[h.sum.ID], h)
 		}
 	}
 
-	if len(idToAddr) == 0 {
-		return nil, errors.New("file not found")
+	if len(holders) == 0 {
+		if nodeErrs != nil {
+			return nil, fmt.Errorf("file not found on reachable nodes, unreachable nodes: %w", nodeErrs)
+		}
+		return nil, ErrNotFound
 	}
 
-	if !isIDToAddrContinuous(idToAddr) {
+	if !chunks.IsContinuous(holders) {
+		if nodeErrs != nil {
+			return nil, fmt.Errorf("file chunks are incomplete, unreachable nodes: %w", nodeErrs)
+		}
 		return nil, errors.New("file chunks are incomplete")
 	}
 
-	return idToAddr, nil
+	if err := c.checkEnd(name, uint64(len(holders)), answered); err != nil {
+		return nil, err
+	}
+
+	if nodeErrs != nil {
+		logger.Logf("some nodes are unreachable, but the file is covered by others: %s", nodeErrs)
+	}
+
+	return holders, nil
 }
 
-func isIDToAddrContinuous(m map[uint64]string) bool {
-	// if ids are correct, map must contain ids: 0, 1, 2, 3 ... etc
-	for i := range len(m) {
-		_, ok := m[uint64(i)]
-		if !ok {
-			return false
+// checkEnd returns the error unless every node which would hold the n'th chunk
+// of the file answered. Without the manifest the count of chunks is not known,
+// and the file ends at the first chunk missing on its nodes, so the
+// unreachable one may hide the tail of the file.
+func (c *Client) checkEnd(name string, n uint64, answered map[string]bool) error {
+	for _, addr := range placement.Nodes(c.addrs, name, n, c.replicas) {
+		if !answered[addr] {
+			return fmt.Errorf("file may have more chunks: node '%s' of chunk %d is unreachable", addr, n)
 		}
 	}
 
-	return true
+	return nil
 }
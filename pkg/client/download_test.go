@@ -0,0 +1,133 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestDownloadRange(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 3)
+	replicas := testcluster.StartMeta(t, 1)
+	nodes := addrs[0] + "," + addrs[1] + "," + addrs[2]
+
+	data := make([]byte, 5*512+100)
+	rand.Read(data)
+
+	clients := map[string]*Client{
+		"plain":   NewClient(nodes, 512),
+		"repair":  NewClient(nodes, 512, WithReadRepair(1)),
+		"meta":    NewClient(nodes, 512, WithMeta(meta.NewClient(replicas[0].Addr))),
+		"erasure": NewClient(nodes, 512, WithMeta(meta.NewClient(replicas[0].Addr)), WithErasureCoding(2, 1)),
+	}
+
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, client.Upload(ctx, name, bytes.NewReader(data), int64(len(data))))
+
+			for _, tc := range []struct{ offset, length, want int64 }{
+				{0, -1, int64(len(data))},
+				{0, 10, 10},
+				{500, 30, 30},
+				{512, 512, 512},
+				{1000, 1500, 1500},
+				{int64(len(data)) - 5, 100, 5},
+				{int64(len(data)), -1, 0},
+			} {
+				r, cls, size, err := client.DownloadRange(ctx, name, tc.offset, tc.length)
+				require.NoError(t, err)
+				got, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.NoError(t, cls())
+
+				require.Equal(t, tc.want, size)
+				require.Equal(t, data[tc.offset:tc.offset+tc.want], got)
+			}
+
+			_, _, _, err := client.DownloadRange(ctx, name, int64(len(data))+1, -1)
+			require.ErrorIs(t, err, ErrInvalidRange)
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 3)
+	replicas := testcluster.StartMeta(t, 1)
+	nodes := addrs[0] + "," + addrs[1] + "," + addrs[2]
+
+	data := make([]byte, 3*512)
+	rand.Read(data)
+	newData := make([]byte, 3*512)
+	rand.Read(newData)
+
+	clients := map[string]*Client{
+		"plain":  NewClient(nodes, 512),
+		"repair": NewClient(nodes, 512, WithReadRepair(1)),
+		"meta":   NewClient(nodes, 512, WithMeta(meta.NewClient(replicas[0].Addr))),
+	}
+
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, client.Upload(ctx, name, bytes.NewReader(data), int64(len(data))))
+
+			obj, err := client.Open(ctx, name)
+			require.NoError(t, err)
+			require.Equal(t, int64(len(data)), obj.Size)
+
+			// replaced between the info and the content
+			require.NoError(t, client.Upload(ctx, name, bytes.NewReader(newData), int64(len(newData))))
+
+			r, cls, _, err := obj.DownloadRange(ctx, 0, -1)
+			if err == nil {
+				var got []byte
+				got, err = io.ReadAll(r)
+				cls()
+				if name == "meta" {
+					require.NoError(t, err)
+					require.Equal(t, data, got)
+				}
+			}
+			// the file is replaced in place without the metadata service,
+			// it's not mixed
+			if name != "meta" {
+				require.Error(t, err)
+			}
+
+			_, err = client.Open(ctx, "missing")
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestEmptyFile(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 2)
+	client := NewClient(addrs[0]+","+addrs[1], 512)
+
+	// stored as the empty chunk, so it's told from the missing one
+	require.NoError(t, client.Upload(ctx, "empty", bytes.NewReader(nil), 0))
+	require.NoError(t, client.UploadStream(ctx, "stream", bytes.NewReader(nil)))
+
+	for _, name := range []string{"empty", "stream"} {
+		info, err := client.Stat(ctx, name)
+		require.NoError(t, err)
+		require.Zero(t, info.Size)
+
+		assertDownload(t, client, name, []byte{})
+	}
+
+	require.NoError(t, client.Delete(ctx, "empty"))
+	_, err := client.Stat(ctx, "empty")
+	require.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,202 @@
+package sfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+const (
+	dataKeySize = 32
+	algorithm   = "AES-256-GCM"
+)
+
+// fileKey is the data key of the encrypted file. Chunks are sealed under the
+// nonce derived from their id, so the key must never seal two files: every
+// upload generates a new one.
+type fileKey struct {
+	aead cipher.AEAD
+	enc  *meta.Encryption
+}
+
+// newFileKey generates the data key for the file and wraps it with the
+// key-encryption key. Returns nil if encryption is off.
+func (c *Client) newFileKey(name string) (*fileKey, error) {
+	if c.kek == nil {
+		return nil, nil
+	}
+
+	if c.meta == nil {
+		return nil, errors.New("encryption requires the metadata service")
+	}
+
+	kek, err := newGCM(c.kek)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key-encryption key: %w", err)
+	}
+
+	dk := make([]byte, dataKeySize)
+	if _, err := rand.Read(dk); err != nil {
+		return nil, fmt.Errorf("can't generate the data key: %w", err)
+	}
+
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("can't generate the nonce: %w", err)
+	}
+
+	aead, err := newGCM(dk)
+	if err != nil {
+		return nil, err
+	}
+
+	// the wrapped key is bound to the file name, so it can't be moved to
+	// another manifest
+	wrapped := kek.Seal(nonce, nonce, dk, []byte(name))
+	return &fileKey{aead: aead, enc: &meta.Encryption{Algorithm: algorithm, WrappedKey: wrapped}}, nil
+}
+
+// openFileKey unwraps the data key of the file. Returns nil for unencrypted
+// files.
+func (c *Client) openFileKey(file meta.File) (*fileKey, error) {
+	if file.Encryption == nil {
+		return nil, nil
+	}
+
+	if file.Encryption.Algorithm != algorithm {
+		return nil, fmt.Errorf("unsupported encryption algorithm: %s", file.Encryption.Algorithm)
+	}
+
+	if c.kek == nil {
+		return nil, errors.New("the file is encrypted, but the key-encryption key is not set")
+	}
+
+	kek, err := newGCM(c.kek)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key-encryption key: %w", err)
+	}
+
+	wrapped := file.Encryption.WrappedKey
+	if len(wrapped) < kek.NonceSize() {
+		return nil, errors.New("the wrapped data key is truncated")
+	}
+
+	dk, err := kek.Open(nil, wrapped[:kek.NonceSize()], wrapped[kek.NonceSize():], []byte(file.Name))
+	if err != nil {
+		return nil, fmt.Errorf("can't unwrap the data key, the key-encryption key is wrong: %w", err)
+	}
+
+	aead, err := newGCM(dk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileKey{aead: aead, enc: file.Encryption}, nil
+}
+
+// manifest returns the encryption to be recorded in the file manifest.
+func (k *fileKey) manifest() *meta.Encryption {
+	if k == nil {
+		return nil
+	}
+
+	return k.enc
+}
+
+// seal encrypts the chunk body in memory. Nil key returns the chunk as is.
+func (k *fileKey) seal(chunk chunks.Chunk) (chunks.Chunk, error) {
+	if k == nil {
+		return chunk, nil
+	}
+
+	raw, err := io.ReadAll(chunk.Body)
+	if err != nil {
+		return chunks.Chunk{}, err
+	}
+
+	sealed := k.aead.Seal(nil, chunkNonce(chunk.ID), raw, nil)
+	chunk.Body, chunk.Size = bytes.NewReader(sealed), uint64(len(sealed))
+	return chunk, nil
+}
+
+// open wraps the received chunk body with decryption. The chunk is decrypted
+// and authenticated on the first read, so it's held in memory one at a time.
+// Nil key returns the chunk as is.
+func (k *fileKey) open(chk chunks.Chunk, name string, id uint64) (chunks.Chunk, error) {
+	if k == nil {
+		return chk, nil
+	}
+
+	overhead := uint64(k.aead.Overhead())
+	if chk.Size < overhead {
+		return chunks.Chunk{}, fmt.Errorf("encrypted chunk %d of '%s' is truncated", id, name)
+	}
+
+	chk.Body = &openingReader{r: chk.Body, aead: k.aead, name: name, id: id}
+	chk.Size -= overhead
+	return chk, nil
+}
+
+// chunkNonce derives the nonce from the chunk id, which is unique within the
+// file, parity chunks included.
+func chunkNonce(id uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.LittleEndian.PutUint64(nonce[4:], id)
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// openingReader decrypts the whole chunk on the first read.
+type openingReader struct {
+	r    io.Reader
+	aead cipher.AEAD
+	name string
+	id   uint64
+
+	plain *bytes.Reader
+	err   error
+}
+
+func (r *openingReader) Read(p []byte) (int, error) {
+	if r.plain == nil && r.err == nil {
+		r.open()
+	}
+
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	return r.plain.Read(p)
+}
+
+func (r *openingReader) open() {
+	sealed, err := io.ReadAll(r.r)
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	plain, err := r.aead.Open(sealed[:0], chunkNonce(r.id), sealed, nil)
+	if err != nil {
+		r.err = fmt.Errorf("chunk %d of '%s' failed authentication, it's corrupted or tampered with: %w", r.id, r.name, err)
+		return
+	}
+
+	r.plain = bytes.NewReader(plain)
+}
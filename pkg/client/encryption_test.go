@@ -0,0 +1,73 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 2)
+	node := nodes[0]
+	replicas := testcluster.StartMeta(t, 1)
+	catalog := meta.NewClient(replicas[0].Addr)
+
+	kek := make([]byte, 32)
+	rand.Read(kek)
+
+	data := bytes.Repeat([]byte("secret "), 1000)
+	client := NewClient(node.Addr, 512, WithMeta(catalog), WithEncryption(kek), WithCompression(Zstd))
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+	assertDownload(t, client, "file", data)
+
+	file, err := catalog.Get(ctx, "file")
+	require.NoError(t, err)
+	require.NotNil(t, file.Encryption)
+	require.Equal(t, uint64(512), file.Chunks[0].Size)
+
+	// nodes hold sealed chunks only
+	stored, err := os.ReadFile(filepath.Join(node.Dir, file.Blob, "0"))
+	require.NoError(t, err)
+	require.Len(t, stored, 512+16)
+	require.NotContains(t, string(stored), "secret")
+
+	// wrong or missing key-encryption key
+	wrong := make([]byte, 32)
+	rand.Read(wrong)
+	_, _, _, err = NewClient(node.Addr, 512, WithMeta(catalog), WithEncryption(wrong)).Download(ctx, "file")
+	require.ErrorContains(t, err, "key-encryption key is wrong")
+	_, _, _, err = NewClient(node.Addr, 512, WithMeta(catalog)).Download(ctx, "file")
+	require.ErrorContains(t, err, "key-encryption key is not set")
+
+	// tampered chunk fails authentication
+	path := filepath.Join(node.Dir, file.Blob, "3")
+	stored, err = os.ReadFile(path)
+	require.NoError(t, err)
+	stored[10] ^= 1
+	require.NoError(t, os.WriteFile(path, stored, 0o644))
+	r, cls, _, err := client.Download(ctx, "file")
+	require.NoError(t, err)
+	defer cls()
+	_, err = io.ReadAll(r)
+	require.ErrorContains(t, err, "chunk 3 of '"+file.Blob+"' failed authentication")
+
+	// erasure coded files are sealed piece by piece
+	ecClient := NewClient(node.Addr+","+nodes[1].Addr, 512, WithMeta(catalog), WithEncryption(kek), WithErasureCoding(1, 1))
+	require.NoError(t, ecClient.Upload(ctx, "ec", bytes.NewReader(data), int64(len(data))))
+	assertDownload(t, ecClient, "ec", data)
+
+	// deduplication needs the same key for the same content
+	err = NewClient(node.Addr, 512, WithMeta(catalog), WithEncryption(kek), WithDedup()).
+		Upload(ctx, "dedup", bytes.NewReader(data), int64(len(data)))
+	require.Error(t, err)
+}
@@ -0,0 +1,337 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/placement"
+	"github.com/tymbaca/sfs/pkg/chunkio"
+	"github.com/tymbaca/sfs/pkg/meta"
+	"go.uber.org/multierr"
+	"golang.org/x/sync/errgroup"
+)
+
+// uploadErasure splits the file into data chunks and uploads them by stripes,
+// each stripe with its parity chunks. Stripes are uploaded one by one to
+// bound the memory and connections used by encoding.
+func (c *Client) uploadErasure(ctx context.Context, file meta.File, r io.ReaderAt, totalSize int64, key *fileKey) error {
+	if c.meta == nil {
+		return errors.New("can't upload the file: erasure coding requires the metadata service")
+	}
+
+	k, m := c.erasure.Data, c.erasure.Parity
+	name := blobName(file)
+	data, err := c.split(r, totalSize)
+	if err != nil {
+		return fmt.Errorf("can't split the file: %w", err)
+	}
+	stripes := (len(data) + k - 1) / k
+
+	var dataChunks, parityChunks []manifestChunk
+	for s := range stripes {
+		firstID := uint64(s * k)
+		nodes, err := c.resolveStripeNodes(name, firstID)
+		if err != nil {
+			return fmt.Errorf("can't upload the file: stripe %d: %w", s, err)
+		}
+
+		// parity chunks follow all the data chunks
+		firstParityID := uint64(len(data) + s*m)
+		stripe := data[s*k : min(s*k+k, len(data))]
+
+		dcs, pcs, err := c.uploadStripe(ctx, name, stripe, firstID, firstParityID, nodes, key)
+		if err != nil {
+			return fmt.Errorf("can't upload the file: stripe %d: %w", s, err)
+		}
+
+		dataChunks = append(dataChunks, dcs...)
+		parityChunks = append(parityChunks, pcs...)
+	}
+
+	file.Size, file.Erasure = totalSize, c.erasure
+	if err := c.commitManifest(ctx, file, append(dataChunks, parityChunks...)); err != nil {
+		return fmt.Errorf("can't upload the file: %w", err)
+	}
+
+	return nil
+}
+
+// resolveStripeNodes returns distinct live and not full nodes for every piece
+// of the stripe starting with chunk id, in placement order.
+func (c *Client) resolveStripeNodes(name string, id uint64) ([]string, error) {
+	need := c.erasure.Data + c.erasure.Parity
+	nodes := make([]string, 0, need)
+	for _, addr := range placement.Nodes(c.addrs, name, id, len(c.addrs)) {
+		if c.health.canStore(addr) {
+			nodes = append(nodes, addr)
+		}
+
+		if len(nodes) == need {
+			return nodes, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%d live nodes are needed for erasure coding, got %d", need, len(nodes))
+}
+
+// uploadStripe uploads the data chunks of the stripe and the parity chunks
+// computed from them on the fly. Data chunk j goes to nodes[j], parity chunk
+// j goes to nodes[k+j].
+func (c *Client) uploadStripe(ctx context.Context, name string, stripe []*chunkio.Reader, firstID, firstParityID uint64, nodes []string, key *fileKey) ([]manifestChunk, []manifestChunk, error) {
+	k, m := c.erasure.Data, c.erasure.Parity
+	tr := transferFrom(ctx)
+
+	// chunks are of different sizes with content-defined chunking
+	shardSize := int64(0)
+	for _, chk := range stripe {
+		shardSize = max(shardSize, chk.Size())
+	}
+
+	enc, err := reedsolomon.NewStream(k, m)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't create encoder: %w", err)
+	}
+
+	var g errgroup.Group
+	dataChunks := make([]manifestChunk, 0, len(stripe))
+	shards := make([]io.Reader, k)
+	for j := range k {
+		if j >= len(stripe) {
+			shards[j] = zeroShard(shardSize)
+			continue
+		}
+		shards[j] = padShard(stripe[j].Clone(), stripe[j].Size(), shardSize)
+
+		crc := &crcReader{r: stripe[j]}
+		chunk := chunks.Chunk{ID: firstID + uint64(j), Filename: name, Size: uint64(stripe[j].Size()), Body: crc}
+		dataChunks = append(dataChunks, manifestChunk{chunk: chunk, nodes: nodes[j : j+1], crc: crc})
+		tr.expect(int64(chunk.Size), 1)
+
+		g.Go(func() error {
+			return c.uploadChunk(ctx, chunk, nodes[j], key)
+		})
+	}
+
+	parityChunks := make([]manifestChunk, 0, m)
+	parity := make([]io.Writer, m)
+	pipes := make([]*io.PipeWriter, m)
+	for j := range m {
+		pr, pw := io.Pipe()
+		parity[j], pipes[j] = pw, pw
+
+		crc := &crcReader{r: pr}
+		chunk := chunks.Chunk{ID: firstParityID + uint64(j), Filename: name, Size: uint64(shardSize), Body: crc}
+		parityChunks = append(parityChunks, manifestChunk{chunk: chunk, nodes: nodes[k+j : k+j+1], crc: crc, parity: true})
+		tr.expect(shardSize, 1)
+
+		g.Go(func() error {
+			err := c.uploadChunk(ctx, chunk, nodes[k+j], key)
+			// unblocks the encoder if the chunk was not read to the end
+			pr.CloseWithError(err)
+			return err
+		})
+	}
+
+	g.Go(func() error {
+		err := enc.Encode(shards, parity)
+		for _, pw := range pipes {
+			pw.CloseWithError(err)
+		}
+
+		if err != nil {
+			return fmt.Errorf("can't encode parity: %w", err)
+		}
+		return nil
+	})
+
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	return dataChunks, parityChunks, nil
+}
+
+// downloadErasure downloads the data chunks of the erasure coded file. Stripes
+// with unavailable data chunks are rebuilt in memory from the other pieces.
+func (c *Client) downloadErasure(ctx context.Context, file meta.File, key *fileKey) (io.Reader, func() error, int64, error) {
+	k, m := file.Erasure.Data, file.Erasure.Parity
+
+	var data, parity []meta.Chunk
+	for _, chk := range file.Chunks {
+		if chk.Parity {
+			parity = append(parity, chk)
+		} else {
+			data = append(data, chk)
+		}
+	}
+
+	stripes := (len(data) + k - 1) / k
+	if len(parity) != stripes*m {
+		return nil, nil, 0, fmt.Errorf("can't download the file: file manifest is incomplete")
+	}
+
+	transferFrom(ctx).expect(file.Size, len(data))
+
+	bodies := make([]io.Reader, len(data))
+	closes := make([]func() error, len(data))
+	closeFn := func() (err error) {
+		for _, cls := range closes {
+			if cls != nil {
+				err = multierr.Append(err, cls())
+			}
+		}
+		return err
+	}
+
+	// Receive all data chunks. The unavailable ones are rebuilt later.
+	var g errgroup.Group
+	for i, chk := range data {
+		g.Go(func() error {
+			body, cls, err := c.recvPiece(ctx, blobName(file), chk, key)
+			if err != nil {
+				logger.Logf("chunk %d of '%s' will be rebuilt: %s", chk.ID, file.Name, err)
+				return nil
+			}
+
+			bodies[i], closes[i] = body, cls
+			return nil
+		})
+	}
+	g.Wait()
+
+	readers := make([]io.Reader, 0, len(data))
+	for s := range stripes {
+		lo, hi := s*k, min(s*k+k, len(data))
+		if !slices.Contains(bodies[lo:hi], nil) {
+			readers = append(readers, bodies[lo:hi]...)
+			continue
+		}
+
+		rebuilt, err := c.rebuildStripe(ctx, blobName(file), k, m, data[lo:hi], parity[s*m:s*m+m], bodies[lo:hi], key)
+		if err != nil {
+			closeFn()
+			return nil, nil, 0, fmt.Errorf("can't download the file: can't rebuild stripe %d: %w", s, err)
+		}
+		readers = append(readers, rebuilt...)
+	}
+
+	return io.MultiReader(readers...), closeFn, file.Size, nil
+}
+
+// rebuildStripe reconstructs the missing data chunks of the stripe (nil
+// bodies) from the received data chunks and as many parity chunks as needed.
+// Returns the readers of all data chunks.
+func (c *Client) rebuildStripe(ctx context.Context, name string, k, m int, data, parity []meta.Chunk, bodies []io.Reader, key *fileKey) ([]io.Reader, error) {
+	shardSize := int64(0)
+	for _, chk := range data {
+		shardSize = max(shardSize, int64(chk.Size))
+	}
+
+	valid := make([]io.Reader, k+m)
+	fill := make([]io.Writer, k+m)
+	bufs := make([]*bytes.Buffer, len(data))
+
+	available := 0
+	for j := range k {
+		if j >= len(data) {
+			valid[j] = zeroShard(shardSize)
+			available++
+			continue
+		}
+
+		bufs[j] = bytes.NewBuffer(make([]byte, 0, shardSize))
+		if bodies[j] == nil {
+			fill[j] = bufs[j]
+			continue
+		}
+
+		valid[j] = io.TeeReader(padShard(bodies[j], int64(data[j].Size), shardSize), bufs[j])
+		available++
+	}
+
+	var closes []func() error
+	defer func() {
+		for _, cls := range closes {
+			cls()
+		}
+	}()
+
+	for j, chk := range parity {
+		if available == k {
+			break
+		}
+
+		body, cls, err := c.recvPiece(ctx, name, chk, key)
+		if err != nil {
+			logger.Logf("parity chunk %d of '%s' is unavailable: %s", chk.ID, name, err)
+			continue
+		}
+
+		closes = append(closes, cls)
+		valid[k+j] = body
+		available++
+	}
+
+	if available < k {
+		return nil, fmt.Errorf("only %d of %d needed pieces are available", available, k)
+	}
+
+	enc, err := reedsolomon.NewStream(k, m)
+	if err != nil {
+		return nil, fmt.Errorf("can't create decoder: %w", err)
+	}
+
+	if err := enc.Reconstruct(valid, fill); err != nil {
+		return nil, fmt.Errorf("can't reconstruct: %w", err)
+	}
+
+	readers := make([]io.Reader, 0, len(data))
+	for j, chk := range data {
+		readers = append(readers, bytes.NewReader(bufs[j].Bytes()[:chk.Size]))
+	}
+
+	return readers, nil
+}
+
+// recvPiece receives the chunk from the first live node which answers.
+func (c *Client) recvPiece(ctx context.Context, name string, chk meta.Chunk, key *fileKey) (io.Reader, func() error, error) {
+	var hs []holder
+	for _, addr := range c.manifestNodes(chunkKey{name, chk.ID}, chk.Nodes) {
+		hs = append(hs, holder{addr: addr, key: chunkKey{name, chk.ID}})
+	}
+
+	if len(hs) == 0 {
+		return nil, nil, errors.New("all nodes holding the chunk are down")
+	}
+
+	body, _, cls, err := c.recvChunk(ctx, hs, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return body.Body, cls, nil
+}
+
+// padShard pads the chunk body with zeros up to the shard size, as all shards
+// of the stripe must be the same size.
+func padShard(r io.Reader, size, shardSize int64) io.Reader {
+	return io.MultiReader(r, zeroShard(shardSize-size))
+}
+
+func zeroShard(size int64) io.Reader {
+	return io.LimitReader(zeros{}, size)
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,71 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestErasureCoding(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 5)
+	addrs := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		addrs = append(addrs, n.Addr)
+	}
+
+	replicas := testcluster.StartMeta(t, 1)
+	catalog := meta.NewClient(replicas[0].Addr)
+
+	// 8 chunks, the last one is short: stripes of 3, 3 and 2 chunks
+	data := make([]byte, 7*512+100)
+	rand.Read(data)
+
+	client := NewClient(strings.Join(addrs, ","), 512, WithMeta(catalog), WithErasureCoding(3, 2))
+	require.NoError(t, client.Upload(ctx, "ec", bytes.NewReader(data), int64(len(data))))
+
+	file, err := catalog.Get(ctx, "ec")
+	require.NoError(t, err)
+	require.Equal(t, &meta.Erasure{Data: 3, Parity: 2}, file.Erasure)
+	require.Len(t, file.Chunks, 8+3*2)
+
+	// files written without erasure coding coexist
+	plain := NewClient(strings.Join(addrs, ","), 512, WithMeta(catalog), WithReplicas(2))
+	require.NoError(t, plain.Upload(ctx, "plain", bytes.NewReader(data), int64(len(data))))
+
+	assertDownload(t, client, "ec", data)
+	assertDownload(t, plain, "ec", data)
+	assertDownload(t, client, "plain", data)
+
+	// any 2 pieces of each stripe may be lost
+	nodes[0].Stop()
+	nodes[3].Stop()
+
+	lost := 0
+	for _, chk := range file.Chunks {
+		if !chk.Parity && (chk.Nodes[0] == nodes[0].Addr || chk.Nodes[0] == nodes[3].Addr) {
+			lost++
+		}
+	}
+	require.NotZero(t, lost)
+
+	assertDownload(t, NewClient(strings.Join(addrs, ","), 512, WithMeta(catalog)), "ec", data)
+
+	// no distinct nodes for new stripes
+	client.health.markDown(nodes[0].Addr)
+	client.health.markDown(nodes[3].Addr)
+	require.Error(t, client.Upload(ctx, "ec2", bytes.NewReader(data), int64(len(data))))
+}
+
+func TestErasureCodingWithoutMeta(t *testing.T) {
+	addrs := testcluster.Start(t, 3)
+	client := NewClient(strings.Join(addrs, ","), 512, WithErasureCoding(2, 1))
+	require.Error(t, client.Upload(context.Background(), "ec", bytes.NewReader([]byte("data")), 4))
+}
@@ -0,0 +1,328 @@
+package sfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FS is the read-only file system of the files of the client bucket, see
+// [Client.FS].
+type FS struct {
+	client *Client
+	ctx    context.Context
+}
+
+var (
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+)
+
+// FS returns the file system of the files of the client bucket, which are
+// served under their names. Slash-separated names are the directory tree,
+// directories exist while they have files. The file shadows the directory of
+// the same name. All requests are done with ctx.
+func (c *Client) FS(ctx context.Context) *FS {
+	return &FS{client: c, ctx: ctx}
+}
+
+func (f *FS) Open(name string) (fs.File, error) {
+	info, err := f.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &fsDir{fsys: f, name: name, info: info}, nil
+	}
+
+	return &fsFile{fsys: f, name: name, info: info}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	return f.stat("stat", name)
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	info, err := f.stat("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.New("is a directory")}
+	}
+
+	r, cls, _, err := f.client.Download(f.ctx, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	defer cls()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	return data, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entries, err := f.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	return entries, nil
+}
+
+// stat returns the info of the file, or the directory if there is no file of
+// the name.
+func (f *FS) stat(op, name string) (*fsInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	// the root exists even without files
+	if name == "." {
+		return &fsInfo{name: ".", dir: true}, nil
+	}
+
+	info, err := f.client.Stat(f.ctx, name)
+	if err == nil {
+		return &fsInfo{name: path.Base(name), file: info}, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	infos, err := f.client.List(f.ctx, Filter{Prefix: name + "/"})
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	if !slices.ContainsFunc(infos, func(info FileInfo) bool { return fs.ValidPath(info.Name) }) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &fsInfo{name: path.Base(name), dir: true}, nil
+}
+
+// readDir returns the entries of the directory sorted by name. Files with
+// names which are not valid paths, e.g. with empty elements, are skipped.
+func (f *FS) readDir(name string) ([]fs.DirEntry, error) {
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+
+	infos, err := f.client.List(f.ctx, Filter{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fs.DirEntry
+	seen := make(map[string]bool)
+	for _, info := range infos {
+		if !fs.ValidPath(info.Name) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(info.Name, prefix)
+		entry := &fsInfo{name: rest, file: info}
+		if dir, _, ok := strings.Cut(rest, "/"); ok {
+			entry = &fsInfo{name: dir, dir: true}
+		}
+
+		// the file of the same name shadows the directory
+		if seen[entry.name] {
+			if !entry.dir {
+				i := slices.IndexFunc(entries, func(e fs.DirEntry) bool { return e.Name() == entry.name })
+				entries[i] = fs.FileInfoToDirEntry(entry)
+			}
+			continue
+		}
+		seen[entry.name] = true
+		entries = append(entries, fs.FileInfoToDirEntry(entry))
+	}
+
+	if len(entries) == 0 && name != "." {
+		return nil, fs.ErrNotExist
+	}
+
+	// "a" is listed after "a.txt" by the full names "a/..."
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+
+	return entries, nil
+}
+
+// fsInfo is the info of the file or the directory. Sys returns [FileInfo] of
+// the file.
+type fsInfo struct {
+	name string
+	dir  bool
+	file FileInfo
+}
+
+func (i *fsInfo) Name() string { return i.name }
+
+func (i *fsInfo) Size() int64 { return i.file.Size }
+
+func (i *fsInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o555
+	}
+
+	return 0o444
+}
+
+func (i *fsInfo) ModTime() time.Time { return i.file.CreatedAt }
+
+func (i *fsInfo) IsDir() bool { return i.dir }
+
+func (i *fsInfo) Sys() any {
+	if i.dir {
+		return nil
+	}
+
+	return i.file
+}
+
+// fsFile is the open file, downloaded from the offset on the first read after
+// open or seek.
+type fsFile struct {
+	fsys   *FS
+	name   string
+	info   *fsInfo
+	offset int64
+	body   io.Reader
+	cls    func() error
+	closed bool
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	if f.body == nil {
+		if f.offset >= f.info.Size() {
+			return 0, io.EOF
+		}
+
+		body, cls, _, err := f.fsys.client.DownloadRange(f.fsys.ctx, f.name, f.offset, -1)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		f.body, f.cls = body, cls
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.cls()
+		f.body, f.cls = nil, nil
+	}
+	f.offset = offset
+
+	return offset, nil
+}
+
+func (f *fsFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+
+	if f.body != nil {
+		return f.cls()
+	}
+	return nil
+}
+
+// fsDir is the open directory, its entries are listed on the first read.
+type fsDir struct {
+	fsys    *FS
+	name    string
+	info    *fsInfo
+	entries []fs.DirEntry
+	listed  bool
+	closed  bool
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+
+	if !d.listed {
+		entries, err := d.fsys.readDir(d.name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: err}
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *fsDir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
@@ -0,0 +1,72 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestFS(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 2)
+	replicas := testcluster.StartMeta(t, 1)
+	nodes := addrs[0] + "," + addrs[1]
+
+	files := map[string]string{
+		"readme.txt":          "readme",
+		"worker-1/a.txt":      "first worker",
+		"worker-1/logs/1.log": "log",
+		"worker-2/a.txt":      "second worker, the file of several chunks",
+		"worker-2.txt":        "listed before worker-2/ by full name",
+	}
+
+	for name, client := range map[string]*Client{
+		"plain": NewClient(nodes, 16),
+		"meta":  NewClient(nodes, 16, WithMeta(meta.NewClient(replicas[0].Addr))),
+	} {
+		t.Run(name, func(t *testing.T) {
+			// each client has its own bucket, so the files don't mix
+			require.NoError(t, client.CreateBucket(ctx, Bucket{Name: name}))
+			client := client.Bucket(name)
+			for name, data := range files {
+				require.NoError(t, client.Upload(ctx, name, bytes.NewReader([]byte(data)), int64(len(data))))
+			}
+
+			fsys := client.FS(ctx)
+			require.NoError(t, fstest.TestFS(fsys, "readme.txt", "worker-1/a.txt", "worker-1/logs/1.log", "worker-2/a.txt", "worker-2.txt"))
+
+			var walked []string
+			require.NoError(t, fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+				walked = append(walked, path)
+				return err
+			}))
+			require.Equal(t, []string{".", "readme.txt", "worker-1", "worker-1/a.txt", "worker-1/logs", "worker-1/logs/1.log", "worker-2", "worker-2/a.txt", "worker-2.txt"}, walked)
+
+			_, err := fsys.Open("missing")
+			require.ErrorIs(t, err, fs.ErrNotExist)
+
+			srv := httptest.NewServer(http.FileServer(http.FS(fsys)))
+			t.Cleanup(srv.Close)
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/worker-2/a.txt", nil)
+			require.NoError(t, err)
+			req.Header.Set("Range", "bytes=20-")
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+			require.Equal(t, files["worker-2/a.txt"][20:], string(got))
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package sfs
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/transport"
+	"golang.org/x/sync/errgroup"
+)
+
+type NodeState int
+
+const (
+	NodeUp NodeState = iota
+	// NodeSuspect is the node which failed recently, but is still used.
+	NodeSuspect
+	// NodeDown is the node which failed several health checks in a row. It's
+	// skipped by placement and listing until it answers the ping again.
+	NodeDown
+)
+
+func (s NodeState) String() string {
+	switch s {
+	case NodeUp:
+		return "up"
+	case NodeSuspect:
+		return "suspect"
+	case NodeDown:
+		return "down"
+	}
+
+	return "unknown"
+}
+
+// downAfter is the count of failed health checks in a row after which the
+// node is considered down.
+const downAfter = 3
+
+type nodeHealth struct {
+	mu       sync.Mutex
+	failures map[string]int
+	down     map[string]bool
+	// full nodes have no space for new chunks, placement skips them
+	full map[string]bool
+}
+
+func newNodeHealth() *nodeHealth {
+	return &nodeHealth{
+		failures: make(map[string]int),
+		down:     make(map[string]bool),
+		full:     make(map[string]bool),
+	}
+}
+
+func (h *nodeHealth) state(addr string) NodeState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case h.down[addr]:
+		return NodeDown
+	case h.failures[addr] > 0:
+		return NodeSuspect
+	}
+
+	return NodeUp
+}
+
+func (h *nodeHealth) isDown(addr string) bool {
+	return h.state(addr) == NodeDown
+}
+
+// canStore reports whether new chunks may be placed on the node.
+func (h *nodeHealth) canStore(addr string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return !h.down[addr] && !h.full[addr]
+}
+
+func (h *nodeHealth) setFull(addr string, full bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if full && !h.full[addr] {
+		logger.Logf("node '%s' is full", addr)
+	}
+	h.full[addr] = full
+}
+
+// success marks node up.
+func (h *nodeHealth) success(addr string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.down[addr] || h.failures[addr] > 0 {
+		logger.Logf("node '%s' is up", addr)
+	}
+
+	delete(h.failures, addr)
+	delete(h.down, addr)
+}
+
+// failure marks node suspect. If checked is true (it's failure of health
+// check, not of the regular request), node may become down.
+func (h *nodeHealth) failure(addr string, checked bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failures[addr]++
+	if checked && h.failures[addr] >= downAfter && !h.down[addr] {
+		h.down[addr] = true
+		logger.Logf("node '%s' is down", addr)
+	}
+}
+
+// requestFailed marks node suspect when the request failed, unless the node is
+// just busy or doesn't have the chunk.
+func (h *nodeHealth) requestFailed(addr string, err error) {
+	if !errors.Is(err, common.ErrBusy) && !errors.Is(err, common.ErrNotFound) {
+		h.failure(addr, false)
+	}
+}
+
+func (h *nodeHealth) markDown(addr string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failures[addr] = max(h.failures[addr], downAfter)
+	h.down[addr] = true
+}
+
+// NodeStates returns the current state of every node.
+func (c *Client) NodeStates() map[string]NodeState {
+	states := make(map[string]NodeState, len(c.addrs))
+	for _, addr := range c.addrs {
+		states[addr] = c.health.state(addr)
+	}
+
+	return states
+}
+
+// RunHealthCheck pings every node each interval until ctx is done. Without it
+// nodes are never considered down. It also asks the nodes for their capacity,
+// so new chunks are not placed on the full ones, and retries the deletions of
+// stale chunks on the nodes which are back.
+func (c *Client) RunHealthCheck(ctx context.Context, interval time.Duration) error {
+	for {
+		c.checkHealth(ctx, interval)
+		c.retryTrims(ctx)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (c *Client) checkHealth(ctx context.Context, timeout time.Duration) {
+	var g errgroup.Group
+	for _, addr := range c.addrs {
+		g.Go(func() error {
+			pingCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			trans := transport.NewTCPTransport(addr)
+			defer trans.Close()
+
+			// busy node is alive
+			if err := trans.Ping(pingCtx); err != nil && !errors.Is(err, common.ErrBusy) {
+				if ctx.Err() == nil {
+					c.health.failure(addr, true)
+				}
+				return nil
+			}
+
+			c.health.success(addr)
+			// old nodes don't report stats
+			if capacity, err := c.nodeCapacity(pingCtx, addr); err == nil {
+				c.health.setFull(addr, capacity.Free < uint64(c.chunkSize))
+			}
+			return nil
+		})
+	}
+
+	g.Wait()
+}
+
+// liveAddrs returns the nodes which are not down.
+func (c *Client) liveAddrs() []string {
+	addrs := make([]string, 0, len(c.addrs))
+	for _, addr := range c.addrs {
+		if !c.health.isDown(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// busyRetries is how many times the request rejected by the busy node is
+// retried, with the backoff doubled from busyBackoff each time.
+const (
+	busyRetries = 5
+	busyBackoff = 50 * time.Millisecond
+)
+
+// retryBusy calls fn again while it fails because the node is busy. rewind
+// prepares fn for the retry, its false result stops the retries. Nil rewind
+// means fn needs no preparation.
+func retryBusy(ctx context.Context, rewind func() bool, fn func() error) error {
+	backoff := busyBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if !errors.Is(err, common.ErrBusy) || attempt == busyRetries {
+			return err
+		}
+
+		// jitter spreads the retries of the requests rejected together
+		select {
+		case <-time.After(backoff/2 + rand.N(backoff)):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+
+		if rewind != nil && !rewind() {
+			return err
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/placement"
+	"github.com/tymbaca/sfs/internal/testcluster"
+)
+
+func TestDeadNode(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 3)
+	addrs := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		addrs = append(addrs, n.Addr)
+	}
+
+	data := make([]byte, 10*512)
+	rand.Read(data)
+
+	client := NewClient(strings.Join(addrs, ","), 512, WithReplicas(2))
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+
+	// the nodes of the chunk after the last one must answer to find the end
+	// of the file, so the other one dies
+	dead := nodes[slices.IndexFunc(nodes, func(n testcluster.Node) bool {
+		return !slices.Contains(placement.Nodes(addrs, "file", 10, 2), n.Addr)
+	})]
+	dead.Stop()
+
+	// surviving replicas cover every chunk
+	assertDownload(t, client, "file", data)
+	require.Equal(t, NodeSuspect, client.NodeStates()[dead.Addr])
+
+	for range downAfter {
+		client.checkHealth(ctx, time.Second)
+	}
+	want := map[string]NodeState{}
+	for _, n := range nodes {
+		want[n.Addr] = NodeUp
+	}
+	want[dead.Addr] = NodeDown
+	require.Equal(t, want, client.NodeStates())
+
+	// placement skips down node
+	require.NoError(t, client.Upload(ctx, "file2", bytes.NewReader(data), int64(len(data))))
+	if slices.Contains(placement.Nodes(addrs, "file2", 10, 2), dead.Addr) {
+		_, _, _, err := client.Download(ctx, "file2")
+		require.Error(t, err)
+	} else {
+		assertDownload(t, client, "file2", data)
+	}
+
+	ids, err := dead.Storage.ListChunkIDs(ctx, "file2")
+	require.Error(t, err)
+	require.Empty(t, ids)
+}
+
+func TestUnreachableTail(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 2)
+	data := []byte("0123456789ab")
+
+	client := NewClient(addrs[0]+","+addrs[1], 4)
+	// the second node is unreachable, chunks are placed the same way
+	partial := NewClient(addrs[0]+",127.0.0.1:1", 4)
+
+	failed := 0
+	for i := range 10 {
+		name := fmt.Sprintf("file%d", i)
+		require.NoError(t, client.Upload(ctx, name, bytes.NewReader(data), int64(len(data))))
+
+		// the stat either fails or is of the whole file, never short
+		info, err := partial.Stat(ctx, name)
+		if err == nil {
+			require.Equal(t, int64(len(data)), info.Size)
+		}
+
+		// the download either fails or is whole, never short
+		r, cls, size, err := partial.Download(ctx, name)
+		if err != nil {
+			failed++
+			continue
+		}
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, cls())
+		require.Equal(t, int64(len(data)), size)
+		require.Equal(t, data, got)
+	}
+	require.NotZero(t, failed)
+}
+
+func assertDownload(t *testing.T, client *Client, name string, data []byte) {
+	t.Helper()
+
+	r, cls, size, err := client.Download(context.Background(), name)
+	require.NoError(t, err)
+	defer cls()
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+	require.Equal(t, data, got)
+}
@@ -0,0 +1,374 @@
+package sfs
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/meta"
+	"go.uber.org/multierr"
+	"golang.org/x/sync/errgroup"
+)
+
+// Metadata is recorded with the file at upload, see [WithMetadata].
+type Metadata struct {
+	// ContentType is the MIME type of the file, e.g. "application/zip".
+	ContentType string
+	// Tags are the user key-value pairs, e.g. the build id.
+	Tags map[string]string
+}
+
+// FileInfo describes the stored file.
+type FileInfo struct {
+	Name string
+	Size int64
+	Metadata
+	// CreatedAt is when the file was uploaded. Zero without the metadata
+	// service.
+	CreatedAt time.Time
+	// ETag is the hash of the file content, see [etag].
+	ETag string
+	// Version is the id of the versioned upload, 0 for unversioned files.
+	Version uint64
+}
+
+// Filter selects the files of [Client.List]. Zero filter selects all.
+type Filter struct {
+	Prefix string
+	// ContentType selects the files of this type.
+	ContentType string
+	// Tags selects the files having all these tags with the same values.
+	Tags map[string]string
+}
+
+func (f Filter) match(info FileInfo) bool {
+	if !strings.HasPrefix(info.Name, f.Prefix) {
+		return false
+	}
+
+	if f.ContentType != "" && info.ContentType != f.ContentType {
+		return false
+	}
+
+	for k, v := range f.Tags {
+		if tag, ok := info.Tags[k]; !ok || tag != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// UploadOption configures the single upload.
+type UploadOption func(*uploadOptions)
+
+type uploadOptions struct {
+	metadata *Metadata
+}
+
+// WithMetadata records md with the uploaded file. It requires the metadata
+// service.
+func WithMetadata(md Metadata) UploadOption {
+	return func(o *uploadOptions) {
+		o.metadata = &md
+	}
+}
+
+func newUploadOptions(opts []UploadOption) uploadOptions {
+	var o uploadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// Stat returns the info of the file. Without the metadata service only its
+// size and ETag are known, which are taken from the checksums of the nodes.
+// Returns [ErrNotFound] if the file is not stored.
+func (c *Client) Stat(ctx context.Context, name string) (FileInfo, error) {
+	obj, err := c.Open(ctx, name)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("can't stat '%s': %w", name, err)
+	}
+
+	return obj.FileInfo, nil
+}
+
+// Object is the upload of the file pinned by [Client.Open]: its info and the
+// content of [Object.DownloadRange] are of the same upload. The chunks of the
+// unversioned file are replaced in place, so they are verified against the
+// checksums taken at open, and the download of the file replaced meanwhile
+// fails instead of mixing the uploads.
+type Object struct {
+	FileInfo
+
+	client *Client
+	name   string
+	file   *meta.File   // nil without the metadata service
+	sums   []chunks.Sum // of the nodes, without the metadata service
+}
+
+// Open returns the current upload of the file, see [Client.Stat]. Returns
+// [ErrNotFound] if the file is not stored.
+func (c *Client) Open(ctx context.Context, name string) (*Object, error) {
+	ctx = c.withBucket(ctx)
+	if c.meta != nil {
+		file, err := c.lookupManifest(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Object{FileInfo: c.fileInfo(file), client: c, name: name, file: &file}, nil
+	}
+
+	sums, err := c.nodeChunkSums(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	info := FileInfo{Name: name, ETag: etag(sums)}
+	for _, sum := range sums {
+		info.Size += int64(sum.Size)
+	}
+
+	return &Object{FileInfo: info, client: c, name: name, sums: sums}, nil
+}
+
+// DownloadRange downloads length bytes of the object from offset, see
+// [Client.DownloadRange].
+func (o *Object) DownloadRange(ctx context.Context, offset, length int64) (io.Reader, func() error, int64, error) {
+	ctx = o.client.withBucket(ctx)
+	ctx, tr := o.client.startTransfer(ctx, opDownload, o.name)
+	return tr.finishDownload(o.downloadRange(ctx, offset, length))
+}
+
+func (o *Object) downloadRange(ctx context.Context, offset, length int64) (io.Reader, func() error, int64, error) {
+	if o.file != nil {
+		return o.client.downloadManifestRange(ctx, *o.file, offset, length, o.file.Version == 0)
+	}
+
+	holders, err := o.client.resolveChunksAddrs(ctx, o.name)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("can't download the file: can't resolve chunks for '%s': %w", o.name, err)
+	}
+
+	changed := fmt.Errorf("can't download the file: '%s' changed meanwhile", o.name)
+	if len(holders) != len(o.sums) {
+		return nil, nil, 0, changed
+	}
+
+	for _, sum := range o.sums {
+		hs := holders[sum.ID]
+		if o.client.readRepair {
+			// the node sums are kept to find the replicas to repair
+			hs = slices.DeleteFunc(hs, func(h holder) bool { return h.sum != sum })
+		} else {
+			for i := range hs {
+				hs[i].sum = sum
+			}
+		}
+
+		if len(hs) == 0 {
+			return nil, nil, 0, changed
+		}
+		holders[sum.ID] = hs
+	}
+
+	return o.client.downloadHoldersRange(ctx, o.name, holders, nil, offset, length, true)
+}
+
+// List returns the files of the client bucket selected by filter, sorted by
+// name. Without the metadata service files have no metadata, so only the
+// prefix filter selects any, and each of them is asked from every node.
+func (c *Client) List(ctx context.Context, filter Filter) ([]FileInfo, error) {
+	ctx = c.withBucket(ctx)
+	if c.meta != nil {
+		files, err := c.meta.List(ctx, c.catalogName(filter.Prefix))
+		if err != nil {
+			return nil, fmt.Errorf("can't list files: %w", err)
+		}
+
+		infos := make([]FileInfo, 0, len(files))
+		for _, file := range files {
+			if !c.inBucket(file.Name) {
+				continue
+			}
+
+			if info := c.fileInfo(file); filter.match(info) {
+				infos = append(infos, info)
+			}
+		}
+
+		return infos, nil
+	}
+
+	names, err := c.nodeFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't list files: %w", err)
+	}
+
+	var infos []FileInfo
+	for _, name := range names {
+		if !filter.match(FileInfo{Name: name}) {
+			continue
+		}
+
+		info, err := c.Stat(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			// deleted meanwhile
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("can't list files: %w", err)
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// fileInfo returns the info of the file of the client bucket by its manifest.
+func (c *Client) fileInfo(file meta.File) FileInfo {
+	var sums []chunks.Sum
+	for _, chk := range file.Chunks {
+		if !chk.Parity {
+			sums = append(sums, chunks.Sum{ID: chk.ID, Size: chk.Size, CRC: chk.CRC})
+		}
+	}
+
+	return FileInfo{
+		Name:      strings.TrimPrefix(file.Name, c.catalogName("")),
+		Size:      file.Size,
+		Metadata:  Metadata{ContentType: file.ContentType, Tags: file.Tags},
+		CreatedAt: file.CreatedAt,
+		ETag:      etag(sums),
+		Version:   file.Version,
+	}
+}
+
+// etag returns the ETag of the file content: hex of the first 16 bytes of
+// SHA-256 over the sizes and CRC-32C of its data chunks in order, then '-' and
+// the count of chunks. Sizes and CRCs are of the plain data, so it doesn't
+// depend on compression and encryption.
+func etag(sums []chunks.Sum) string {
+	sums = slices.Clone(sums)
+	slices.SortFunc(sums, func(a, b chunks.Sum) int { return cmp.Compare(a.ID, b.ID) })
+
+	h := sha256.New()
+	for _, sum := range sums {
+		binary.Write(h, binary.LittleEndian, sum.Size)
+		binary.Write(h, binary.LittleEndian, sum.CRC)
+	}
+
+	return fmt.Sprintf("%x-%d", h.Sum(nil)[:16], len(sums))
+}
+
+// nodeChunkSums returns the checksum of each chunk of the file, taken from
+// any live node holding it.
+func (c *Client) nodeChunkSums(ctx context.Context, name string) ([]chunks.Sum, error) {
+	var mu sync.Mutex
+	var errs error
+	byID := make(map[uint64]chunks.Sum)
+	answered := make(map[string]bool)
+
+	var g errgroup.Group
+	for _, addr := range c.liveAddrs() {
+		g.Go(func() error {
+			trans := transport.NewTCPTransport(addr)
+			defer trans.Close()
+
+			sums, err := trans.ChunkSums(ctx, "", name)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && !errors.Is(err, common.ErrNotFound) {
+				errs = multierr.Append(errs, fmt.Errorf("'%s': %w", addr, err))
+				return nil
+			}
+			answered[addr] = true
+
+			for _, sum := range sums {
+				byID[sum.ID] = sum
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	if len(byID) == 0 {
+		if errs != nil {
+			return nil, fmt.Errorf("%w on reachable nodes, unreachable nodes: %w", ErrNotFound, errs)
+		}
+		return nil, ErrNotFound
+	}
+
+	sums := make([]chunks.Sum, 0, len(byID))
+	for _, sum := range byID {
+		sums = append(sums, sum)
+	}
+	slices.SortFunc(sums, func(a, b chunks.Sum) int { return cmp.Compare(a.ID, b.ID) })
+
+	if sums[len(sums)-1].ID != uint64(len(sums)-1) {
+		return nil, errors.New("file chunks are incomplete")
+	}
+
+	// the unreachable node may hide the tail of the file
+	if err := c.checkEnd(name, uint64(len(sums)), answered); err != nil {
+		return nil, err
+	}
+
+	return sums, nil
+}
+
+// nodeFiles returns the names of the files any live node has chunks of,
+// sorted.
+func (c *Client) nodeFiles(ctx context.Context) ([]string, error) {
+	var mu sync.Mutex
+	seen := make(map[string]struct{})
+
+	var g errgroup.Group
+	for _, addr := range c.liveAddrs() {
+		g.Go(func() error {
+			trans := transport.NewTCPTransport(addr)
+			defer trans.Close()
+
+			names, err := trans.ListFiles(ctx)
+			if err != nil {
+				return fmt.Errorf("can't list files on '%s': %w", addr, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, name := range names {
+				// chunks of versions and deduplicated ones are not files
+				if !strings.HasPrefix(name, versionsDir+"/") && !strings.HasPrefix(name, contentDir+"/") {
+					seen[name] = struct{}{}
+				}
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
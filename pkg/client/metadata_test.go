@@ -0,0 +1,70 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 2)
+	replicas := testcluster.StartMeta(t, 1)
+	catalog := meta.NewClient(replicas[0].Addr)
+	client := NewClient(addrs[0]+","+addrs[1], 512, WithMeta(catalog))
+
+	upload := func(name string, md Metadata, data []byte) {
+		require.NoError(t, client.Upload(ctx, name, bytes.NewReader(data), int64(len(data)), WithMetadata(md)))
+	}
+
+	data := make([]byte, 3*512+100)
+	rand.Read(data)
+	upload("builds/1.zip", Metadata{ContentType: "application/zip", Tags: map[string]string{"build": "1"}}, data)
+	upload("builds/2.zip", Metadata{ContentType: "application/zip", Tags: map[string]string{"build": "2"}}, data[:512])
+	upload("builds/2.txt", Metadata{ContentType: "text/plain", Tags: map[string]string{"build": "2"}}, []byte("log"))
+
+	info, err := client.Stat(ctx, "builds/1.zip")
+	require.NoError(t, err)
+	require.Equal(t, "builds/1.zip", info.Name)
+	require.Equal(t, int64(len(data)), info.Size)
+	require.Equal(t, "application/zip", info.ContentType)
+	require.Equal(t, map[string]string{"build": "1"}, info.Tags)
+	require.False(t, info.CreatedAt.IsZero())
+	require.Regexp(t, `^[0-9a-f]{32}-4$`, info.ETag)
+
+	_, err = client.Stat(ctx, "missing")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// ETag is the same without the catalog, as it's of the chunk checksums
+	plain := NewClient(addrs[0]+","+addrs[1], 512)
+	file, err := catalog.Get(ctx, "builds/1.zip")
+	require.NoError(t, err)
+	plainInfo, err := plain.Stat(ctx, file.Blob)
+	require.NoError(t, err)
+	require.Equal(t, info.ETag, plainInfo.ETag)
+	require.Equal(t, info.Size, plainInfo.Size)
+
+	names := func(infos []FileInfo, err error) []string {
+		require.NoError(t, err)
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name)
+		}
+		return names
+	}
+	require.Equal(t, []string{"builds/1.zip", "builds/2.txt", "builds/2.zip"}, names(client.List(ctx, Filter{Prefix: "builds/"})))
+	require.Equal(t, []string{"builds/2.txt", "builds/2.zip"}, names(client.List(ctx, Filter{Tags: map[string]string{"build": "2"}})))
+	require.Equal(t, []string{"builds/2.zip"}, names(client.List(ctx, Filter{ContentType: "application/zip", Tags: map[string]string{"build": "2"}})))
+	// nodes hold the chunks of versions, which are not files without the catalog
+	require.Empty(t, names(plain.List(ctx, Filter{Prefix: "builds/"})))
+	require.Empty(t, names(plain.List(ctx, Filter{ContentType: "text/plain"})))
+
+	// there is nowhere to record it without the catalog
+	require.ErrorContains(t, plain.Upload(ctx, "file", bytes.NewReader(data), int64(len(data)), WithMetadata(Metadata{ContentType: "text/plain"})), "metadata service")
+}
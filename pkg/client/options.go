@@ -0,0 +1,125 @@
+package sfs
+
+import (
+	"time"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/pkg/chunkio"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+type Option func(c *Client)
+
+// WithReplicas sets the count of nodes each chunk is stored on. Default is 1.
+// All clients of the cluster must use the same value.
+func WithReplicas(n int) Option {
+	return func(c *Client) {
+		c.replicas = max(1, n)
+	}
+}
+
+// WithReadRepair enables read repair: Download verifies the chunks against
+// the checksums of their nodes or the manifest and, after the whole file is
+// read, uploads the chunks which are missing or differ on the nodes that must
+// hold them. DownloadRange reads the whole chunks of the range for that.
+// Repairs run in background, at most maxInFlight at once, the rest are
+// skipped.
+func WithReadRepair(maxInFlight int) Option {
+	return func(c *Client) {
+		c.readRepair = true
+		c.repairSem = make(chan struct{}, max(1, maxInFlight))
+	}
+}
+
+// WithMeta makes the client use the metadata service catalog: Upload commits
+// the file manifest there after all chunks are stored, Download takes the
+// chunk locations from it instead of asking every node.
+func WithMeta(m *meta.Client) Option {
+	return func(c *Client) {
+		c.meta = m
+	}
+}
+
+// WithErasureCoding makes Upload store files erasure coded instead of
+// replicated: each stripe of data consecutive chunks gets parity Reed-Solomon
+// chunks, all of them placed on distinct nodes. Download survives the loss of
+// any parity pieces of each stripe. The scheme is recorded in the file
+// manifest, so it requires WithMeta. Files uploaded without the option are
+// still downloaded as usual.
+func WithErasureCoding(data, parity int) Option {
+	return func(c *Client) {
+		c.erasure = &meta.Erasure{Data: max(1, data), Parity: max(1, parity)}
+	}
+}
+
+// WithContentDefinedChunking makes Upload cut files into chunks of variable
+// size with [chunkio.SplitCDC] instead of fixed chunkSize ones, so similar
+// files share most of the chunks.
+func WithContentDefinedChunking(cfg chunkio.CDCConfig) Option {
+	return func(c *Client) {
+		c.cdc = &cfg
+	}
+}
+
+// WithDedup makes Upload store chunks by their content hash, so identical
+// chunks of any files are stored once. Chunks the nodes already have are not
+// uploaded. Files are the lists of hashes in their manifests, so it requires
+// WithMeta. Works best with WithContentDefinedChunking. Unreferenced chunks
+// are deleted by CollectGarbage. Ignored with WithErasureCoding.
+func WithDedup() Option {
+	return func(c *Client) {
+		c.dedup = true
+	}
+}
+
+// WithCompression makes the client compress chunks before upload. Nodes keep
+// them compressed. Download of any client asks nodes for the compressed form
+// and decompresses it locally.
+func WithCompression(codec Compression) Option {
+	return func(c *Client) {
+		c.compression = chunks.Codec(codec)
+	}
+}
+
+// WithEncryption makes Upload encrypt the files on the client: each file gets
+// the random data key, its chunks are sealed with AES-GCM. The data key is
+// stored in the file manifest wrapped by kek (16, 24 or 32 bytes), so it
+// requires WithMeta. Download decrypts the files and fails when a chunk
+// doesn't pass authentication. Encrypted chunks are not compressed and can't
+// be deduplicated.
+func WithEncryption(kek []byte) Option {
+	return func(c *Client) {
+		c.kek = kek
+	}
+}
+
+// WithResume makes Upload resumable: chunks the placement nodes already hold
+// with the same checksums are not uploaded again. The local checksums are
+// computed on every attempt, so the file may change between them. Uploaded
+// chunks are recorded in the checkpoint file in dir, which is removed when the
+// upload succeeds. Ignored with
+// WithErasureCoding and WithEncryption, WithDedup skips present chunks anyway.
+func WithResume(dir string) Option {
+	return func(c *Client) {
+		c.resumeDir = dir
+	}
+}
+
+// WithProgress makes uploads and downloads report their progress to fn, at
+// most once per interval and once when done. fn is never called concurrently,
+// but it's called in the middle of the transfer, so it must be fast.
+func WithProgress(interval time.Duration, fn func(Progress)) Option {
+	return func(c *Client) {
+		c.progress = fn
+		c.progressInterval = interval
+	}
+}
+
+// WithBandwidth limits the rate of chunk uploads and downloads of the client.
+// The priority class the nodes schedule the requests by is set in their
+// context with qos.WithClass.
+func WithBandwidth(limits Bandwidth) Option {
+	return func(c *Client) {
+		c.bandwidth = newLimiters(limits)
+	}
+}
@@ -0,0 +1,214 @@
+package sfs
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress is the state of the upload or download reported to the callback
+// of WithProgress. Each replica and erasure coding piece is a separate chunk
+// transfer. Totals may grow while the transfer is planned, they are zero while
+// unknown, e.g. for UploadStream.
+type Progress struct {
+	Op          string // "upload" or "download"
+	Name        string
+	Bytes       int64
+	TotalBytes  int64
+	Chunks      int
+	TotalChunks int
+	Nodes       map[string]NodeProgress
+	Elapsed     time.Duration
+	// ETA is zero while it can't be estimated.
+	ETA time.Duration
+	// Done is set in the last report of the transfer, successful or not.
+	Done bool
+}
+
+// NodeProgress is the transfer to or from the node.
+type NodeProgress struct {
+	Bytes int64
+	// Throughput is in bytes per second, averaged over the transfer.
+	Throughput float64
+}
+
+const (
+	opUpload   = "upload"
+	opDownload = "download"
+)
+
+// transfer counts the progress of one upload or download. Nil transfer
+// counts nothing, so the callers don't check whether progress is on.
+type transfer struct {
+	mu       sync.Mutex
+	progress Progress
+	start    time.Time
+	reported time.Time
+	interval time.Duration
+	report   func(Progress)
+}
+
+type transferKey struct{}
+
+// startTransfer returns the context carrying the new transfer, so the chunk
+// uploads and downloads deep inside the operation are counted.
+func (c *Client) startTransfer(ctx context.Context, op, name string) (context.Context, *transfer) {
+	if c.progress == nil {
+		return ctx, nil
+	}
+
+	tr := &transfer{
+		progress: Progress{Op: op, Name: name, Nodes: make(map[string]NodeProgress)},
+		start:    time.Now(),
+		interval: c.progressInterval,
+		report:   c.progress,
+	}
+
+	return context.WithValue(ctx, transferKey{}, tr), tr
+}
+
+func transferFrom(ctx context.Context) *transfer {
+	tr, _ := ctx.Value(transferKey{}).(*transfer)
+	return tr
+}
+
+// expect adds the planned chunk transfers to the totals.
+func (t *transfer) expect(bytes int64, chunks int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.progress.TotalBytes += bytes
+	t.progress.TotalChunks += chunks
+}
+
+// skip counts the planned chunk transfers which are not needed as done.
+func (t *transfer) skip(bytes int64, chunks int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.progress.Bytes += bytes
+	t.progress.Chunks += chunks
+	t.maybeReport()
+}
+
+func (t *transfer) add(addr string, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.progress.Bytes += int64(n)
+	node := t.progress.Nodes[addr]
+	node.Bytes += int64(n)
+	t.progress.Nodes[addr] = node
+	t.maybeReport()
+}
+
+func (t *transfer) chunkDone() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.progress.Chunks++
+	t.maybeReport()
+}
+
+// finish makes the last report.
+func (t *transfer) finish() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.progress.Done {
+		return
+	}
+
+	t.progress.Done = true
+	t.reportLocked()
+}
+
+func (t *transfer) maybeReport() {
+	if time.Since(t.reported) >= t.interval {
+		t.reportLocked()
+	}
+}
+
+// reportLocked calls the callback under the lock, so it's never called
+// concurrently.
+func (t *transfer) reportLocked() {
+	t.reported = time.Now()
+
+	p := t.progress
+	p.Elapsed = time.Since(t.start)
+	p.Nodes = make(map[string]NodeProgress, len(t.progress.Nodes))
+	for addr, node := range t.progress.Nodes {
+		node.Throughput = float64(node.Bytes) / p.Elapsed.Seconds()
+		p.Nodes[addr] = node
+	}
+
+	if p.Bytes > 0 && p.TotalBytes > p.Bytes && !p.Done {
+		p.ETA = time.Duration(float64(p.Elapsed) * float64(p.TotalBytes-p.Bytes) / float64(p.Bytes))
+	}
+
+	t.report(p)
+}
+
+// finishDownload wraps the result of the download, so the last report is made
+// when the file is read to the end.
+func (t *transfer) finishDownload(r io.Reader, cls func() error, size int64, err error) (io.Reader, func() error, int64, error) {
+	if t == nil {
+		return r, cls, size, err
+	}
+
+	if err != nil {
+		t.finish()
+		return nil, nil, 0, err
+	}
+
+	return io.MultiReader(r, &eofHook{fn: t.finish}), cls, size, nil
+}
+
+// reader counts the bytes read through r as transferred with the node. With
+// eofDone the chunk is done when r is read to the end.
+func (t *transfer) reader(r io.Reader, addr string, eofDone bool) io.Reader {
+	if t == nil {
+		return r
+	}
+
+	return &countingReader{r: r, tr: t, addr: addr, eofDone: eofDone}
+}
+
+type countingReader struct {
+	r       io.Reader
+	tr      *transfer
+	addr    string
+	eofDone bool
+	done    bool
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.tr.add(r.addr, n)
+	}
+
+	if err == io.EOF && r.eofDone && !r.done {
+		r.done = true
+		r.tr.chunkDone()
+	}
+
+	return n, err
+}
@@ -0,0 +1,69 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+)
+
+func TestProgress(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 3)
+
+	var reports []Progress
+	client := NewClient(strings.Join(addrs, ","), 512, WithReplicas(2), WithProgress(0, func(p Progress) {
+		reports = append(reports, p)
+	}))
+
+	data := make([]byte, 10*512+100)
+	rand.Read(data)
+
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+	last := reports[len(reports)-1]
+	require.True(t, last.Done)
+	require.Equal(t, opUpload, last.Op)
+	require.Equal(t, int64(2*len(data)), last.Bytes)
+	require.Equal(t, last.TotalBytes, last.Bytes)
+	require.Equal(t, 22, last.Chunks)
+	require.Equal(t, last.TotalChunks, last.Chunks)
+	assertNodesSum(t, last)
+
+	// bytes only grow
+	for i := 1; i < len(reports); i++ {
+		require.GreaterOrEqual(t, reports[i].Bytes, reports[i-1].Bytes)
+	}
+
+	reports = nil
+	r, cls, _, err := client.Download(ctx, "file")
+	require.NoError(t, err)
+	defer cls()
+	require.Empty(t, reports, "nothing is transferred before the read")
+
+	_, err = io.ReadAll(r)
+	require.NoError(t, err)
+	last = reports[len(reports)-1]
+	require.True(t, last.Done)
+	require.Equal(t, opDownload, last.Op)
+	require.Equal(t, int64(len(data)), last.Bytes)
+	require.Equal(t, last.TotalBytes, last.Bytes)
+	require.Equal(t, 11, last.Chunks)
+	assertNodesSum(t, last)
+}
+
+func assertNodesSum(t *testing.T, p Progress) {
+	t.Helper()
+
+	sum := int64(0)
+	for _, node := range p.Nodes {
+		require.Greater(t, node.Throughput, 0.0)
+		sum += node.Bytes
+	}
+	require.Equal(t, p.Bytes, sum)
+}
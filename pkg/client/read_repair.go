@@ -0,0 +1,213 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/transport"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// withReadRepair wraps the chunk readers so the chunks are verified against
+// the checksums of the nodes they were downloaded from. When the whole file
+// is read and valid, the replicas which are missing or differ are repaired
+// in background.
+func (c *Client) withReadRepair(ctx context.Context, name string, holders map[uint64][]holder, ids []uint64, readers []io.Reader) []io.Reader {
+	wrapped := make([]io.Reader, 0, len(readers)+1)
+	for i, r := range readers {
+		src := holders[ids[i]][0]
+		wrapped = append(wrapped, &verifyingReader{r: r, crc: crc32.New(crcTable), name: name, src: src})
+	}
+
+	// read only after all the chunks are read and verified
+	wrapped = append(wrapped, &eofHook{fn: func() {
+		c.startReadRepair(context.WithoutCancel(ctx), name, holders)
+	}})
+
+	return wrapped
+}
+
+func (c *Client) startReadRepair(ctx context.Context, name string, holders map[uint64][]holder) {
+	select {
+	case c.repairSem <- struct{}{}:
+	default:
+		logger.Logf("read repair of '%s' is skipped: too many repairs in flight", name)
+		return
+	}
+
+	go func() {
+		defer func() { <-c.repairSem }()
+
+		repaired, err := c.repairReplicas(ctx, name, holders)
+		if err != nil {
+			logger.Logf("read repair of '%s' failed: %s", name, err)
+			return
+		}
+
+		if repaired > 0 {
+			logger.Logf("read repair of '%s': repaired %d replicas", name, repaired)
+		}
+	}()
+}
+
+// repairReplicas uploads the chunks to the nodes which must hold them according
+// to placement, but miss them or hold different data. The targets are asked
+// for their checksums, as the holders of the manifest may not hold the chunks
+// anymore. The chunk is taken from the first holder whose data matches the
+// checksum verified during the download.
+func (c *Client) repairReplicas(ctx context.Context, name string, holders map[uint64][]holder) (int, error) {
+	// holders may be of the part of the file, see [Client.DownloadRange]
+	ids := make([]uint64, 0, len(holders))
+	for id := range holders {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	present := make(map[string]map[uint64]chunks.Sum) // by target and name
+
+	repaired := 0
+	for _, id := range ids {
+		hs := holders[id]
+		sum := hs[0].sum // it's verified during the download
+		key := hs[0].key
+
+		sources := slices.DeleteFunc(slices.Clone(hs), func(h holder) bool {
+			return h.sum != sum
+		})
+
+		var data []byte
+		fetched := false
+		for _, target := range c.resolveNodesByChunk(key.name, key.id) {
+			sums, err := targetSums(ctx, present, target, key.name)
+			if err != nil {
+				return repaired, err
+			}
+			if got, ok := sums[key.id]; ok && got.Size == sum.Size && got.CRC == sum.CRC {
+				continue
+			}
+
+			if !fetched {
+				if data, err = recvVerified(ctx, name, sources); err != nil {
+					return repaired, err
+				}
+				fetched = true
+			}
+
+			if err := sendChunk(ctx, key, data, target); err != nil {
+				return repaired, err
+			}
+			repaired++
+		}
+	}
+
+	return repaired, nil
+}
+
+// targetSums returns the checksums of the chunks of name the target holds,
+// they're cached in present.
+func targetSums(ctx context.Context, present map[string]map[uint64]chunks.Sum, target, name string) (map[uint64]chunks.Sum, error) {
+	cacheKey := target + "/" + name
+	if sums, ok := present[cacheKey]; ok {
+		return sums, nil
+	}
+
+	trans := transport.NewTCPTransport(target)
+	defer trans.Close()
+
+	sums, err := trans.ChunkSums(ctx, "", name)
+	if err != nil && !errors.Is(err, common.ErrNotFound) {
+		return nil, fmt.Errorf("can't get checksums of '%s' from '%s': %w", name, target, err)
+	}
+
+	byID := make(map[uint64]chunks.Sum, len(sums))
+	for _, sum := range sums {
+		byID[sum.ID] = sum
+	}
+	present[cacheKey] = byID
+
+	return byID, nil
+}
+
+// recvVerified receives the chunk from the first of the holders whose data
+// matches their checksum.
+func recvVerified(ctx context.Context, name string, hs []holder) ([]byte, error) {
+	var errs []error
+	for _, h := range hs {
+		data, err := recvChunkData(ctx, name, h)
+		if err == nil {
+			return data, nil
+		}
+
+		logger.Logf("read repair of '%s' can't use '%s' as the source: %s", name, h.addr, err)
+		errs = append(errs, err)
+	}
+
+	return nil, fmt.Errorf("no valid replica of chunk %d: %w", hs[0].key.id, errors.Join(errs...))
+}
+
+func recvChunkData(ctx context.Context, name string, h holder) ([]byte, error) {
+	src := transport.NewTCPTransport(h.addr)
+	defer src.Close()
+
+	chk, err := src.RecvChunk(ctx, h.key.name, h.key.id)
+	if err != nil {
+		return nil, fmt.Errorf("can't receive chunk %d from '%s': %w", h.key.id, h.addr, err)
+	}
+
+	return io.ReadAll(&verifyingReader{r: chk.Body, crc: crc32.New(crcTable), name: name, src: h})
+}
+
+func sendChunk(ctx context.Context, key chunkKey, data []byte, to string) error {
+	dst := transport.NewTCPTransport(to)
+	defer dst.Close()
+
+	chk := chunks.Chunk{ID: key.id, Filename: key.name, Size: uint64(len(data)), Body: bytes.NewReader(data)}
+	if err := dst.SendChunk(ctx, chk); err != nil {
+		return fmt.Errorf("can't send chunk %d to '%s': %w", key.id, to, err)
+	}
+
+	return nil
+}
+
+// verifyingReader checks the chunk body against the checksum its node reported.
+type verifyingReader struct {
+	r    io.Reader
+	crc  hash.Hash32
+	n    uint64
+	name string
+	src  holder
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc.Write(p[:n])
+	r.n += uint64(n)
+
+	if err == io.EOF && (r.n != r.src.sum.Size || r.crc.Sum32() != r.src.sum.CRC) {
+		return n, fmt.Errorf("chunk %d of '%s' from '%s' is corrupted: got size %d crc %x, expected size %d crc %x",
+			r.src.sum.ID, r.name, r.src.addr, r.n, r.crc.Sum32(), r.src.sum.Size, r.src.sum.CRC)
+	}
+
+	return n, err
+}
+
+// eofHook calls fn on the first read and returns [io.EOF].
+type eofHook struct {
+	once sync.Once
+	fn   func()
+}
+
+func (h *eofHook) Read(p []byte) (int, error) {
+	h.once.Do(h.fn)
+	return 0, io.EOF
+}
@@ -0,0 +1,149 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/placement"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestReadRepair(t *testing.T) {
+	ctx := context.Background()
+
+	metaAddr := testcluster.StartMeta(t, 1)[0].Addr
+	tests := []struct {
+		name     string
+		opts     []Option
+		download func(c *Client, name string) (io.Reader, func() error, int64, error)
+	}{
+		{
+			name: "nodes",
+			download: func(c *Client, name string) (io.Reader, func() error, int64, error) {
+				return c.Download(ctx, name)
+			},
+		},
+		{
+			name: "meta",
+			opts: []Option{WithMeta(meta.NewClient(metaAddr))},
+			download: func(c *Client, name string) (io.Reader, func() error, int64, error) {
+				return c.Download(ctx, name)
+			},
+		},
+		{
+			name: "range",
+			opts: []Option{WithMeta(meta.NewClient(metaAddr))},
+			download: func(c *Client, name string) (io.Reader, func() error, int64, error) {
+				// the range ends in the middle of the last chunk
+				return c.DownloadRange(ctx, name, 0, 10*512-100)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := testcluster.StartNodes(t, 3)
+			addrs := make([]string, 0, len(nodes))
+			for _, n := range nodes {
+				addrs = append(addrs, n.Addr)
+			}
+
+			data := make([]byte, 10*512)
+			rand.Read(data)
+
+			opts := append([]Option{WithReplicas(2), WithReadRepair(1)}, tt.opts...)
+			client := NewClient(strings.Join(addrs, ","), 512, opts...)
+			require.NoError(t, client.Upload(ctx, tt.name, bytes.NewReader(data), int64(len(data))))
+
+			blob := tt.name
+			if client.meta != nil {
+				file, err := client.lookupManifest(ctx, tt.name)
+				require.NoError(t, err)
+				blob = blobName(file)
+			}
+
+			// every node loses one of its chunks
+			for i, n := range nodes {
+				for id := range uint64(10) {
+					if slices.Contains(placement.Nodes(addrs, blob, id, 2), n.Addr) && id%3 == uint64(i) {
+						require.NoError(t, n.Storage.DeleteChunk(ctx, blob, id))
+						break
+					}
+				}
+			}
+
+			r, cls, size, err := tt.download(client, tt.name)
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, cls())
+			require.Equal(t, data[:size], got)
+
+			require.Eventually(t, func() bool {
+				for id := range uint64(10) {
+					for _, addr := range placement.Nodes(addrs, blob, id, 2) {
+						n := nodes[slices.Index(addrs, addr)]
+						ids, err := n.Storage.ListChunkIDs(ctx, blob)
+						if err != nil || !slices.Contains(ids, id) {
+							return false
+						}
+					}
+				}
+				return true
+			}, 2*time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestReadRepairCorruptedSource(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 3)
+	addrs := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		addrs = append(addrs, n.Addr)
+	}
+
+	data := make([]byte, 512)
+	rand.Read(data)
+
+	client := NewClient(strings.Join(addrs, ","), 512, WithReplicas(3), WithReadRepair(1))
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+
+	holders, err := client.resolveChunksAddrs(ctx, "file")
+	require.NoError(t, err)
+	hs := holders[0]
+	require.Len(t, hs, 3)
+
+	// the first holder rotted after its checksum was reported, the last one
+	// lost the chunk
+	rotten := nodes[slices.Index(addrs, hs[0].addr)]
+	corrupted := slices.Clone(data)
+	corrupted[0] ^= 0xff
+	require.NoError(t, os.WriteFile(filepath.Join(rotten.Dir, "file", "0"), corrupted, 0o644))
+
+	lost := nodes[slices.Index(addrs, hs[2].addr)]
+	require.NoError(t, lost.Storage.DeleteChunk(ctx, "file", 0))
+	holders[0] = hs[:2]
+
+	repaired, err := client.repairReplicas(ctx, "file", holders)
+	require.NoError(t, err)
+	require.Equal(t, 1, repaired)
+
+	chk, cls, err := lost.Storage.GetChunk(ctx, "file", 0)
+	require.NoError(t, err)
+	got, err := io.ReadAll(chk.Body)
+	require.NoError(t, err)
+	require.NoError(t, cls())
+	require.Equal(t, data, got)
+}
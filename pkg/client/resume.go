@@ -0,0 +1,328 @@
+package sfs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/chunkio"
+	"github.com/tymbaca/sfs/pkg/meta"
+	"golang.org/x/sync/errgroup"
+)
+
+// uploadResumable uploads only the replicas the nodes don't have yet: the
+// nodes report the checksums of the chunks they hold, which are compared with
+// the checksums of the local chunks. The checkpoint of the previous attempt
+// is not trusted for that, as the local file may have changed since. The
+// versioned file continues the version of the previous attempt.
+func (c *Client) uploadResumable(ctx context.Context, file meta.File, r io.ReaderAt, totalSize int64) error {
+	chks, err := c.splitStored(r, totalSize)
+	if err != nil {
+		return fmt.Errorf("can't split the file: %w", err)
+	}
+
+	// checkpoints of the same name in different buckets must not collide
+	hdr := checkpointHeader{Name: file.Name, Size: totalSize, ChunkSize: c.chunkSize, Version: file.Version}
+	if c.cdc != nil {
+		hdr.CDC = *c.cdc
+	}
+
+	ckpt, err := openCheckpoint(c.resumeDir, hdr)
+	if err != nil {
+		return fmt.Errorf("can't upload the file: %w", err)
+	}
+	defer ckpt.close()
+
+	if ckpt.version != file.Version {
+		setVersion(&file, ckpt.version)
+	}
+	name := blobName(file)
+
+	nodeSums := c.nodeSums(ctx, name)
+
+	var g errgroup.Group
+	manifest := make([]manifestChunk, len(chks))
+	for id, chk := range chks {
+		g.Go(func() error {
+			mc, err := c.resumeChunk(ctx, name, uint64(id), chk, nodeSums, ckpt)
+			manifest[id] = mc
+			return err
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("can't upload the file: %w", err)
+	}
+
+	if c.meta != nil {
+		file.Size = totalSize
+		if err := c.commitManifest(ctx, file, manifest); err != nil {
+			return fmt.Errorf("can't upload the file: %w", err)
+		}
+	} else {
+		c.trimStale(ctx, name, uint64(len(chks)))
+	}
+
+	if err := ckpt.remove(); err != nil {
+		logger.Logf("can't remove the checkpoint of '%s': %s", name, err)
+	}
+
+	return nil
+}
+
+// resumeChunk uploads the chunk to the placement nodes which miss it or hold
+// different data, and records it in the checkpoint.
+func (c *Client) resumeChunk(ctx context.Context, name string, id uint64, chk *chunkio.Reader, nodeSums map[string]map[uint64]chunks.Sum, ckpt *checkpoint) (manifestChunk, error) {
+	addrs := c.resolveNodesByChunk(name, id)
+	if len(addrs) == 0 {
+		return manifestChunk{}, fmt.Errorf("can't upload chunk %d: all nodes are down", id)
+	}
+
+	tr := transferFrom(ctx)
+	tr.expect(chk.Size()*int64(len(addrs)), len(addrs))
+
+	var sum chunks.Sum
+	var known bool
+	var missing []string
+	for _, addr := range addrs {
+		got, ok := nodeSums[addr][id]
+		if !ok || got.Size != uint64(chk.Size()) {
+			missing = append(missing, addr)
+			continue
+		}
+
+		if !known {
+			crc := crc32.New(crcTable)
+			if _, err := io.Copy(crc, chk.Clone()); err != nil {
+				return manifestChunk{}, fmt.Errorf("can't read chunk %d: %w", id, err)
+			}
+			sum, known = chunks.Sum{ID: id, Size: uint64(chk.Size()), CRC: crc.Sum32()}, true
+
+			if prev, ok := ckpt.sum(id); ok && prev != sum {
+				logger.Logf("chunk %d of '%s' changed since the previous attempt", id, name)
+			}
+		}
+
+		if got != sum {
+			missing = append(missing, addr)
+		}
+	}
+
+	var crc *crcReader
+	var g errgroup.Group
+	for i, addr := range missing {
+		chunk := chunks.Chunk{ID: id, Filename: name, Size: uint64(chk.Size()), Body: chk.Clone()}
+		if !known && i == 0 {
+			crc = &crcReader{r: chunk.Body}
+			chunk.Body = crc
+		}
+
+		g.Go(func() error {
+			return c.uploadChunk(ctx, chunk, addr, nil)
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return manifestChunk{}, err
+	}
+	tr.skip(chk.Size()*int64(len(addrs)-len(missing)), len(addrs)-len(missing))
+
+	if !known {
+		sum = chunks.Sum{ID: id, Size: uint64(chk.Size()), CRC: crc.crc}
+	}
+	logger.Debugf("chunk %d of '%s': %d of %d replicas uploaded", id, name, len(missing), len(addrs))
+
+	if err := ckpt.record(sum); err != nil {
+		return manifestChunk{}, err
+	}
+
+	return manifestChunk{
+		chunk: chunks.Chunk{ID: id, Size: sum.Size},
+		nodes: addrs,
+		crc:   &crcReader{crc: sum.CRC},
+	}, nil
+}
+
+// nodeSums returns the checksums of the file chunks held by each live node.
+// Unreachable nodes report nothing, so their chunks are uploaded again.
+func (c *Client) nodeSums(ctx context.Context, name string) map[string]map[uint64]chunks.Sum {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	result := make(map[string]map[uint64]chunks.Sum)
+	for _, addr := range c.liveAddrs() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			trans := transport.NewTCPTransport(addr)
+			defer trans.Close()
+
+			sums, err := trans.ChunkSums(ctx, "", name)
+			if err != nil {
+				logger.Logf("can't get checksums of '%s' from '%s': %s", name, addr, err)
+				return
+			}
+
+			byID := make(map[uint64]chunks.Sum, len(sums))
+			for _, sum := range sums {
+				byID[sum.ID] = sum
+			}
+
+			mu.Lock()
+			result[addr] = byID
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return result
+}
+
+// checkpointHeader identifies the upload. The checkpoint of another upload
+// (or the same file split differently) is discarded. Version is of the
+// versioned file, it's not compared.
+type checkpointHeader struct {
+	Name      string            `json:"name"`
+	Size      int64             `json:"size"`
+	ChunkSize int64             `json:"chunk_size"`
+	CDC       chunkio.CDCConfig `json:"cdc"`
+	Version   uint64            `json:"version,omitempty"`
+}
+
+type checkpointChunk struct {
+	ID   uint64 `json:"id"`
+	Size uint64 `json:"size"`
+	CRC  uint32 `json:"crc"`
+}
+
+// checkpoint is the local file with the header line followed by a line per
+// uploaded chunk. The torn last line of the interrupted upload is ignored.
+type checkpoint struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	sums    map[uint64]chunks.Sum
+	version uint64
+}
+
+func openCheckpoint(dir string, hdr checkpointHeader) (*checkpoint, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("can't create the checkpoint dir: %w", err)
+	}
+
+	ckpt := &checkpoint{
+		path:    filepath.Join(dir, url.PathEscape(hdr.Name)+".checkpoint"),
+		sums:    make(map[uint64]chunks.Sum),
+		version: hdr.Version,
+	}
+
+	if err := ckpt.load(hdr); err != nil {
+		return nil, err
+	}
+	hdr.Version = ckpt.version
+
+	// rewritten from scratch, so the torn line doesn't stay in the middle
+	f, err := os.Create(ckpt.path)
+	if err != nil {
+		return nil, fmt.Errorf("can't create the checkpoint: %w", err)
+	}
+	ckpt.f = f
+
+	if err := ckpt.writeLine(hdr); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	for _, sum := range ckpt.sums {
+		if err := ckpt.writeLine(checkpointChunk{ID: sum.ID, Size: sum.Size, CRC: sum.CRC}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return ckpt, nil
+}
+
+// load reads the uploaded chunks and the version from the checkpoint, if it's
+// of the same upload.
+func (ckpt *checkpoint) load(hdr checkpointHeader) error {
+	f, err := os.Open(ckpt.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't open the checkpoint: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	var got checkpointHeader
+	ok := sc.Scan() && json.Unmarshal(sc.Bytes(), &got) == nil
+	version := got.Version
+	got.Version = hdr.Version
+	if !ok || got != hdr || (version == 0) != (hdr.Version == 0) {
+		logger.Logf("checkpoint of '%s' is of another upload, starting over", hdr.Name)
+		return nil
+	}
+	ckpt.version = version
+
+	for sc.Scan() {
+		var chk checkpointChunk
+		if err := json.Unmarshal(sc.Bytes(), &chk); err != nil {
+			break
+		}
+		ckpt.sums[chk.ID] = chunks.Sum{ID: chk.ID, Size: chk.Size, CRC: chk.CRC}
+	}
+
+	return nil
+}
+
+func (ckpt *checkpoint) sum(id uint64) (chunks.Sum, bool) {
+	ckpt.mu.Lock()
+	defer ckpt.mu.Unlock()
+
+	sum, ok := ckpt.sums[id]
+	return sum, ok
+}
+
+func (ckpt *checkpoint) record(sum chunks.Sum) error {
+	ckpt.mu.Lock()
+	defer ckpt.mu.Unlock()
+
+	ckpt.sums[sum.ID] = sum
+	return ckpt.writeLine(checkpointChunk{ID: sum.ID, Size: sum.Size, CRC: sum.CRC})
+}
+
+func (ckpt *checkpoint) writeLine(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ckpt.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("can't write the checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+func (ckpt *checkpoint) close() error {
+	return ckpt.f.Close()
+}
+
+// remove deletes the checkpoint of the finished upload.
+func (ckpt *checkpoint) remove() error {
+	ckpt.close()
+	return os.Remove(ckpt.path)
+}
@@ -0,0 +1,183 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestResume(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 2)
+	addrs := nodes[0].Addr + "," + nodes[1].Addr
+	ckptDir := t.TempDir()
+
+	data := make([]byte, 10*512)
+	rand.Read(data)
+
+	// the interrupted upload stored the first half
+	client := NewClient(addrs, 512, WithReplicas(2))
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data[:5*512]), 5*512))
+
+	// and chunk 2 got different data on the first node
+	trans := transport.NewTCPTransport(nodes[0].Addr)
+	require.NoError(t, trans.SendChunk(ctx, chunks.Chunk{ID: 2, Filename: "file", Size: 512, Body: bytes.NewReader(make([]byte, 512))}))
+	trans.Close()
+
+	past := time.Now().Add(-time.Hour)
+	for _, node := range nodes {
+		for id := range 5 {
+			require.NoError(t, os.Chtimes(chunkPath(node, "file", id), past, past))
+		}
+	}
+
+	client = NewClient(addrs, 512, WithReplicas(2), WithResume(ckptDir))
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+	assertDownload(t, client, "file", data)
+
+	for _, node := range nodes {
+		for id := range 5 {
+			stat, err := os.Stat(chunkPath(node, "file", id))
+			require.NoError(t, err)
+			replaced := id == 2 && node.Addr == nodes[0].Addr
+			require.Equal(t, replaced, stat.ModTime().After(past), "node %s chunk %d", node.Addr, id)
+		}
+	}
+
+	// the checkpoint is removed after success
+	entries, err := os.ReadDir(ckptDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	hdr := checkpointHeader{Name: "dir/file", Size: 100, ChunkSize: 10}
+
+	ckpt, err := openCheckpoint(dir, hdr)
+	require.NoError(t, err)
+	require.NoError(t, ckpt.record(chunks.Sum{ID: 0, Size: 10, CRC: 1}))
+	require.NoError(t, ckpt.record(chunks.Sum{ID: 3, Size: 10, CRC: 2}))
+	require.NoError(t, ckpt.close())
+
+	// torn line of the killed process
+	path := filepath.Join(dir, "dir%2Ffile.checkpoint")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	f.WriteString(`{"id":4,"si`)
+	f.Close()
+
+	ckpt, err = openCheckpoint(dir, hdr)
+	require.NoError(t, err)
+	require.Len(t, ckpt.sums, 2)
+	require.Equal(t, chunks.Sum{ID: 3, Size: 10, CRC: 2}, ckpt.sums[3])
+	require.NoError(t, ckpt.record(chunks.Sum{ID: 5, Size: 10, CRC: 3}))
+	require.NoError(t, ckpt.close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(string(content)), "\n"), 4)
+
+	// the checkpoint of another upload is discarded
+	hdr.Size = 200
+	ckpt, err = openCheckpoint(dir, hdr)
+	require.NoError(t, err)
+	require.Empty(t, ckpt.sums)
+	require.NoError(t, ckpt.remove())
+}
+
+func chunkPath(node testcluster.Node, name string, id int) string {
+	return filepath.Join(node.Dir, name, strconv.Itoa(id))
+}
+
+func TestResumeChangedFile(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := strings.Join(testcluster.Start(t, 2), ",")
+	ckptDir := t.TempDir()
+
+	old := make([]byte, 4*512)
+	rand.Read(old)
+
+	// the interrupted upload stored the old content and recorded it
+	client := NewClient(addrs, 512, WithReplicas(2))
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(old), int64(len(old))))
+
+	ckpt, err := openCheckpoint(ckptDir, checkpointHeader{Name: "file", Size: int64(len(old)), ChunkSize: 512})
+	require.NoError(t, err)
+	sums, err := client.nodeChunkSums(ctx, "file")
+	require.NoError(t, err)
+	for _, sum := range sums {
+		require.NoError(t, ckpt.record(sum))
+	}
+	require.NoError(t, ckpt.close())
+
+	// then the file changed, keeping the size
+	data := bytes.Clone(old)
+	rand.Read(data[512:1024])
+
+	client = NewClient(addrs, 512, WithReplicas(2), WithResume(ckptDir))
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+	assertDownload(t, client, "file", data)
+}
+
+func TestResumeVersioned(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 2)
+	addrs := nodes[0].Addr + "," + nodes[1].Addr
+	replicas := testcluster.StartMeta(t, 1)
+	catalog := meta.NewClient(replicas[0].Addr)
+	ckptDir := t.TempDir()
+
+	data := make([]byte, 10*512)
+	rand.Read(data)
+
+	// the interrupted upload of the version stored the first half
+	const version = 42
+	hdr := checkpointHeader{Name: "file", Size: int64(len(data)), ChunkSize: 512, Version: version}
+	ckpt, err := openCheckpoint(ckptDir, hdr)
+	require.NoError(t, err)
+	require.NoError(t, ckpt.close())
+
+	blob := versionBlob("file", version)
+	require.NoError(t, NewClient(addrs, 512, WithReplicas(2)).Upload(ctx, blob, bytes.NewReader(data[:5*512]), 5*512))
+
+	past := time.Now().Add(-time.Hour)
+	for _, node := range nodes {
+		for id := range 5 {
+			require.NoError(t, os.Chtimes(chunkPath(node, blob, id), past, past))
+		}
+	}
+
+	client := NewClient(addrs, 512, WithReplicas(2), WithMeta(catalog), WithResume(ckptDir))
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+	assertDownload(t, client, "file", data)
+
+	// the version is continued, not started over
+	file, err := catalog.Get(ctx, "file")
+	require.NoError(t, err)
+	require.Equal(t, uint64(version), file.Version)
+	require.Equal(t, blob, file.Blob)
+
+	for _, node := range nodes {
+		for id := range 5 {
+			stat, err := os.Stat(chunkPath(node, blob, id))
+			require.NoError(t, err)
+			require.Equal(t, past.Unix(), stat.ModTime().Unix(), "node %s chunk %d", node.Addr, id)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"golang.org/x/sync/errgroup"
+)
+
+// streamBuffers is the count of chunk buffers UploadStream reads into, so
+// it's also the count of chunks uploaded at once.
+const streamBuffers = 4
+
+// UploadStream uploads the file read from r until EOF, so its size doesn't
+// have to be known in advance. Chunks of chunkSize are read into the bounded
+// pool of buffers and uploaded while the next ones are read. Erasure coding,
+// deduplication and content-defined chunking need the whole file, so the
+// first two are not supported and the last one is ignored.
+func (c *Client) UploadStream(ctx context.Context, name string, r io.Reader, opts ...UploadOption) error {
+	ctx = c.withBucket(ctx)
+	ctx, tr := c.startTransfer(ctx, opUpload, name)
+	defer tr.finish()
+
+	if c.erasure != nil || c.dedup {
+		return errors.New("can't upload the stream: not supported with erasure coding and deduplication")
+	}
+
+	if c.chunkSize < 1 {
+		panic("can't split byte non-positive size")
+	}
+
+	file, key, err := c.newManifest(name, newUploadOptions(opts))
+	if err != nil {
+		return fmt.Errorf("can't upload the stream: %w", err)
+	}
+
+	// buffers are allocated on demand, so small streams don't take much
+	pool := make(chan []byte, streamBuffers)
+	for range streamBuffers {
+		pool <- nil
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	var manifest []manifestChunk
+	size := int64(0)
+	for id := uint64(0); ; id++ {
+		var buf []byte
+		select {
+		case buf = <-pool:
+		case <-gctx.Done():
+			return fmt.Errorf("can't upload the stream: %w", g.Wait())
+		}
+
+		if buf == nil {
+			buf = make([]byte, c.chunkSize)
+		}
+
+		n, err := io.ReadFull(r, buf)
+		// without the manifest the empty stream is the empty chunk 0, see splitStored
+		if n > 0 || id == 0 && c.meta == nil {
+			data := buf[:n]
+			size += int64(n)
+
+			addrs := c.resolveNodesByChunk(blobName(file), id)
+			tr.expect(int64(n)*int64(len(addrs)), len(addrs))
+			chunk := chunks.Chunk{ID: id, Filename: blobName(file), Size: uint64(n)}
+			manifest = append(manifest, manifestChunk{
+				chunk: chunk,
+				nodes: addrs,
+				crc:   &crcReader{crc: crc32.Checksum(data, crcTable)},
+			})
+
+			g.Go(func() error {
+				defer func() { pool <- buf }()
+				return c.uploadReplicas(gctx, chunk, data, addrs, key)
+			})
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+
+		if err != nil {
+			g.Wait()
+			return fmt.Errorf("can't upload the stream: can't read chunk %d: %w", id, err)
+		}
+	}
+
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("can't upload the stream: %w", err)
+	}
+
+	if c.meta == nil {
+		c.trimStale(ctx, name, uint64(len(manifest)))
+		return nil
+	}
+
+	file.Size = size
+	if err := c.commitManifest(ctx, file, manifest); err != nil {
+		return fmt.Errorf("can't upload the stream: %w", err)
+	}
+
+	return nil
+}
+
+// uploadReplicas uploads the chunk with data body to all addrs.
+func (c *Client) uploadReplicas(ctx context.Context, chunk chunks.Chunk, data []byte, addrs []string, key *fileKey) error {
+	if len(addrs) == 0 {
+		return fmt.Errorf("can't upload chunk %d: all nodes are down", chunk.ID)
+	}
+
+	var g errgroup.Group
+	for _, addr := range addrs {
+		replica := chunk
+		replica.Body = bytes.NewReader(data)
+		g.Go(func() error {
+			return c.uploadChunk(ctx, replica, addr, key)
+		})
+	}
+
+	return g.Wait()
+}
@@ -0,0 +1,51 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestUploadStream(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 3)
+	replicas := testcluster.StartMeta(t, 1)
+	catalog := meta.NewClient(replicas[0].Addr)
+
+	data := make([]byte, 20*512+100)
+	rand.Read(data)
+
+	client := NewClient(strings.Join(addrs, ","), 512, WithReplicas(2), WithMeta(catalog))
+
+	// the pipe hides the size, as the stdin does
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(data)
+		pw.Close()
+	}()
+
+	require.NoError(t, client.UploadStream(ctx, "file", pr))
+	assertDownload(t, client, "file", data)
+
+	file, err := catalog.Get(ctx, "file")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), file.Size)
+	require.Len(t, file.Chunks, 21)
+
+	// read error fails the upload
+	broken := io.MultiReader(bytes.NewReader(data[:1000]), errReader{errors.New("broken pipe")})
+	require.ErrorContains(t, client.UploadStream(ctx, "broken", broken), "broken pipe")
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,276 @@
+package sfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+// versionsDir is the directory the chunks of versioned files are stored in,
+// each version as the file named by its id and the file name.
+const versionsDir = "_versions"
+
+func versionBlob(name string, version uint64) string {
+	return versionsDir + "/" + strconv.FormatUint(version, 10) + "/" + name
+}
+
+// setVersion makes file the version of the same name.
+func setVersion(file *meta.File, version uint64) {
+	file.Blob = versionBlob(strings.TrimPrefix(file.Blob, versionBlob("", file.Version)), version)
+	file.Version = version
+}
+
+// blobName returns the name the chunks of the file are stored under.
+func blobName(file meta.File) string {
+	if file.Blob != "" {
+		return file.Blob
+	}
+
+	return file.Name
+}
+
+// newManifest returns the manifest of the new upload of the file without
+// chunks and size: its metadata, encryption and, with the metadata service,
+// the new version.
+func (c *Client) newManifest(name string, opts uploadOptions) (meta.File, *fileKey, error) {
+	file := meta.File{Name: c.catalogName(name)}
+	if c.bucket != "" {
+		file.Blob = name
+	}
+
+	if md := opts.metadata; md != nil {
+		if c.meta == nil {
+			return meta.File{}, nil, errors.New("metadata requires the metadata service")
+		}
+		file.ContentType = md.ContentType
+		file.Tags = md.Tags
+	}
+
+	key, err := c.newFileKey(file.Name)
+	if err != nil {
+		return meta.File{}, nil, err
+	}
+	file.Encryption = key.manifest()
+
+	// the manifest published after the chunks are stored is the only way to
+	// replace the file atomically
+	if c.meta != nil {
+		file.Version = uint64(time.Now().UnixNano())
+		file.Blob = versionBlob(name, file.Version)
+	}
+
+	return file, key, nil
+}
+
+// DownloadVersion downloads the version of the file, current or replaced, see
+// [meta.Client.Versions].
+func (c *Client) DownloadVersion(ctx context.Context, name string, version uint64) (io.Reader, func() error, int64, error) {
+	ctx = c.withBucket(ctx)
+	ctx, tr := c.startTransfer(ctx, opDownload, name)
+	return tr.finishDownload(c.downloadVersion(ctx, name, version))
+}
+
+func (c *Client) downloadVersion(ctx context.Context, name string, version uint64) (io.Reader, func() error, int64, error) {
+	if c.meta == nil {
+		return nil, nil, 0, errors.New("can't download the file: versioning requires the metadata service")
+	}
+
+	file, err := c.meta.GetVersion(ctx, c.catalogName(name), version)
+	if err != nil {
+		if errors.Is(err, meta.ErrNotFound) {
+			return nil, nil, 0, fmt.Errorf("can't download the file: version %d of '%s' not found", version, name)
+		}
+		return nil, nil, 0, fmt.Errorf("can't download the file: can't get the manifest: %w", err)
+	}
+
+	return c.downloadManifest(ctx, file)
+}
+
+// Retention is the policy of keeping the replaced versions of files: the
+// version is kept while it's one of Keep newest replaced versions of the file
+// or it was replaced less than KeepFor ago. KeepFor must be longer than the
+// longest download, so readers don't lose the version they have pinned.
+type Retention struct {
+	Keep    int
+	KeepFor time.Duration
+}
+
+// PruneVersions deletes the replaced versions of files of the client bucket
+// which are out of retention, returns the count of pruned versions.
+func (c *Client) PruneVersions(ctx context.Context, retention Retention) (int, error) {
+	ctx = c.withBucket(ctx)
+	if c.meta == nil {
+		return 0, errors.New("can't prune versions: versioning requires the metadata service")
+	}
+
+	all, err := c.meta.Versions(ctx, c.catalogName(""))
+	if err != nil {
+		return 0, fmt.Errorf("can't get versions: %w", err)
+	}
+
+	versions := slices.DeleteFunc(all, func(v meta.Version) bool { return !c.inBucket(v.Name) })
+
+	// count the newer replaced versions of the same file, versions are sorted
+	// by name, the oldest first
+	newer := make([]int, len(versions))
+	for i := len(versions) - 2; i >= 0; i-- {
+		if versions[i].Name == versions[i+1].Name {
+			newer[i] = newer[i+1] + 1
+		}
+	}
+
+	pruned := 0
+	for i, v := range versions {
+		if newer[i] < retention.Keep || time.Since(v.ReplacedAt) < retention.KeepFor {
+			continue
+		}
+
+		// forget it first, failed deletion leaks the chunks, which is better
+		// than the manifest of lost chunks
+		if err := c.meta.DeleteVersion(ctx, v.Name, v.Version); errors.Is(err, meta.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return pruned, fmt.Errorf("can't delete version %d of '%s': %w", v.Version, v.Name, err)
+		}
+
+		if err := c.deleteBlob(ctx, v.File); err != nil {
+			return pruned, fmt.Errorf("can't prune version %d of '%s': %w", v.Version, v.Name, err)
+		}
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// deleteBlob deletes the chunks of the file version from every node, not only
+// the placement ones, as the cluster may be not rebalanced yet. Deduplicated
+// chunks become garbage in the catalog instead.
+func (c *Client) deleteBlob(ctx context.Context, file meta.File) error {
+	if file.Blob == "" {
+		return nil
+	}
+
+	_, err := c.deleteStored(ctx, file.Blob)
+	return err
+}
+
+// staleTrims are the deletions of stale chunks which failed or whose nodes
+// were down, by node and file name. Each holds the chunk count of the last
+// upload of the file.
+type staleTrims struct {
+	mu      sync.Mutex
+	pending map[string]map[string]uint64
+}
+
+func newStaleTrims() *staleTrims {
+	return &staleTrims{pending: make(map[string]map[string]uint64)}
+}
+
+func (t *staleTrims) add(addr, name string, count uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pending[addr] == nil {
+		t.pending[addr] = make(map[string]uint64)
+	}
+	t.pending[addr][name] = count
+}
+
+// done forgets the trim, unless the file was uploaded again meanwhile.
+func (t *staleTrims) done(addr, name string, count uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if got, ok := t.pending[addr][name]; ok && got == count {
+		delete(t.pending[addr], name)
+	}
+	if len(t.pending[addr]) == 0 {
+		delete(t.pending, addr)
+	}
+}
+
+func (t *staleTrims) of(addr string) map[string]uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return maps.Clone(t.pending[addr])
+}
+
+// trimStale deletes the chunks left from the previous bigger upload of the
+// file, which would be stitched to the new one on download. Without the
+// metadata service nothing else tells them apart. The trim is recorded for
+// every node, and the ones down or failed are retried by [Client.retryTrims].
+func (c *Client) trimStale(ctx context.Context, name string, count uint64) {
+	for _, addr := range c.addrs {
+		c.trims.add(addr, name, count)
+	}
+
+	c.retryTrims(ctx)
+}
+
+// retryTrims deletes the stale chunks of the pending trims from the live
+// nodes.
+func (c *Client) retryTrims(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, addr := range c.liveAddrs() {
+		for name, count := range c.trims.of(addr) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				if err := trimChunks(ctx, addr, name, count); err != nil {
+					logger.Logf("can't delete stale chunks of '%s', will retry: %s", name, err)
+					return
+				}
+				c.trims.done(addr, name, count)
+			}()
+		}
+	}
+
+	wg.Wait()
+}
+
+// trimChunks deletes the chunks of the file from count on from the node.
+func trimChunks(ctx context.Context, addr, name string, count uint64) error {
+	trans := transport.NewTCPTransport(addr)
+	defer trans.Close()
+
+	ids, err := trans.ListIDs(ctx, name)
+	if err != nil {
+		return fmt.Errorf("can't list chunks on '%s': %w", addr, err)
+	}
+
+	var stale []uint64
+	for _, id := range ids {
+		if id >= count {
+			stale = append(stale, id)
+		}
+	}
+
+	return deleteChunks(ctx, addr, name, stale)
+}
+
+func deleteChunks(ctx context.Context, addr, name string, ids []uint64) error {
+	for _, id := range ids {
+		trans := transport.NewTCPTransport(addr)
+		err := trans.TombstoneChunk(ctx, name, id)
+		trans.Close()
+		if err != nil && !errors.Is(err, common.ErrNotFound) {
+			return fmt.Errorf("can't delete chunk %d from '%s': %w", id, addr, err)
+		}
+	}
+
+	return nil
+}
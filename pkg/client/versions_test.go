@@ -0,0 +1,120 @@
+package sfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestVersioning(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 3)
+	replicas := testcluster.StartMeta(t, 1)
+	catalog := meta.NewClient(replicas[0].Addr)
+
+	client := NewClient(strings.Join(addrs, ","), 512, WithReplicas(2), WithMeta(catalog))
+
+	data := make([][]byte, 3)
+	versions := make([]uint64, 3)
+	for i := range data {
+		// each next version is smaller
+		data[i] = make([]byte, (10-i)*512)
+		rand.Read(data[i])
+		require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data[i]), int64(len(data[i]))))
+
+		file, err := catalog.Get(ctx, "file")
+		require.NoError(t, err)
+		versions[i] = file.Version
+	}
+
+	assertDownload(t, client, "file", data[2])
+	for i, v := range versions {
+		r, cls, _, err := client.DownloadVersion(ctx, "file", v)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, data[i], got)
+		cls()
+	}
+
+	old, err := catalog.Versions(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, old, 2)
+	require.Equal(t, versions[0], old[0].Version)
+
+	// the newest replaced version is kept
+	pruned, err := client.PruneVersions(ctx, Retention{Keep: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, pruned)
+
+	_, _, _, err = client.DownloadVersion(ctx, "file", versions[0])
+	require.Error(t, err)
+	for _, addr := range addrs {
+		trans := transport.NewTCPTransport(addr)
+		ids, err := trans.ListIDs(ctx, versionBlob("file", versions[0]))
+		trans.Close()
+		require.NoError(t, err)
+		require.Empty(t, ids)
+	}
+
+	// deleted file is the replaced version too
+	require.NoError(t, catalog.Delete(ctx, "file"))
+	pruned, err = client.PruneVersions(ctx, Retention{})
+	require.NoError(t, err)
+	require.Equal(t, 2, pruned)
+	old, err = catalog.Versions(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, old)
+}
+
+func TestOverwriteWithoutMeta(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient(strings.Join(testcluster.Start(t, 3), ","), 512)
+
+	big := make([]byte, 10*512)
+	rand.Read(big)
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(big), int64(len(big))))
+
+	// stale chunks of the bigger version are not stitched to the new one
+	small := []byte("small")
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(small), int64(len(small))))
+	assertDownload(t, client, "file", small)
+}
+
+func TestTrimRetry(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 2)
+	client := NewClient(nodes[0].Addr+","+nodes[1].Addr, 512, WithReplicas(2))
+
+	big := make([]byte, 10*512)
+	rand.Read(big)
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(big), int64(len(big))))
+
+	// the node missed the trim while it was down
+	client.health.markDown(nodes[0].Addr)
+	small := []byte("small")
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(small), int64(len(small))))
+
+	ids, err := nodes[0].Storage.ListChunkIDs(ctx, "file")
+	require.NoError(t, err)
+	require.Len(t, ids, 10)
+
+	// and gets it when it's back
+	client.checkHealth(ctx, time.Second)
+	client.retryTrims(ctx)
+	ids, err = nodes[0].Storage.ListChunkIDs(ctx, "file")
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0}, ids)
+	require.Empty(t, client.trims.of(nodes[0].Addr))
+}
@@ -0,0 +1,539 @@
+// Package davgw serves the files of the cluster over WebDAV, so they may be
+// mounted as the network drive. Slash-separated names are the directory tree.
+package davgw
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/logger"
+	sfs "github.com/tymbaca/sfs/pkg/client"
+	"golang.org/x/net/webdav"
+)
+
+// Config configures the handler.
+type Config struct {
+	// Prefix is the URL path the handler is mounted under.
+	Prefix string
+	// SpoolDir keeps the files being written until they're uploaded to the
+	// cluster on close. Empty means the system temp dir.
+	SpoolDir string
+}
+
+// ErrNoMeta is returned by [New] for the client without the metadata
+// service, which is required to store empty directories.
+var ErrNoMeta = errors.New("webdav gateway requires the metadata service")
+
+// New returns the WebDAV handler of the files of the client bucket. Locks are
+// kept in memory. The client must have the metadata service.
+func New(client *sfs.Client, cfg Config) (http.Handler, error) {
+	fsys, err := NewFileSystem(client, cfg.SpoolDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webdav.Handler{
+		Prefix:     cfg.Prefix,
+		FileSystem: fsys,
+		LockSystem: webdav.NewMemLS(),
+		// clients probe missing files a lot, e.g. desktop.ini
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				logger.Logf("webdav gateway: %s %s: %s", r.Method, r.URL.Path, err)
+			}
+		},
+	}, nil
+}
+
+// dirMarker is the suffix of the empty file which keeps the directory created
+// with Mkdir while it has no files, as S3 tools do. The nodes don't take such
+// names, so it's kept by the metadata service only.
+const dirMarker = "/"
+
+type fileSystem struct {
+	client   *sfs.Client
+	spoolDir string
+}
+
+// NewFileSystem returns the WebDAV file system of the files of the client
+// bucket. Written files are spooled to spoolDir and uploaded on close. Rename
+// copies the files, as they're stored by name. Returns [ErrNoMeta] if the
+// client has no metadata service.
+func NewFileSystem(client *sfs.Client, spoolDir string) (webdav.FileSystem, error) {
+	if !client.HasMeta() {
+		return nil, ErrNoMeta
+	}
+
+	return &fileSystem{client: client, spoolDir: spoolDir}, nil
+}
+
+func (f *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = clean(name)
+	if _, err := f.stat(ctx, name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err := f.checkParent(ctx, "mkdir", name); err != nil {
+		return err
+	}
+
+	if err := f.client.Upload(ctx, name+dirMarker, strings.NewReader(""), 0); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+func (f *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = clean(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return f.create(ctx, name, flag)
+	}
+
+	info, err := f.stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &dir{fsys: f, ctx: ctx, name: name, info: info}, nil
+	}
+
+	// reads are served by the io/fs file system, which downloads the range
+	// after seek
+	file, err := f.client.FS(ctx).Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readFile{File: file, info: info}, nil
+}
+
+func (f *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = clean(name)
+	if name == "" {
+		return &fs.PathError{Op: "removeall", Path: "/", Err: fs.ErrPermission}
+	}
+
+	names, err := f.tree(ctx, name)
+	if err != nil {
+		return &fs.PathError{Op: "removeall", Path: name, Err: err}
+	}
+
+	if len(names) == 0 {
+		return &fs.PathError{Op: "removeall", Path: name, Err: fs.ErrNotExist}
+	}
+
+	for _, n := range names {
+		if err := f.client.Delete(ctx, n); err != nil && !errors.Is(err, sfs.ErrNotFound) {
+			return &fs.PathError{Op: "removeall", Path: name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (f *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = clean(oldName), clean(newName)
+	if oldName == "" || newName == "" || strings.HasPrefix(newName+"/", oldName+"/") {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrInvalid}
+	}
+
+	if err := f.checkParent(ctx, "rename", newName); err != nil {
+		return err
+	}
+
+	names, err := f.tree(ctx, oldName)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: err}
+	}
+
+	if len(names) == 0 {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+
+	// the old files are deleted only after all are copied, so the failed
+	// rename leaves them as they were
+	for _, n := range names {
+		if err := f.copy(ctx, n, newName+strings.TrimPrefix(n, oldName)); err != nil {
+			return &fs.PathError{Op: "rename", Path: oldName, Err: err}
+		}
+	}
+
+	for _, n := range names {
+		if err := f.client.Delete(ctx, n); err != nil && !errors.Is(err, sfs.ErrNotFound) {
+			return &fs.PathError{Op: "rename", Path: oldName, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (f *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return f.stat(ctx, clean(name))
+}
+
+// stat returns the info of the file, or the directory if there is no file of
+// the name.
+func (f *fileSystem) stat(ctx context.Context, name string) (*fileInfo, error) {
+	if name == "" {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+
+	info, err := f.client.Stat(ctx, name)
+	if err == nil {
+		return &fileInfo{name: path.Base(name), file: info}, nil
+	}
+	if !errors.Is(err, sfs.ErrNotFound) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	infos, err := f.client.List(ctx, sfs.Filter{Prefix: name + "/"})
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	if len(infos) == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &fileInfo{name: path.Base(name), dir: true}, nil
+}
+
+// checkParent returns fs.ErrNotExist if the parent directory of the name
+// doesn't exist.
+func (f *fileSystem) checkParent(ctx context.Context, op, name string) error {
+	parent := path.Dir(name)
+	if parent == "." {
+		return nil
+	}
+
+	info, err := f.stat(ctx, parent)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return nil
+}
+
+// tree returns the stored names of the file or the directory: its files,
+// directory markers included.
+func (f *fileSystem) tree(ctx context.Context, name string) ([]string, error) {
+	infos, err := f.client.List(ctx, sfs.Filter{Prefix: name})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, info := range infos {
+		if info.Name == name || strings.HasPrefix(info.Name, name+"/") {
+			names = append(names, info.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// copy copies the file with its metadata, through the spool file.
+func (f *fileSystem) copy(ctx context.Context, src, dst string) error {
+	info, err := f.client.Stat(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	spool, err := os.CreateTemp(f.spoolDir, "dav-")
+	if err != nil {
+		return err
+	}
+	defer removeSpooled(spool)
+
+	if info.Size > 0 {
+		r, cls, _, err := f.client.Download(ctx, src)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(spool, r)
+		cls()
+		if err != nil {
+			return err
+		}
+	}
+
+	var opts []sfs.UploadOption
+	if info.ContentType != "" || info.Tags != nil {
+		opts = append(opts, sfs.WithMetadata(info.Metadata))
+	}
+
+	return f.client.Upload(ctx, dst, spool, info.Size, opts...)
+}
+
+// create opens the file for writing, it's uploaded on close. The file is
+// always truncated, as it's uploaded whole.
+func (f *fileSystem) create(ctx context.Context, name string, flag int) (webdav.File, error) {
+	if name == "" {
+		return nil, &fs.PathError{Op: "open", Path: "/", Err: fs.ErrInvalid}
+	}
+
+	info, err := f.stat(ctx, name)
+	switch {
+	case err == nil && info.IsDir():
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	case err == nil && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, err
+	}
+
+	if err := f.checkParent(ctx, "open", name); err != nil {
+		return nil, err
+	}
+
+	spool, err := os.CreateTemp(f.spoolDir, "dav-")
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &writeFile{fsys: f, ctx: ctx, name: name, spool: spool}, nil
+}
+
+func clean(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+func removeSpooled(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// fileInfo is the info of the file or the directory. It gives the ETag and
+// content type of files without downloading them.
+type fileInfo struct {
+	name string
+	dir  bool
+	file sfs.FileInfo
+}
+
+var (
+	_ webdav.ETager       = (*fileInfo)(nil)
+	_ webdav.ContentTyper = (*fileInfo)(nil)
+)
+
+func (i *fileInfo) Name() string { return i.name }
+
+func (i *fileInfo) Size() int64 { return i.file.Size }
+
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o755
+	}
+
+	return 0o644
+}
+
+func (i *fileInfo) ModTime() time.Time { return i.file.CreatedAt }
+
+func (i *fileInfo) IsDir() bool { return i.dir }
+
+func (i *fileInfo) Sys() any { return nil }
+
+// ETag returns the ETag of the stored file. Directories and the files being
+// written have none.
+func (i *fileInfo) ETag(context.Context) (string, error) {
+	if i.file.ETag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+
+	return `"` + i.file.ETag + `"`, nil
+}
+
+func (i *fileInfo) ContentType(context.Context) (string, error) {
+	if i.file.ContentType != "" {
+		return i.file.ContentType, nil
+	}
+
+	if byExt := mime.TypeByExtension(path.Ext(i.name)); byExt != "" {
+		return byExt, nil
+	}
+
+	return "application/octet-stream", nil
+}
+
+// readFile is the file open for reading.
+type readFile struct {
+	fs.File
+	info *fileInfo
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	return f.File.(io.Seeker).Seek(offset, whence)
+}
+
+func (f *readFile) Readdir(int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: f.info.name, Err: errors.New("not a directory")}
+}
+
+func (f *readFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *readFile) Write([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.info.name, Err: fs.ErrPermission}
+}
+
+// writeFile is the file open for writing, spooled until close.
+type writeFile struct {
+	fsys  *fileSystem
+	ctx   context.Context
+	name  string
+	spool *os.File
+}
+
+func (f *writeFile) Read(p []byte) (int, error) {
+	return f.spool.Read(p)
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	return f.spool.Write(p)
+}
+
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) {
+	return f.spool.Seek(offset, whence)
+}
+
+func (f *writeFile) Readdir(int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errors.New("not a directory")}
+}
+
+func (f *writeFile) Stat() (fs.FileInfo, error) {
+	stat, err := f.spool.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{name: path.Base(f.name), file: sfs.FileInfo{Name: f.name, Size: stat.Size(), CreatedAt: stat.ModTime()}}, nil
+}
+
+// Close uploads the written file.
+func (f *writeFile) Close() error {
+	defer removeSpooled(f.spool)
+
+	stat, err := f.spool.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := f.fsys.client.Upload(f.ctx, f.name, f.spool, stat.Size()); err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+// dir is the open directory, its entries are listed on the first read.
+type dir struct {
+	fsys    *fileSystem
+	ctx     context.Context
+	name    string
+	info    *fileInfo
+	entries []fs.FileInfo
+	listed  bool
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Write([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Seek(int64, int) (int64, error) {
+	return 0, &fs.PathError{Op: "seek", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error { return nil }
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Readdir(count int) ([]fs.FileInfo, error) {
+	if !d.listed {
+		entries, err := d.list()
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: err}
+		}
+		d.entries, d.listed = entries, true
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	count = min(count, len(d.entries))
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+// list returns the entries of the directory. The file shadows the directory
+// of the same name.
+func (d *dir) list() ([]fs.FileInfo, error) {
+	prefix := d.name + "/"
+	if d.name == "" {
+		prefix = ""
+	}
+
+	infos, err := d.fsys.client.List(d.ctx, sfs.Filter{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fs.FileInfo
+	index := make(map[string]int)
+	for _, info := range infos {
+		rest := strings.TrimPrefix(info.Name, prefix)
+		entry := &fileInfo{name: rest, file: info}
+		if child, _, ok := strings.Cut(rest, "/"); ok {
+			entry = &fileInfo{name: child, dir: true}
+		}
+
+		// the marker of this directory has no name
+		if entry.name == "" {
+			continue
+		}
+
+		if i, ok := index[entry.name]; ok {
+			if !entry.dir {
+				entries[i] = entry
+			}
+			continue
+		}
+		index[entry.name] = len(entries)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,113 @@
+package davgw_test
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/studio-b12/gowebdav"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	sfs "github.com/tymbaca/sfs/pkg/client"
+	"github.com/tymbaca/sfs/pkg/davgw"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestGateway(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 2)
+	replicas := testcluster.StartMeta(t, 1)
+	client := sfs.NewClient(addrs[0]+","+addrs[1], 512, sfs.WithMeta(meta.NewClient(replicas[0].Addr)))
+
+	handler, err := davgw.New(client, davgw.Config{Prefix: "/dav", SpoolDir: t.TempDir()})
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.Handle("/dav/", handler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	dav := gowebdav.NewClient(srv.URL+"/dav", "", "")
+
+	names := func(dir string) []string {
+		infos, err := dav.ReadDir(dir)
+		require.NoError(t, err)
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		return names
+	}
+
+	// MKCOL keeps the empty directory
+	require.NoError(t, dav.Mkdir("/docs", 0o755))
+	info, err := dav.Stat("/docs")
+	require.NoError(t, err)
+	require.True(t, info.IsDir())
+	require.Empty(t, names("/docs"))
+	require.Error(t, dav.Mkdir("/missing/docs", 0o755))
+
+	// PUT and GET
+	data := make([]byte, 3*512+100)
+	rand.Read(data)
+	require.NoError(t, dav.Write("/docs/report.pdf", data, 0o644))
+	got, err := dav.Read("/docs/report.pdf")
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	r, err := dav.ReadStreamRange("/docs/report.pdf", 600, 500)
+	require.NoError(t, err)
+	got, err = io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	require.Equal(t, data[600:1100], got)
+
+	// PROPFIND
+	info, err = dav.Stat("/docs/report.pdf")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), info.Size())
+	require.Equal(t, "application/pdf", info.(*gowebdav.File).ContentType())
+	stat, err := client.Stat(ctx, "docs/report.pdf")
+	require.NoError(t, err)
+	require.Equal(t, `"`+stat.ETag+`"`, info.(*gowebdav.File).ETag())
+
+	require.NoError(t, dav.Write("/notes.txt", []byte("notes"), 0o644))
+	require.Equal(t, []string{"docs", "notes.txt"}, names("/"))
+	require.Equal(t, []string{"report.pdf"}, names("/docs"))
+
+	// MOVE of the file and the directory
+	require.NoError(t, dav.Rename("/notes.txt", "/docs/notes.txt", false))
+	require.Equal(t, []string{"docs"}, names("/"))
+	require.NoError(t, dav.Rename("/docs", "/archive", false))
+	require.Equal(t, []string{"archive"}, names("/"))
+	require.Equal(t, []string{"notes.txt", "report.pdf"}, names("/archive"))
+	got, err = dav.Read("/archive/report.pdf")
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	// DELETE
+	require.NoError(t, dav.Remove("/archive/notes.txt"))
+	_, err = dav.Stat("/archive/notes.txt")
+	require.True(t, gowebdav.IsErrNotFound(err), err)
+	require.NoError(t, dav.RemoveAll("/archive"))
+	require.Empty(t, names("/"))
+
+	files, err := client.List(ctx, sfs.Filter{})
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestGatewayWithoutMeta(t *testing.T) {
+	addrs := testcluster.Start(t, 2)
+	client := sfs.NewClient(addrs[0]+","+addrs[1], 512)
+
+	// empty directories can't be kept
+	_, err := davgw.New(client, davgw.Config{SpoolDir: t.TempDir()})
+	require.ErrorIs(t, err, davgw.ErrNoMeta)
+	_, err = davgw.NewFileSystem(client, t.TempDir())
+	require.ErrorIs(t, err, davgw.ErrNoMeta)
+}
@@ -0,0 +1,228 @@
+// Package httpgw serves the files of the cluster over plain HTTP, for browsers
+// and curl:
+//   - GET /files/<name> - download the file, with Range and If-None-Match
+//   - PUT /files/<name> - upload the request body
+//   - DELETE /files/<name> - delete the file
+//   - GET /files/?prefix= - list the files as JSON
+package httpgw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/httprange"
+	"github.com/tymbaca/sfs/internal/logger"
+	sfs "github.com/tymbaca/sfs/pkg/client"
+)
+
+// File is the entry of the listing.
+type File struct {
+	Name        string            `json:"name"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"content_type,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	// CreatedAt is nil without the metadata service.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ETag      string     `json:"etag"`
+	Version   uint64     `json:"version,omitempty"`
+}
+
+type handler struct {
+	client *sfs.Client
+}
+
+// New returns the handler of the files of the client bucket. It may be
+// mounted under any path with [http.StripPrefix].
+func New(client *sfs.Client) http.Handler {
+	h := &handler{client: client}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /files/{name...}", h.handleGet)
+	mux.HandleFunc("PUT /files/{name...}", h.handlePut)
+	mux.HandleFunc("DELETE /files/{name...}", h.handleDelete)
+	return mux
+}
+
+func (h *handler) handleGet(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "" {
+		h.handleList(w, r)
+		return
+	}
+
+	// the headers and the body are of the same upload
+	obj, err := h.client.Open(r.Context(), name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	info := obj.FileInfo
+
+	etag := `"` + info.ETag + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", contentType(info))
+	if !info.CreatedAt.IsZero() {
+		w.Header().Set("Last-Modified", info.CreatedAt.UTC().Format(http.TimeFormat))
+	}
+
+	if matchETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	status := http.StatusOK
+	offset, length := int64(0), info.Size
+	// the range is of the other version if If-Range doesn't match, so the
+	// whole file is sent
+	header := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		header = ""
+	}
+	if header != "" {
+		var partial bool
+		offset, length, partial, err = httprange.Parse(header, info.Size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if partial {
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, info.Size))
+		}
+	}
+
+	if r.Method == http.MethodHead || length == 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(status)
+		return
+	}
+
+	body, cls, length, err := obj.DownloadRange(r.Context(), offset, length)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer cls()
+
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+	if _, err := io.Copy(w, body); err != nil {
+		logger.Logf("http gateway: can't send '%s': %s", name, err)
+	}
+}
+
+func (h *handler) handleList(w http.ResponseWriter, r *http.Request) {
+	infos, err := h.client.List(r.Context(), sfs.Filter{Prefix: r.URL.Query().Get("prefix")})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	files := make([]File, 0, len(infos))
+	for _, info := range infos {
+		file := File{
+			Name:        info.Name,
+			Size:        info.Size,
+			ContentType: info.ContentType,
+			Tags:        info.Tags,
+			ETag:        info.ETag,
+			Version:     info.Version,
+		}
+		if !info.CreatedAt.IsZero() {
+			file.CreatedAt = &info.CreatedAt
+		}
+		files = append(files, file)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(files); err != nil {
+		logger.Logf("http gateway: can't write the listing: %s", err)
+	}
+}
+
+func (h *handler) handlePut(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == "" {
+		http.Error(w, "file name is empty", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.client.UploadStream(r.Context(), name, r.Body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	info, err := h.client.Stat(r.Context(), name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+info.ETag+`"`)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *handler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if err := h.client.Delete(r.Context(), r.PathValue("name")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// contentType returns the recorded type of the file, or the one of its
+// extension.
+func contentType(info sfs.FileInfo) string {
+	if info.ContentType != "" {
+		return info.ContentType
+	}
+
+	if byExt := mime.TypeByExtension(path.Ext(info.Name)); byExt != "" {
+		return byExt
+	}
+
+	return "application/octet-stream"
+}
+
+// matchETag reports whether If-None-Match header matches etag. Weak tags are
+// compared as strong ones, as the ETag is of the content.
+func matchETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, sfs.ErrNotFound), errors.Is(err, common.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, sfs.ErrInvalidRange):
+		status = http.StatusRequestedRangeNotSatisfiable
+	case errors.Is(err, common.ErrNoSpace):
+		status = http.StatusInsufficientStorage
+	case errors.Is(err, common.ErrBusy):
+		status = http.StatusServiceUnavailable
+	default:
+		logger.Logf("http gateway: %s %s: %s", r.Method, r.URL.Path, err)
+	}
+
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,114 @@
+package httpgw_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	sfs "github.com/tymbaca/sfs/pkg/client"
+	"github.com/tymbaca/sfs/pkg/httpgw"
+)
+
+func TestGateway(t *testing.T) {
+	addrs := testcluster.Start(t, 2)
+	client := sfs.NewClient(addrs[0]+","+addrs[1], 512)
+
+	// mounted under the prefix of the existing service
+	mux := http.NewServeMux()
+	mux.Handle("/sfs/", http.StripPrefix("/sfs", httpgw.New(client)))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	do := func(method, path string, body []byte, headers ...string) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, srv.URL+"/sfs"+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, got
+	}
+
+	data := make([]byte, 4*512+100)
+	rand.Read(data)
+
+	resp, _ := do(http.MethodPut, "/files/docs/report.pdf", data)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+
+	resp, got := do(http.MethodGet, "/files/docs/report.pdf", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, data, got)
+	require.Equal(t, int64(len(data)), resp.ContentLength)
+	require.Equal(t, etag, resp.Header.Get("ETag"))
+	require.Equal(t, "application/pdf", resp.Header.Get("Content-Type"))
+
+	resp, got = do(http.MethodGet, "/files/docs/report.pdf", nil, "Range", "bytes=1000-1999")
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, data[1000:2000], got)
+	require.Equal(t, "bytes 1000-1999/2148", resp.Header.Get("Content-Range"))
+
+	resp, got = do(http.MethodGet, "/files/docs/report.pdf", nil, "Range", "bytes=1000-1999", "If-Range", `"other"`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, data, got)
+
+	resp, _ = do(http.MethodGet, "/files/docs/report.pdf", nil, "Range", "bytes=5000-")
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+
+	resp, got = do(http.MethodGet, "/files/docs/report.pdf", nil, "If-None-Match", etag)
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+	require.Empty(t, got)
+
+	resp, got = do(http.MethodHead, "/files/docs/report.pdf", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, int64(len(data)), resp.ContentLength)
+	require.Empty(t, got)
+
+	resp, _ = do(http.MethodPut, "/files/notes.txt", []byte("notes"))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// empty file is stored too
+	resp, _ = do(http.MethodPut, "/files/empty", nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, got = do(http.MethodGet, "/files/empty", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, got)
+	require.Equal(t, int64(0), resp.ContentLength)
+	resp, _ = do(http.MethodDelete, "/files/empty", nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	list := func(prefix string) []string {
+		resp, got := do(http.MethodGet, "/files/?prefix="+prefix, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var files []httpgw.File
+		require.NoError(t, json.Unmarshal(got, &files))
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+	require.Equal(t, []string{"docs/report.pdf", "notes.txt"}, list(""))
+	require.Equal(t, []string{"docs/report.pdf"}, list("docs/"))
+
+	resp, _ = do(http.MethodDelete, "/files/notes.txt", nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do(http.MethodDelete, "/files/notes.txt", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = do(http.MethodGet, "/files/notes.txt", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,256 @@
+package meta
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LeaderHeader is set by the follower in [http.StatusServiceUnavailable]
+// response. It contains the API address of the leader, if it's known.
+const LeaderHeader = "X-Sfs-Meta-Leader"
+
+// retryTimeout limits the time client searches for the leader, e.g. while
+// the new one is elected.
+const retryTimeout = 10 * time.Second
+
+// Client talks to the metadata service replicas. Requests go to the leader,
+// the client finds it by itself.
+type Client struct {
+	addrs []string
+	http  *http.Client
+
+	mu     sync.Mutex
+	leader string
+}
+
+// NewClient creates the client for comma-separated API addresses of the
+// metadata service replicas.
+func NewClient(addrs string) *Client {
+	return &Client{
+		addrs: strings.Split(addrs, ","),
+		http:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) Put(ctx context.Context, file File) error {
+	body, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodPut, filePath(file.Name), body, nil)
+}
+
+func (c *Client) Get(ctx context.Context, name string) (File, error) {
+	var file File
+	err := c.do(ctx, http.MethodGet, filePath(name), nil, &file)
+	return file, err
+}
+
+// GetVersion returns the manifest of the version of the file, current or
+// replaced.
+func (c *Client) GetVersion(ctx context.Context, name string, version uint64) (File, error) {
+	var file File
+	err := c.do(ctx, http.MethodGet, filePath(name)+"?version="+strconv.FormatUint(version, 10), nil, &file)
+	return file, err
+}
+
+// Delete deletes the file manifest. The versioned file becomes the replaced
+// version, so its chunks are deleted when the version is pruned.
+func (c *Client) Delete(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, filePath(name), nil, nil)
+}
+
+// List returns the files with names starting with prefix, sorted by name.
+func (c *Client) List(ctx context.Context, prefix string) ([]File, error) {
+	var files []File
+	err := c.do(ctx, http.MethodGet, "/files?prefix="+url.QueryEscape(prefix), nil, &files)
+	return files, err
+}
+
+// Versions returns the replaced versions of the files with names starting
+// with prefix, sorted by name, the oldest first.
+func (c *Client) Versions(ctx context.Context, prefix string) ([]Version, error) {
+	var versions []Version
+	err := c.do(ctx, http.MethodGet, "/versions?prefix="+url.QueryEscape(prefix), nil, &versions)
+	return versions, err
+}
+
+// DeleteVersion forgets the replaced version of the file.
+func (c *Client) DeleteVersion(ctx context.Context, name string, version uint64) error {
+	return c.do(ctx, http.MethodDelete, "/versions/"+strconv.FormatUint(version, 10)+"/"+url.PathEscape(name), nil, nil)
+}
+
+// Garbage returns the deduplicated chunks no file references, sorted by hash.
+func (c *Client) Garbage(ctx context.Context) ([]Garbage, error) {
+	var garbage []Garbage
+	err := c.do(ctx, http.MethodGet, "/garbage", nil, &garbage)
+	return garbage, err
+}
+
+// PinChunks keeps the deduplicated chunks from being collected for ttl, so
+// the upload may skip the ones the nodes have. The manifest referencing the
+// chunk which is neither referenced, garbage nor pinned is rejected with
+// [ErrCollected]. Returns [ErrCollected] if some chunk is being collected.
+func (c *Client) PinChunks(ctx context.Context, hashes []string, ttl time.Duration) error {
+	body, err := json.Marshal(PinRequest{Hashes: hashes, TTL: ttl})
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodPost, "/pins", body, nil)
+}
+
+// ClaimGarbage marks the garbage chunk as being collected for lease, so it's
+// not pinned until it's deleted from the nodes and forgotten with
+// [Client.DeleteGarbage]. The chunk is garbage again after the lease. Returns
+// [ErrNotFound] if it's not garbage or pinned, e.g. some file references it
+// again.
+func (c *Client) ClaimGarbage(ctx context.Context, hash string, lease time.Duration) error {
+	return c.do(ctx, http.MethodPost, "/garbage/"+url.PathEscape(hash)+"/claim?lease="+lease.String(), nil, nil)
+}
+
+// DeleteGarbage forgets the claimed chunk after it's collected. Returns
+// [ErrNotFound] if it's not claimed.
+func (c *Client) DeleteGarbage(ctx context.Context, hash string) error {
+	return c.do(ctx, http.MethodDelete, "/garbage/"+url.PathEscape(hash), nil, nil)
+}
+
+func filePath(name string) string {
+	return "/files/" + url.PathEscape(name)
+}
+
+// do sends the request to the leader, retrying on other replicas while the
+// leader is unknown or unavailable.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, retryTimeout)
+	defer cancel()
+
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		for _, addr := range c.candidates() {
+			res := c.doOnce(ctx, addr, method, path, body, out)
+			if !res.retry {
+				c.setLeader(addr)
+				return res.err
+			}
+
+			lastErr = res.err
+			if res.leader != "" {
+				c.setLeader(res.leader)
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("can't reach metadata leader: %w", lastErr)
+		case <-time.After(time.Duration(min(attempt+1, 10)) * 50 * time.Millisecond):
+		}
+	}
+}
+
+// candidates returns the addresses to try, known leader first.
+func (c *Client) candidates() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.leader == "" {
+		return c.addrs
+	}
+
+	res := []string{c.leader}
+	for _, addr := range c.addrs {
+		if addr != c.leader {
+			res = append(res, addr)
+		}
+	}
+
+	return res
+}
+
+func (c *Client) setLeader(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.leader = addr
+}
+
+type result struct {
+	err error
+	// retry is true if the request must be repeated on another replica
+	retry bool
+	// leader is the hint from the follower
+	leader string
+}
+
+// doOnce makes a single request to the replica.
+func (c *Client) doOnce(ctx context.Context, addr, method, path string, body []byte, out any) result {
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+addr+path, bytes.NewReader(body))
+	if err != nil {
+		return result{err: err}
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return result{err: err, retry: true}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if out == nil {
+			return result{}
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return result{err: fmt.Errorf("can't decode response: %w", err)}
+		}
+		return result{}
+
+	case http.StatusNoContent:
+		return result{}
+
+	case http.StatusNotFound:
+		return result{err: ErrNotFound}
+
+	case http.StatusConflict:
+		return result{err: ErrCollected}
+
+	case http.StatusServiceUnavailable:
+		return result{
+			err:    fmt.Errorf("'%s' is not the leader", addr),
+			retry:  true,
+			leader: resp.Header.Get(LeaderHeader),
+		}
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return result{err: fmt.Errorf("got error from metadata service, status %d: %s", resp.StatusCode, msg)}
+}
+
+// JoinRequest adds the replica to the metadata service cluster.
+type JoinRequest struct {
+	// ID is the API address of the replica.
+	ID       string `json:"id"`
+	RaftAddr string `json:"raft_addr"`
+}
+
+// Join adds the new replica to the metadata service cluster.
+func (c *Client) Join(ctx context.Context, id, raftAddr string) error {
+	body, err := json.Marshal(JoinRequest{ID: id, RaftAddr: raftAddr})
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodPost, "/join", body, nil)
+}
@@ -0,0 +1,65 @@
+package meta_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestLeaderLoss(t *testing.T) {
+	ctx := context.Background()
+
+	replicas := testcluster.StartMeta(t, 3)
+	addrs := make([]string, 0, len(replicas))
+	for _, r := range replicas {
+		addrs = append(addrs, r.Addr)
+	}
+
+	client := meta.NewClient(strings.Join(addrs, ","))
+
+	file := meta.File{
+		Name: "dir/file",
+		Size: 10,
+		Chunks: []meta.Chunk{
+			{ID: 0, Size: 6, CRC: 1, Nodes: []string{"a", "b"}},
+			{ID: 1, Size: 4, CRC: 2, Nodes: []string{"b", "c"}},
+		},
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	require.NoError(t, client.Put(ctx, file))
+
+	got, err := client.Get(ctx, file.Name)
+	require.NoError(t, err)
+	require.Equal(t, file, got)
+
+	stopped := 0
+	for _, r := range replicas {
+		if r.Node.IsLeader() {
+			r.Stop()
+			stopped++
+		}
+	}
+	require.Equal(t, 1, stopped)
+
+	// the rest of the replicas elect the new leader and keep the catalog
+	got, err = client.Get(ctx, file.Name)
+	require.NoError(t, err)
+	require.Equal(t, file, got)
+
+	other := meta.File{Name: "other", Size: 0, Chunks: []meta.Chunk{}, CreatedAt: file.CreatedAt}
+	require.NoError(t, client.Put(ctx, other))
+
+	list, err := client.List(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []meta.File{file, other}, list)
+
+	require.NoError(t, client.Delete(ctx, file.Name))
+	_, err = client.Get(ctx, file.Name)
+	require.ErrorIs(t, err, meta.ErrNotFound)
+	require.ErrorIs(t, client.Delete(ctx, file.Name), meta.ErrNotFound)
+}
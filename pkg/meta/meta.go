@@ -0,0 +1,91 @@
+// Package meta is the client of the metadata service (see cmd/meta), which
+// keeps the authoritative catalog of stored files and their chunk locations.
+package meta
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("file not found in catalog")
+
+// ErrCollected is returned when the deduplicated chunk is being collected or
+// was collected, so the upload must store it again.
+var ErrCollected = errors.New("deduplicated chunk is collected")
+
+// File is the manifest of the stored file.
+type File struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Chunks    []Chunk   `json:"chunks"`
+	CreatedAt time.Time `json:"created_at"`
+	// Erasure is the erasure coding scheme of the file, nil for replicated
+	// files.
+	Erasure *Erasure `json:"erasure,omitempty"`
+	// Encryption is the encryption of the file chunks, nil for plain files.
+	Encryption *Encryption `json:"encryption,omitempty"`
+	// Version is the id of the versioned upload, 0 for unversioned files.
+	// Replaced versions are kept until pruned, see [Client.Versions].
+	Version uint64 `json:"version,omitempty"`
+	// Blob is the name the chunks are stored under on the nodes, if it's not
+	// the file name. Each version of the file has its own.
+	Blob string `json:"blob,omitempty"`
+	// ContentType is the MIME type of the file, empty if unknown.
+	ContentType string `json:"content_type,omitempty"`
+	// Tags are the user key-value pairs of the file.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Version is the replaced version of the file.
+type Version struct {
+	File
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// Erasure is the Reed-Solomon scheme: each stripe of Data consecutive data
+// chunks has Parity parity chunks. The last stripe may have less data chunks,
+// the missing ones are considered zero.
+type Erasure struct {
+	Data   int `json:"data"`
+	Parity int `json:"parity"`
+}
+
+// Encryption is the client-side encryption of the file. Chunks are sealed
+// with the random data key, which is stored wrapped by the key-encryption key
+// of the user. Sizes and CRCs of the chunks are of the plain data.
+type Encryption struct {
+	Algorithm  string `json:"algorithm"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+type Chunk struct {
+	ID   uint64 `json:"id"`
+	Size uint64 `json:"size"`
+	CRC  uint32 `json:"crc"` // CRC-32C
+	// Nodes are the addresses of the nodes holding the chunk.
+	Nodes []string `json:"nodes"`
+	// Parity is true for the parity chunks of erasure coded file. They follow
+	// the data chunks, ordered by stripe.
+	Parity bool `json:"parity,omitempty"`
+	// Hash is hex SHA-256 of the deduplicated chunk, which is stored by its
+	// content and may be shared by several files. Empty for chunks stored
+	// under the file name.
+	Hash string `json:"hash,omitempty"`
+}
+
+// Garbage is the deduplicated chunk no file references anymore. It's kept
+// until collected, see Client.ClaimGarbage.
+type Garbage struct {
+	Hash  string   `json:"hash"`
+	Size  uint64   `json:"size"`
+	Nodes []string `json:"nodes"`
+	// Since is when the last reference was dropped.
+	Since time.Time `json:"since"`
+}
+
+// PinRequest pins the deduplicated chunks of the upload, see
+// [Client.PinChunks].
+type PinRequest struct {
+	Hashes []string      `json:"hashes"`
+	TTL    time.Duration `json:"ttl"`
+}
@@ -0,0 +1,60 @@
+// Package qos defines the priority classes of node requests. The class is
+// carried in the context, so the requests made with it are scheduled by the
+// nodes accordingly.
+package qos
+
+import (
+	"context"
+	"fmt"
+)
+
+type Class uint64
+
+const (
+	// Interactive is the default class, e.g. of user reads and writes.
+	Interactive Class = iota
+	// Batch is for bulk jobs which must finish, but not right away.
+	Batch
+	// Background is for maintenance, e.g. repair and rebalance.
+	Background
+)
+
+// Classes is the count of classes.
+const Classes = 3
+
+func (c Class) String() string {
+	switch c {
+	case Interactive:
+		return "interactive"
+	case Batch:
+		return "batch"
+	case Background:
+		return "background"
+	}
+
+	return fmt.Sprintf("class(%d)", uint64(c))
+}
+
+// ParseClass parses the class name as returned by [Class.String].
+func ParseClass(s string) (Class, error) {
+	for c := range Class(Classes) {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown class '%s'", s)
+}
+
+type classKey struct{}
+
+// WithClass returns the context the requests of which have the class.
+func WithClass(ctx context.Context, c Class) context.Context {
+	return context.WithValue(ctx, classKey{}, c)
+}
+
+// ClassFrom returns the class of ctx, [Interactive] if it's not set.
+func ClassFrom(ctx context.Context) Class {
+	c, _ := ctx.Value(classKey{}).(Class)
+	return c
+}
@@ -0,0 +1,408 @@
+package rebalance
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/placement"
+	"github.com/tymbaca/sfs/internal/ratelimit"
+	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/qos"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+// Move describes the chunk that must be moved from one node to another.
+type Move struct {
+	// Bucket is the bucket of the file, empty for the default one.
+	Bucket string `json:"bucket,omitempty"`
+	Name   string `json:"name"`
+	ID     uint64 `json:"id"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// Plan computes the moves needed to go from oldAddrs cluster layout to
+// newAddrs one, keeping replicas copies of each chunk. It asks every node of
+// both layouts what chunks it actually holds, so chunks that are already in
+// place are not moved.
+func Plan(ctx context.Context, oldAddrs, newAddrs []string, replicas int) ([]Move, error) {
+	if len(newAddrs) == 0 {
+		return nil, errors.New("new layout has no nodes")
+	}
+
+	nodes := union(oldAddrs, newAddrs)
+
+	var mu sync.Mutex
+	holders := make(map[chunkKey][]string)
+	var g errgroup.Group
+
+	for _, addr := range nodes {
+		addr := addr
+		g.Go(func() error {
+			inv, err := inventory(ctx, addr)
+			if err != nil {
+				return fmt.Errorf("can't get inventory of '%s': %w", addr, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, key := range inv {
+				holders[key] = append(holders[key], addr)
+			}
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var moves []Move
+	for key, addrs := range holders {
+		targets := placement.Nodes(newAddrs, key.name, key.id, replicas)
+
+		// targets which don't have the chunk yet
+		var missing []string
+		for _, to := range targets {
+			if !slices.Contains(addrs, to) {
+				missing = append(missing, to)
+			}
+		}
+
+		for _, from := range addrs {
+			if slices.Contains(targets, from) {
+				continue
+			}
+
+			// extra copy is still moved (not just deleted), so the
+			// target is verified before the source is gone
+			to := targets[0]
+			if len(missing) > 0 {
+				to, missing = missing[0], missing[1:]
+			}
+
+			moves = append(moves, Move{Bucket: key.bucket, Name: key.name, ID: key.id, From: from, To: to})
+		}
+	}
+
+	slices.SortFunc(moves, func(a, b Move) int {
+		return cmp.Or(
+			strings.Compare(a.Bucket, b.Bucket),
+			strings.Compare(a.Name, b.Name),
+			cmp.Compare(a.ID, b.ID),
+			strings.Compare(a.From, b.From),
+		)
+	})
+
+	return moves, nil
+}
+
+type chunkKey struct {
+	bucket string
+	name   string
+	id     uint64
+}
+
+// inventory returns the chunks of every bucket the node holds.
+func inventory(ctx context.Context, addr string) ([]chunkKey, error) {
+	trans := transport.NewTCPTransport(addr)
+	buckets, err := trans.ListBuckets(ctx)
+	trans.Close()
+	if err != nil {
+		return nil, fmt.Errorf("can't list buckets: %w", err)
+	}
+
+	// the default bucket first
+	names := []string{""}
+	for _, b := range buckets {
+		names = append(names, b.Name)
+	}
+
+	var inv []chunkKey
+	for _, bucket := range names {
+		ctx := common.WithBucket(ctx, bucket)
+
+		trans := transport.NewTCPTransport(addr)
+		files, err := trans.ListFiles(ctx)
+		trans.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range files {
+			trans := transport.NewTCPTransport(addr)
+			ids, err := trans.ListIDs(ctx, name)
+			trans.Close()
+			if err != nil {
+				return nil, err
+			}
+
+			for _, id := range ids {
+				inv = append(inv, chunkKey{bucket: bucket, name: name, id: id})
+			}
+		}
+	}
+
+	return inv, nil
+}
+
+func union(a, b []string) []string {
+	res := make([]string, 0, len(a)+len(b))
+	for _, addr := range append(slices.Clone(a), b...) {
+		if !slices.Contains(res, addr) {
+			res = append(res, addr)
+		}
+	}
+
+	return res
+}
+
+type Config struct {
+	// Journal is the path to the file where completed moves are recorded.
+	// If the rebalance is interrupted, the next run with the same journal
+	// skips them. Empty means no journal.
+	Journal string
+	// BytesPerSec limits the total copy rate. Non-positive means unlimited.
+	BytesPerSec int64
+	// Parallel is the count of moves executed at the same time. Defaults to 1.
+	Parallel int
+}
+
+type Stats struct {
+	Moved   int
+	Skipped int
+	Bytes   int64
+}
+
+type Rebalancer struct {
+	cfg     Config
+	limiter *rate.Limiter
+
+	mu      sync.Mutex
+	journal *os.File
+	done    map[Move]struct{}
+}
+
+func New(cfg Config) *Rebalancer {
+	if cfg.Parallel < 1 {
+		cfg.Parallel = 1
+	}
+
+	return &Rebalancer{
+		cfg:     cfg,
+		limiter: ratelimit.NewLimiter(cfg.BytesPerSec),
+		done:    make(map[Move]struct{}),
+	}
+}
+
+// Run executes the moves. Each chunk is copied to the target node, verified
+// there and only then deleted from the source node. Run stops on the first
+// error, already completed moves are kept in the journal. Its requests have
+// the background class.
+func (r *Rebalancer) Run(ctx context.Context, moves []Move) (Stats, error) {
+	ctx = qos.WithClass(ctx, qos.Background)
+
+	if err := r.openJournal(); err != nil {
+		return Stats{}, err
+	}
+	defer r.closeJournal()
+
+	var stats Stats
+	var statsMu sync.Mutex
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(r.cfg.Parallel)
+
+	for _, m := range moves {
+		m := m
+		if r.isDone(m) {
+			stats.Skipped++
+			continue
+		}
+
+		g.Go(func() error {
+			n, err := r.move(gctx, m)
+			if err != nil {
+				return fmt.Errorf("can't move '%s' chunk %d from '%s' to '%s': %w", m.Name, m.ID, m.From, m.To, err)
+			}
+
+			if err := r.markDone(m); err != nil {
+				return err
+			}
+
+			statsMu.Lock()
+			stats.Moved++
+			stats.Bytes += n
+			statsMu.Unlock()
+
+			logger.Logf("moved '%s' chunk %d from '%s' to '%s', %d bytes", m.Name, m.ID, m.From, m.To, n)
+			return nil
+		})
+	}
+
+	err := g.Wait()
+	return stats, err
+}
+
+func (r *Rebalancer) move(ctx context.Context, m Move) (int64, error) {
+	ctx = common.WithBucket(ctx, m.Bucket)
+
+	srcTrans := transport.NewTCPTransport(m.From)
+	defer srcTrans.Close()
+
+	// compressed chunk is moved as is
+	src, err := srcTrans.RecvChunk(ctx, m.Name, m.ID, chunks.Codecs...)
+	if errors.Is(err, common.ErrNotFound) {
+		// Previous run could copy and delete the chunk, but didn't
+		// manage to write the journal. Nothing to do if target has it.
+		if _, _, err := r.checksum(ctx, m.To, m.Name, m.ID); err != nil {
+			return 0, fmt.Errorf("chunk is neither on source nor on target: %w", err)
+		}
+
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("can't receive chunk from source: %w", err)
+	}
+
+	hash := sha256.New()
+	src.Body = ratelimit.NewReader(ctx, io.TeeReader(src.Body, hash), r.limiter)
+
+	dstTrans := transport.NewTCPTransport(m.To)
+	defer dstTrans.Close()
+
+	if err := dstTrans.SendChunk(ctx, src); err != nil {
+		return 0, fmt.Errorf("can't send chunk to target: %w", err)
+	}
+
+	size, sum, err := r.checksum(ctx, m.To, m.Name, m.ID)
+	if err != nil {
+		return 0, fmt.Errorf("can't verify chunk on target: %w", err)
+	}
+
+	if size != src.Size || string(sum) != string(hash.Sum(nil)) {
+		return 0, fmt.Errorf("chunk on target differs from source: size %d, expected %d", size, src.Size)
+	}
+
+	delTrans := transport.NewTCPTransport(m.From)
+	defer delTrans.Close()
+
+	if err := delTrans.DeleteChunk(ctx, m.Name, m.ID); err != nil && !errors.Is(err, common.ErrNotFound) {
+		return 0, fmt.Errorf("can't delete chunk from source: %w", err)
+	}
+
+	return int64(src.Size), nil
+}
+
+// checksum downloads the chunk from addr and returns its size and sha256 of
+// the body, compressed if it's stored so.
+func (r *Rebalancer) checksum(ctx context.Context, addr, name string, id uint64) (uint64, []byte, error) {
+	trans := transport.NewTCPTransport(addr)
+	defer trans.Close()
+
+	chk, err := trans.RecvChunk(ctx, name, id, chunks.Codecs...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return hashChunk(chk)
+}
+
+func hashChunk(chk chunks.Chunk) (uint64, []byte, error) {
+	hash := sha256.New()
+	n, err := io.Copy(hash, chk.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	size := chk.Size
+	if chk.Codec != chunks.CodecNone {
+		size = chk.BodySize
+	}
+
+	if uint64(n) != size {
+		return 0, nil, fmt.Errorf("chunk is truncated: got %d bytes, expected %d", n, size)
+	}
+
+	return chk.Size, hash.Sum(nil), nil
+}
+
+func (r *Rebalancer) openJournal() error {
+	if r.cfg.Journal == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(r.cfg.Journal, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("can't open journal: %w", err)
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m Move
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			// the last line can be torn by the crash
+			logger.Logf("skipping invalid journal line: %s", scanner.Text())
+			continue
+		}
+
+		r.done[m] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return fmt.Errorf("can't read journal: %w", err)
+	}
+
+	r.journal = f
+	return nil
+}
+
+func (r *Rebalancer) closeJournal() {
+	if r.journal != nil {
+		r.journal.Close()
+		r.journal = nil
+	}
+}
+
+func (r *Rebalancer) isDone(m Move) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.done[m]
+	return ok
+}
+
+func (r *Rebalancer) markDone(m Move) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.done[m] = struct{}{}
+	if r.journal == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("can't write journal: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package rebalance
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	sfs "github.com/tymbaca/sfs/pkg/client"
+	"github.com/tymbaca/sfs/pkg/meta"
+)
+
+func TestRebalance(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 4)
+	oldAddrs, newAddrs := addrs[:3], addrs[1:]
+
+	data := make([]byte, 100*1024)
+	rand.Read(data)
+
+	client := sfs.NewClient(strings.Join(oldAddrs, ","), 1024)
+	require.NoError(t, client.Upload(ctx, "dir/file", bytes.NewReader(data), int64(len(data))))
+
+	moves, err := Plan(ctx, oldAddrs, newAddrs, 1)
+	require.NoError(t, err)
+	require.NotEmpty(t, moves)
+
+	journal := filepath.Join(t.TempDir(), "journal")
+
+	// interrupted run must be resumed by the next one
+	stats, err := New(Config{Journal: journal}).Run(ctx, moves[:len(moves)/2])
+	require.NoError(t, err)
+	require.Equal(t, len(moves)/2, stats.Moved)
+
+	stats, err = New(Config{Journal: journal, Parallel: 4}).Run(ctx, moves)
+	require.NoError(t, err)
+	require.Equal(t, len(moves)/2, stats.Skipped)
+	require.Equal(t, len(moves)-len(moves)/2, stats.Moved)
+
+	// removed node must be empty and nothing is left to move
+	moves, err = Plan(ctx, oldAddrs, newAddrs, 1)
+	require.NoError(t, err)
+	require.Empty(t, moves)
+
+	inv, err := inventory(ctx, addrs[0])
+	require.NoError(t, err)
+	require.Empty(t, inv)
+
+	// new layout serves the whole file
+	client = sfs.NewClient(strings.Join(newAddrs, ","), 1024)
+	r, cls, size, err := client.Download(ctx, "dir/file")
+	require.NoError(t, err)
+	defer cls()
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+	require.Equal(t, data, got)
+}
+
+func TestRebalanceManifest(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 4)
+	oldAddrs := addrs[:3]
+	replicas := testcluster.StartMeta(t, 1)
+	metaClient := meta.NewClient(replicas[0].Addr)
+
+	data := make([]byte, 20*1024)
+	rand.Read(data)
+
+	plain := sfs.NewClient(strings.Join(oldAddrs, ","), 1024, sfs.WithMeta(metaClient))
+	require.NoError(t, plain.Upload(ctx, "plain", bytes.NewReader(data), int64(len(data))))
+	erasure := sfs.NewClient(strings.Join(oldAddrs, ","), 1024, sfs.WithMeta(metaClient), sfs.WithErasureCoding(2, 1))
+	require.NoError(t, erasure.Upload(ctx, "erasure", bytes.NewReader(data), int64(len(data))))
+
+	moves, err := Plan(ctx, oldAddrs, addrs, 1)
+	require.NoError(t, err)
+	require.NotEmpty(t, moves)
+
+	_, err = New(Config{}).Run(ctx, moves)
+	require.NoError(t, err)
+
+	// manifests still list the old nodes of the moved chunks
+	client := sfs.NewClient(strings.Join(addrs, ","), 1024, sfs.WithMeta(metaClient))
+	for _, name := range []string{"plain", "erasure"} {
+		r, cls, _, err := client.Download(ctx, name)
+		require.NoError(t, err, name)
+
+		got, err := io.ReadAll(r)
+		require.NoError(t, err, name)
+		require.NoError(t, cls())
+		require.Equal(t, data, got, name)
+	}
+}
+
+func TestRebalanceBuckets(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 4)
+	oldAddrs, newAddrs := addrs[:3], addrs[1:]
+
+	var csv strings.Builder
+	for i := range 5000 {
+		fmt.Fprintf(&csv, "%d,some,compressible,line\n", i)
+	}
+	data := []byte(csv.String())
+
+	client := sfs.NewClient(strings.Join(addrs, ","), 1024, sfs.WithCompression(sfs.Zstd))
+	require.NoError(t, client.CreateBucket(ctx, sfs.Bucket{Name: "team"}))
+
+	old := sfs.NewClient(strings.Join(oldAddrs, ","), 1024, sfs.WithCompression(sfs.Zstd))
+	require.NoError(t, old.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+	require.NoError(t, old.Bucket("team").Upload(ctx, "file", bytes.NewReader(data[1:]), int64(len(data)-1)))
+
+	moves, err := Plan(ctx, oldAddrs, newAddrs, 1)
+	require.NoError(t, err)
+	require.NotEmpty(t, moves)
+
+	_, err = New(Config{}).Run(ctx, moves)
+	require.NoError(t, err)
+
+	// removed node is empty in every bucket
+	inv, err := inventory(ctx, addrs[0])
+	require.NoError(t, err)
+	require.Empty(t, inv)
+
+	client = sfs.NewClient(strings.Join(newAddrs, ","), 1024)
+	for bucket, want := range map[string][]byte{"": data, "team": data[1:]} {
+		r, cls, _, err := client.Bucket(bucket).Download(ctx, "file")
+		require.NoError(t, err, bucket)
+
+		got, err := io.ReadAll(r)
+		require.NoError(t, err, bucket)
+		require.NoError(t, cls())
+		require.Equal(t, want, got, bucket)
+	}
+}
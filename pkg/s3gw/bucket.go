@@ -0,0 +1,100 @@
+package s3gw
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"slices"
+	"time"
+
+	sfs "github.com/tymbaca/sfs/pkg/client"
+)
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   owner
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string
+	DisplayName string
+}
+
+type bucketEntry struct {
+	Name string
+	// CreationDate is not tracked by the cluster, so it's zero.
+	CreationDate time.Time
+}
+
+var gatewayOwner = owner{ID: "sfs", DisplayName: "sfs"}
+
+func (g *Gateway) handleListBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, err := g.client.ListBuckets(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	result := listAllMyBucketsResult{Owner: gatewayOwner, Buckets: []bucketEntry{}}
+	for _, b := range buckets {
+		result.Buckets = append(result.Buckets, bucketEntry{Name: b.Name})
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+func (g *Gateway) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("bucket")
+
+	// creating the existing one would reset its quotas
+	exists, err := g.bucketExists(r.Context(), name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if exists {
+		writeError(w, r, errBucketExists)
+		return
+	}
+
+	if err := g.client.CreateBucket(r.Context(), sfs.Bucket{Name: name}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/"+name)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *Gateway) handleHeadBucket(w http.ResponseWriter, r *http.Request) {
+	exists, err := g.bucketExists(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !exists {
+		writeError(w, r, errNoSuchBucket)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *Gateway) handleDeleteBucket(w http.ResponseWriter, r *http.Request) {
+	if err := g.client.DeleteBucket(r.Context(), r.PathValue("bucket")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) bucketExists(ctx context.Context, name string) (bool, error) {
+	buckets, err := g.client.ListBuckets(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.ContainsFunc(buckets, func(b sfs.BucketStats) bool { return b.Name == name }), nil
+}
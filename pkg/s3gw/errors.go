@@ -0,0 +1,100 @@
+package s3gw
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/httprange"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/storage"
+	sfs "github.com/tymbaca/sfs/pkg/client"
+)
+
+// apiError is the S3 error response.
+type apiError struct {
+	code   string
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.code + ": " + e.msg
+}
+
+var (
+	errNoSuchKey         = &apiError{"NoSuchKey", http.StatusNotFound, "The specified key does not exist."}
+	errNoSuchBucket      = &apiError{"NoSuchBucket", http.StatusNotFound, "The specified bucket does not exist."}
+	errNoSuchUpload      = &apiError{"NoSuchUpload", http.StatusNotFound, "The specified multipart upload does not exist."}
+	errBucketExists      = &apiError{"BucketAlreadyOwnedByYou", http.StatusConflict, "The bucket already exists."}
+	errBucketNotEmpty    = &apiError{"BucketNotEmpty", http.StatusConflict, "The bucket you tried to delete is not empty."}
+	errInvalidBucketName = &apiError{"InvalidBucketName", http.StatusBadRequest, "The specified bucket is not valid."}
+	errInvalidRange      = &apiError{"InvalidRange", http.StatusRequestedRangeNotSatisfiable, "The requested range is not satisfiable."}
+	errInvalidPart       = &apiError{"InvalidPart", http.StatusBadRequest, "One or more of the specified parts could not be found."}
+	errInvalidPartOrder  = &apiError{"InvalidPartOrder", http.StatusBadRequest, "The list of parts was not in ascending order."}
+	errMalformedXML      = &apiError{"MalformedXML", http.StatusBadRequest, "The XML you provided was not well-formed."}
+	errInvalidArgument   = &apiError{"InvalidArgument", http.StatusBadRequest, "Invalid argument."}
+	errNotImplemented    = &apiError{"NotImplemented", http.StatusNotImplemented, "The requested functionality is not implemented."}
+	errSlowDown          = &apiError{"SlowDown", http.StatusServiceUnavailable, "Please reduce your request rate."}
+	errStorageFull       = &apiError{"InsufficientStorage", http.StatusInsufficientStorage, "The cluster or the bucket quota is full."}
+	errInternal          = &apiError{"InternalError", http.StatusInternalServerError, "We encountered an internal error. Please try again."}
+)
+
+// toAPIError returns the S3 error of the client error.
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, sfs.ErrNotFound):
+		return errNoSuchKey
+	case errors.Is(err, common.ErrNotFound):
+		// nodes answer so to the requests of the missing bucket
+		return errNoSuchBucket
+	case errors.Is(err, storage.ErrBucketNotEmpty):
+		return errBucketNotEmpty
+	case errors.Is(err, storage.ErrInvalidBucket):
+		return errInvalidBucketName
+	case errors.Is(err, sfs.ErrInvalidRange), errors.Is(err, httprange.ErrUnsatisfiable):
+		return errInvalidRange
+	case errors.Is(err, common.ErrNoSpace):
+		return errStorageFull
+	case errors.Is(err, common.ErrBusy):
+		return errSlowDown
+	default:
+		return errInternal
+	}
+}
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+// writeError writes the S3 error of err. Internal errors are logged, as their
+// details are not sent.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := toAPIError(err)
+	if apiErr == errInternal {
+		logger.Logf("s3 gateway: %s %s: %s", r.Method, r.URL.Path, err)
+	}
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(apiErr.status)
+		return
+	}
+
+	writeXML(w, apiErr.status, errorResponse{Code: apiErr.code, Message: apiErr.msg, Resource: r.URL.Path})
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		logger.Logf("s3 gateway: can't write response: %s", err)
+	}
+}
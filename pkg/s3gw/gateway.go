@@ -0,0 +1,136 @@
+// Package s3gw serves the subset of the S3 REST API over the cluster: S3
+// buckets are the cluster buckets and objects are the files in them. Requests
+// are not authenticated, signatures are ignored.
+package s3gw
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/logger"
+	sfs "github.com/tymbaca/sfs/pkg/client"
+)
+
+// DefaultUploadExpiry is the default [Config.UploadExpiry].
+const DefaultUploadExpiry = 24 * time.Hour
+
+// spoolPatterns are the spool files and dirs of the gateway.
+var spoolPatterns = []string{objectPattern + "*", uploadPattern + "*"}
+
+// Config configures the gateway.
+type Config struct {
+	// SpoolDir keeps the objects and the parts of multipart uploads until
+	// they're uploaded to the cluster. Empty means the system temp dir. The
+	// spool files left by the previous run are removed on start, so the dir
+	// must not be shared by several gateways.
+	SpoolDir string
+	// UploadExpiry is how long the multipart upload is kept after its last
+	// request, then it's aborted. Zero means [DefaultUploadExpiry].
+	UploadExpiry time.Duration
+	// Metadata records Content-Type and x-amz-meta-* headers with the object.
+	// It requires the client with the metadata service.
+	Metadata bool
+}
+
+// Gateway translates the S3 requests to the client calls. Objects are spooled
+// to disk and uploaded with [sfs.Client.Upload], so any client options apply.
+// Multipart uploads are kept in memory and lost on restart, their parts are
+// removed on the next start.
+type Gateway struct {
+	client *sfs.Client
+	cfg    Config
+
+	mu      sync.Mutex
+	uploads map[string]*multipartUpload
+}
+
+func New(client *sfs.Client, cfg Config) *Gateway {
+	if cfg.UploadExpiry <= 0 {
+		cfg.UploadExpiry = DefaultUploadExpiry
+	}
+
+	cleanSpool(cfg.SpoolDir)
+
+	return &Gateway{
+		client:  client,
+		cfg:     cfg,
+		uploads: make(map[string]*multipartUpload),
+	}
+}
+
+// cleanSpool removes the objects and the multipart uploads left in dir by the
+// previous run, they can't be completed anymore.
+func cleanSpool(dir string) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	for _, pattern := range spoolPatterns {
+		paths, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			logger.Logf("s3 gateway: can't list the spool dir: %s", err)
+			return
+		}
+
+		for _, pth := range paths {
+			if err := os.RemoveAll(pth); err != nil {
+				logger.Logf("s3 gateway: can't remove '%s' from the spool dir: %s", pth, err)
+			}
+		}
+	}
+}
+
+// Handler returns the handler of the path-style S3 requests:
+// /<bucket>/<key>.
+func (g *Gateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", g.handleListBuckets)
+	mux.HandleFunc("PUT /{bucket}", g.handleCreateBucket)
+	mux.HandleFunc("HEAD /{bucket}", g.handleHeadBucket)
+	mux.HandleFunc("DELETE /{bucket}", g.handleDeleteBucket)
+	mux.HandleFunc("GET /{bucket}", g.handleListObjects)
+	mux.HandleFunc("PUT /{bucket}/{key...}", g.handlePut)
+	mux.HandleFunc("GET /{bucket}/{key...}", g.handleGetObject)
+	mux.HandleFunc("HEAD /{bucket}/{key...}", g.handleHeadObject)
+	mux.HandleFunc("DELETE /{bucket}/{key...}", g.handleDelete)
+	mux.HandleFunc("POST /{bucket}/{key...}", g.handlePost)
+	return mux
+}
+
+// bucket returns the client of the request bucket.
+func (g *Gateway) bucket(r *http.Request) *sfs.Client {
+	return g.client.Bucket(r.PathValue("bucket"))
+}
+
+// metadata returns the object metadata of the request headers, ok is false
+// if there is nothing to record.
+func (g *Gateway) metadata(r *http.Request) (md sfs.Metadata, ok bool) {
+	if !g.cfg.Metadata {
+		return sfs.Metadata{}, false
+	}
+
+	md.ContentType = r.Header.Get("Content-Type")
+	for name, values := range r.Header {
+		if tag, found := strings.CutPrefix(name, "X-Amz-Meta-"); found && len(values) > 0 {
+			if md.Tags == nil {
+				md.Tags = make(map[string]string)
+			}
+			md.Tags[strings.ToLower(tag)] = values[0]
+		}
+	}
+
+	return md, md.ContentType != "" || md.Tags != nil
+}
+
+// withMetadata returns the options of the upload recording md, if ok.
+func withMetadata(md sfs.Metadata, ok bool) []sfs.UploadOption {
+	if !ok {
+		return nil
+	}
+
+	return []sfs.UploadOption{sfs.WithMetadata(md)}
+}
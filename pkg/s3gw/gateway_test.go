@@ -0,0 +1,261 @@
+package s3gw_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	sfs "github.com/tymbaca/sfs/pkg/client"
+	"github.com/tymbaca/sfs/pkg/meta"
+	"github.com/tymbaca/sfs/pkg/s3gw"
+)
+
+func TestGateway(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 3)
+	replicas := testcluster.StartMeta(t, 1)
+	client := sfs.NewClient(addrs[0]+","+addrs[1]+","+addrs[2], 512, sfs.WithMeta(meta.NewClient(replicas[0].Addr)))
+
+	gateway := httptest.NewServer(s3gw.New(client, s3gw.Config{SpoolDir: t.TempDir(), Metadata: true}).Handler())
+	t.Cleanup(gateway.Close)
+
+	s3c := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(gateway.URL),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})
+
+	random := func(size int) []byte {
+		data := make([]byte, size)
+		rand.Read(data)
+		return data
+	}
+	get := func(key string, rng *string) ([]byte, *s3.GetObjectOutput) {
+		out, err := s3c.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("builds"), Key: aws.String(key), Range: rng})
+		require.NoError(t, err)
+		defer out.Body.Close()
+
+		data, err := io.ReadAll(out.Body)
+		require.NoError(t, err)
+		return data, out
+	}
+
+	_, err := s3c.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("builds")})
+	require.NoError(t, err)
+	_, err = s3c.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("builds")})
+	require.NoError(t, err)
+
+	// put object, the file of the bucket with its metadata
+	data := random(5*512 + 100)
+	put, err := s3c.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String("builds"),
+		Key:         aws.String("linux/1.zip"),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/zip"),
+		Metadata:    map[string]string{"build": "1"},
+	})
+	require.NoError(t, err)
+
+	info, err := client.Bucket("builds").Stat(ctx, "linux/1.zip")
+	require.NoError(t, err)
+	require.Equal(t, `"`+info.ETag+`"`, aws.ToString(put.ETag))
+	require.Equal(t, "application/zip", info.ContentType)
+	require.Equal(t, map[string]string{"build": "1"}, info.Tags)
+
+	got, out := get("linux/1.zip", nil)
+	require.Equal(t, data, got)
+	require.Equal(t, "application/zip", aws.ToString(out.ContentType))
+	require.Equal(t, "1", out.Metadata["build"])
+	require.Equal(t, put.ETag, out.ETag)
+
+	// ranges
+	got, out = get("linux/1.zip", aws.String("bytes=500-1599"))
+	require.Equal(t, data[500:1600], got)
+	require.Equal(t, "bytes 500-1599/2660", aws.ToString(out.ContentRange))
+	got, _ = get("linux/1.zip", aws.String("bytes=-10"))
+	require.Equal(t, data[len(data)-10:], got)
+	got, _ = get("linux/1.zip", aws.String("bytes=2600-"))
+	require.Equal(t, data[2600:], got)
+	_, err = s3c.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("builds"), Key: aws.String("linux/1.zip"), Range: aws.String("bytes=9000-")})
+	require.ErrorContains(t, err, "InvalidRange")
+
+	head, err := s3c.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("builds"), Key: aws.String("linux/1.zip")})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), aws.ToInt64(head.ContentLength))
+
+	_, err = s3c.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("builds"), Key: aws.String("missing")})
+	var notFound *types.NotFound
+	require.True(t, errors.As(err, &notFound), err)
+	_, err = s3c.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("builds"), Key: aws.String("missing")})
+	var noSuchKey *types.NoSuchKey
+	require.True(t, errors.As(err, &noSuchKey), err)
+
+	// multipart upload, parts are uploaded out of order
+	parts := [][]byte{random(1000), random(1000), random(300)}
+	created, err := s3c.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String("builds"), Key: aws.String("linux/2.zip")})
+	require.NoError(t, err)
+
+	completed := make([]types.CompletedPart, len(parts))
+	for _, i := range []int{2, 0, 1} {
+		out, err := s3c.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String("builds"),
+			Key:        aws.String("linux/2.zip"),
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int32(int32(i + 1)),
+			Body:       bytes.NewReader(parts[i]),
+		})
+		require.NoError(t, err)
+		completed[i] = types.CompletedPart{PartNumber: aws.Int32(int32(i + 1)), ETag: out.ETag}
+	}
+
+	_, err = s3c.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("builds"),
+		Key:             aws.String("linux/2.zip"),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	require.NoError(t, err)
+	got, _ = get("linux/2.zip", nil)
+	require.Equal(t, bytes.Join(parts, nil), got)
+
+	// the completed upload is gone, the aborted one too
+	_, err = s3c.UploadPart(ctx, &s3.UploadPartInput{Bucket: aws.String("builds"), Key: aws.String("linux/2.zip"), UploadId: created.UploadId, PartNumber: aws.Int32(1), Body: bytes.NewReader(parts[0])})
+	require.ErrorContains(t, err, "NoSuchUpload")
+	aborted, err := s3c.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String("builds"), Key: aws.String("linux/3.zip")})
+	require.NoError(t, err)
+	_, err = s3c.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: aws.String("builds"), Key: aws.String("linux/3.zip"), UploadId: aborted.UploadId})
+	require.NoError(t, err)
+
+	// streaming upload in aws-chunked encoding
+	body := "5;chunk-signature=aaa\r\nhello\r\n6;chunk-signature=bbb\r\n world\r\n0;chunk-signature=ccc\r\n\r\n"
+	req, err := http.NewRequest(http.MethodPut, gateway.URL+"/builds/linux/3.txt", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("X-Amz-Content-Sha256", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	got, _ = get("linux/3.txt", nil)
+	require.Equal(t, "hello world", string(got))
+
+	// list with delimiter and pages
+	for _, key := range []string{"mac/1.zip", "mac/2.zip", "readme.txt"} {
+		_, err := s3c.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("builds"), Key: aws.String(key), Body: bytes.NewReader(random(10))})
+		require.NoError(t, err)
+	}
+
+	list, err := s3c.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("builds"), Delimiter: aws.String("/")})
+	require.NoError(t, err)
+	require.Len(t, list.Contents, 1)
+	require.Equal(t, "readme.txt", aws.ToString(list.Contents[0].Key))
+	require.Len(t, list.CommonPrefixes, 2)
+	require.Equal(t, "linux/", aws.ToString(list.CommonPrefixes[0].Prefix))
+	require.Equal(t, "mac/", aws.ToString(list.CommonPrefixes[1].Prefix))
+
+	var keys []string
+	pages := s3.NewListObjectsV2Paginator(s3c, &s3.ListObjectsV2Input{Bucket: aws.String("builds"), MaxKeys: aws.Int32(2)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		require.NoError(t, err)
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+	require.Equal(t, []string{"linux/1.zip", "linux/2.zip", "linux/3.txt", "mac/1.zip", "mac/2.zip", "readme.txt"}, keys)
+
+	list, err = s3c.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("builds"), Prefix: aws.String("mac/")})
+	require.NoError(t, err)
+	require.Len(t, list.Contents, 2)
+
+	// delete
+	_, err = s3c.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("builds"), Key: aws.String("linux/1.zip")})
+	require.NoError(t, err)
+	_, err = s3c.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("builds"), Key: aws.String("linux/1.zip")})
+	require.NoError(t, err)
+	_, err = s3c.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("builds"), Key: aws.String("linux/1.zip")})
+	require.Error(t, err)
+
+	_, err = s3c.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String("builds")})
+	require.ErrorContains(t, err, "BucketNotEmpty")
+}
+
+func TestMultipartExpiry(t *testing.T) {
+	ctx := context.Background()
+
+	addrs := testcluster.Start(t, 1)
+	client := sfs.NewClient(addrs[0], 512)
+
+	// the upload and the object left by the previous run
+	spoolDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(spoolDir, "upload-1"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(spoolDir, "upload-1", "1"), []byte("part"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(spoolDir, "object-1"), []byte("object"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(spoolDir, "other"), nil, 0o644))
+
+	gateway := httptest.NewServer(s3gw.New(client, s3gw.Config{SpoolDir: spoolDir, UploadExpiry: 200 * time.Millisecond}).Handler())
+	t.Cleanup(gateway.Close)
+
+	spooled := func() []string {
+		entries, err := os.ReadDir(spoolDir)
+		require.NoError(t, err)
+
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+	require.Equal(t, []string{"other"}, spooled())
+
+	s3c := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(gateway.URL),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})
+	_, err := s3c.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("builds")})
+	require.NoError(t, err)
+
+	uploadPart := func(id *string) error {
+		_, err := s3c.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String("builds"),
+			Key:        aws.String("file"),
+			UploadId:   id,
+			PartNumber: aws.Int32(1),
+			Body:       strings.NewReader("part"),
+		})
+		return err
+	}
+
+	created, err := s3c.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String("builds"), Key: aws.String("file")})
+	require.NoError(t, err)
+
+	// requests keep the upload
+	for range 3 {
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, uploadPart(created.UploadId))
+	}
+	require.Len(t, spooled(), 2)
+
+	// idle upload expires with its parts
+	time.Sleep(300 * time.Millisecond)
+	require.ErrorContains(t, uploadPart(created.UploadId), "NoSuchUpload")
+	require.Equal(t, []string{"other"}, spooled())
+}
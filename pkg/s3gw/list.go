@@ -0,0 +1,145 @@
+package s3gw
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	sfs "github.com/tymbaca/sfs/pkg/client"
+)
+
+// maxKeys is the max count of keys and common prefixes listed at once.
+const maxKeys = 1000
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	EncodingType          string `xml:",omitempty"`
+	MaxKeys               int
+	KeyCount              int
+	IsTruncated           bool
+	Contents              []objectEntry
+	CommonPrefixes        []commonPrefix
+}
+
+type objectEntry struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+// handleListObjects lists the objects with ListObjectsV2. Objects with the
+// delimiter after the prefix are grouped into common prefixes, which are
+// counted as one key. Continuation token is the last listed key or prefix.
+func (g *Gateway) handleListObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		writeError(w, r, errNotImplemented)
+		return
+	}
+
+	limit := maxKeys
+	if s := query.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, r, errInvalidArgument)
+			return
+		}
+		limit = min(n, maxKeys)
+	}
+
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, r, errInvalidArgument)
+			return
+		}
+		after = string(decoded)
+	}
+
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	infos, err := g.bucket(r).List(r.Context(), sfs.Filter{Prefix: prefix})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	result := listBucketResult{
+		Name:              r.PathValue("bucket"),
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           limit,
+	}
+
+	last := ""
+	for _, info := range infos {
+		entry, grouped := info.Name, false
+		if delimiter != "" {
+			rest := strings.TrimPrefix(info.Name, prefix)
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				entry, grouped = prefix+rest[:i+len(delimiter)], true
+			}
+		}
+
+		// names are sorted, so the ones of the same prefix follow each other
+		if entry <= after || (grouped && entry == last) {
+			continue
+		}
+
+		if result.KeyCount == limit {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+			break
+		}
+
+		if grouped {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+		} else {
+			result.Contents = append(result.Contents, objectEntry{
+				Key:          info.Name,
+				LastModified: info.CreatedAt.UTC(),
+				ETag:         quote(info.ETag),
+				Size:         info.Size,
+				StorageClass: "STANDARD",
+			})
+		}
+		result.KeyCount++
+		last = entry
+	}
+
+	if query.Get("encoding-type") == "url" {
+		result.EncodingType = "url"
+		result.Prefix, result.Delimiter, result.StartAfter = encodeKey(result.Prefix), encodeKey(result.Delimiter), encodeKey(result.StartAfter)
+		for i := range result.Contents {
+			result.Contents[i].Key = encodeKey(result.Contents[i].Key)
+		}
+		for i := range result.CommonPrefixes {
+			result.CommonPrefixes[i].Prefix = encodeKey(result.CommonPrefixes[i].Prefix)
+		}
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// encodeKey encodes the key of encoding-type=url responses.
+func encodeKey(key string) string {
+	return strings.ReplaceAll(url.QueryEscape(key), "+", "%20")
+}
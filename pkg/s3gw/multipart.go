@@ -0,0 +1,270 @@
+package s3gw
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/logger"
+	sfs "github.com/tymbaca/sfs/pkg/client"
+)
+
+// maxPartNumber is the max number of the part of the multipart upload.
+const maxPartNumber = 10000
+
+// uploadPattern is the pattern of the multipart upload dirs.
+const uploadPattern = "upload-"
+
+// multipartUpload is the upload in progress. Its parts are spooled to dir,
+// each named by its number, and uploaded to the cluster as one file on
+// completion.
+type multipartUpload struct {
+	bucket string
+	key    string
+	md     sfs.Metadata
+	hasMD  bool
+	dir    string
+	// used is the time of the last request of the upload, guarded by the
+	// gateway mutex
+	used time.Time
+
+	mu    sync.Mutex
+	parts map[int]part
+}
+
+type part struct {
+	etag string
+	size int64
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+func (g *Gateway) handleCreateMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	g.expireUploads()
+
+	dir, err := os.MkdirTemp(g.cfg.SpoolDir, uploadPattern)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("can't create the upload dir: %w", err))
+		return
+	}
+
+	upload := &multipartUpload{
+		bucket: r.PathValue("bucket"),
+		key:    r.PathValue("key"),
+		dir:    dir,
+		used:   time.Now(),
+		parts:  make(map[int]part),
+	}
+	upload.md, upload.hasMD = g.metadata(r)
+
+	g.mu.Lock()
+	g.uploads[hex.EncodeToString(id)] = upload
+	g.mu.Unlock()
+
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Bucket:   upload.bucket,
+		Key:      upload.key,
+		UploadId: hex.EncodeToString(id),
+	})
+}
+
+func (g *Gateway) handleUploadPart(w http.ResponseWriter, r *http.Request) {
+	upload, ok := g.upload(r)
+	if !ok {
+		writeError(w, r, errNoSuchUpload)
+		return
+	}
+
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || number < 1 || number > maxPartNumber {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+
+	f, size, sum, err := spool(upload.dir, "part-", requestBody(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	f.Close()
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	// the part uploaded again replaces the previous one, the upload may be
+	// completed or aborted meanwhile
+	if err := os.Rename(f.Name(), upload.partPath(number)); err != nil {
+		os.Remove(f.Name())
+		writeError(w, r, errNoSuchUpload)
+		return
+	}
+
+	etag := hex.EncodeToString(sum)
+	upload.parts[number] = part{etag: etag, size: size}
+
+	w.Header().Set("ETag", quote(etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *Gateway) handleCompleteMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("uploadId")
+	upload, ok := g.upload(r)
+	if !ok {
+		writeError(w, r, errNoSuchUpload)
+		return
+	}
+
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+
+	// parts are not replaced while they're read
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	files := make([]*os.File, 0, len(req.Parts))
+	sizes := make([]int64, 0, len(req.Parts))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			writeError(w, r, errInvalidPartOrder)
+			return
+		}
+
+		stored, ok := upload.parts[p.PartNumber]
+		if !ok || strings.Trim(p.ETag, `"`) != stored.etag {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+
+		f, err := os.Open(upload.partPath(p.PartNumber))
+		if err != nil {
+			writeError(w, r, fmt.Errorf("can't open part %d: %w", p.PartNumber, err))
+			return
+		}
+		files = append(files, f)
+		sizes = append(sizes, stored.size)
+	}
+
+	client := g.client.Bucket(upload.bucket)
+	body := newPartsReader(files, sizes)
+	if err := client.Upload(r.Context(), upload.key, body, body.size(), withMetadata(upload.md, upload.hasMD)...); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	info, err := client.Stat(r.Context(), upload.key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	g.removeUpload(id)
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Location: "/" + upload.bucket + "/" + upload.key,
+		Bucket:   upload.bucket,
+		Key:      upload.key,
+		ETag:     quote(info.ETag),
+	})
+}
+
+func (g *Gateway) handleAbortMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	if _, ok := g.upload(r); !ok {
+		writeError(w, r, errNoSuchUpload)
+		return
+	}
+
+	g.removeUpload(r.URL.Query().Get("uploadId"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// upload returns the multipart upload of the request, it must be of the same
+// bucket and key.
+func (g *Gateway) upload(r *http.Request) (*multipartUpload, bool) {
+	g.expireUploads()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	upload, ok := g.uploads[r.URL.Query().Get("uploadId")]
+	if !ok || upload.bucket != r.PathValue("bucket") || upload.key != r.PathValue("key") {
+		return nil, false
+	}
+	upload.used = time.Now()
+
+	return upload, true
+}
+
+// expireUploads aborts the uploads without requests for the expiry. They're
+// checked on the multipart requests, so the idle gateway keeps them longer.
+func (g *Gateway) expireUploads() {
+	var expired []*multipartUpload
+
+	g.mu.Lock()
+	for id, upload := range g.uploads {
+		if time.Since(upload.used) > g.cfg.UploadExpiry {
+			expired = append(expired, upload)
+			delete(g.uploads, id)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, upload := range expired {
+		logger.Logf("s3 gateway: multipart upload of '%s/%s' expired", upload.bucket, upload.key)
+		os.RemoveAll(upload.dir)
+	}
+}
+
+// removeUpload forgets the upload and removes its parts.
+func (g *Gateway) removeUpload(id string) {
+	g.mu.Lock()
+	upload, ok := g.uploads[id]
+	delete(g.uploads, id)
+	g.mu.Unlock()
+
+	if ok {
+		os.RemoveAll(upload.dir)
+	}
+}
+
+func (u *multipartUpload) partPath(number int) string {
+	return filepath.Join(u.dir, strconv.Itoa(number))
+}
@@ -0,0 +1,170 @@
+package s3gw
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tymbaca/sfs/internal/httprange"
+	"github.com/tymbaca/sfs/internal/logger"
+	sfs "github.com/tymbaca/sfs/pkg/client"
+)
+
+// defaultContentType is the type of objects uploaded without one, as S3 has.
+const defaultContentType = "binary/octet-stream"
+
+// objectPattern is the pattern of the spooled objects.
+const objectPattern = "object-"
+
+func (g *Gateway) handlePut(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("uploadId") {
+		g.handleUploadPart(w, r)
+		return
+	}
+
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		writeError(w, r, errNotImplemented)
+		return
+	}
+
+	f, size, _, err := spool(g.cfg.SpoolDir, objectPattern, requestBody(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer removeSpooled(f)
+
+	client, key := g.bucket(r), r.PathValue("key")
+	md, ok := g.metadata(r)
+	if err := client.Upload(r.Context(), key, f, size, withMetadata(md, ok)...); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	info, err := client.Stat(r.Context(), key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", quote(info.ETag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *Gateway) handleHeadObject(w http.ResponseWriter, r *http.Request) {
+	info, err := g.bucket(r).Stat(r.Context(), r.PathValue("key"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setObjectHeaders(w, info)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *Gateway) handleGetObject(w http.ResponseWriter, r *http.Request) {
+	client, key := g.bucket(r), r.PathValue("key")
+	if key == "" {
+		// /<bucket>/ is the bucket too
+		g.handleListObjects(w, r)
+		return
+	}
+
+	// info and data are of the same upload even if the object is replaced
+	obj, err := client.Open(r.Context(), key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	info := obj.FileInfo
+
+	status := http.StatusOK
+	offset, length := int64(0), info.Size
+	if header := r.Header.Get("Range"); header != "" {
+		var partial bool
+		offset, length, partial, err = httprange.Parse(header, info.Size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			writeError(w, r, err)
+			return
+		}
+		if partial {
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, info.Size))
+		}
+	}
+
+	// empty files have no chunks to download
+	body := io.Reader(strings.NewReader(""))
+	if length > 0 {
+		var cls func() error
+		body, cls, length, err = obj.DownloadRange(r.Context(), offset, length)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		defer cls()
+	}
+
+	setObjectHeaders(w, info)
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+
+	if _, err := io.Copy(w, body); err != nil {
+		logger.Logf("s3 gateway: can't send '%s': %s", r.URL.Path, err)
+	}
+}
+
+func (g *Gateway) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("uploadId") {
+		g.handleAbortMultipartUpload(w, r)
+		return
+	}
+
+	// deleting the missing object succeeds in S3
+	err := g.bucket(r).Delete(r.Context(), r.PathValue("key"))
+	if err != nil && !errors.Is(err, sfs.ErrNotFound) {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) handlePost(w http.ResponseWriter, r *http.Request) {
+	switch query := r.URL.Query(); {
+	case query.Has("uploads"):
+		g.handleCreateMultipartUpload(w, r)
+	case query.Has("uploadId"):
+		g.handleCompleteMultipartUpload(w, r)
+	default:
+		writeError(w, r, errNotImplemented)
+	}
+}
+
+// setObjectHeaders sets the headers of the object but its length.
+func setObjectHeaders(w http.ResponseWriter, info sfs.FileInfo) {
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("ETag", quote(info.ETag))
+	h.Set("Accept-Ranges", "bytes")
+	if !info.CreatedAt.IsZero() {
+		h.Set("Last-Modified", info.CreatedAt.UTC().Format(http.TimeFormat))
+	}
+	for k, v := range info.Tags {
+		h.Set("X-Amz-Meta-"+k, v)
+	}
+}
+
+func quote(etag string) string {
+	return `"` + etag + `"`
+}
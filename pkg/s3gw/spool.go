@@ -0,0 +1,157 @@
+package s3gw
+
+import (
+	"bufio"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// spool writes r to the new file in dir, returns the file rewound with the
+// size and MD5 of the data. The caller removes the file.
+func spool(dir, pattern string, r io.Reader) (*os.File, int64, []byte, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("can't create the spool file: %w", err)
+	}
+
+	h := md5.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, nil, fmt.Errorf("can't spool the data: %w", err)
+	}
+
+	return f, size, h.Sum(nil), nil
+}
+
+// removeSpooled closes and removes the spool file.
+func removeSpooled(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// requestBody returns the object data of the request body. Streaming uploads
+// are sent in aws-chunked encoding, their chunk signatures are not verified.
+func requestBody(r *http.Request) io.Reader {
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return &chunkedReader{r: bufio.NewReader(r.Body)}
+	}
+
+	return r.Body
+}
+
+// chunkedReader decodes aws-chunked encoding:
+// <hex size>;chunk-signature=<sig>\r\n<data>\r\n ... 0;chunk-signature=<sig>\r\n
+// Trailers after the last chunk are ignored.
+type chunkedReader struct {
+	r    *bufio.Reader
+	left int64
+	done bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+
+	if c.left == 0 {
+		line, err := c.r.ReadSlice('\n')
+		if err != nil {
+			return 0, fmt.Errorf("can't read chunk header: %w", noEOF(err))
+		}
+
+		sizeHex, _, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("invalid chunk size '%s'", sizeHex)
+		}
+
+		if size == 0 {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.left = size
+	}
+
+	n, err := c.r.Read(p[:min(int64(len(p)), c.left)])
+	c.left -= int64(n)
+	if err != nil {
+		return n, noEOF(err)
+	}
+
+	if c.left == 0 {
+		crlf := make([]byte, 2)
+		if _, err := io.ReadFull(c.r, crlf); err != nil {
+			return n, fmt.Errorf("can't read chunk end: %w", noEOF(err))
+		}
+		if string(crlf) != "\r\n" {
+			return n, errors.New("chunk data is longer than its size")
+		}
+	}
+
+	return n, nil
+}
+
+// noEOF returns io.ErrUnexpectedEOF instead of io.EOF, as the encoded body
+// ends with the last chunk only.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// partsReader reads the spooled parts as one file.
+type partsReader struct {
+	parts  []*os.File
+	starts []int64 // offset of each part, then the total size
+}
+
+func newPartsReader(parts []*os.File, sizes []int64) *partsReader {
+	starts := make([]int64, 0, len(sizes)+1)
+	offset := int64(0)
+	for _, size := range sizes {
+		starts = append(starts, offset)
+		offset += size
+	}
+	starts = append(starts, offset)
+
+	return &partsReader{parts: parts, starts: starts}
+}
+
+func (p *partsReader) size() int64 {
+	return p.starts[len(p.starts)-1]
+}
+
+func (p *partsReader) ReadAt(b []byte, off int64) (int, error) {
+	read := 0
+	for read < len(b) {
+		if off >= p.size() {
+			return read, io.EOF
+		}
+
+		// the last part starting at or before off
+		i := sort.Search(len(p.parts), func(i int) bool { return p.starts[i+1] > off })
+		want := min(int64(len(b)-read), p.starts[i+1]-off)
+		n, err := p.parts[i].ReadAt(b[read:read+int(want)], off-p.starts[i])
+		read += n
+		off += int64(n)
+		if err != nil && !(errors.Is(err, io.EOF) && int64(n) == want) {
+			return read, err
+		}
+	}
+
+	return read, nil
+}
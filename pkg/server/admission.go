@@ -0,0 +1,241 @@
+package sfs
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/codes"
+	"github.com/tymbaca/sfs/internal/logger"
+)
+
+const (
+	// rejectTimeout limits the time the rejected request is read, so the
+	// client gets BUSY response after it sent the request instead of the
+	// reset.
+	rejectTimeout = time.Second
+	// maxRejecting is the count of rejected requests read at once. The
+	// following ones are closed right after BUSY response, so the flood of
+	// connections doesn't hold the file descriptors.
+	maxRejecting = 64
+)
+
+type AdmissionConfig struct {
+	// MaxConns is the count of connections served at once. Non-positive means
+	// unlimited.
+	MaxConns int
+	// MaxInFlight is the count of requests of each kind served at once, by
+	// the head character of the request, e.g. '*' for send chunk. Kinds
+	// without the limit are not limited.
+	MaxInFlight map[byte]int
+	// QueueTimeout is how long the connection or request over the limit
+	// waits for its turn before it's rejected with BUSY. Zero rejects it
+	// right away. At most MaxConns connections wait at once, the following
+	// ones are rejected right away too.
+	QueueTimeout time.Duration
+	// IdleTimeout drops the connection when any read or write of it stalls
+	// for longer. Zero means no timeout.
+	IdleTimeout time.Duration
+	// HeaderTimeout drops the connection which didn't send the whole request,
+	// except the chunk body, in time, e.g. the slow loris one. Zero means no
+	// timeout.
+	HeaderTimeout time.Duration
+}
+
+// SetAdmission sets the limits of the following connections.
+func (s *Server) SetAdmission(cfg AdmissionConfig) {
+	s.admissionMu.Lock()
+	defer s.admissionMu.Unlock()
+
+	s.admission = newAdmission(cfg)
+}
+
+func (s *Server) getAdmission() *admission {
+	s.admissionMu.Lock()
+	defer s.admissionMu.Unlock()
+
+	return s.admission
+}
+
+type admission struct {
+	cfg    AdmissionConfig
+	conns  chan struct{}
+	queued chan struct{} // connections waiting for the conns slot
+	ops    map[byte]chan struct{}
+}
+
+func newAdmission(cfg AdmissionConfig) *admission {
+	a := &admission{cfg: cfg, ops: make(map[byte]chan struct{})}
+	if cfg.MaxConns > 0 {
+		a.conns = make(chan struct{}, cfg.MaxConns)
+		a.queued = make(chan struct{}, cfg.MaxConns)
+	}
+
+	for head, limit := range cfg.MaxInFlight {
+		if limit > 0 {
+			a.ops[head] = make(chan struct{}, limit)
+		}
+	}
+
+	return a
+}
+
+// acquireConn takes the connection slot, waiting for it in the queue of
+// limited length.
+func (a *admission) acquireConn(ctx context.Context) bool {
+	if a.conns == nil {
+		return true
+	}
+
+	select {
+	case a.conns <- struct{}{}:
+		return true
+	default:
+	}
+
+	select {
+	case a.queued <- struct{}{}:
+		defer func() { <-a.queued }()
+	default:
+		return false
+	}
+
+	return a.acquire(ctx, a.conns)
+}
+
+// acquire takes the slot of sem, waiting for it at most the queue timeout.
+// Nil sem is unlimited.
+func (a *admission) acquire(ctx context.Context, sem chan struct{}) bool {
+	if sem == nil {
+		return true
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+	}
+
+	if a.cfg.QueueTimeout <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(a.cfg.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	return false
+}
+
+func (a *admission) release(sem chan struct{}) {
+	if sem != nil {
+		<-sem
+	}
+}
+
+// wrap applies the idle and header timeouts to conn. The header timeout
+// starts when the connection is admitted.
+func (a *admission) wrap(conn net.Conn) net.Conn {
+	if a.cfg.IdleTimeout <= 0 && a.cfg.HeaderTimeout <= 0 {
+		return conn
+	}
+
+	c := &deadlineConn{Conn: conn, idle: a.cfg.IdleTimeout, headerTimeout: a.cfg.HeaderTimeout}
+	c.restartHeader()
+
+	return c
+}
+
+// admitted restarts the header timeout of conn after the request waited for
+// its turn in the queue.
+func admitted(conn io.ReadWriter) {
+	if c, ok := conn.(*deadlineConn); ok {
+		c.restartHeader()
+	}
+}
+
+// rejecting limits the rejected requests read at once.
+var rejecting = make(chan struct{}, maxRejecting)
+
+// reject responds BUSY and reads the rest of the request for a short time,
+// unless too many requests are rejected at once.
+func reject(conn net.Conn, msg string) error {
+	logger.Logf("rejecting the request from '%s': %s", conn.RemoteAddr(), msg)
+
+	if c, ok := conn.(*deadlineConn); ok {
+		conn = c.Conn
+	}
+	conn.SetWriteDeadline(time.Now().Add(rejectTimeout))
+	if err := writeCodeMsg(conn, codes.Busy, msg); err != nil {
+		return err
+	}
+
+	select {
+	case rejecting <- struct{}{}:
+		defer func() { <-rejecting }()
+	default:
+		return nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(rejectTimeout))
+	io.Copy(io.Discard, conn)
+
+	return nil
+}
+
+// deadlineConn drops the stalled connection: each read and write must
+// progress within idle and the request header must be read before header.
+type deadlineConn struct {
+	net.Conn
+	idle          time.Duration
+	headerTimeout time.Duration
+	header        time.Time // zero when there is no deadline
+}
+
+func (c *deadlineConn) restartHeader() {
+	if c.headerTimeout > 0 {
+		c.header = time.Now().Add(c.headerTimeout)
+	}
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	var deadline time.Time
+	if c.idle > 0 {
+		deadline = time.Now().Add(c.idle)
+	}
+
+	if !c.header.IsZero() && (deadline.IsZero() || c.header.Before(deadline)) {
+		deadline = c.header
+	}
+
+	if err := c.Conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if c.idle > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.idle)); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Conn.Write(p)
+}
+
+// headerRead lifts the header timeout of conn before the chunk body is read,
+// which may take long.
+func headerRead(conn io.ReadWriter) {
+	if c, ok := conn.(*deadlineConn); ok {
+		c.header = time.Time{}
+	}
+}
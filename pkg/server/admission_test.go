@@ -0,0 +1,145 @@
+package sfs_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/internal/transport"
+	sfs_client "github.com/tymbaca/sfs/pkg/client"
+	sfs "github.com/tymbaca/sfs/pkg/server"
+)
+
+func TestAdmission(t *testing.T) {
+	ctx := context.Background()
+
+	node := testcluster.StartNodes(t, 1)[0]
+	node.Server.SetAdmission(sfs.AdmissionConfig{
+		MaxConns:      1,
+		IdleTimeout:   300 * time.Millisecond,
+		HeaderTimeout: time.Second,
+	})
+
+	ping := func() error {
+		trans := transport.NewTCPTransport(node.Addr)
+		defer trans.Close()
+		return trans.Ping(ctx)
+	}
+
+	// idle connection takes the only slot until it's dropped
+	idle, err := net.Dial("tcp", node.Addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	require.ErrorIs(t, ping(), common.ErrBusy)
+	_, err = idle.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.NoError(t, ping())
+
+	// slow loris is dropped even though it keeps sending
+	loris, err := net.Dial("tcp", node.Addr)
+	require.NoError(t, err)
+	defer loris.Close()
+
+	// list ids request with the long file name sent byte by byte
+	_, err = loris.Write(append([]byte("%"), binary.LittleEndian.AppendUint64(nil, 100)...))
+	require.NoError(t, err)
+
+	start := time.Now()
+	for time.Since(start) < 2*time.Second {
+		if _, err = loris.Write([]byte("a")); err != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 2*time.Second)
+
+	// client retries while the node is busy
+	busy, err := net.Dial("tcp", node.Addr)
+	require.NoError(t, err)
+	time.AfterFunc(200*time.Millisecond, func() { busy.Close() })
+
+	data := make([]byte, 4*512)
+	rand.Read(data)
+	client := sfs_client.NewClient(node.Addr, 512)
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+	require.Equal(t, sfs_client.NodeUp, client.NodeStates()[node.Addr])
+}
+
+func TestAdmissionQueue(t *testing.T) {
+	ctx := context.Background()
+
+	node := testcluster.StartNodes(t, 1)[0]
+	node.Server.SetAdmission(sfs.AdmissionConfig{
+		MaxConns:      1,
+		QueueTimeout:  2 * time.Second,
+		HeaderTimeout: 300 * time.Millisecond,
+	})
+
+	ping := func() error {
+		trans := transport.NewTCPTransport(node.Addr)
+		defer trans.Close()
+		return trans.Ping(ctx)
+	}
+
+	// idle connection takes the only slot until the header timeout
+	idle, err := net.Dial("tcp", node.Addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	queued := make(chan error)
+	go func() { queued <- ping() }()
+	time.Sleep(50 * time.Millisecond)
+
+	// the queue is full, the connection isn't left waiting
+	start := time.Now()
+	require.ErrorIs(t, ping(), common.ErrBusy)
+	require.Less(t, time.Since(start), time.Second)
+
+	require.NoError(t, <-queued)
+}
+
+func TestAdmissionInFlight(t *testing.T) {
+	ctx := context.Background()
+
+	node := testcluster.StartNodes(t, 1)[0]
+	node.Server.SetAdmission(sfs.AdmissionConfig{
+		MaxInFlight:  map[byte]int{'%': 1},
+		QueueTimeout: 200 * time.Millisecond,
+	})
+
+	// the request of the limited kind is stuck reading the file name
+	stuck, err := net.Dial("tcp", node.Addr)
+	require.NoError(t, err)
+	_, err = stuck.Write([]byte("%"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	trans := transport.NewTCPTransport(node.Addr)
+	_, err = trans.ListIDs(ctx, "file")
+	trans.Close()
+	require.ErrorIs(t, err, common.ErrBusy)
+
+	// other kinds are not limited
+	trans = transport.NewTCPTransport(node.Addr)
+	require.NoError(t, trans.Ping(ctx))
+	trans.Close()
+
+	// queued request gets the slot when it's freed
+	time.AfterFunc(100*time.Millisecond, func() { stuck.Close() })
+	trans = transport.NewTCPTransport(node.Addr)
+	defer trans.Close()
+	_, err = trans.ListIDs(ctx, "file")
+	require.NoError(t, err)
+}
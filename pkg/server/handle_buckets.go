@@ -0,0 +1,114 @@
+package sfs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tymbaca/sfs/internal/codes"
+	"github.com/tymbaca/sfs/internal/common"
+	filestorage "github.com/tymbaca/sfs/internal/storage"
+)
+
+// maxBucketSize limits the bucket name read from the request, longer ones are
+// invalid anyway.
+const maxBucketSize = 63
+
+// readBucket reads the bucket name of the '+' prefix or the bucket request.
+func readBucket(r io.Reader) (string, error) {
+	var size uint64
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return "", fmt.Errorf("can't read bucket size: %w", err)
+	}
+
+	if size > maxBucketSize {
+		return "", fmt.Errorf("%w: %d bytes long", filestorage.ErrInvalidBucket, size)
+	}
+
+	name := make([]byte, size)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", fmt.Errorf("can't read bucket: %w", err)
+	}
+
+	return string(name), filestorage.CheckBucketName(string(name))
+}
+
+func (s *Server) handleCreateBucket(ctx context.Context, conn io.ReadWriter) error {
+	name, err := readBucket(conn)
+	if err != nil {
+		writeCodeMsg(conn, codes.InvalidReq, err.Error())
+		return err
+	}
+
+	var quotas [2]uint64
+	if err := binary.Read(conn, binary.LittleEndian, &quotas); err != nil {
+		return fmt.Errorf("can't read bucket quotas: %w", err)
+	}
+
+	bucket := filestorage.Bucket{Name: name, MaxBytes: quotas[0], MaxObjects: quotas[1]}
+	if err := s.storage.CreateBucket(ctx, bucket); err != nil {
+		err = fmt.Errorf("can't create bucket: %w", err)
+		writeCodeMsg(conn, codes.Internal, err.Error())
+		return err
+	}
+
+	return writeCodeMsg(conn, codes.Ok, "created")
+}
+
+func (s *Server) handleListBuckets(ctx context.Context, conn io.ReadWriter) error {
+	buckets, err := s.storage.ListBuckets(ctx)
+	if err != nil {
+		err = fmt.Errorf("can't list buckets: %w", err)
+		writeCodeMsg(conn, codes.Internal, err.Error())
+		return err
+	}
+
+	if err := writeCode(conn, codes.Ok); err != nil {
+		return fmt.Errorf("can't write OK: %w", err)
+	}
+
+	if err := binary.Write(conn, binary.LittleEndian, uint64(len(buckets))); err != nil {
+		return fmt.Errorf("can't write buckets len: %w", err)
+	}
+
+	for _, b := range buckets {
+		if err := binary.Write(conn, binary.LittleEndian, uint64(len(b.Name))); err != nil {
+			return fmt.Errorf("can't write bucket size: %w", err)
+		}
+
+		if _, err := conn.Write([]byte(b.Name)); err != nil {
+			return fmt.Errorf("can't write bucket: %w", err)
+		}
+
+		if err := binary.Write(conn, binary.LittleEndian, []uint64{b.MaxBytes, b.MaxObjects, b.Bytes, b.Objects}); err != nil {
+			return fmt.Errorf("can't write bucket stats: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) handleDeleteBucket(ctx context.Context, conn io.ReadWriter) error {
+	name, err := readBucket(conn)
+	if err != nil {
+		writeCodeMsg(conn, codes.InvalidReq, err.Error())
+		return err
+	}
+
+	if err := s.storage.DeleteBucket(ctx, name); err != nil {
+		err = fmt.Errorf("can't delete bucket: %w", err)
+		switch {
+		case errors.Is(err, common.ErrNotFound):
+			writeCodeMsg(conn, codes.NotFound, err.Error())
+		case errors.Is(err, filestorage.ErrBucketNotEmpty):
+			writeCodeMsg(conn, codes.InvalidReq, err.Error())
+		default:
+			writeCodeMsg(conn, codes.Internal, err.Error())
+		}
+		return err
+	}
+
+	return writeCodeMsg(conn, codes.Ok, "deleted")
+}
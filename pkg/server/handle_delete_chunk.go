@@ -0,0 +1,50 @@
+package sfs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tymbaca/sfs/internal/codes"
+	"github.com/tymbaca/sfs/internal/common"
+)
+
+// handleDeleteChunk deletes the chunk. If tombstone is true, the delete is
+// remembered, so repair doesn't copy the chunk back.
+func (s *Server) handleDeleteChunk(ctx context.Context, conn io.ReadWriter, tombstone bool) error {
+	var filenameSize uint64
+	if err := binary.Read(conn, binary.LittleEndian, &filenameSize); err != nil {
+		return fmt.Errorf("can't read filename size from request: %w", err)
+	}
+
+	filename := make([]byte, filenameSize)
+	_, err := io.ReadFull(conn, filename)
+	if err != nil {
+		return fmt.Errorf("can't read filename from request: %w", err)
+	}
+
+	var id uint64
+	if err := binary.Read(conn, binary.LittleEndian, &id); err != nil {
+		return fmt.Errorf("can't read ID from request: %w", err)
+	}
+
+	del := s.storage.DeleteChunk
+	if rs, ok := s.storage.(replicaStorage); ok && tombstone {
+		del = rs.TombstoneChunk
+	}
+
+	if err := del(ctx, string(filename), id); err != nil {
+		if errors.Is(err, common.ErrNotFound) {
+			// normal case, not an error
+			return writeCode(conn, codes.NotFound)
+		}
+
+		err = fmt.Errorf("can't delete chunk from storage: %w", err)
+		writeCodeMsg(conn, codes.Internal, err.Error())
+		return err
+	}
+
+	return writeCodeMsg(conn, codes.Ok, "deleted")
+}
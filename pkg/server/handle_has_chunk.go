@@ -0,0 +1,41 @@
+package sfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/tymbaca/sfs/internal/codes"
+)
+
+func (s *Server) handleHasChunk(ctx context.Context, conn io.ReadWriter) error {
+	var filenameSize uint64
+	if err := binary.Read(conn, binary.LittleEndian, &filenameSize); err != nil {
+		return fmt.Errorf("can't read filename size from request: %w", err)
+	}
+
+	filename := make([]byte, filenameSize)
+	_, err := io.ReadFull(conn, filename)
+	if err != nil {
+		return fmt.Errorf("can't read filename from request: %w", err)
+	}
+
+	var id uint64
+	if err := binary.Read(conn, binary.LittleEndian, &id); err != nil {
+		return fmt.Errorf("can't read ID from request: %w", err)
+	}
+
+	has, err := s.storage.HasChunk(ctx, string(filename), id)
+	if err != nil {
+		err = fmt.Errorf("can't check chunk in storage: %w", err)
+		writeCodeMsg(conn, codes.Internal, err.Error())
+		return err
+	}
+
+	if !has {
+		return writeCode(conn, codes.NotFound)
+	}
+
+	return writeCode(conn, codes.Ok)
+}
@@ -0,0 +1,44 @@
+package sfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/tymbaca/sfs/internal/codes"
+)
+
+func (s *Server) handleListFiles(ctx context.Context, conn io.ReadWriter) error {
+	names, err := s.storage.ListFiles(ctx)
+	if err != nil {
+		err = fmt.Errorf("can't list files from storage: %w", err)
+		writeCodeMsg(conn, codes.Internal, err.Error())
+		return err
+	}
+
+	return writeListFilesResp(conn, names)
+}
+
+func writeListFilesResp(w io.Writer, names []string) error {
+	if err := writeCode(w, codes.Ok); err != nil {
+		return fmt.Errorf("can't write OK: %w", err)
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(names))); err != nil {
+		return fmt.Errorf("can't write names len: %w", err)
+	}
+
+	for _, name := range names {
+		// we need len of bytes, not len of utf-8 symbols, so we use [len]
+		if err := binary.Write(w, binary.LittleEndian, uint64(len(name))); err != nil {
+			return fmt.Errorf("can't write filename size: %w", err)
+		}
+
+		if _, err := w.Write([]byte(name)); err != nil {
+			return fmt.Errorf("can't write filename: %w", err)
+		}
+	}
+
+	return nil
+}
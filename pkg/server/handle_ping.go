@@ -0,0 +1,12 @@
+package sfs
+
+import (
+	"context"
+	"io"
+
+	"github.com/tymbaca/sfs/internal/codes"
+)
+
+func (s *Server) handlePing(ctx context.Context, conn io.ReadWriter) error {
+	return writeCodeMsg(conn, codes.Ok, "pong")
+}
@@ -18,6 +18,9 @@ func (s *Server) handleRecvChunk(ctx context.Context, conn io.ReadWriter) error
 		return fmt.Errorf("can't read filename size from chunk: %w", err)
 	}
 
+	ext := filenameSize&chunks.ExtFlag != 0
+	filenameSize &^= chunks.ExtFlag
+
 	filename := make([]byte, filenameSize)
 	_, err := io.ReadFull(conn, filename)
 	if err != nil {
@@ -29,6 +32,14 @@ func (s *Server) handleRecvChunk(ctx context.Context, conn io.ReadWriter) error
 		return fmt.Errorf("can't read ID from chunk: %w", err)
 	}
 
+	// old clients don't know about compression
+	var accept uint64
+	if ext {
+		if err := binary.Read(conn, binary.LittleEndian, &accept); err != nil {
+			return fmt.Errorf("can't read accepted codecs: %w", err)
+		}
+	}
+
 	chk, closeChk, err := s.storage.GetChunk(ctx, string(filename), id)
 	if err != nil {
 		if errors.Is(err, common.ErrNotFound) {
@@ -42,9 +53,24 @@ func (s *Server) handleRecvChunk(ctx context.Context, conn io.ReadWriter) error
 	}
 	defer closeChk()
 
+	if !chunks.Accepts(accept, chk.Codec) {
+		dec, err := chunks.Decompress(chk.Codec, chk.Body)
+		if err != nil {
+			err = fmt.Errorf("can't decompress the chunk: %w", err)
+			writeCodeMsg(conn, codes.Internal, err.Error())
+			return err
+		}
+		defer dec.Close()
+
+		chk.Body, chk.Codec = dec, chunks.CodecNone
+	}
+
 	if err := writeCode(conn, codes.Ok); err != nil {
 		return fmt.Errorf("can't write OK: %w", err)
 	}
 
+	var done func()
+	chk.Body, done = s.throttle(ctx, chk.Body)
+	defer done()
 	return chunks.SendChunk(conn, chk)
 }
@@ -2,11 +2,14 @@ package sfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/tymbaca/sfs/internal/chunks"
 	"github.com/tymbaca/sfs/internal/codes"
+	"github.com/tymbaca/sfs/internal/common"
+	filestorage "github.com/tymbaca/sfs/internal/storage"
 )
 
 func (s *Server) handleSendChunk(ctx context.Context, conn io.ReadWriter) error {
@@ -14,9 +17,30 @@ func (s *Server) handleSendChunk(ctx context.Context, conn io.ReadWriter) error
 	if err != nil {
 		return fmt.Errorf("can't receive chunk from client: %w", err)
 	}
+	headerRead(conn)
+	var done func()
+	chk.Body, done = s.throttle(ctx, chk.Body)
+	defer done()
 
 	if err = s.storage.StoreChunk(ctx, chk); err != nil {
 		err = fmt.Errorf("can't store the chunk: %w", err)
+		// the client reads the response after it sent the whole chunk
+		switch {
+		case errors.Is(err, common.ErrNoSpace):
+			io.Copy(io.Discard, chk.Body)
+			writeCodeMsg(conn, codes.NoSpace, err.Error())
+			return err
+		case errors.Is(err, filestorage.ErrInvalidName):
+			io.Copy(io.Discard, chk.Body)
+			writeCodeMsg(conn, codes.InvalidReq, err.Error())
+			return err
+		case errors.Is(err, common.ErrNotFound):
+			// e.g. the bucket doesn't exist
+			io.Copy(io.Discard, chk.Body)
+			writeCodeMsg(conn, codes.NotFound, err.Error())
+			return err
+		}
+
 		writeCodeMsg(conn, codes.Internal, err.Error())
 		return err
 	}
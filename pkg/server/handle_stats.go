@@ -0,0 +1,25 @@
+package sfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/tymbaca/sfs/internal/codes"
+)
+
+func (s *Server) handleStats(ctx context.Context, conn io.ReadWriter) error {
+	stats, err := s.storage.Stats()
+	if err != nil {
+		err = fmt.Errorf("can't get storage stats: %w", err)
+		writeCodeMsg(conn, codes.Internal, err.Error())
+		return err
+	}
+
+	if err := writeCode(conn, codes.Ok); err != nil {
+		return fmt.Errorf("can't write OK: %w", err)
+	}
+
+	return binary.Write(conn, binary.LittleEndian, []uint64{stats.Used, stats.Free, stats.Total})
+}
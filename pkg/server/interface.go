@@ -4,10 +4,18 @@ import (
 	"context"
 
 	"github.com/tymbaca/sfs/internal/chunks"
+	filestorage "github.com/tymbaca/sfs/internal/storage"
 )
 
 type storage interface {
 	StoreChunk(ctx context.Context, chunk chunks.Chunk) error
 	GetChunk(ctx context.Context, name string, id uint64) (chunks.Chunk, func() error, error)
+	HasChunk(ctx context.Context, name string, id uint64) (bool, error)
 	ListChunkIDs(ctx context.Context, name string) ([]uint64, error)
+	ListFiles(ctx context.Context) ([]string, error)
+	DeleteChunk(ctx context.Context, name string, id uint64) error
+	Stats() (filestorage.Stats, error)
+	CreateBucket(ctx context.Context, bucket filestorage.Bucket) error
+	ListBuckets(ctx context.Context) ([]filestorage.BucketStats, error)
+	DeleteBucket(ctx context.Context, name string) error
 }
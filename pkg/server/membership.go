@@ -0,0 +1,313 @@
+package sfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/codes"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/member"
+	"github.com/tymbaca/sfs/internal/transport"
+)
+
+type MembershipConfig struct {
+	// Self is the address of this node as clients and other nodes see it.
+	Self string
+	// Seeds are the nodes contacted to join the cluster. They come first in
+	// the member list in this order, so every node must have the same seeds,
+	// listed the same way as in the clients' SFS_ADDRS. One is enough to join.
+	Seeds []string
+	// GossipInterval is the period of heartbeats and gossip rounds.
+	GossipInterval time.Duration
+	// SuspectTimeout is the time without heartbeat after which the member is
+	// suspected. It becomes dead after 2*SuspectTimeout. Dead and left members
+	// are never forgotten, as clients place chunks by the member list.
+	SuspectTimeout time.Duration
+	// Fanout is the count of members gossiped with each round. Defaults to 3.
+	Fanout int
+}
+
+type membership struct {
+	cfg MembershipConfig
+
+	mu      sync.Mutex
+	members map[string]*memberEntry
+}
+
+type memberEntry struct {
+	member.Member
+	// updated is when the heartbeat of the member was increased last time,
+	// in local time.
+	updated time.Time
+}
+
+func newMembership(cfg MembershipConfig) *membership {
+	if cfg.Fanout < 1 {
+		cfg.Fanout = 3
+	}
+
+	return &membership{
+		cfg: cfg,
+		members: map[string]*memberEntry{
+			cfg.Self: {Member: member.Member{Addr: cfg.Self, State: member.Alive, Joined: uint64(time.Now().UnixNano())}, updated: time.Now()},
+		},
+	}
+}
+
+// RunMembership joins the cluster through the seeds and gossips with other
+// members until ctx is done. On ctx done the node leaves the cluster.
+func (s *Server) RunMembership(ctx context.Context, cfg MembershipConfig) error {
+	m := newMembership(cfg)
+
+	s.membershipMu.Lock()
+	s.membership = m
+	s.membershipMu.Unlock()
+
+	for {
+		m.tick(time.Now())
+		m.gossip(ctx)
+
+		select {
+		case <-ctx.Done():
+			m.leave(context.WithoutCancel(ctx))
+			return ctx.Err()
+		case <-time.After(cfg.GossipInterval):
+		}
+	}
+}
+
+// Members returns the cluster members known to this node in the placement
+// order (see [member.Sort]). Returns nil if membership is not running.
+func (s *Server) Members() []member.Member {
+	s.membershipMu.Lock()
+	m := s.membership
+	s.membershipMu.Unlock()
+
+	if m == nil {
+		return nil
+	}
+
+	return m.list()
+}
+
+func (m *membership) list() []member.Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]member.Member, 0, len(m.members))
+	for _, e := range m.members {
+		list = append(list, e.Member)
+	}
+
+	member.Sort(list, m.cfg.Seeds)
+
+	return list
+}
+
+// tick increments own heartbeat and detects failures.
+func (m *membership) tick(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	self := m.members[m.cfg.Self]
+	self.Heartbeat++
+	self.updated = now
+
+	for addr, e := range m.members {
+		if addr == m.cfg.Self {
+			continue
+		}
+
+		silence := now.Sub(e.updated)
+		switch {
+		case silence > 2*m.cfg.SuspectTimeout && e.State == member.Suspect:
+			e.State = member.Dead
+			logger.Logf("membership: member '%s' is dead", addr)
+		case silence > m.cfg.SuspectTimeout && e.State == member.Alive:
+			e.State = member.Suspect
+			logger.Logf("membership: member '%s' is suspected", addr)
+		}
+	}
+}
+
+// merge merges the members received from other node.
+func (m *membership) merge(remote []member.Member, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, rm := range remote {
+		if rm.Addr == m.cfg.Self {
+			// others think we are not alive, refute it
+			self := m.members[m.cfg.Self]
+			self.Joined = earliest(self.Joined, rm.Joined)
+			if self.State == member.Alive && rm.State != member.Alive && rm.Heartbeat >= self.Heartbeat {
+				self.Heartbeat = rm.Heartbeat + 1
+			}
+			continue
+		}
+
+		local, ok := m.members[rm.Addr]
+		if !ok {
+			if rm.State == member.Alive || rm.State == member.Suspect {
+				logger.Logf("membership: member '%s' joined", rm.Addr)
+			}
+			m.members[rm.Addr] = &memberEntry{Member: rm, updated: now}
+			continue
+		}
+
+		joined := earliest(local.Joined, rm.Joined)
+		local.Joined = joined
+		if !rm.Supersedes(local.Member) {
+			continue
+		}
+
+		if rm.State != local.State {
+			logger.Logf("membership: member '%s' is %s", rm.Addr, rm.State)
+		}
+
+		if rm.Heartbeat > local.Heartbeat {
+			local.updated = now
+		}
+		local.Member = rm
+		local.Joined = joined
+	}
+}
+
+// earliest returns the earliest of the known join times. Restarted member
+// gets its join time back from the others, so it keeps its place.
+func earliest(a, b uint64) uint64 {
+	switch {
+	case a == 0:
+		return b
+	case b == 0:
+		return a
+	}
+	return min(a, b)
+}
+
+// gossip exchanges members with random peers. Seeds are used while no peers
+// are known.
+func (m *membership) gossip(ctx context.Context) {
+	list := m.list()
+
+	var peers []string
+	for _, mem := range list {
+		if mem.Addr != m.cfg.Self && (mem.State == member.Alive || mem.State == member.Suspect) {
+			peers = append(peers, mem.Addr)
+		}
+	}
+
+	if len(peers) == 0 {
+		peers = slices.DeleteFunc(slices.Clone(m.cfg.Seeds), func(addr string) bool { return addr == m.cfg.Self })
+	}
+
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > m.cfg.Fanout {
+		peers = peers[:m.cfg.Fanout]
+	}
+
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.exchange(ctx, peer, list); err != nil {
+				logger.Debugf("membership: can't gossip with '%s': %s", peer, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (m *membership) exchange(ctx context.Context, peer string, list []member.Member) error {
+	ctx, cancel := context.WithTimeout(ctx, max(m.cfg.GossipInterval, time.Second))
+	defer cancel()
+
+	trans := transport.NewTCPTransport(peer)
+	defer trans.Close()
+
+	remote, err := trans.Gossip(ctx, list)
+	if err != nil {
+		return err
+	}
+
+	m.merge(remote, time.Now())
+	return nil
+}
+
+// leave announces that this node leaves the cluster.
+func (m *membership) leave(ctx context.Context) {
+	m.mu.Lock()
+	self := m.members[m.cfg.Self]
+	self.Heartbeat++
+	self.State = member.Left
+	m.mu.Unlock()
+
+	list := m.list()
+	var wg sync.WaitGroup
+	for _, mem := range list {
+		if mem.Addr == m.cfg.Self || mem.State != member.Alive {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.exchange(ctx, mem.Addr, list)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (s *Server) getMembership() (*membership, error) {
+	s.membershipMu.Lock()
+	defer s.membershipMu.Unlock()
+
+	if s.membership == nil {
+		return nil, fmt.Errorf("membership is not running on the node")
+	}
+
+	return s.membership, nil
+}
+
+func (s *Server) handleGossip(ctx context.Context, conn io.ReadWriter) error {
+	remote, err := member.Read(conn)
+	if err != nil {
+		return fmt.Errorf("can't read members from request: %w", err)
+	}
+
+	m, err := s.getMembership()
+	if err != nil {
+		writeCodeMsg(conn, codes.Internal, err.Error())
+		return err
+	}
+
+	m.merge(remote, time.Now())
+
+	if err := writeCode(conn, codes.Ok); err != nil {
+		return fmt.Errorf("can't write OK: %w", err)
+	}
+
+	return member.Write(conn, m.list())
+}
+
+func (s *Server) handleMembers(ctx context.Context, conn io.ReadWriter) error {
+	m, err := s.getMembership()
+	if err != nil {
+		writeCodeMsg(conn, codes.Internal, err.Error())
+		return err
+	}
+
+	if err := writeCode(conn, codes.Ok); err != nil {
+		return fmt.Errorf("can't write OK: %w", err)
+	}
+
+	return member.Write(conn, m.list())
+}
@@ -0,0 +1,114 @@
+package sfs_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/member"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/internal/transport"
+	sfs_client "github.com/tymbaca/sfs/pkg/client"
+	sfs "github.com/tymbaca/sfs/pkg/server"
+)
+
+func TestMembership(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 4)
+	nodes, joiner := nodes[:3], nodes[3]
+	seed := nodes[0].Addr
+
+	cancels := make([]context.CancelFunc, len(nodes))
+	run := func(n testcluster.Node) context.CancelFunc {
+		mctx, cancel := context.WithCancel(ctx)
+		t.Cleanup(cancel)
+
+		go n.Server.RunMembership(mctx, sfs.MembershipConfig{
+			Self:           n.Addr,
+			Seeds:          []string{seed},
+			GossipInterval: 10 * time.Millisecond,
+			SuspectTimeout: 200 * time.Millisecond,
+		})
+		return cancel
+	}
+	for i, n := range nodes {
+		cancels[i] = run(n)
+	}
+
+	states := func(n testcluster.Node) map[string]member.State {
+		res := make(map[string]member.State)
+		for _, m := range n.Server.Members() {
+			res[m.Addr] = m.State
+		}
+		return res
+	}
+
+	// everyone joins through the seed
+	require.Eventually(t, func() bool {
+		for _, n := range nodes {
+			if len(states(n)) != 3 {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// failure of the node which never heartbeats spreads
+	const ghost = "127.0.0.1:1"
+	trans := transport.NewTCPTransport(nodes[1].Addr)
+	_, err := trans.Gossip(ctx, []member.Member{{Addr: ghost, Heartbeat: 1, State: member.Alive}})
+	trans.Close()
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return states(nodes[0])[ghost] == member.Dead && states(nodes[2])[ghost] == member.Dead
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// client bootstraps from the single seed
+	client, err := sfs_client.Bootstrap(ctx, seed, 512)
+	require.NoError(t, err)
+	require.Equal(t, map[string]sfs_client.NodeState{
+		nodes[0].Addr: sfs_client.NodeUp,
+		nodes[1].Addr: sfs_client.NodeUp,
+		nodes[2].Addr: sfs_client.NodeUp,
+		ghost:         sfs_client.NodeDown,
+	}, client.NodeStates())
+
+	// leave spreads
+	cancels[2]()
+	require.Eventually(t, func() bool {
+		return states(nodes[0])[nodes[2].Addr] == member.Left && states(nodes[1])[nodes[2].Addr] == member.Left
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// dead and left members are kept, so the placement doesn't change
+	time.Sleep(10 * 200 * time.Millisecond)
+	client, err = sfs_client.Bootstrap(ctx, seed, 512)
+	require.NoError(t, err)
+	require.Equal(t, map[string]sfs_client.NodeState{
+		nodes[0].Addr: sfs_client.NodeUp,
+		nodes[1].Addr: sfs_client.NodeUp,
+		nodes[2].Addr: sfs_client.NodeDown,
+		ghost:         sfs_client.NodeDown,
+	}, client.NodeStates())
+
+	// joining node goes last on every node, the seed stays first
+	order := func(n testcluster.Node) []string {
+		var res []string
+		for _, m := range n.Server.Members() {
+			res = append(res, m.Addr)
+		}
+		return res
+	}
+
+	run(joiner)
+	require.Eventually(t, func() bool {
+		want := order(nodes[0])
+		if len(want) != 5 || want[0] != seed || want[4] != joiner.Addr {
+			return false
+		}
+		return slices.Equal(want, order(nodes[1])) && slices.Equal(want, order(joiner))
+	}, 2*time.Second, 10*time.Millisecond)
+}
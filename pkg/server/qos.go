@@ -0,0 +1,213 @@
+package sfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/ratelimit"
+	"github.com/tymbaca/sfs/pkg/qos"
+	"golang.org/x/time/rate"
+)
+
+// DefaultMinShares are the minimum shares of the classes used when
+// [QoSConfig.MinShares] is nil.
+var DefaultMinShares = map[qos.Class]float64{qos.Batch: 0.2, qos.Background: 0.1}
+
+// shareInterval is how often the paused transfer checks its share.
+const shareInterval = 50 * time.Millisecond
+
+type QoSConfig struct {
+	// ConnBytesPerSec limits the rate of chunk bodies sent or received over
+	// each connection. Non-positive means unlimited.
+	ConnBytesPerSec int64
+	// BytesPerSec limits the total rate of chunk bodies. Transfers of the
+	// lower class pause while there are ones of the higher class, e.g.
+	// background ones wait for interactive and batch ones, unless the class
+	// got less than its minimum share. Non-positive means unlimited, then
+	// nothing is shared and classes are ignored.
+	BytesPerSec int64
+	// MinShares are the fractions of BytesPerSec the classes get while the
+	// higher ones are running, so they are not starved. Classes without the
+	// share are paused until the higher ones end. Nil means
+	// [DefaultMinShares].
+	MinShares map[qos.Class]float64
+}
+
+// SetQoS sets the bandwidth limits of the following requests.
+func (s *Server) SetQoS(cfg QoSConfig) {
+	s.qosMu.Lock()
+	defer s.qosMu.Unlock()
+
+	if cfg.MinShares == nil {
+		cfg.MinShares = DefaultMinShares
+	}
+
+	s.qos = cfg
+	s.bandwidth = newGate(ratelimit.NewLimiter(cfg.BytesPerSec), cfg.MinShares)
+}
+
+// readClass reads the class of the request which starts with the '~' prefix.
+func readClass(r io.Reader) (qos.Class, error) {
+	var class uint64
+	if err := binary.Read(r, binary.LittleEndian, &class); err != nil {
+		return 0, fmt.Errorf("can't read the class: %w", err)
+	}
+
+	if class >= qos.Classes {
+		return 0, fmt.Errorf("unknown class %d", class)
+	}
+
+	return qos.Class(class), nil
+}
+
+// throttle limits the rate of chunk body r by the connection and total
+// limits. done must be called when the transfer ends.
+func (s *Server) throttle(ctx context.Context, r io.Reader) (io.Reader, func()) {
+	s.qosMu.Lock()
+	cfg, g := s.qos, s.bandwidth
+	s.qosMu.Unlock()
+
+	if cfg.ConnBytesPerSec > 0 {
+		r = ratelimit.NewReader(ctx, r, ratelimit.NewLimiter(cfg.ConnBytesPerSec))
+	}
+
+	if cfg.BytesPerSec <= 0 {
+		return r, func() {}
+	}
+
+	class := qos.ClassFrom(ctx)
+	done := g.start(class)
+	return &gateReader{ctx: ctx, r: r, gate: g, class: class}, done
+}
+
+// gate shares the limiter between the transfers by priority: the transfer
+// waits for the tokens only when there are no running transfers of the
+// higher classes, or its class got less than its minimum share of the bytes
+// served lately.
+type gate struct {
+	lim       *rate.Limiter
+	minShares [qos.Classes]float64
+
+	mu      sync.Mutex
+	running [qos.Classes]int
+	served  [qos.Classes]float64 // bytes, halved each second
+	decayed time.Time
+	changed chan struct{} // closed when running transfers of any class end
+}
+
+func newGate(lim *rate.Limiter, minShares map[qos.Class]float64) *gate {
+	g := &gate{lim: lim, decayed: time.Now(), changed: make(chan struct{})}
+	for class, share := range minShares {
+		if class < qos.Classes {
+			g.minShares[class] = share
+		}
+	}
+
+	return g
+}
+
+func (g *gate) start(class qos.Class) func() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.running[class]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+
+			g.running[class]--
+			close(g.changed)
+			g.changed = make(chan struct{})
+		})
+	}
+}
+
+func (g *gate) waitN(ctx context.Context, class qos.Class, n int) error {
+	for {
+		g.mu.Lock()
+		preempted := g.preempted(class)
+		changed := g.changed
+		g.mu.Unlock()
+
+		if !preempted {
+			break
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(shareInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if err := g.lim.WaitN(ctx, n); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.served[class] += float64(n)
+	g.mu.Unlock()
+
+	return nil
+}
+
+// preempted tells if the transfer of class must wait for the higher ones.
+func (g *gate) preempted(class qos.Class) bool {
+	higher := false
+	for c := range class {
+		higher = higher || g.running[c] > 0
+	}
+	if !higher {
+		return false
+	}
+
+	// recent bytes weigh more, so the share follows the current load
+	now := time.Now()
+	if now.Sub(g.decayed) > time.Minute {
+		g.served = [qos.Classes]float64{}
+		g.decayed = now
+	}
+	for ; now.Sub(g.decayed) > time.Second; g.decayed = g.decayed.Add(time.Second) {
+		for c := range g.served {
+			g.served[c] /= 2
+		}
+	}
+
+	total := 0.0
+	for _, served := range g.served {
+		total += served
+	}
+
+	return g.served[class] >= g.minShares[class]*total
+}
+
+type gateReader struct {
+	ctx   context.Context
+	r     io.Reader
+	gate  *gate
+	class qos.Class
+}
+
+func (r *gateReader) Read(p []byte) (int, error) {
+	// never ask for more tokens than bucket can hold
+	if burst := r.gate.lim.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.gate.waitN(r.ctx, r.class, n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
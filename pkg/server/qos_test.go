@@ -0,0 +1,87 @@
+package sfs_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/mem"
+	"github.com/tymbaca/sfs/pkg/qos"
+	sfs "github.com/tymbaca/sfs/pkg/server"
+)
+
+func TestQoS(t *testing.T) {
+	ctx := context.Background()
+
+	node := startQoSNode(t, sfs.QoSConfig{BytesPerSec: mem.MiB}, map[string]int64{"background": 3 * mem.MiB, "interactive": mem.MiB})
+	recv := qosRecv(t, node)
+
+	// background transfer pauses until interactive one is done, even though
+	// it started first, but for its minimum share
+	background := recv(qos.WithClass(ctx, qos.Background), "background")
+	time.Sleep(100 * time.Millisecond)
+	interactive := recv(ctx, "interactive")
+
+	interactiveDone := <-interactive
+	backgroundDone := <-background
+	require.True(t, interactiveDone.Before(backgroundDone))
+	require.Greater(t, backgroundDone.Sub(interactiveDone), 500*time.Millisecond)
+}
+
+func TestQoSMinShare(t *testing.T) {
+	ctx := context.Background()
+
+	node := startQoSNode(t, sfs.QoSConfig{
+		BytesPerSec: mem.MiB,
+		MinShares:   map[qos.Class]float64{qos.Background: 0.5},
+	}, map[string]int64{"background": mem.MiB, "interactive": 3 * mem.MiB})
+	recv := qosRecv(t, node)
+
+	// background transfer is not starved by the interactive one
+	interactive := recv(ctx, "interactive")
+	time.Sleep(100 * time.Millisecond)
+	background := recv(qos.WithClass(ctx, qos.Background), "background")
+
+	backgroundDone := <-background
+	interactiveDone := <-interactive
+	require.True(t, backgroundDone.Before(interactiveDone))
+}
+
+func startQoSNode(t *testing.T, cfg sfs.QoSConfig, sizes map[string]int64) testcluster.Node {
+	node := testcluster.StartNodes(t, 1)[0]
+	node.Server.SetQoS(cfg)
+
+	for name, size := range sizes {
+		data := make([]byte, size)
+		require.NoError(t, node.Storage.StoreChunk(context.Background(), chunks.Chunk{Filename: name, Size: uint64(size), Body: bytes.NewReader(data)}))
+	}
+
+	return node
+}
+
+func qosRecv(t *testing.T, node testcluster.Node) func(ctx context.Context, name string) <-chan time.Time {
+	return func(ctx context.Context, name string) <-chan time.Time {
+		done := make(chan time.Time, 1)
+		go func() {
+			trans := transport.NewTCPTransport(node.Addr)
+			defer trans.Close()
+
+			chk, err := trans.RecvChunk(ctx, name, 0)
+			if err == nil {
+				_, err = io.Copy(io.Discard, chk.Body)
+			}
+			if err != nil {
+				t.Errorf("can't receive '%s': %s", name, err)
+			}
+			done <- time.Now()
+		}()
+
+		return done
+	}
+}
@@ -0,0 +1,604 @@
+package sfs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/codes"
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/placement"
+	"github.com/tymbaca/sfs/internal/ratelimit"
+	"github.com/tymbaca/sfs/internal/transport"
+	"github.com/tymbaca/sfs/pkg/qos"
+	"golang.org/x/time/rate"
+)
+
+// Layout describes the cluster as the clients see it.
+type Layout struct {
+	// Self is the address of this node as it's listed in Nodes.
+	Self string
+	// Nodes is the cluster addresses in the same order as clients use.
+	Nodes []string
+	// Replicas is the count of nodes each chunk is stored on.
+	Replicas int
+}
+
+// mustHold reports whether node addr must hold the chunk.
+func (l Layout) mustHold(addr, name string, id uint64) bool {
+	return slices.Contains(placement.Nodes(l.Nodes, name, id, l.Replicas), addr)
+}
+
+// DefaultTombstoneTTL is the default [RepairConfig.TombstoneTTL].
+const DefaultTombstoneTTL = 7 * 24 * time.Hour
+
+type RepairConfig struct {
+	Layout Layout
+	// Interval between the end of one repair pass and the start of the next one.
+	Interval time.Duration
+	// BytesPerSec limits the rate of exchanged chunks. Non-positive means
+	// unlimited.
+	BytesPerSec int64
+	// TombstoneTTL is how long the deletes are remembered, so the replicas
+	// which missed them are deleted instead of copied back. Zero means
+	// [DefaultTombstoneTTL].
+	TombstoneTTL time.Duration
+}
+
+type RepairStats struct {
+	Pulled int
+	Pushed int
+	// Conflicts is the count of chunks whose replicas differed, they are
+	// resolved by the newer one.
+	Conflicts int
+	// Deleted is the count of replicas deleted as their chunks were deleted
+	// on another node after they were written.
+	Deleted int
+	Bytes   int64
+}
+
+type summer interface {
+	ChunkSums(ctx context.Context, name string) ([]chunks.Sum, error)
+}
+
+// replicaStorage keeps the times the chunks were written and deleted, so
+// repair resolves the replicas which differ.
+type replicaStorage interface {
+	ChunkReplicas(ctx context.Context, name string) ([]chunks.Replica, error)
+	SetChunkStamp(ctx context.Context, name string, id uint64, stamp time.Time) error
+	TombstoneChunk(ctx context.Context, name string, id uint64) error
+	PurgeTombstones(ctx context.Context, before time.Time) error
+}
+
+// SetLayout sets the cluster layout. It's needed to answer the filtered sums
+// requests of other nodes.
+func (s *Server) SetLayout(layout Layout) {
+	s.layoutMu.Lock()
+	defer s.layoutMu.Unlock()
+
+	s.layout = &layout
+}
+
+func (s *Server) getLayout() *Layout {
+	s.layoutMu.Lock()
+	defer s.layoutMu.Unlock()
+
+	return s.layout
+}
+
+// RunRepair periodically compares the chunks of this node with its replica
+// peers and exchanges the chunks that differ until ctx is done. Its requests
+// have the background class.
+func (s *Server) RunRepair(ctx context.Context, cfg RepairConfig) error {
+	ctx = qos.WithClass(ctx, qos.Background)
+	s.SetLayout(cfg.Layout)
+	lim := ratelimit.NewLimiter(cfg.BytesPerSec)
+
+	ttl := cfg.TombstoneTTL
+	if ttl <= 0 {
+		ttl = DefaultTombstoneTTL
+	}
+
+	for {
+		stats, err := s.repair(ctx, cfg.Layout, lim, ttl)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Logf("repair failed: %s", err)
+		} else {
+			logger.Logf("repair done: pulled %d, pushed %d chunks (%d bytes), %d conflicts, %d deleted", stats.Pulled, stats.Pushed, stats.Bytes, stats.Conflicts, stats.Deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cfg.Interval):
+		}
+	}
+}
+
+// Repair makes a single repair pass with every peer of the layout.
+func (s *Server) Repair(ctx context.Context, layout Layout) (RepairStats, error) {
+	s.SetLayout(layout)
+	return s.repair(ctx, layout, ratelimit.NewLimiter(0), DefaultTombstoneTTL)
+}
+
+func (s *Server) repair(ctx context.Context, layout Layout, lim *rate.Limiter, ttl time.Duration) (RepairStats, error) {
+	buckets, err := s.storage.ListBuckets(ctx)
+	if err != nil {
+		return RepairStats{}, fmt.Errorf("can't list buckets: %w", err)
+	}
+
+	// the default bucket first
+	names := []string{""}
+	for _, b := range buckets {
+		names = append(names, b.Name)
+	}
+
+	rs, ok := s.storage.(replicaStorage)
+	if !ok {
+		return RepairStats{}, fmt.Errorf("storage %T doesn't support replica states", s.storage)
+	}
+
+	var total RepairStats
+	for _, bucket := range names {
+		ctx := common.WithBucket(ctx, bucket)
+		if err := rs.PurgeTombstones(ctx, time.Now().Add(-ttl)); err != nil {
+			logger.Logf("can't purge tombstones of bucket '%s': %s", bucket, err)
+		}
+
+		for _, peer := range layout.Nodes {
+			if peer == layout.Self {
+				continue
+			}
+
+			stats, err := s.repairWith(ctx, layout, peer, lim)
+			total.Pulled += stats.Pulled
+			total.Pushed += stats.Pushed
+			total.Conflicts += stats.Conflicts
+			total.Deleted += stats.Deleted
+			total.Bytes += stats.Bytes
+			if err != nil {
+				if ctx.Err() != nil {
+					return total, ctx.Err()
+				}
+
+				// unavailable peer must not stop the repair of others
+				logger.Logf("can't repair bucket '%s' with '%s': %s", bucket, peer, err)
+			}
+		}
+	}
+
+	return total, nil
+}
+
+func (s *Server) repairWith(ctx context.Context, layout Layout, peer string, lim *rate.Limiter) (RepairStats, error) {
+	var stats RepairStats
+
+	remote, err := withTrans(peer, func(t transport.Transport) ([]chunks.FileSum, error) {
+		return t.FileSums(ctx, layout.Self)
+	})
+	if err != nil {
+		return stats, fmt.Errorf("can't get file sums: %w", err)
+	}
+
+	local, err := s.fileSums(ctx, layout, peer)
+	if err != nil {
+		return stats, fmt.Errorf("can't get local file sums: %w", err)
+	}
+
+	for _, name := range diffFileSums(local, remote) {
+		remoteReplicas, err := withTrans(peer, func(t transport.Transport) ([]chunks.Replica, error) {
+			return t.ChunkReplicas(ctx, layout.Self, name)
+		})
+		if err != nil {
+			return stats, fmt.Errorf("can't get chunk replicas of '%s': %w", name, err)
+		}
+
+		localReplicas, err := s.chunkReplicas(ctx, layout, peer, name)
+		if err != nil {
+			return stats, fmt.Errorf("can't get local chunk replicas of '%s': %w", name, err)
+		}
+
+		localByID := make(map[uint64]chunks.Replica, len(localReplicas))
+		for _, r := range localReplicas {
+			localByID[r.ID] = r
+		}
+
+		for _, rr := range remoteReplicas {
+			lr := localByID[rr.ID]
+			delete(localByID, rr.ID)
+
+			if err := s.repairChunk(ctx, peer, name, lr, rr, lim, &stats); err != nil {
+				return stats, err
+			}
+		}
+
+		for _, lr := range localByID {
+			if err := s.repairChunk(ctx, peer, name, lr, chunks.Replica{}, lim, &stats); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// repairChunk makes the local and remote replicas of the chunk the same. The
+// zero replica is the missing one. Replicas which differ are resolved by the
+// newer one, the chunk written after its delete is copied back, otherwise
+// the delete wins.
+func (s *Server) repairChunk(ctx context.Context, peer, name string, local, remote chunks.Replica, lim *rate.Limiter, stats *RepairStats) error {
+	localLive := !local.Stamp.IsZero() && !local.Deleted
+	remoteLive := !remote.Stamp.IsZero() && !remote.Deleted
+
+	switch {
+	case !localLive && !remoteLive:
+		return nil
+
+	case localLive && remoteLive:
+		if local.Sum == remote.Sum {
+			return nil
+		}
+
+		logger.Logf("repair: conflict of '%s' chunk %d with '%s': local size %d crc %x at %s, remote size %d crc %x at %s",
+			name, local.ID, peer, local.Size, local.CRC, local.Stamp, remote.Size, remote.CRC, remote.Stamp)
+		stats.Conflicts++
+
+		if remote.Stamp.After(local.Stamp) {
+			return s.pull(ctx, peer, name, remote, lim, stats)
+		}
+		return s.push(ctx, peer, name, local, lim, stats)
+
+	case remoteLive:
+		if local.Deleted && local.Stamp.After(remote.Stamp) {
+			if err := withTransErr(peer, func(t transport.Transport) error {
+				return t.TombstoneChunk(ctx, name, remote.ID)
+			}); err != nil && !errors.Is(err, common.ErrNotFound) {
+				return fmt.Errorf("can't delete '%s' chunk %d on peer: %w", name, remote.ID, err)
+			}
+			stats.Deleted++
+			return nil
+		}
+		return s.pull(ctx, peer, name, remote, lim, stats)
+
+	default:
+		if remote.Deleted && remote.Stamp.After(local.Stamp) {
+			rs := s.storage.(replicaStorage)
+			if err := rs.TombstoneChunk(ctx, name, local.ID); err != nil && !errors.Is(err, common.ErrNotFound) {
+				return fmt.Errorf("can't delete '%s' chunk %d: %w", name, local.ID, err)
+			}
+			stats.Deleted++
+			return nil
+		}
+		return s.push(ctx, peer, name, local, lim, stats)
+	}
+}
+
+func (s *Server) pull(ctx context.Context, peer, name string, remote chunks.Replica, lim *rate.Limiter, stats *RepairStats) error {
+	if err := s.pullChunk(ctx, peer, name, remote.ID, lim); err != nil {
+		return err
+	}
+
+	// the copy is as old as the replica, so the later deletes still win
+	if err := s.storage.(replicaStorage).SetChunkStamp(ctx, name, remote.ID, remote.Stamp); err != nil {
+		return fmt.Errorf("can't set time of pulled '%s' chunk %d: %w", name, remote.ID, err)
+	}
+
+	stats.Pulled++
+	stats.Bytes += int64(remote.Size)
+	return nil
+}
+
+// push sends the chunk to peer, which stamps the copy with the time it's
+// received.
+func (s *Server) push(ctx context.Context, peer, name string, local chunks.Replica, lim *rate.Limiter, stats *RepairStats) error {
+	if err := s.pushChunk(ctx, peer, name, local.ID, lim); err != nil {
+		return err
+	}
+
+	stats.Pushed++
+	stats.Bytes += int64(local.Size)
+	return nil
+}
+
+func (s *Server) pullChunk(ctx context.Context, peer, name string, id uint64, lim *rate.Limiter) error {
+	trans := transport.NewTCPTransport(peer)
+	defer trans.Close()
+
+	chk, err := trans.RecvChunk(ctx, name, id)
+	if err != nil {
+		return fmt.Errorf("can't pull '%s' chunk %d: %w", name, id, err)
+	}
+
+	chk.Body = ratelimit.NewReader(ctx, chk.Body, lim)
+	if err := s.storage.StoreChunk(ctx, chk); err != nil {
+		return fmt.Errorf("can't store pulled '%s' chunk %d: %w", name, id, err)
+	}
+
+	return nil
+}
+
+func (s *Server) pushChunk(ctx context.Context, peer, name string, id uint64, lim *rate.Limiter) error {
+	chk, closeChk, err := s.storage.GetChunk(ctx, name, id)
+	if err != nil {
+		return fmt.Errorf("can't get '%s' chunk %d to push: %w", name, id, err)
+	}
+	defer closeChk()
+
+	trans := transport.NewTCPTransport(peer)
+	defer trans.Close()
+
+	chk.Body = ratelimit.NewReader(ctx, chk.Body, lim)
+	if err := trans.SendChunk(ctx, chk); err != nil {
+		return fmt.Errorf("can't push '%s' chunk %d: %w", name, id, err)
+	}
+
+	return nil
+}
+
+// diffFileSums returns the names of files whose summaries differ.
+func diffFileSums(a, b []chunks.FileSum) []string {
+	hashes := make(map[string][32]byte, len(a))
+	for _, sum := range a {
+		hashes[sum.Name] = sum.Hash
+	}
+
+	var names []string
+	for _, sum := range b {
+		hash, ok := hashes[sum.Name]
+		delete(hashes, sum.Name)
+		if !ok || hash != sum.Hash {
+			names = append(names, sum.Name)
+		}
+	}
+
+	for name := range hashes {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// fileSums summarizes every file on this node. If peer is not empty, only
+// chunks that both this node and peer must hold are taken into account.
+func (s *Server) fileSums(ctx context.Context, layout Layout, peer string) ([]chunks.FileSum, error) {
+	names, err := s.storage.ListFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]chunks.FileSum, 0, len(names))
+	for _, name := range names {
+		sums, err := s.chunkSums(ctx, layout, peer, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(sums) > 0 {
+			res = append(res, chunks.FileSum{Name: name, Hash: chunks.HashSums(sums)})
+		}
+	}
+
+	return res, nil
+}
+
+func (s *Server) chunkSums(ctx context.Context, layout Layout, peer, name string) ([]chunks.Sum, error) {
+	sm, ok := s.storage.(summer)
+	if !ok {
+		return nil, fmt.Errorf("storage %T doesn't support checksums", s.storage)
+	}
+
+	sums, err := sm.ChunkSums(ctx, name)
+	if errors.Is(err, common.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if peer == "" {
+		return sums, nil
+	}
+
+	return slices.DeleteFunc(sums, func(sum chunks.Sum) bool {
+		return !layout.mustHold(layout.Self, name, sum.ID) || !layout.mustHold(peer, name, sum.ID)
+	}), nil
+}
+
+// chunkReplicas returns the states of the file chunks on this node, filtered
+// by peer the same way as in [Server.chunkSums].
+func (s *Server) chunkReplicas(ctx context.Context, layout Layout, peer, name string) ([]chunks.Replica, error) {
+	rs, ok := s.storage.(replicaStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage %T doesn't support replica states", s.storage)
+	}
+
+	replicas, err := rs.ChunkReplicas(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if peer == "" {
+		return replicas, nil
+	}
+
+	return slices.DeleteFunc(replicas, func(r chunks.Replica) bool {
+		return !layout.mustHold(layout.Self, name, r.ID) || !layout.mustHold(peer, name, r.ID)
+	}), nil
+}
+
+func withTrans[T any](addr string, fn func(t transport.Transport) (T, error)) (T, error) {
+	trans := transport.NewTCPTransport(addr)
+	defer trans.Close()
+
+	return fn(trans)
+}
+
+func (s *Server) handleSums(ctx context.Context, conn io.ReadWriter) error {
+	peer, err := readString(conn)
+	if err != nil {
+		return fmt.Errorf("can't read peer from request: %w", err)
+	}
+
+	name, err := readString(conn)
+	if err != nil {
+		return fmt.Errorf("can't read filename from request: %w", err)
+	}
+
+	var layout Layout
+	if peer != "" {
+		l := s.getLayout()
+		if l == nil {
+			err := errors.New("can't filter sums by peer: node has no cluster layout")
+			writeCodeMsg(conn, codes.Internal, err.Error())
+			return err
+		}
+		layout = *l
+	}
+
+	if name == "" {
+		sums, err := s.fileSums(ctx, layout, peer)
+		if err != nil {
+			err = fmt.Errorf("can't get file sums: %w", err)
+			writeCodeMsg(conn, codes.Internal, err.Error())
+			return err
+		}
+
+		return writeFileSumsResp(conn, sums)
+	}
+
+	sums, err := s.chunkSums(ctx, layout, peer, name)
+	if err != nil {
+		err = fmt.Errorf("can't get chunk sums: %w", err)
+		writeCodeMsg(conn, codes.Internal, err.Error())
+		return err
+	}
+
+	return writeChunkSumsResp(conn, sums)
+}
+
+func withTransErr(addr string, fn func(t transport.Transport) error) error {
+	trans := transport.NewTCPTransport(addr)
+	defer trans.Close()
+
+	return fn(trans)
+}
+
+func (s *Server) handleReplicas(ctx context.Context, conn io.ReadWriter) error {
+	peer, err := readString(conn)
+	if err != nil {
+		return fmt.Errorf("can't read peer from request: %w", err)
+	}
+
+	name, err := readString(conn)
+	if err != nil {
+		return fmt.Errorf("can't read filename from request: %w", err)
+	}
+
+	var layout Layout
+	if peer != "" {
+		l := s.getLayout()
+		if l == nil {
+			err := errors.New("can't filter replicas by peer: node has no cluster layout")
+			writeCodeMsg(conn, codes.Internal, err.Error())
+			return err
+		}
+		layout = *l
+	}
+
+	replicas, err := s.chunkReplicas(ctx, layout, peer, name)
+	if err != nil {
+		err = fmt.Errorf("can't get chunk replicas: %w", err)
+		writeCodeMsg(conn, codes.Internal, err.Error())
+		return err
+	}
+
+	if err := writeCode(conn, codes.Ok); err != nil {
+		return fmt.Errorf("can't write OK: %w", err)
+	}
+
+	if err := binary.Write(conn, binary.LittleEndian, uint64(len(replicas))); err != nil {
+		return fmt.Errorf("can't write replicas len: %w", err)
+	}
+
+	for _, r := range replicas {
+		deleted := uint64(0)
+		if r.Deleted {
+			deleted = 1
+		}
+
+		raw := [5]uint64{r.ID, r.Size, uint64(r.CRC), uint64(r.Stamp.UnixNano()), deleted}
+		if err := binary.Write(conn, binary.LittleEndian, raw); err != nil {
+			return fmt.Errorf("can't write chunk replica: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func readString(r io.Reader) (string, error) {
+	var size uint64
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+func writeFileSumsResp(w io.Writer, sums []chunks.FileSum) error {
+	if err := writeCode(w, codes.Ok); err != nil {
+		return fmt.Errorf("can't write OK: %w", err)
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(sums))); err != nil {
+		return fmt.Errorf("can't write sums len: %w", err)
+	}
+
+	for _, sum := range sums {
+		// we need len of bytes, not len of utf-8 symbols, so we use [len]
+		if err := binary.Write(w, binary.LittleEndian, uint64(len(sum.Name))); err != nil {
+			return fmt.Errorf("can't write filename size: %w", err)
+		}
+
+		if _, err := w.Write([]byte(sum.Name)); err != nil {
+			return fmt.Errorf("can't write filename: %w", err)
+		}
+
+		if _, err := w.Write(sum.Hash[:]); err != nil {
+			return fmt.Errorf("can't write file hash: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func writeChunkSumsResp(w io.Writer, sums []chunks.Sum) error {
+	if err := writeCode(w, codes.Ok); err != nil {
+		return fmt.Errorf("can't write OK: %w", err)
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(sums))); err != nil {
+		return fmt.Errorf("can't write sums len: %w", err)
+	}
+
+	for _, sum := range sums {
+		if err := binary.Write(w, binary.LittleEndian, [3]uint64{sum.ID, sum.Size, uint64(sum.CRC)}); err != nil {
+			return fmt.Errorf("can't write chunk sum: %w", err)
+		}
+	}
+
+	return nil
+}
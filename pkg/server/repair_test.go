@@ -0,0 +1,178 @@
+package sfs_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tymbaca/sfs/internal/chunks"
+	"github.com/tymbaca/sfs/internal/common"
+	"github.com/tymbaca/sfs/internal/placement"
+	"github.com/tymbaca/sfs/internal/testcluster"
+	"github.com/tymbaca/sfs/internal/transport"
+	sfs_client "github.com/tymbaca/sfs/pkg/client"
+	sfs "github.com/tymbaca/sfs/pkg/server"
+)
+
+func TestRepair(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 3)
+	addrs := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		addrs = append(addrs, n.Addr)
+	}
+
+	layout := sfs.Layout{Nodes: addrs, Replicas: 2}
+	for _, n := range nodes {
+		l := layout
+		l.Self = n.Addr
+		n.Server.SetLayout(l)
+	}
+
+	const chunkCount = 30
+	data := make([]byte, chunkCount*512)
+	rand.Read(data)
+
+	client := sfs_client.NewClient(strings.Join(addrs, ","), 512, sfs_client.WithReplicas(2))
+	require.NoError(t, client.Upload(ctx, "file", bytes.NewReader(data), int64(len(data))))
+
+	// diverge: every node loses some of its chunks
+	lost := 0
+	for id := range uint64(chunkCount) {
+		holders := placement.Nodes(addrs, "file", id, 2)
+		if id%3 != 0 {
+			continue
+		}
+
+		victim := nodes[id%uint64(len(nodes))]
+		for _, h := range holders {
+			if h == victim.Addr {
+				require.NoError(t, victim.Storage.DeleteChunk(ctx, "file", id))
+				lost++
+			}
+		}
+	}
+	require.NotZero(t, lost)
+
+	healed := 0
+	for _, n := range nodes {
+		self := layout
+		self.Self = n.Addr
+
+		stats, err := n.Server.Repair(ctx, self)
+		require.NoError(t, err)
+		require.Zero(t, stats.Conflicts)
+		healed += stats.Pulled + stats.Pushed
+	}
+	require.Equal(t, lost, healed)
+
+	for id := range uint64(chunkCount) {
+		for _, h := range placement.Nodes(addrs, "file", id, 2) {
+			for _, n := range nodes {
+				if n.Addr != h {
+					continue
+				}
+
+				ids, err := n.Storage.ListChunkIDs(ctx, "file")
+				require.NoError(t, err)
+				require.Contains(t, ids, id, "node %s must hold chunk %d", h, id)
+			}
+		}
+	}
+
+	// nothing left to repair
+	for _, n := range nodes {
+		self := layout
+		self.Self = n.Addr
+
+		stats, err := n.Server.Repair(ctx, self)
+		require.NoError(t, err)
+		require.Equal(t, sfs.RepairStats{}, stats)
+	}
+}
+
+func TestRepairResolve(t *testing.T) {
+	ctx := context.Background()
+
+	nodes := testcluster.StartNodes(t, 2)
+	addrs := []string{nodes[0].Addr, nodes[1].Addr}
+	layout := sfs.Layout{Nodes: addrs, Replicas: 2}
+
+	repair := func() sfs.RepairStats {
+		var total sfs.RepairStats
+		for _, n := range nodes {
+			self := layout
+			self.Self = n.Addr
+
+			stats, err := n.Server.Repair(ctx, self)
+			require.NoError(t, err)
+			total.Pulled += stats.Pulled
+			total.Pushed += stats.Pushed
+			total.Conflicts += stats.Conflicts
+			total.Deleted += stats.Deleted
+		}
+		return total
+	}
+	read := func(n testcluster.Node, id uint64) string {
+		chk, cls, err := n.Storage.GetChunk(ctx, "file", id)
+		if errors.Is(err, common.ErrNotFound) {
+			return ""
+		}
+		require.NoError(t, err)
+		defer cls()
+
+		data, err := io.ReadAll(chk.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+	store := func(n testcluster.Node, id uint64, body string) {
+		require.NoError(t, n.Storage.StoreChunk(ctx, chunks.Chunk{ID: id, Filename: "file", Size: uint64(len(body)), Body: strings.NewReader(body)}))
+		time.Sleep(10 * time.Millisecond)
+	}
+	tombstone := func(n testcluster.Node, id uint64) {
+		trans := transport.NewTCPTransport(n.Addr)
+		defer trans.Close()
+		require.NoError(t, trans.TombstoneChunk(ctx, "file", id))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for id := range uint64(3) {
+		for _, n := range nodes {
+			store(n, id, "old")
+		}
+	}
+
+	// the overwrite failed on the second node
+	store(nodes[0], 0, "new")
+	// the delete failed on the second node
+	tombstone(nodes[0], 1)
+	// the chunk is written again after the delete
+	tombstone(nodes[0], 2)
+	store(nodes[1], 2, "again")
+
+	stats := repair()
+	require.Equal(t, 1, stats.Conflicts)
+	require.Equal(t, 1, stats.Deleted)
+	require.Equal(t, 2, stats.Pulled+stats.Pushed)
+
+	for _, n := range nodes {
+		require.Equal(t, "new", read(n, 0))
+		require.Equal(t, "", read(n, 1))
+		require.Equal(t, "again", read(n, 2))
+	}
+
+	// the plain delete is the loss, the chunk is copied back
+	require.NoError(t, nodes[0].Storage.DeleteChunk(ctx, "file", 0))
+	stats = repair()
+	require.Equal(t, 1, stats.Pulled+stats.Pushed)
+	require.Equal(t, "new", read(nodes[0], 0))
+
+	require.Equal(t, sfs.RepairStats{}, repair())
+}
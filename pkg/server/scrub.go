@@ -0,0 +1,120 @@
+package sfs
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tymbaca/sfs/internal/codes"
+	"github.com/tymbaca/sfs/internal/logger"
+	"github.com/tymbaca/sfs/internal/ratelimit"
+	filestorage "github.com/tymbaca/sfs/internal/storage"
+)
+
+// scrubMetrics are published under 'sfs_scrub' expvar, keyed by server
+// address.
+var scrubMetrics = expvar.NewMap("sfs_scrub")
+
+type scrubber interface {
+	Scrub(ctx context.Context, opts filestorage.ScrubOptions) (filestorage.ScrubReport, error)
+}
+
+type ScrubConfig struct {
+	// Interval between the end of one scrub and the start of the next one.
+	Interval time.Duration
+	// BytesPerSec limits the read rate. Non-positive means unlimited.
+	BytesPerSec int64
+	// Quarantine moves bad chunks out of the way, so they are not served.
+	Quarantine bool
+	// CheckGaps reports files with gaps in chunk ids, see [filestorage.ScrubOptions].
+	CheckGaps bool
+}
+
+// RunScrubber periodically verifies the chunks stored on the node until ctx
+// is done. The last report is available over the '!' request.
+func (s *Server) RunScrubber(ctx context.Context, cfg ScrubConfig) error {
+	scr, ok := s.storage.(scrubber)
+	if !ok {
+		return fmt.Errorf("storage %T doesn't support scrubbing", s.storage)
+	}
+
+	metrics := new(expvar.Map).Init()
+	scrubMetrics.Set(s.addr, metrics)
+
+	opts := filestorage.ScrubOptions{
+		Limiter:    ratelimit.NewLimiter(cfg.BytesPerSec),
+		Quarantine: cfg.Quarantine,
+		CheckGaps:  cfg.CheckGaps,
+	}
+
+	for {
+		report, err := scr.Scrub(ctx, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Logf("scrub failed: %s", err)
+		} else {
+			s.setScrubReport(report, metrics)
+			logger.Logf("scrub done: checked %d chunks, %d corrupted, %d truncated, %d empty, %d unknown, time elapsed: %s",
+				report.Checked, len(report.Corrupted), len(report.Truncated), len(report.Empty), len(report.Unknown), report.Duration)
+			for _, entry := range report.Unknown {
+				logger.Logf("scrub: got non-int name in chunks folder: %s", entry)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cfg.Interval):
+		}
+	}
+}
+
+func (s *Server) setScrubReport(report filestorage.ScrubReport, metrics *expvar.Map) {
+	s.scrubMu.Lock()
+	s.scrubReport = &report
+	s.scrubMu.Unlock()
+
+	metrics.Add("runs", 1)
+	metrics.Add("checked_chunks", report.Checked)
+	metrics.Add("checked_bytes", report.CheckedBytes)
+	metrics.Add("corrupted", int64(len(report.Corrupted)))
+	metrics.Add("truncated", int64(len(report.Truncated)))
+	metrics.Add("empty", int64(len(report.Empty)))
+	metrics.Add("quarantined", int64(len(report.Quarantined)))
+
+	lastUnknown := new(expvar.Int)
+	lastUnknown.Set(int64(len(report.Unknown)))
+	metrics.Set("last_unknown", lastUnknown)
+
+	lastMissing := new(expvar.Int)
+	lastMissing.Set(int64(len(report.MissingChecksum)))
+	metrics.Set("last_missing_checksum", lastMissing)
+
+	lastRun := new(expvar.Int)
+	lastRun.Set(report.Started.Unix())
+	metrics.Set("last_run_unix", lastRun)
+}
+
+func (s *Server) handleScrubReport(ctx context.Context, conn io.ReadWriter) error {
+	s.scrubMu.Lock()
+	report := s.scrubReport
+	s.scrubMu.Unlock()
+
+	if report == nil {
+		return writeCode(conn, codes.NotFound)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		err = fmt.Errorf("can't marshal scrub report: %w", err)
+		writeCodeMsg(conn, codes.Internal, err.Error())
+		return err
+	}
+
+	return writeCodeMsg(conn, codes.Ok, string(data))
+}
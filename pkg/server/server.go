@@ -3,25 +3,47 @@ package sfs
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/tymbaca/sfs/internal/codes"
+	"github.com/tymbaca/sfs/internal/common"
 	"github.com/tymbaca/sfs/internal/logger"
+	filestorage "github.com/tymbaca/sfs/internal/storage"
+	"github.com/tymbaca/sfs/pkg/qos"
 )
 
 type Server struct {
 	addr    string
 	storage storage
+
+	scrubMu     sync.Mutex
+	scrubReport *filestorage.ScrubReport
+
+	layoutMu sync.Mutex
+	layout   *Layout
+
+	membershipMu sync.Mutex
+	membership   *membership
+
+	qosMu     sync.Mutex
+	qos       QoSConfig
+	bandwidth *gate
+
+	admissionMu sync.Mutex
+	admission   *admission
 }
 
 func New(addr string, storage storage) *Server {
 	return &Server{
-		addr:    addr,
-		storage: storage,
+		addr:      addr,
+		storage:   storage,
+		admission: newAdmission(AdmissionConfig{}),
 	}
 }
 
@@ -35,35 +57,103 @@ func (s *Server) Run(ctx context.Context) error {
 		return fmt.Errorf("can't listen addr '%s': %w", s.addr, err)
 	}
 
+	return s.Serve(ctx, lis)
+}
+
+// Serve accepts connections on lis. Use it instead of [Server.Run] when the
+// listener is created by the caller (e.g. on random port in tests).
+func (s *Server) Serve(ctx context.Context, lis net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+
+	var backoff time.Duration
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			panic(err)
-		}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
 
-		go func() {
-			err := s.handleConn(ctx, conn)
-			if err != nil {
-				logger.Logf("can't handle conn: %s", err)
-				return
+			// e.g. out of file descriptors, which are released when the
+			// served connections end
+			backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+			logger.Logf("can't accept the connection, retrying in %s: %s", backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-		}()
+			continue
+		}
+		backoff = 0
+
+		go s.serveConn(ctx, s.getAdmission(), conn)
 	}
 }
 
-func (s *Server) handleConn(ctx context.Context, conn net.Conn) error {
+// serveConn waits for the connection slot, so the accept loop isn't blocked
+// by the queue, and handles the connection.
+func (s *Server) serveConn(ctx context.Context, adm *admission, conn net.Conn) {
 	defer conn.Close()
 
+	if !adm.acquireConn(ctx) {
+		reject(conn, "too many connections")
+		return
+	}
+	// released before the close, so the slot is free when the client sees it
+	defer adm.release(adm.conns)
+
+	if err := s.handleConn(ctx, adm, adm.wrap(conn)); err != nil {
+		logger.Logf("can't handle conn: %s", err)
+	}
+}
+
+func (s *Server) handleConn(ctx context.Context, adm *admission, conn net.Conn) error {
 	head, err := peekByte(conn)
 	if err != nil {
 		logger.Logf("can't read the head of request: %s", err)
 		return err
 	}
 
+	// prefixes of the request
+	for head == '~' || head == '+' {
+		switch head {
+		case '~':
+			class, err := readClass(conn)
+			if err != nil {
+				return writeCodeMsg(conn, codes.InvalidReq, err.Error())
+			}
+			ctx = qos.WithClass(ctx, class)
+		case '+':
+			bucket, err := readBucket(conn)
+			if err != nil {
+				return writeCodeMsg(conn, codes.InvalidReq, err.Error())
+			}
+			ctx = common.WithBucket(ctx, bucket)
+		}
+
+		if head, err = peekByte(conn); err != nil {
+			logger.Logf("can't read the head of request: %s", err)
+			return err
+		}
+	}
+
+	sem := adm.ops[head]
+	if !adm.acquire(ctx, sem) {
+		return reject(conn, fmt.Sprintf("too many '%c' requests", head))
+	}
+	defer adm.release(sem)
+	admitted(conn)
+
 	start := time.Now()
 	traceID := uuid.New()
 	defer func() {
-		logger.Logf("request '%c', trace-id '%s', time elapsed: %s", head, traceID, time.Since(start))
+		logger.Logf("request '%c', class %s, bucket '%s', trace-id '%s', time elapsed: %s", head, qos.ClassFrom(ctx), common.BucketFrom(ctx), traceID, time.Since(start))
 	}()
 
 	switch head {
@@ -73,6 +163,34 @@ func (s *Server) handleConn(ctx context.Context, conn net.Conn) error {
 		return s.handleRecvChunk(ctx, conn)
 	case '%':
 		return s.handleListIDs(ctx, conn)
+	case '$':
+		return s.handleListFiles(ctx, conn)
+	case '-':
+		return s.handleDeleteChunk(ctx, conn, false)
+	case '_':
+		return s.handleDeleteChunk(ctx, conn, true)
+	case '#':
+		return s.handleHasChunk(ctx, conn)
+	case '!':
+		return s.handleScrubReport(ctx, conn)
+	case '^':
+		return s.handleSums(ctx, conn)
+	case '<':
+		return s.handleReplicas(ctx, conn)
+	case '?':
+		return s.handlePing(ctx, conn)
+	case '@':
+		return s.handleGossip(ctx, conn)
+	case '&':
+		return s.handleMembers(ctx, conn)
+	case '=':
+		return s.handleStats(ctx, conn)
+	case '(':
+		return s.handleCreateBucket(ctx, conn)
+	case '[':
+		return s.handleListBuckets(ctx, conn)
+	case ')':
+		return s.handleDeleteBucket(ctx, conn)
 	}
 
 	return writeCodeMsg(conn, codes.InvalidReq, fmt.Sprintf("incorrect head character: '%c' (dec:%d)", head, head))